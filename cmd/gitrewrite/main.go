@@ -5,30 +5,129 @@ import (
 	"os"
 
 	"github.com/MrLemur/gitrewrite/internal/commands"
+	"github.com/MrLemur/gitrewrite/internal/services"
 	"github.com/MrLemur/gitrewrite/internal/ui"
 )
 
+// Build information, set via -ldflags at build time
+var (
+	Version   = "dev"
+	BuildTime = "unknown"
+)
+
 func main() {
-	// Setup TUI
-	ui.SetupTUI()
-	go func() {
-		if err := ui.App.SetRoot(ui.MainFlex, true).Run(); err != nil {
-			panic(err)
+	// git rebase runs the binary as its editor when rewording commits
+	if len(os.Args) > 1 && os.Args[1] == services.SequenceEditorArg {
+		if err := services.RunSequenceEditor(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "gitrewrite: %v\n", err)
+			os.Exit(1)
 		}
-	}()
+		return
+	}
 
-	ui.LogInfo("Git Commit Message Rewriter started")
-	ui.LogInfo("Keyboard controls:")
-	ui.LogInfo("  Ctrl+C: Exit program")
-	ui.LogInfo("  PgUp/PgDn: Scroll log up/down")
-	ui.LogInfo("  Home/End: Jump to start/end of log")
+	// Subcommands that run without the TUI
+	if len(os.Args) > 1 && os.Args[1] == "login" {
+		if err := commands.LoginCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Login failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		if err := commands.SelftestCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Selftest failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		if err := commands.LintCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Lint failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "changelog" {
+		if err := commands.ChangelogCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Changelog failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cleanup" {
+		if err := commands.CleanupCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Cleanup failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "suggest" {
+		if err := commands.SuggestCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Suggest failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "install-hook" {
+		if err := commands.InstallHookCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Install-hook failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		if err := commands.ServeCommand(os.Args[2:]); err != nil {
+			fmt.Printf("Serve failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Parse command line flags
 	commands.ParseFlags()
 
+	// Validate repository path
+	if commands.RepoPath == "" {
+		fmt.Println("Please provide a path to a git repository using -repo=/path/to/repo")
+		os.Exit(1)
+	}
+
+	// Setup TUI, or plain console output in headless mode
+	if commands.NoTUI {
+		ui.SetupConsole()
+	} else {
+		if err := ui.LoadTheme(commands.Theme, commands.ThemeFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ui.SetupTUI()
+		go func() {
+			if err := ui.App.SetRoot(ui.MainFlex, true).Run(); err != nil {
+				panic(err)
+			}
+		}()
+	}
+	if err := ui.SetupLogging(commands.LogFormat, commands.LogFile); err != nil {
+		ui.Stop()
+		fmt.Printf("Failed to set up logging: %v\n", err)
+		os.Exit(1)
+	}
+	ui.LogInfo("Git Commit Message Rewriter started")
+	if !commands.NoTUI {
+		ui.LogInfo("Keyboard controls:")
+		ui.LogInfo("  Ctrl+C: Exit program")
+		ui.LogInfo("  PgUp/PgDn: Scroll log up/down")
+		ui.LogInfo("  Home/End: Jump to start/end of log")
+		ui.LogInfo("  Up/Down: Select a commit in the commit list, Enter: Show its messages")
+		ui.LogInfo("  /: Search the log, n/N: Next/previous match, Esc: Clear the search")
+		ui.LogInfo("  f: Show only warnings and errors in the log, press again to show every line")
+		ui.LogInfo("  p: Pause after the current commit, press again to resume")
+	}
+
 	// Initialize debug logging if enabled
 	if commands.DebugLogFile != "" {
 		if err := ui.InitDebugLogging(commands.DebugLogFile); err != nil {
+			ui.Stop()
 			fmt.Printf("Failed to initialize debug logging: %v\n", err)
 			os.Exit(1)
 		}
@@ -36,12 +135,7 @@ func main() {
 		ui.LogInfo("Debug logging enabled to %s", commands.DebugLogFile)
 	}
 
-	// Validate repository path
-	if commands.RepoPath == "" {
-		fmt.Println("Please provide a path to a git repository using -repo=/path/to/repo")
-		os.Exit(1)
-	}
-
 	// Run the application
+	commands.Version = Version
 	commands.RunApplication()
 }
@@ -0,0 +1,265 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+)
+
+// changelogLine matches a subject written as "type(scope): description" or as
+// "type: description (scope)", the default form of rewritten messages
+var changelogLine = regexp.MustCompile(`^([a-z]+)(?:\(([^)]*)\))?(!)?: (.+?)(?: \(([^()]+)\))?$`)
+
+// changelogSections are the sections of each changelog format and the commit types
+// listed in them, in order. Types not listed, like chore, are left out.
+var changelogSections = map[string][]struct {
+	title string
+	types []string
+}{
+	"keep-a-changelog": {
+		{"Added", []string{"feat"}},
+		{"Changed", []string{"perf", "refactor"}},
+		{"Removed", []string{"revert"}},
+		{"Fixed", []string{"fix"}},
+	},
+	"conventional": {
+		{"Features", []string{"feat"}},
+		{"Bug Fixes", []string{"fix"}},
+		{"Performance Improvements", []string{"perf"}},
+		{"Reverts", []string{"revert"}},
+	},
+}
+
+// changelogEntry is one change described by a commit message
+type changelogEntry struct {
+	Type        string
+	Scope       string
+	Description string
+	Breaking    bool
+	CommitID    string
+}
+
+// changelogEntries returns the changes described by each subject line of a message,
+// including those of git revert.
+// Commits with several changes have one subject per change, and with -single-subject
+// the others are listed as "- type: description" lines.
+func changelogEntries(commitID, message string) []changelogEntry {
+	message = strings.TrimSpace(message)
+	breaking := strings.Contains(message, "\nBREAKING CHANGE: ") || strings.Contains(message, "\nBREAKING-CHANGE: ")
+	var entries []changelogEntry
+	for _, line := range strings.FieldsFunc(message, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		// Indented lines quote other text, like the original message of -keep-original
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		line = strings.TrimPrefix(strings.TrimSpace(line), "- ")
+		if strings.HasPrefix(line, `Revert "`) && strings.HasSuffix(line, `"`) {
+			entries = append(entries, changelogEntry{Type: "revert", Description: line[len(`Revert "`) : len(line)-1], CommitID: commitID})
+			continue
+		}
+		match := changelogLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		scope := match[2]
+		if scope == "" {
+			scope = match[5]
+		}
+		entries = append(entries, changelogEntry{
+			Type:        match[1],
+			Scope:       scope,
+			Description: match[4],
+			Breaking:    match[3] == "!" || breaking,
+			CommitID:    commitID,
+		})
+	}
+	return entries
+}
+
+// changelogRelease is a release of a changelog with its changes, newest first
+type changelogRelease struct {
+	name    string
+	date    time.Time
+	entries []changelogEntry
+}
+
+// buildChangelog renders releases, newest first, in a changelog format. The entries of
+// each release's sections are grouped by scope.
+func buildChangelog(releases []changelogRelease, format string) string {
+	var b strings.Builder
+	b.WriteString("# Changelog\n\n")
+	if format == "keep-a-changelog" {
+		b.WriteString("All notable changes to this project are documented in this file, following [Keep a Changelog](https://keepachangelog.com/en/1.1.0/).\n\n")
+	}
+	for i, release := range releases {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		writeChangelogRelease(&b, format, release)
+	}
+	return b.String()
+}
+
+// writeChangelogRelease writes a release's heading and its sections
+func writeChangelogRelease(b *strings.Builder, format string, release changelogRelease) {
+	if format == "keep-a-changelog" {
+		fmt.Fprintf(b, "## [%s] - %s\n", release.name, release.date.Format("2006-01-02"))
+	} else {
+		fmt.Fprintf(b, "## %s (%s)\n", release.name, release.date.Format("2006-01-02"))
+	}
+
+	var breaking []changelogEntry
+	for _, entry := range release.entries {
+		if entry.Breaking {
+			breaking = append(breaking, entry)
+		}
+	}
+	if format == "conventional" && len(breaking) > 0 {
+		writeChangelogSection(b, format, "BREAKING CHANGES", breaking)
+	}
+
+	for _, section := range changelogSections[format] {
+		var listed []changelogEntry
+		for _, entry := range release.entries {
+			for _, commitType := range section.types {
+				if entry.Type == commitType {
+					listed = append(listed, entry)
+				}
+			}
+		}
+		writeChangelogSection(b, format, section.title, listed)
+	}
+}
+
+// writeChangelogSection writes a section's entries sorted by scope, unscoped first
+func writeChangelogSection(b *strings.Builder, format, title string, entries []changelogEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Scope < entries[j].Scope })
+
+	fmt.Fprintf(b, "\n### %s\n\n", title)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		text := entry.Description
+		if entry.Scope != "" {
+			text = fmt.Sprintf("**%s:** %s", entry.Scope, text)
+		}
+		if entry.Breaking && format == "keep-a-changelog" {
+			text = "**BREAKING:** " + text
+		}
+		if seen[text] {
+			continue
+		}
+		seen[text] = true
+		if format == "conventional" {
+			fmt.Fprintf(b, "* %s (%s)\n", text, entry.CommitID[:7])
+		} else {
+			fmt.Fprintf(b, "- %s\n", text)
+		}
+	}
+}
+
+// writeChangelog writes the -changelog file from the messages of the applied commits
+func writeChangelog() {
+	if ChangelogFile == "" || len(appliedCommits) == 0 {
+		return
+	}
+	var entries []changelogEntry
+	for i := len(appliedCommits) - 1; i >= 0; i-- {
+		mapping := appliedCommits[i]
+		entries = append(entries, changelogEntries(mapping.NewID, appliedMessages[mapping.OriginalID])...)
+	}
+	release := changelogRelease{name: "Unreleased", date: time.Now(), entries: entries}
+	changelog := buildChangelog([]changelogRelease{release}, ChangelogFormat)
+	if err := os.WriteFile(ChangelogFile, []byte(changelog), 0644); err != nil {
+		ui.LogError("Failed to write changelog: %v", err)
+		return
+	}
+	ui.LogSuccess("Changelog of the rewritten history written to %s", ChangelogFile)
+}
+
+// ChangelogCommand writes a changelog of the repository's history, or of the commits
+// selected with -range, -since and -until, from its Conventional Commits messages, e.g.
+// those of a rewritten history. Every tag starts a release; the commits after the
+// newest tag are Unreleased.
+//
+//	gitrewrite changelog [-repo=.] [-range=v1.0.0..HEAD] [-format=keep-a-changelog|conventional] [-output=CHANGELOG.md]
+func ChangelogCommand(args []string) error {
+	flags := flag.NewFlagSet("changelog", flag.ContinueOnError)
+	flags.StringVar(&RepoPath, "repo", ".", "Path to the git repository")
+	flags.StringVar(&RevisionRange, "range", "", "Only list the commits of a revision range, e.g. v1.0.0..HEAD")
+	flags.StringVar(&Since, "since", "", "Only list commits committed on or after this date (2006-01-02) or time (RFC 3339)")
+	flags.StringVar(&Until, "until", "", "Only list commits committed before the end of this date or on or before this time")
+	flags.BoolVar(&FirstParent, "first-parent", false, "Only list the first-parent chain of HEAD")
+	flags.StringVar(&ChangelogFormat, "format", "keep-a-changelog", "Changelog format: 'keep-a-changelog' or 'conventional' (conventional-changelog)")
+	output := flags.String("output", "", "Write the changelog to this file instead of standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	if _, ok := changelogSections[ChangelogFormat]; !ok {
+		return fmt.Errorf("invalid -format value %q: must be 'keep-a-changelog' or 'conventional'", ChangelogFormat)
+	}
+
+	ui.SetupQuietConsole()
+	if err := resolveScanLimits(RepoPath); err != nil {
+		return err
+	}
+	repo, err := git.PlainOpen(RepoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository at %s: %v", RepoPath, err)
+	}
+	opts := scanOptions()
+	opts.Select, opts.MessagesOnly = services.SelectAll, true
+	_, commits, err := services.GetCommitsChronological(repo, opts)
+	if err != nil {
+		return err
+	}
+	tags, err := services.ReleaseTags(repo)
+	if err != nil {
+		return err
+	}
+
+	changelog := buildChangelog(changelogReleases(commits, tags, time.Now()), ChangelogFormat)
+	if *output == "" {
+		os.Stdout.WriteString(changelog)
+	} else if err := os.WriteFile(*output, []byte(changelog), 0644); err != nil {
+		return fmt.Errorf("failed to write changelog: %v", err)
+	}
+	return nil
+}
+
+// changelogReleases splits the changes of commits, oldest first, into releases at the
+// tagged commits, newest release first. A commit with several tags is released under
+// the last by name. Releases without changes to list are left out.
+func changelogReleases(commits []models.CommitOutput, tags map[string][]models.ReleaseTag, now time.Time) []changelogRelease {
+	var releases []changelogRelease
+	current := changelogRelease{name: "Unreleased", date: now}
+	for _, commit := range commits {
+		current.entries = append(changelogEntries(commit.CommitID, commit.Message), current.entries...)
+		if commitTags := tags[commit.CommitID]; len(commitTags) > 0 {
+			tag := commitTags[len(commitTags)-1]
+			current.name, current.date = tag.Name, tag.Date
+			if len(current.entries) > 0 {
+				releases = append([]changelogRelease{current}, releases...)
+			}
+			current = changelogRelease{name: "Unreleased", date: now}
+		}
+	}
+	if len(current.entries) > 0 {
+		releases = append([]changelogRelease{current}, releases...)
+	}
+	return releases
+}
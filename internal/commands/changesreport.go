@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// reportFormat returns the format -report writes for a file name, "markdown" or
+// "html", or an empty string for an unsupported extension
+func reportFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		return "markdown"
+	case ".html", ".htm":
+		return "html"
+	}
+	return ""
+}
+
+// writeReport writes the changes of a dry run to -report, as a Markdown table of every
+// proposed rewrite or, for a .html file, as the HTML report
+func writeReport(path string, outputs []models.RewriteOutput) {
+	if path == "" || len(outputs) == 0 {
+		return
+	}
+	if reportFormat(path) == "html" {
+		writeHTMLReport(path, outputs)
+		return
+	}
+	ui.LogInfo("Writing Markdown report to %s", path)
+	if err := os.WriteFile(path, []byte(buildMarkdownReport(outputs)), 0644); err != nil {
+		ui.LogError("Failed to write Markdown report: %v", err)
+		return
+	}
+	ui.LogSuccess("Markdown report saved to %s", path)
+}
+
+// buildMarkdownReport renders the changes of a dry run as a Markdown table with the
+// original and new message and file count of every commit, for pasting into a PR
+func buildMarkdownReport(outputs []models.RewriteOutput) string {
+	rewritten := 0
+	for _, output := range outputs {
+		if !output.Skipped {
+			rewritten++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# GitRewrite changes for %s\n\n", services.GetRepoName(RepoPath))
+	fmt.Fprintf(&b, "%d commits, %d rewritten, %d keeping their original message. Generated %s.\n\n",
+		len(outputs), rewritten, len(outputs)-rewritten, time.Now().Format(time.RFC1123))
+	b.WriteString("| Commit | Original Message | New Message | Files |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	for _, output := range outputs {
+		newMessage := markdownMessageCell(output.RewrittenMsg)
+		if output.Skipped {
+			newMessage = "_kept: " + markdownCell(output.SkipReason) + "_"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %d |\n", output.CommitID[:min(8, len(output.CommitID))],
+			markdownMessageCell(output.OriginalMsg), newMessage, output.FilesChanged)
+	}
+	return b.String()
+}
+
+// markdownMessageCell escapes a message for a Markdown table cell, with its lines
+// joined by line breaks
+func markdownMessageCell(message string) string {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimSpace(message), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = markdownCell(line)
+	}
+	return strings.Join(lines, "<br>")
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+)
+
+// CleanupCommand removes the temporary editor scripts, message files and directories
+// left behind by runs that crashed or were killed. Paths listed in the session
+// manifests of processes that have exited are removed along with the manifests, as are
+// files in the temp directory named like gitrewrite's that are older than -min-age.
+//
+//	gitrewrite cleanup [-dry-run] [-min-age=24h]
+func CleanupCommand(args []string) error {
+	flags := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "List the leftovers without removing them")
+	minAge := flags.Duration("min-age", 24*time.Hour, "Minimum age of temp files not listed in a session manifest before they are removed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	leftovers, manifests, err := services.FindLeftovers(*minAge)
+	if err != nil {
+		return err
+	}
+	if len(leftovers) == 0 && len(manifests) == 0 {
+		fmt.Println("No leftovers from earlier runs found")
+		return nil
+	}
+
+	failed := 0
+	for _, leftover := range leftovers {
+		if *dryRun {
+			fmt.Printf("Would remove %s\n", leftover.Path)
+			continue
+		}
+		if err := os.RemoveAll(leftover.Path); err != nil {
+			fmt.Printf("Failed to remove %s: %v\n", leftover.Path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Removed %s\n", leftover.Path)
+	}
+	if *dryRun {
+		fmt.Printf("%d leftovers from %d crashed sessions would be removed\n", len(leftovers), len(manifests))
+		return nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d of %d leftovers", failed, len(leftovers))
+	}
+	for _, manifest := range manifests {
+		if err := os.Remove(manifest); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove session manifest %s: %v", manifest, err)
+		}
+	}
+	fmt.Printf("Removed %d leftovers from %d crashed sessions\n", len(leftovers), len(manifests))
+	return nil
+}
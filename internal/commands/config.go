@@ -0,0 +1,141 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+)
+
+// Config holds settings that are easier to express in a file than as flags
+type Config struct {
+	// Thresholds override -max-length for commits touching matching path prefixes
+	Thresholds []models.PathThreshold `json:"thresholds,omitempty"`
+	// CommitterName and CommitterEmail are used when -committer-name/-committer-email are not given
+	CommitterName  string `json:"committer_name,omitempty"`
+	CommitterEmail string `json:"committer_email,omitempty"`
+	// Footers add ticket references and similar footers to rewritten messages
+	Footers []models.FooterRule `json:"footers,omitempty"`
+	// PromptFile is used when -prompt-file is not given
+	PromptFile string `json:"prompt_file,omitempty"`
+	// MessageTemplate is used when -message-template is not given
+	MessageTemplate string `json:"message_template,omitempty"`
+	// DiffLimits override -max-diff for files matching a pattern, e.g. "*.json"
+	DiffLimits []models.DiffLimit `json:"diff_limits,omitempty"`
+}
+
+// AppConfig is the configuration loaded from -config
+var AppConfig Config
+
+// LoadConfig reads the JSON configuration file at path into AppConfig
+func LoadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := json.Unmarshal(data, &AppConfig); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	for _, rule := range AppConfig.Thresholds {
+		if rule.Path == "" {
+			return fmt.Errorf("threshold rule in %s is missing a path", path)
+		}
+	}
+	for _, limit := range AppConfig.DiffLimits {
+		if _, err := filepath.Match(limit.Pattern, ""); err != nil || limit.Pattern == "" {
+			return fmt.Errorf("diff limit in %s has an invalid pattern %q", path, limit.Pattern)
+		}
+		if limit.MaxDiff < 0 {
+			return fmt.Errorf("diff limit for %q in %s must not be negative", limit.Pattern, path)
+		}
+	}
+	return compileFooterRules(AppConfig.Footers)
+}
+
+// scanLimits restrict rewriting to a period and commit range, see resolveScanLimits
+var scanLimits struct {
+	since time.Time
+	until time.Time
+	// untilExclusive is set when -until is a date, whose bound is the next midnight
+	untilExclusive bool
+	inRange        map[string]bool
+	author         *regexp.Regexp
+	skip           *regexp.Regexp
+}
+
+// resolveScanLimits parses -since and -until, resolves -range to its commits and
+// compiles -author and -skip-message-pattern
+func resolveScanLimits(repoPath string) error {
+	var err error
+	if Since != "" {
+		if scanLimits.since, err = parseDateFlag(Since, false); err != nil {
+			return fmt.Errorf("invalid -since value: %v", err)
+		}
+	}
+	if Until != "" {
+		if scanLimits.until, err = parseDateFlag(Until, true); err != nil {
+			return fmt.Errorf("invalid -until value: %v", err)
+		}
+		_, timestampErr := time.Parse(time.RFC3339, Until)
+		scanLimits.untilExclusive = timestampErr != nil
+	}
+	if RevisionRange != "" {
+		if scanLimits.inRange, err = services.ResolveRevisionRange(repoPath, RevisionRange); err != nil {
+			return err
+		}
+	}
+	if Author != "" {
+		if scanLimits.author, err = regexp.Compile(Author); err != nil {
+			return fmt.Errorf("invalid -author pattern: %v", err)
+		}
+	}
+	if SkipMessagePattern != "" {
+		if scanLimits.skip, err = regexp.Compile(SkipMessagePattern); err != nil {
+			return fmt.Errorf("invalid -skip-message-pattern: %v", err)
+		}
+	}
+	return nil
+}
+
+// parseDateFlag parses a date (2006-01-02) or timestamp (RFC 3339). A plain date given
+// as an end bound covers the whole day: it returns the next midnight, an exclusive bound.
+func parseDateFlag(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a date (2006-01-02) nor an RFC 3339 timestamp", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// scanOptions builds the commit selection options from flags and configuration
+func scanOptions() services.ScanOptions {
+	return services.ScanOptions{
+		Select:         Select,
+		MaxMsgLength:   MaxMsgLength,
+		MaxDiffLength:  MaxDiffLength,
+		MinScore:       MinScore,
+		PathThresholds: AppConfig.Thresholds,
+		DiffLimits:     AppConfig.DiffLimits,
+		Since:          scanLimits.since,
+		Until:          scanLimits.until,
+		UntilExclusive: scanLimits.untilExclusive,
+		InRange:        scanLimits.inRange,
+		Author:         scanLimits.author,
+		SkipMessage:    scanLimits.skip,
+		KeepReverts:    Reverts != "rewrite",
+		FirstParent:    FirstParent,
+		AllRefs:        AllRefs,
+	}
+}
@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/MrLemur/gitrewrite/pkg/events"
+)
+
+// fakeOllama is an Ollama server answering chat requests with a mock generator's
+// canned messages
+type fakeOllama struct {
+	*httptest.Server
+	chats atomic.Int32
+}
+
+// newFakeOllama starts a fake Ollama server answering with generator's messages and
+// points OLLAMA_HOST at it for the test
+func newFakeOllama(t *testing.T, generator services.MockClient) *fakeOllama {
+	t.Helper()
+	f := &fakeOllama{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"models": []any{}})
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"model_info": map[string]any{"fake.context_length": 32768}})
+	})
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Model    string                 `json:"model"`
+			Messages []services.ChatMessage `json:"messages"`
+			Format   json.RawMessage        `json:"format"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.chats.Add(1)
+		format := request.Format
+		if len(format) == 0 || string(format) == "null" {
+			format = nil
+		}
+		content, err := generator.Chat(r.Context(), request.Model, request.Messages, format, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		json.NewEncoder(w).Encode(map[string]any{
+			"model":             request.Model,
+			"message":           map[string]string{"role": "assistant", "content": content},
+			"done":              true,
+			"prompt_eval_count": 100,
+			"eval_count":        20,
+		})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	t.Setenv("OLLAMA_HOST", f.URL)
+	return f
+}
+
+// useClient sets the generation backend and model for a test, restoring them afterwards
+func useClient(t *testing.T, client services.LLMClient) {
+	t.Helper()
+	previousClient, previousModel, previousCacheDir := services.Client, Model, services.MessageCacheDir
+	t.Cleanup(func() {
+		services.Client, Model, services.MessageCacheDir = previousClient, previousModel, previousCacheDir
+	})
+	ui.SetupQuietConsole()
+	services.Client = client
+	services.MessageCacheDir = ""
+	Model = "fake"
+}
+
+// newFixtureRepo builds the selftest repository, with merges, renames, symlinks,
+// executable files and empty commits, in a temporary directory
+func newFixtureRepo(t *testing.T) string {
+	t.Helper()
+	sourcePath := filepath.Join(t.TempDir(), "source")
+	if err := buildSelftestRepo(sourcePath); err != nil {
+		t.Fatalf("failed to build fixture repository: %v", err)
+	}
+	return sourcePath
+}
+
+// appliedCounter counts the CommitApplied events of a run
+type appliedCounter struct {
+	events.NopHandler
+	applied, rewritten int
+}
+
+func (c *appliedCounter) OnCommitApplied(e events.CommitApplied) {
+	c.applied++
+	if e.Rewritten {
+		c.rewritten++
+	}
+}
+
+func TestRewriteWithFakeOllama(t *testing.T) {
+	server := newFakeOllama(t, services.MockClient{Message: selftestMessage})
+	useClient(t, services.OllamaClient{})
+
+	if err := services.Client.CheckAvailability(); err != nil {
+		t.Fatalf("CheckAvailability: %v", err)
+	}
+	contextSize, err := services.Client.ContextSize(Model)
+	if err != nil {
+		t.Fatalf("ContextSize: %v", err)
+	}
+	if contextSize != 32768 {
+		t.Fatalf("ContextSize = %d, want 32768", contextSize)
+	}
+	modelContextSize = contextSize
+
+	sourcePath := newFixtureRepo(t)
+	for _, method := range []string{"fast-import", "worktree"} {
+		t.Run(method, func(t *testing.T) {
+			before := server.chats.Load()
+			counter := &appliedCounter{}
+			defer events.Register(counter)()
+			problems, err := runSelftest(sourcePath, method)
+			if err != nil {
+				t.Fatalf("runSelftest: %v", err)
+			}
+			for _, problem := range problems {
+				t.Error(problem)
+			}
+			if server.chats.Load() == before {
+				t.Fatalf("no chat requests reached the fake Ollama server")
+			}
+			if counter.applied != len(appliedCommits) || counter.rewritten == 0 {
+				t.Errorf("got %d CommitApplied events, %d rewritten, for %d applied commits", counter.applied, counter.rewritten, len(appliedCommits))
+			}
+		})
+	}
+}
+
+func TestMockClientMessages(t *testing.T) {
+	tests := []struct {
+		files []string
+		want  string
+	}{
+		{nil, `{"commit_id":"abc","messages":[{"affected_app":"repo","description":"update repository","type":"chore"}]}`},
+		{[]string{"README.md", "docs/usage.txt"}, `{"commit_id":"abc","messages":[{"affected_app":"repo","description":"update README.md, usage.txt","type":"docs"}]}`},
+		{[]string{"api/server.go", "a", "b", "c"}, `{"commit_id":"abc","messages":[{"affected_app":"api","description":"update server.go, a, b and 1 more files","type":"chore"}]}`},
+	}
+	for _, tt := range tests {
+		commit := map[string]any{"commit_id": "abc", "files": []map[string]string{}}
+		for _, path := range tt.files {
+			commit["files"] = append(commit["files"].([]map[string]string), map[string]string{"path": path})
+		}
+		content, _ := json.Marshal(commit)
+		got, err := services.MockClient{}.Chat(context.Background(), "", []services.ChatMessage{{Role: "user", Content: string(content)}}, json.RawMessage(`{}`), 0)
+		if err != nil {
+			t.Fatalf("Chat(%v): %v", tt.files, err)
+		}
+		if got != tt.want {
+			t.Errorf("Chat(%v) = %s, want %s", tt.files, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/MrLemur/gitrewrite/pkg/helpers"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// footerPatterns are the compiled patterns of AppConfig.Footers, in the same order
+var footerPatterns []*regexp.Regexp
+
+// commitBranches maps commits to the branch they were merged from, loaded on first use
+var commitBranches map[string]string
+
+// currentBranch is the branch commits not listed in commitBranches were made on
+var currentBranch string
+
+// compileFooterRules validates the footer rules and compiles their patterns
+func compileFooterRules(rules []models.FooterRule) error {
+	footerPatterns = nil
+	for _, rule := range rules {
+		if rule.Key == "" || rule.Pattern == "" {
+			return fmt.Errorf("footer rule needs both a key and a pattern")
+		}
+		if rule.From != "" && rule.From != "branch" && rule.From != "message" {
+			return fmt.Errorf("footer rule %s: from must be 'branch', 'message' or empty, got %q", rule.Key, rule.From)
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("footer rule %s has an invalid pattern: %v", rule.Key, err)
+		}
+		footerPatterns = append(footerPatterns, pattern)
+	}
+	return nil
+}
+
+// addFooters appends the original message's trailers, unless -keep-trailers=false, and
+// the configured footers to a rewritten message. Values already present in the message
+// are not repeated.
+func addFooters(message string, commit models.CommitOutput) string {
+	if KeepTrailers {
+		_, trailers := helpers.ParseTrailers(commit.Message)
+		message = helpers.MergeTrailers(message, trailers)
+	}
+	for i, rule := range AppConfig.Footers {
+		var sources []string
+		if rule.From != "message" {
+			sources = append(sources, branchOf(commit.CommitID))
+		}
+		if rule.From != "branch" {
+			sources = append(sources, commit.Message)
+		}
+
+		var values []string
+		seen := make(map[string]bool)
+		for _, source := range sources {
+			for _, value := range footerPatterns[i].FindAllString(source, -1) {
+				if !seen[value] && !strings.Contains(message, value) {
+					seen[value] = true
+					values = append(values, value)
+				}
+			}
+		}
+		if len(values) > 0 {
+			message = helpers.AppendTrailer(message, rule.Key, strings.Join(values, ", "))
+		}
+	}
+	return message
+}
+
+// branchOf returns the name of the branch a commit was made on, see
+// services.GetCommitBranches
+func branchOf(commitID string) string {
+	if commitBranches == nil {
+		var err error
+		commitBranches, err = services.GetCommitBranches(RepoPath)
+		if err != nil {
+			ui.LogWarning("Branch names are not available for footers: %v", err)
+			commitBranches = make(map[string]string)
+		}
+		currentBranch, _ = services.GetCurrentBranchName(RepoPath)
+	}
+	if branch, ok := commitBranches[commitID]; ok {
+		return branch
+	}
+	return currentBranch
+}
+
+// originalMessageNotesRef is the notes ref of -keep-original=note
+const originalMessageNotesRef = "original-message"
+
+// originalMessages are the original messages kept with -keep-original=note by original
+// hash, until they are attached to the applied commits
+var originalMessages = make(map[string]string)
+
+// keepOriginal preserves the original message of a rewritten commit as -keep-original
+// asks: as an Original-Message trailer, as a footer paragraph, or as a git note added
+// once the commits are applied. The original's trailers are left out of the trailer and
+// footer, -keep-trailers carries them over on their own.
+func keepOriginal(repo *git.Repository, commitID, message string) string {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitID))
+	if err != nil {
+		ui.LogWarning("Failed to read the original message of commit %s: %v", commitID[:8], err)
+		return message
+	}
+	original, _ := helpers.ParseTrailers(commit.Message)
+	original = strings.TrimSpace(original)
+	if original == "" {
+		return message
+	}
+
+	switch KeepOriginal {
+	case "trailer":
+		// Continuation lines of a trailer can't be blank
+		var lines []string
+		for _, line := range strings.Split(original, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return helpers.AppendTrailer(message, "Original-Message", strings.Join(lines, "\n "))
+	case "footer":
+		body, trailers := helpers.ParseTrailers(message)
+		if strings.Contains(original, "\n") {
+			body += "\n\nOriginal message:\n\n    " + strings.ReplaceAll(original, "\n", "\n    ")
+		} else {
+			body += "\n\nOriginal message: " + original
+		}
+		return helpers.FormatTrailers(body, trailers)
+	case "note":
+		originalMessages[commitID] = commit.Message
+	}
+	return message
+}
+
+// writeOriginalMessageNotes attaches the messages kept with -keep-original=note to the
+// rewritten commits in the new repository
+func writeOriginalMessageNotes(newRepoPath string) {
+	if len(originalMessages) == 0 {
+		return
+	}
+	ui.UpdateStatus("Adding original message notes...")
+	added := 0
+	for _, mapping := range appliedCommits {
+		original, ok := originalMessages[mapping.OriginalID]
+		if !ok {
+			continue
+		}
+		if err := services.AddNote(newRepoPath, originalMessageNotesRef, mapping.NewID, original); err != nil {
+			ui.LogError("Failed to add original message notes: %v", err)
+			return
+		}
+		added++
+	}
+	ui.LogSuccess("Attached the original messages of %d commits as notes under refs/notes/%s", added, originalMessageNotesRef)
+}
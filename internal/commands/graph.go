@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// HistoryDiffMode compares the source history with the linear history a rewrite would
+// produce and writes both graphs, plus a summary of structural changes, to outputFile
+func HistoryDiffMode(repoPath, outputFile string) {
+	ui.UpdateStatus("Comparing history graphs...")
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		ui.LogError("Failed to open repository: %v", err)
+		ui.UpdateStatus("Error: Failed to open repository")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Failed to open repository at %s: %v", repoPath, err)
+	}
+
+	sourceGraph, err := services.GetHistoryGraph(repoPath)
+	if err != nil {
+		ui.LogError("Failed to render source history: %v", err)
+		ui.UpdateStatus("Error: Failed to render source history")
+		return
+	}
+
+	allCommits, _, err := services.GetCommitsChronological(repo, scanOptions())
+	if err != nil {
+		ui.LogError("Failed to get commits: %v", err)
+		ui.UpdateStatus("Error: Failed to get commits")
+		return
+	}
+
+	// Position of each commit in the planned (oldest first) history
+	position := make(map[string]int, len(allCommits))
+	for i, commit := range allCommits {
+		position[commit.CommitID] = i
+	}
+
+	var planned []string
+	var merges, squashed, reordered, rewritten int
+	for _, commit := range allCommits {
+		c, err := repo.CommitObject(plumbing.NewHash(commit.CommitID))
+		if err != nil {
+			ui.LogError("Failed to read commit %s: %v", commit.CommitID[:8], err)
+			return
+		}
+
+		var notes []string
+		if c.NumParents() > 1 {
+			merges++
+			notes = append(notes, fmt.Sprintf("merge flattened, %d parents -> 1", c.NumParents()))
+		}
+		for _, parent := range c.ParentHashes {
+			if parentPos, ok := position[parent.String()]; ok && parentPos > position[commit.CommitID] {
+				reordered++
+				notes = append(notes, "reordered before its parent "+parent.String()[:8])
+				break
+			}
+		}
+		if commit.NeedsRewrite {
+			if len(commit.Files) > MaxFilesPerCommit && !SummarizeOversizedCommits {
+				squashed++
+				notes = append(notes, fmt.Sprintf("squashed into next commit, %d files exceed -max-files", len(commit.Files)))
+			} else {
+				rewritten++
+				notes = append(notes, "message rewritten")
+			}
+		}
+
+		subject := strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0]
+		line := fmt.Sprintf("* %s %s", commit.CommitID[:8], subject)
+		if len(notes) > 0 {
+			line += "  [" + strings.Join(notes, "; ") + "]"
+		}
+		planned = append(planned, line)
+	}
+
+	var b strings.Builder
+	b.WriteString("== Source history ==\n\n")
+	b.WriteString(sourceGraph)
+	b.WriteString("\n== Planned rewritten history ==\n\n")
+	for i := len(planned) - 1; i >= 0; i-- {
+		b.WriteString(planned[i] + "\n")
+	}
+	b.WriteString("\n== Structural changes ==\n\n")
+	fmt.Fprintf(&b, "- %d commits in total, %d messages rewritten\n", len(allCommits), rewritten)
+	fmt.Fprintf(&b, "- %d merge commits flattened into linear history\n", merges)
+	fmt.Fprintf(&b, "- %d commits squashed into their successor\n", squashed)
+	fmt.Fprintf(&b, "- %d commits reordered before one of their parents\n", reordered)
+
+	if err := os.WriteFile(outputFile, []byte(b.String()), 0644); err != nil {
+		ui.LogError("Failed to write history comparison: %v", err)
+		ui.UpdateStatus("Error: Failed to write history comparison")
+		return
+	}
+
+	ui.LogInfo("%d merges flattened, %d commits squashed, %d commits reordered", merges, squashed, reordered)
+	ui.LogSuccess("History comparison written to %s", outputFile)
+	ui.UpdateStatus("History comparison written to " + outputFile + ". Press Ctrl+C to exit")
+}
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+)
+
+// hookMarker identifies prepare-commit-msg hooks written by install-hook, which it may
+// replace without -force
+const hookMarker = "# Written by 'gitrewrite install-hook'"
+
+// hookScript runs suggest for plain git commit runs. Messages given with -m or -F, from
+// a template, of merges and squashes, and of amended commits are left alone. A failing
+// suggestion never blocks the commit; the editor just opens without it.
+const hookScript = `#!/bin/sh
+%s
+# Pre-fills the commit message with a suggestion for the staged changes.
+case "$2" in
+"") ;;
+*) exit 0 ;;
+esac
+%s suggest -message-file="$1" %s || true
+`
+
+// InstallHookCommand writes a prepare-commit-msg hook into the repository that fills
+// the commit editor with a message generated by suggest, so new commits get a good
+// message from the start. Flags after "--" are passed on to suggest, e.g. the model.
+//
+//	gitrewrite install-hook [-repo=.] [-force] [-- suggest flags]
+func InstallHookCommand(args []string) error {
+	flags := flag.NewFlagSet("install-hook", flag.ContinueOnError)
+	repoPath := flags.String("repo", ".", "Path to the git repository")
+	force := flags.Bool("force", false, "Replace an existing prepare-commit-msg hook that wasn't written by install-hook")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	for _, arg := range flags.Args() {
+		if !strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unexpected argument %q: flags for suggest go after \"--\"", arg)
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the gitrewrite executable: %v", err)
+	}
+	hooksDir, err := services.GetCommandOutput("git", []string{"rev-parse", "--git-path", "hooks"}, *repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to find the hooks directory of %s: %v", *repoPath, err)
+	}
+	hooksDir = strings.TrimSpace(hooksDir)
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(*repoPath, hooksDir)
+	}
+	hookPath := filepath.Join(hooksDir, "prepare-commit-msg")
+	if existing, err := os.ReadFile(hookPath); err == nil && !strings.Contains(string(existing), hookMarker) && !*force {
+		return fmt.Errorf("%s already exists, run with -force to replace it", hookPath)
+	}
+
+	quoted := make([]string, len(flags.Args()))
+	for i, arg := range flags.Args() {
+		quoted[i] = shellQuote(arg)
+	}
+	script := fmt.Sprintf(hookScript, hookMarker, shellQuote(executable), strings.Join(quoted, " "))
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return fmt.Errorf("failed to create hooks directory: %v", err)
+	}
+	if err := os.WriteFile(hookPath, []byte(script), 0755); err != nil {
+		return fmt.Errorf("failed to write hook: %v", err)
+	}
+	fmt.Printf("Installed the prepare-commit-msg hook at %s\n", hookPath)
+	return nil
+}
+
+// shellQuote quotes a word for a POSIX shell
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/MrLemur/gitrewrite/pkg/helpers"
+	"github.com/go-git/go-git/v5"
+)
+
+// htmlReportEntry is one commit's section of the HTML report
+type htmlReportEntry struct {
+	CommitID string
+	ShortID  string
+	Subject  string
+	Status   string
+	Diff     []helpers.DiffOp
+	Files    []string
+}
+
+// htmlReportTemplate renders the HTML report as a single self-contained page. Each
+// commit is a collapsed section, so thousands of entries stay readable.
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>GitRewrite changes for {{.Repo}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #24292f; }
+details { border: 1px solid #d0d7de; border-radius: 6px; margin: 0.4em 0; }
+summary { cursor: pointer; padding: 0.5em 0.8em; }
+summary code { color: #57606a; }
+.status { float: right; font-size: 0.85em; color: #57606a; }
+.status.skipped { color: #9a6700; }
+.body { padding: 0 0.8em 0.8em; }
+pre { background: #f6f8fa; padding: 0.8em; border-radius: 6px; white-space: pre-wrap; word-break: break-word; }
+del { background: #ffebe9; color: #82071e; }
+ins { background: #dafbe1; color: #116329; text-decoration: none; }
+ul.files { font-family: monospace; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>GitRewrite changes for {{.Repo}}</h1>
+<p>{{.Total}} commits, {{.Rewritten}} rewritten, {{.Skipped}} keeping their original message. Generated {{.Date}}.</p>
+<p><button onclick="document.querySelectorAll('details').forEach(d => d.open = true)">Expand all</button>
+<button onclick="document.querySelectorAll('details').forEach(d => d.open = false)">Collapse all</button></p>
+{{range .Entries}}<details id="{{.CommitID}}">
+<summary><code>{{.ShortID}}</code> {{.Subject}}<span class="status{{if ne .Status "rewritten"}} skipped{{end}}">{{.Status}}</span></summary>
+<div class="body">
+<pre>{{range .Diff}}{{if eq .Kind '-'}}<del>{{.Text}}</del>{{else if eq .Kind '+'}}<ins>{{.Text}}</ins>{{else}}{{.Text}}{{end}}{{end}}</pre>
+{{if .Files}}<ul class="files">{{range .Files}}<li>{{.}}</li>{{end}}</ul>{{end}}
+</div>
+</details>
+{{end}}</body>
+</html>
+`))
+
+// writeHTMLReport renders the entries of a changes file as an HTML page with a word
+// level diff of each commit's original and rewritten message and its changed files
+func writeHTMLReport(path string, outputs []models.RewriteOutput) {
+	if path == "" || len(outputs) == 0 {
+		return
+	}
+	ui.LogInfo("Writing HTML report to %s", path)
+
+	repo, err := git.PlainOpen(RepoPath)
+	if err != nil {
+		ui.LogWarning("Failed to open repository for the HTML report file lists: %v", err)
+	}
+
+	data := struct {
+		Repo      string
+		Date      string
+		Total     int
+		Rewritten int
+		Skipped   int
+		Entries   []htmlReportEntry
+	}{
+		Repo: services.GetRepoName(RepoPath),
+		Date: time.Now().Format(time.RFC1123),
+	}
+	for _, output := range outputs {
+		original := strings.TrimSpace(output.OriginalMsg)
+		rewritten := strings.TrimSpace(output.RewrittenMsg)
+		entry := htmlReportEntry{
+			CommitID: output.CommitID,
+			ShortID:  output.CommitID[:min(8, len(output.CommitID))],
+			Subject:  strings.SplitN(rewritten, "\n", 2)[0],
+			Status:   "rewritten",
+			Diff:     helpers.WordDiff(original, rewritten),
+		}
+		if output.Skipped {
+			entry.Status = "kept: " + output.SkipReason
+			data.Skipped++
+		} else {
+			data.Rewritten++
+		}
+		if repo != nil {
+			if entry.Files, err = services.ChangedFiles(repo, output.CommitID); err != nil {
+				ui.LogWarning("Failed to list changed files for %s: %v", entry.ShortID, err)
+			}
+		}
+		data.Entries = append(data.Entries, entry)
+	}
+	data.Total = len(data.Entries)
+
+	var b bytes.Buffer
+	if err := htmlReportTemplate.Execute(&b, data); err != nil {
+		ui.LogError("Failed to render HTML report: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		ui.LogError("Failed to write HTML report: %v", err)
+		return
+	}
+	ui.LogSuccess("HTML report saved to %s", path)
+}
@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// inPlaceRepoPath returns the absolute path of the source repository, which -in-place
+// rewrites instead of creating a new repository next to it
+func inPlaceRepoPath(repoPath string) string {
+	absPath, err := filepath.Abs(repoPath)
+	if err != nil {
+		ui.LogWarning("Failed to get absolute path for source repository: %v", err)
+		return filepath.Clean(repoPath)
+	}
+	return filepath.Clean(absPath)
+}
+
+// applyTarget describes where the commits are applied, for confirmation messages
+func applyTarget(newRepoPath string) string {
+	if InPlace {
+		return fmt.Sprintf("the history of %s, replacing it in place", newRepoPath)
+	}
+	return "a new repository at " + newRepoPath
+}
+
+// prepareInPlace asks for the extra confirmation -in-place requires, which -yes does
+// not answer, and backs up every branch and tag under refs/original/ before any ref
+// is replaced. Either failing stops the run.
+func prepareInPlace(repoPath string) {
+	refs := "the current branch"
+	if AllRefs {
+		refs = "every branch and tag"
+	}
+	confirmMessage := fmt.Sprintf("The history of %s will be rewritten in place. Every commit from the first rewritten one onwards gets a new hash, and %s will point at the rewritten commits.\n\nThe branches and tags are backed up under refs/original/ first. Do you really want to rewrite this repository?", repoPath, refs)
+	if !ui.ShowConfirmationDialog(confirmMessage) {
+		ui.LogInfo("User cancelled the in-place rewrite. Exiting.")
+		ui.Stop()
+		os.Exit(0)
+	}
+
+	ui.UpdateStatus("Backing up branches and tags...")
+	count, err := services.BackupRefs(repoPath)
+	if err != nil {
+		ui.LogError("%v", err)
+		ui.UpdateStatus("Error: Failed to back up branches and tags")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Failed to back up branches and tags: %v", err)
+	}
+	ui.LogSuccess("Backed up %d branches and tags under refs/original/", count)
+}
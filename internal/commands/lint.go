@@ -0,0 +1,179 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/MrLemur/gitrewrite/pkg/helpers"
+	"github.com/go-git/go-git/v5"
+)
+
+// lintTypes are the commit types accepted by lint, those of the widely used
+// config-conventional rule set
+var lintTypes = map[string]bool{
+	"build": true, "chore": true, "ci": true, "docs": true, "feat": true, "fix": true,
+	"perf": true, "refactor": true, "revert": true, "style": true, "test": true,
+}
+
+// lintIgnoredPrefixes mark subjects written by git itself, which lint leaves out
+var lintIgnoredPrefixes = []string{"Merge ", "Revert \"", "fixup! ", "squash! ", "amend! "}
+
+// LintCommand checks the messages of the repository's history, or of the commits
+// selected with -range, -since, -until and -author, against Conventional Commits and
+// prints a Markdown or JSON report. It fails when any commit violates the format, so
+// CI can run it without modifying anything.
+//
+//	gitrewrite lint [-repo=.] [-range=main..HEAD] [-format=markdown|json] [-output=file]
+func LintCommand(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.StringVar(&RepoPath, "repo", ".", "Path to the git repository")
+	flags.StringVar(&RevisionRange, "range", "", "Only lint the commits of a revision range, e.g. main..HEAD")
+	flags.StringVar(&Since, "since", "", "Only lint commits committed on or after this date (2006-01-02) or time (RFC 3339)")
+	flags.StringVar(&Until, "until", "", "Only lint commits committed before the end of this date or on or before this time")
+	flags.StringVar(&Author, "author", "", "Only lint commits whose author \"Name <email>\" matches this regular expression")
+	flags.BoolVar(&FirstParent, "first-parent", false, "Only lint the first-parent chain of HEAD")
+	format := flags.String("format", "markdown", "Report format: 'markdown' or 'json'")
+	output := flags.String("output", "", "Write the report to this file instead of standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	if *format != "markdown" && *format != "json" {
+		return fmt.Errorf("invalid -format value %q: must be 'markdown' or 'json'", *format)
+	}
+
+	ui.SetupQuietConsole()
+	if err := resolveScanLimits(RepoPath); err != nil {
+		return err
+	}
+	repo, err := git.PlainOpen(RepoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository at %s: %v", RepoPath, err)
+	}
+	opts := scanOptions()
+	opts.Select, opts.MessagesOnly, opts.KeepReverts = services.SelectAll, true, false
+	_, commits, err := services.GetCommitsChronological(repo, opts)
+	if err != nil {
+		return err
+	}
+
+	report := lintCommits(commits)
+	report.Repo = services.GetRepoName(RepoPath)
+	var data []byte
+	if *format == "json" {
+		if data, err = json.MarshalIndent(report, "", "  "); err != nil {
+			return fmt.Errorf("failed to marshal lint report: %v", err)
+		}
+		data = append(data, '\n')
+	} else {
+		data = []byte(lintMarkdown(report))
+	}
+	if *output == "" {
+		os.Stdout.Write(data)
+	} else if err := os.WriteFile(*output, data, 0644); err != nil {
+		return fmt.Errorf("failed to write lint report: %v", err)
+	}
+
+	if len(report.Violations) > 0 {
+		return fmt.Errorf("%d of %d commits don't follow Conventional Commits", len(report.Violations), report.Checked)
+	}
+	return nil
+}
+
+// lintCommits checks every commit's message and counts the types of the valid ones
+func lintCommits(commits []models.CommitOutput) models.LintReport {
+	report := models.LintReport{Types: make(map[string]int), Violations: []models.LintViolation{}}
+	for _, commit := range commits {
+		message := strings.TrimSpace(commit.Message)
+		subject := strings.TrimSpace(strings.SplitN(message, "\n", 2)[0])
+		if lintIgnored(subject) {
+			report.Ignored++
+			continue
+		}
+		report.Checked++
+
+		problems := lintMessage(message, subject)
+		if len(problems) > 0 {
+			report.Violations = append(report.Violations, models.LintViolation{
+				CommitID: commit.CommitID,
+				Subject:  subject,
+				Problems: problems,
+			})
+			continue
+		}
+		report.Types[strings.ToLower(helpers.ConventionalSubject.FindStringSubmatch(subject)[1])]++
+	}
+	return report
+}
+
+// lintIgnored reports whether a subject was written by git, e.g. for a merge
+func lintIgnored(subject string) bool {
+	for _, prefix := range lintIgnoredPrefixes {
+		if strings.HasPrefix(subject, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lintMessage returns the Conventional Commits rules a message breaks
+func lintMessage(message, subject string) []string {
+	var problems []string
+	match := helpers.ConventionalSubject.FindStringSubmatch(subject)
+	switch {
+	case message == "":
+		return []string{"message is empty"}
+	case match == nil:
+		problems = append(problems, "subject is not \"type(scope): description\"")
+	case !lintTypes[strings.ToLower(match[1])]:
+		problems = append(problems, fmt.Sprintf("unknown type %q", match[1]))
+	}
+	if len(subject) > maxVerifiedSubjectLength {
+		problems = append(problems, fmt.Sprintf("subject is %d characters long, more than %d", len(subject), maxVerifiedSubjectLength))
+	}
+	if lines := strings.SplitN(message, "\n", 3); len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		problems = append(problems, "body is not separated from the subject by a blank line")
+	}
+	return problems
+}
+
+// lintMarkdown renders a lint report as Markdown
+func lintMarkdown(report models.LintReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Conventional Commits lint for %s\n\n", report.Repo)
+	percent := 0.0
+	if report.Checked > 0 {
+		percent = float64(len(report.Violations)) / float64(report.Checked) * 100
+	}
+	fmt.Fprintf(&b, "%d commits checked, %d violations (%.1f%%), %d merge, revert and fixup commits ignored.\n",
+		report.Checked, len(report.Violations), percent, report.Ignored)
+
+	if len(report.Types) > 0 {
+		b.WriteString("\n## Types\n\n| Type | Commits |\n| --- | ---: |\n")
+		for _, commitType := range sortedTypes(report.Types) {
+			fmt.Fprintf(&b, "| %s | %d |\n", commitType, report.Types[commitType])
+		}
+	}
+
+	if len(report.Violations) > 0 {
+		b.WriteString("\n## Violations\n\n| Commit | Subject | Problems |\n| --- | --- | --- |\n")
+		for _, violation := range report.Violations {
+			fmt.Fprintf(&b, "| `%s` | %s | %s |\n", violation.CommitID[:8],
+				markdownCell(violation.Subject), markdownCell(strings.Join(violation.Problems, "; ")))
+		}
+	}
+	return b.String()
+}
+
+// markdownCell escapes text for a Markdown table cell
+func markdownCell(text string) string {
+	return strings.NewReplacer("|", "\\|", "<", "&lt;", ">", "&gt;").Replace(text)
+}
@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// earlySignals catches interrupts between taking the first lock and the run setting
+// up its own interrupt handling, so the lock files are removed then as well
+var earlySignals chan os.Signal
+
+// lockRepository stops the run when another one is working on the repository at
+// repoPath, and otherwise holds its lock file until this run exits
+func lockRepository(repoPath string) {
+	lock, err := services.LockRepository(repoPath)
+	if err != nil {
+		ui.LogError("%v", err)
+		ui.UpdateStatus("Error: Repository is in use by another run")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Repository is in use by another run: %v", err)
+	}
+	ui.AtExit(func() {
+		if err := lock.Unlock(); err != nil {
+			ui.LogError("%v", err)
+		}
+	})
+
+	if earlySignals == nil {
+		earlySignals = make(chan os.Signal, 1)
+		signal.Notify(earlySignals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			if _, ok := <-earlySignals; ok {
+				ui.LogInfo("Received interrupt signal, shutting down...")
+				ui.Stop()
+				os.Exit(1)
+			}
+		}()
+	}
+}
+
+// stopEarlySignals hands interrupts over to the run's own handling
+func stopEarlySignals() {
+	if earlySignals != nil {
+		signal.Stop(earlySignals)
+		close(earlySignals)
+		earlySignals = nil
+	}
+}
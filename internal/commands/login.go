@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"golang.org/x/term"
+)
+
+// LoginCommand stores the API key of an OpenAI-compatible API in the OS keychain, so
+// it doesn't have to be passed with -api-key. The key is read from the terminal
+// without echo, or from stdin when it is piped in.
+//
+//	gitrewrite login [-api-base=url] [-delete]
+func LoginCommand(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	apiBase := flags.String("api-base", services.DefaultOpenAIBase, "Base URL of the OpenAI-compatible API the key belongs to")
+	remove := flags.Bool("delete", false, "Remove the stored key instead of saving one")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	base := services.NormalizeAPIBase(*apiBase)
+
+	if *remove {
+		if err := services.DeleteAPIKey(base); err != nil {
+			return err
+		}
+		fmt.Printf("Removed API key for %s\n", base)
+		return nil
+	}
+
+	key, err := readAPIKey(base)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("no API key given")
+	}
+	if err := services.StoreAPIKey(base, key); err != nil {
+		return err
+	}
+	fmt.Printf("Stored API key for %s in the OS keychain\n", base)
+	return nil
+}
+
+// readAPIKey reads an API key from the terminal without echo, or from piped stdin
+func readAPIKey(apiBase string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Printf("API key for %s: ", apiBase)
+		key, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", fmt.Errorf("failed to read API key: %v", err)
+		}
+		return strings.TrimSpace(string(key)), nil
+	}
+
+	key, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && key == "" {
+		return "", fmt.Errorf("failed to read API key from stdin: %v", err)
+	}
+	return strings.TrimSpace(key), nil
+}
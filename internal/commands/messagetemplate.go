@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+)
+
+// subjectData is what -message-template can use for each of the model's messages
+type subjectData struct {
+	Type        string
+	Scope       string
+	Description string
+}
+
+// messageTemplateFuncs are the helper functions available in message templates
+var messageTemplateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// messageTemplate formats subject lines, nil for "type: description (scope)"
+var messageTemplate *template.Template
+
+// subjectSeparator joins the subjects of a commit with several changes
+var subjectSeparator = "\n\r"
+
+// loadMessageTemplate parses a -message-template and renders a sample so mistakes are
+// reported before the run starts
+func loadMessageTemplate(text string) error {
+	tmpl, err := template.New("message-template").Funcs(messageTemplateFuncs).Parse(text)
+	if err != nil {
+		return fmt.Errorf("failed to parse -message-template: %v", err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, subjectData{Type: "feat", Scope: "app", Description: "add a feature"}); err != nil {
+		return fmt.Errorf("failed to render -message-template: %v", err)
+	}
+	if strings.TrimSpace(out.String()) == "" {
+		return fmt.Errorf("-message-template renders an empty subject")
+	}
+	messageTemplate = tmpl
+	return nil
+}
+
+// parseSubjectSeparator reads a -message-separator, where \n, \r and \t escapes stand
+// for line breaks and tabs
+func parseSubjectSeparator(value string) (string, error) {
+	separator, err := strconv.Unquote(`"` + strings.ReplaceAll(value, `"`, `\"`) + `"`)
+	if err != nil {
+		return "", fmt.Errorf("invalid -message-separator %q: %v", value, err)
+	}
+	return separator, nil
+}
+
+// gitmojis replace the commit type with -style=gitmoji, following gitmoji.dev
+var gitmojis = map[string]string{
+	"feat":     "✨",
+	"fix":      "🐛",
+	"chore":    "🔧",
+	"docs":     "📝",
+	"refactor": "♻️",
+	"perf":     "⚡️",
+}
+
+// formatSubject renders one of the model's messages as a subject line in the -style.
+// The scope is left out when the model didn't name an affected app.
+func formatSubject(msg map[string]string) string {
+	data := subjectData{Type: msg["type"], Scope: strings.TrimSpace(msg["affected_app"]), Description: msg["description"]}
+	if messageTemplate != nil {
+		var out bytes.Buffer
+		if err := messageTemplate.Execute(&out, data); err == nil {
+			return strings.TrimSpace(out.String())
+		}
+	}
+
+	prefix := data.Type + ":"
+	switch Style {
+	case services.StylePlain:
+		sentence := strings.TrimSuffix(strings.TrimSpace(data.Description), ".")
+		if sentence == "" {
+			return sentence
+		}
+		first, size := utf8.DecodeRuneInString(sentence)
+		return string(unicode.ToUpper(first)) + sentence[size:]
+	case services.StyleGitmoji:
+		prefix = gitmojis[data.Type]
+	}
+	if data.Scope == "" {
+		return fmt.Sprintf("%s %s", prefix, data.Description)
+	}
+	return fmt.Sprintf("%s %s (%s)", prefix, data.Description, data.Scope)
+}
@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+)
+
+// Phases of a run that -phases selects from
+const (
+	phaseScan     = "scan"
+	phaseGenerate = "generate"
+	phaseApply    = "apply"
+)
+
+// scanOnly is set when -phases=scan stops the run after scanning the history
+var scanOnly bool
+
+// applyPhases maps -phases onto the existing run modes: scan alone writes the run plan,
+// scan,generate is a dry run that writes the changes file, apply applies the changes
+// file of an earlier dry run and all three are a regular run. Other combinations would
+// skip a phase whose output the next one needs and are rejected.
+func applyPhases() error {
+	if Phases == "" {
+		return nil
+	}
+	selected := make(map[string]bool)
+	for _, phase := range strings.Split(Phases, ",") {
+		phase = strings.TrimSpace(phase)
+		if phase != phaseScan && phase != phaseGenerate && phase != phaseApply {
+			return fmt.Errorf("unknown phase %q, expected scan, generate or apply", phase)
+		}
+		selected[phase] = true
+	}
+
+	switch {
+	case selected[phaseScan] && selected[phaseGenerate] && selected[phaseApply]:
+		if DryRun {
+			return fmt.Errorf("-dry-run cannot be combined with the apply phase")
+		}
+	case selected[phaseScan] && selected[phaseGenerate] && !selected[phaseApply]:
+		DryRun = true
+	case selected[phaseScan] && !selected[phaseGenerate] && !selected[phaseApply]:
+		scanOnly = true
+		if PlanOutputFile == "" {
+			PlanOutputFile = fmt.Sprintf("%s-rewrite-plan.json", services.GetRepoName(RepoPath))
+		}
+	case selected[phaseApply] && !selected[phaseScan] && !selected[phaseGenerate]:
+		if ApplyChangesFile == "" {
+			ApplyChangesFile = OutputFile
+		}
+		if ApplyChangesFile == "" {
+			ApplyChangesFile = fmt.Sprintf("%s-rewrite-changes.json", services.GetRepoName(RepoPath))
+		}
+	default:
+		return fmt.Errorf("phases %q skip a phase the next one depends on, use scan, scan,generate, apply or scan,generate,apply", Phases)
+	}
+	if ApplyChangesFile != "" && selected[phaseGenerate] {
+		return fmt.Errorf("-apply-changes cannot be combined with the generate phase")
+	}
+	return nil
+}
+
+// ScanMode only scans the history and writes the run plan, listing the commits a
+// generate phase would send to the model, without contacting a model
+func ScanMode(repoPath string) {
+	ui.SetPhase("Scan")
+	ui.UpdateStatus("Scanning commits...")
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		ui.LogError("Failed to open repository: %v", err)
+		ui.UpdateStatus("Error: Failed to open repository")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Failed to open repository at %s: %v", repoPath, err)
+	}
+
+	allCommits, commitsToRewrite, err := services.GetCommitsChronological(repo, scanOptions())
+	if err != nil {
+		ui.LogError("Failed to get commits: %v", err)
+		ui.UpdateStatus("Error: Failed to get commits")
+		return
+	}
+	ui.LogInfo("Found %d total commits, %d need rewriting", len(allCommits), len(commitsToRewrite))
+
+	plan := buildRunPlan("scan", "", Model, len(allCommits), len(commitsToRewrite), commitsToRewrite)
+	for _, commit := range commitsToRewrite {
+		plan.RewriteCommitIDs = append(plan.RewriteCommitIDs, commit.CommitID)
+	}
+	if err := writeRunPlan(plan); err != nil {
+		ui.LogError("%v", err)
+		ui.UpdateStatus("Error: Failed to write run plan")
+		return
+	}
+	ui.LogSuccess("Scan complete: %d of %d commits would be rewritten, about %d prompt tokens",
+		plan.RewriteCommits, plan.TotalCommits, plan.EstimatedTokens)
+	ui.UpdateStatus("Scan completed. Press Ctrl+C to exit")
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// Rough per-commit durations used to estimate how long a run will take
+const (
+	estimatedGenerationTime = 5 * time.Second
+	estimatedApplyTime      = 500 * time.Millisecond
+)
+
+// buildRunPlan summarizes a run before it starts. commitsToRewrite holds the commits
+// that will be sent to the model; rewriteCount may differ when messages come from a file.
+func buildRunPlan(mode, targetPath, model string, totalCommits, rewriteCount int, commitsToRewrite []models.CommitOutput) models.RunPlan {
+	tokens := 0
+	for _, commit := range commitsToRewrite {
+		tokens += services.EstimateCommitTokens(commit)
+	}
+
+	estimate := time.Duration(len(commitsToRewrite)) * estimatedGenerationTime
+	if targetPath != "" {
+		estimate += time.Duration(totalCommits) * estimatedApplyTime
+	}
+
+	return models.RunPlan{
+		RepoPath:         RepoPath,
+		TargetPath:       targetPath,
+		Mode:             mode,
+		Model:            model,
+		TotalCommits:     totalCommits,
+		RewriteCommits:   rewriteCount,
+		EstimatedTokens:  tokens,
+		TokenBudget:      MaxTotalTokens,
+		EstimatedSeconds: int(estimate.Seconds()),
+	}
+}
+
+// writeRunPlan saves the plan as JSON to -plan-output, if set
+func writeRunPlan(plan models.RunPlan) error {
+	if PlanOutputFile == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run plan: %v", err)
+	}
+	if err := os.WriteFile(PlanOutputFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write run plan: %v", err)
+	}
+
+	ui.LogInfo("Run plan written to %s", PlanOutputFile)
+	return nil
+}
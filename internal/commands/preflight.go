@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// maxListedChanges is the number of uncommitted changes named in the preflight error
+const maxListedChanges = 5
+
+// checkWorktree stops the run before anything is rewritten when the repository is
+// in a state the rewrite can't sensibly start from. A detached HEAD always stops it,
+// since there is no branch to rewrite. Uncommitted changes and an unfinished rebase,
+// merge or cherry-pick stop it unless -allow-dirty is given: they are not part of
+// the history that gets rewritten, and are easily lost or confused with it.
+func checkWorktree(repoPath string) {
+	ui.UpdateStatus("Checking repository state...")
+	state, err := services.InspectWorktree(repoPath)
+	if err != nil {
+		ui.LogError("Failed to check repository state: %v", err)
+		ui.UpdateStatus("Error: Failed to check repository state")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Failed to check repository state: %v", err)
+	}
+
+	if state.DetachedHEAD {
+		ui.LogError("Repository %s is not ready to be rewritten: HEAD is detached, check out the branch to rewrite first", repoPath)
+		ui.UpdateStatus("Error: HEAD is detached")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Repository %s is not ready to be rewritten: HEAD is detached, check out the branch to rewrite first", repoPath)
+	}
+
+	var problems, fixes []string
+	if state.Operation != "" {
+		problems = append(problems, fmt.Sprintf("a %s is in progress", state.Operation))
+		fixes = append(fixes, fmt.Sprintf("finish or abort the %s", state.Operation))
+	}
+	if len(state.Changes) > 0 {
+		problems = append(problems, fmt.Sprintf("the working tree has uncommitted changes (%d files)", len(state.Changes)))
+		fixes = append(fixes, "commit or stash the changes")
+	}
+	if len(problems) == 0 {
+		return
+	}
+	logChanges := func() {
+		for i, change := range state.Changes {
+			if i == maxListedChanges {
+				ui.LogInfo("  ... and %d more", len(state.Changes)-maxListedChanges)
+				break
+			}
+			ui.LogInfo("  %s", change)
+		}
+	}
+	if AllowDirty {
+		ui.LogWarning("Proceeding with -allow-dirty although %s", strings.Join(problems, " and "))
+		logChanges()
+		return
+	}
+
+	ui.LogError("Repository %s is not ready to be rewritten: %s", repoPath, strings.Join(problems, " and "))
+	logChanges()
+	advice := strings.Join(fixes, " and ") + " first, or use -allow-dirty to proceed anyway"
+	ui.LogError("Please %s", advice)
+	ui.UpdateStatus("Error: Repository is not ready to be rewritten")
+	time.Sleep(2 * time.Second)
+	ui.Stop()
+	log.Fatalf("Repository %s is not ready to be rewritten: %s. Please %s", repoPath, strings.Join(problems, " and "), advice)
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/MrLemur/gitrewrite/pkg/events"
+)
+
+// progressStream writes the events of a run as JSON lines to -progress
+type progressStream struct {
+	events.NopHandler
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	// ownsFile is false for a descriptor handed over by a wrapper, which stays open
+	ownsFile   bool
+	unregister func()
+}
+
+// activeProgressStream is the open -progress stream, nil without one
+var activeProgressStream *progressStream
+
+// openProgressStream starts writing the run's events to the -progress target,
+// "jsonl:" followed by a file path or the number of an open file descriptor
+func openProgressStream(target string) error {
+	dest, ok := strings.CutPrefix(target, "jsonl:")
+	if !ok || dest == "" {
+		return fmt.Errorf("invalid -progress value %q: must be 'jsonl:<path>' or 'jsonl:<fd>'", target)
+	}
+	stream := &progressStream{}
+	if fd, err := strconv.Atoi(dest); err == nil {
+		stream.file = os.NewFile(uintptr(fd), "fd "+dest)
+		if _, err := stream.file.Stat(); err != nil {
+			return fmt.Errorf("failed to open progress stream on file descriptor %d: %v", fd, err)
+		}
+	} else {
+		if stream.file, err = os.Create(dest); err != nil {
+			return fmt.Errorf("failed to open progress stream: %v", err)
+		}
+		stream.ownsFile = true
+	}
+	stream.encoder = json.NewEncoder(stream.file)
+	stream.unregister = events.Register(stream)
+	activeProgressStream = stream
+	return nil
+}
+
+// closeProgressStream writes the last line for event, "finished" or "stopped", and
+// stops the stream
+func closeProgressStream(event string) {
+	stream := activeProgressStream
+	if stream == nil {
+		return
+	}
+	activeProgressStream = nil
+	stream.unregister()
+	stream.write(models.ProgressEvent{Event: event})
+
+	if stream.ownsFile {
+		stream.file.Close()
+	}
+}
+
+// write adds the time and the run's progress to an event and writes it as a line.
+// Failures are only logged, a wrapper going away never stops a run.
+func (s *progressStream) write(e models.ProgressEvent) {
+	e.Time = time.Now()
+	e.Processed, e.Total = ui.ProcessedCommits, ui.TotalCommits
+	if e.Total > 0 {
+		e.Percent = float64(e.Processed) / float64(e.Total) * 100
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.encoder.Encode(e); err != nil {
+		ui.LogWarning("Failed to write progress event: %v", err)
+	}
+}
+
+func (s *progressStream) OnCommitStarted(e events.CommitStarted) {
+	s.write(models.ProgressEvent{Event: "started", CommitID: e.CommitID})
+}
+
+func (s *progressStream) OnMessageGenerated(e events.MessageGenerated) {
+	s.write(models.ProgressEvent{Event: "generated", CommitID: e.CommitID, Message: e.Message})
+}
+
+func (s *progressStream) OnCommitApplied(e events.CommitApplied) {
+	s.write(models.ProgressEvent{Event: "applied", CommitID: e.CommitID, NewCommitID: e.NewCommitID, Message: e.Message, Rewritten: e.Rewritten})
+}
+
+func (s *progressStream) OnError(e events.Error) {
+	s.write(models.ProgressEvent{Event: "failed", CommitID: e.CommitID, Error: e.Err.Error()})
+}
+
+func (s *progressStream) OnProgress(e events.Progress) {
+	s.write(models.ProgressEvent{Event: "progress", ETASeconds: int(e.ETA.Seconds())})
+}
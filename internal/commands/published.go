@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"log"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// checkPublishedHistory stops the run when commits that are about to get new messages
+// are already on the origin remote, unless -rewrite-published acknowledges that the
+// rewritten history will have to be force-pushed over the shared one
+func checkPublishedHistory(commitIDs []string) {
+	if len(commitIDs) == 0 {
+		return
+	}
+	ui.UpdateStatus("Checking for published commits...")
+	published, err := services.PublishedCommits(RepoPath, commitIDs)
+	if err != nil {
+		ui.LogWarning("Could not check whether the commits are published: %v", err)
+		return
+	}
+	if len(published) == 0 {
+		return
+	}
+
+	if RewritePublished {
+		ui.LogWarning("%d of the %d commits to rewrite are already on origin; publishing the result requires a force-push", len(published), len(commitIDs))
+		return
+	}
+	ui.LogError("%d of the %d commits to rewrite are already on origin, e.g. %s", len(published), len(commitIDs), published[0][:8])
+	ui.LogError("Rewriting them means force-pushing over history others may have based work on. Pass -rewrite-published to continue anyway")
+	ui.UpdateStatus("Error: Commits to rewrite are already published")
+	time.Sleep(2 * time.Second)
+	ui.Stop()
+	log.Fatalf("%d commits to rewrite are already on origin; pass -rewrite-published to rewrite published history", len(published))
+}
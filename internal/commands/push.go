@@ -0,0 +1,96 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// pushNewRepository pushes the rewritten branch, or every branch and tag with -all-refs,
+// to -push-to once the rewrite is complete and confirmed.
+// With -force-with-lease the remote's branches are only replaced while they still are
+// where the source repository last fetched them, so commits pushed by others since
+// then are never lost.
+func pushNewRepository(newRepoPath string) {
+	var refs []string
+	if AllRefs {
+		var err error
+		if refs, err = services.ListRefs(newRepoPath, "refs/heads/", "refs/tags/"); err != nil {
+			ui.LogError("Failed to push to %s: %v", PushTo, err)
+			ui.UpdateStatus("Error: Push failed")
+			return
+		}
+	} else {
+		branch, err := services.GetCurrentBranchName(newRepoPath)
+		if err != nil {
+			ui.LogError("Failed to push to %s: %v", PushTo, err)
+			ui.UpdateStatus("Error: Push failed")
+			return
+		}
+		refs = []string{"refs/heads/" + branch}
+	}
+	if len(refs) == 0 {
+		return
+	}
+
+	url, remote := services.ResolveRemote(RepoPath, PushTo)
+	var leases map[string]string
+	if ForceWithLease {
+		tracked := map[string]string{}
+		if remote == "" {
+			ui.LogWarning("%s is not a remote of %s, so only branches it doesn't have yet can be pushed with -force-with-lease", PushTo, RepoPath)
+		} else {
+			var err error
+			if tracked, err = services.RemoteTrackingBranches(RepoPath, remote); err != nil {
+				ui.LogError("Failed to push to %s: %v", PushTo, err)
+				ui.UpdateStatus("Error: Push failed")
+				return
+			}
+		}
+		leases = make(map[string]string)
+		for _, ref := range refs {
+			leases[ref] = tracked[ref]
+		}
+	}
+
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+	}
+	action := "pushed to"
+	if ForceWithLease {
+		action = "force-pushed with lease to"
+	}
+	confirmMessage := fmt.Sprintf("The new repository's %s will be %s %s.", formatRefList(names), action, url)
+	if ForceWithLease {
+		confirmMessage += "\n\nThis replaces the published history there for everyone. Branches that changed on the remote since your last fetch are not overwritten."
+	}
+	if !AssumeYes && !ui.ShowConfirmationDialog(confirmMessage) {
+		ui.LogInfo("Push cancelled, the new repository was not pushed")
+		return
+	}
+
+	ui.UpdateStatus("Pushing to " + url + "...")
+	ui.LogInfo("Pushing %d refs to %s", len(refs), url)
+	if err := services.PushRefs(newRepoPath, url, refs, leases); err != nil {
+		ui.LogError("Failed to push to %s: %v", url, err)
+		if ForceWithLease {
+			ui.LogError("A branch changed on the remote since the last fetch, or the remote rejected the push. Fetch in %s and check the remote before retrying", RepoPath)
+		} else {
+			ui.LogError("Rewritten history can't replace published history without -force-with-lease")
+		}
+		ui.UpdateStatus("Error: Push failed")
+		return
+	}
+	ui.LogSuccess("Pushed %s to %s", formatRefList(names), url)
+}
+
+// formatRefList names a few refs, e.g. "main, v1.0 and 3 more"
+func formatRefList(names []string) string {
+	if len(names) <= 3 {
+		return strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(names[:3], ", "), len(names)-3)
+}
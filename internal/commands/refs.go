@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// sideBranchCommits holds the commits only reachable from other branches and tags,
+// which -all-refs applies onto the rewritten versions of their parents
+var sideBranchCommits = make(map[string]bool)
+
+// rememberSideBranchCommits records which of the scanned commits are side branch commits
+func rememberSideBranchCommits(commits []models.CommitOutput) {
+	count := 0
+	for _, commit := range commits {
+		if commit.SideBranch {
+			sideBranchCommits[commit.CommitID] = true
+			count++
+		}
+	}
+	if count > 0 {
+		ui.LogInfo("Found %d commits on other branches and tags", count)
+	}
+}
+
+// applySideBranchCommit imports a side branch commit onto the new hashes of its parents
+func applySideBranchCommit(repo *git.Repository, newRepoPath, commitID, message string, rewritten bool) error {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitID))
+	if err != nil {
+		return fmt.Errorf("failed to get commit object: %v", err)
+	}
+	var parents []string
+	for _, parent := range commit.ParentHashes {
+		newID, ok := rewrittenIDs[parent.String()]
+		if !ok {
+			return fmt.Errorf("parent %s was not applied to the new repository", parent.String()[:8])
+		}
+		parents = append(parents, newID)
+	}
+
+	if err := startImporter(newRepoPath); err != nil {
+		return err
+	}
+	newID, err := importer.ImportOnto(repo, commitID, message, parents)
+	if err != nil {
+		return err
+	}
+	recordAppliedCommit(commitID, newID, message, rewritten)
+	return nil
+}
+
+// copyRefs recreates the source repository's other branches and its tags in the new
+// repository before the import is finished
+func copyRefs() {
+	if importer == nil {
+		return
+	}
+	repo, err := git.PlainOpen(RepoPath)
+	if err != nil {
+		ui.LogError("Failed to open repository to copy branches and tags: %v", err)
+		return
+	}
+	ui.UpdateStatus("Recreating branches and tags...")
+	count, err := importer.CopyRefs(repo, rewrittenIDs)
+	if err != nil {
+		ui.LogError("Failed to recreate branches and tags: %v", err)
+		return
+	}
+	ui.LogSuccess("Recreated %d branches and tags in the new repository", count)
+}
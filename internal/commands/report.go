@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Name of the report file committed to the new repository and the notes ref used otherwise
+const (
+	repoReportFile     = "REWRITE_REPORT.md"
+	repoReportNotesRef = "rewrite-report"
+)
+
+// provenanceNotesRef is the notes ref of -provenance-notes
+const provenanceNotesRef = "gitrewrite"
+
+// rewriteProvenance holds the provenance of the rewritten commits by original hash
+// until it is attached to the applied commits with -provenance-notes
+var rewriteProvenance = make(map[string]models.RewriteProvenance)
+
+// buildRepoReport renders the provenance report for the rewritten history.
+// An empty model means the messages were applied from a changes file.
+func buildRepoReport(model string) string {
+	rewritten := 0
+	for _, mapping := range appliedCommits {
+		if mapping.Rewritten {
+			rewritten++
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("# Rewrite Report\n\n")
+	b.WriteString("The history of this repository was rewritten by GitRewrite.\n\n")
+	fmt.Fprintf(&b, "- **Date:** %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- **Tool version:** %s\n", Version)
+	fmt.Fprintf(&b, "- **Source repository:** %s\n", services.GetRepoName(RepoPath))
+	if model != "" {
+		fmt.Fprintf(&b, "- **Model:** %s (temperature %.2f)\n", model, Temperature)
+	} else {
+		fmt.Fprintf(&b, "- **Model:** n/a (messages applied from %s)\n", ApplyChangesFile)
+	}
+	fmt.Fprintf(&b, "- **Commits:** %d total, %d rewritten\n\n", len(appliedCommits), rewritten)
+
+	b.WriteString("## Commit Mapping\n\n")
+	b.WriteString("| Original | Rewritten | Message Changed |\n")
+	b.WriteString("| --- | --- | --- |\n")
+	for _, mapping := range appliedCommits {
+		changed := "no"
+		if mapping.Rewritten {
+			changed = "yes"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", mapping.OriginalID, mapping.NewID, changed)
+	}
+	return b.String()
+}
+
+// writeRepoReport records the rewrite report in the new repository according to -repo-report
+func writeRepoReport(newRepoPath, model string) {
+	if RepoReport == "" || len(appliedCommits) == 0 {
+		return
+	}
+
+	report := buildRepoReport(model)
+	ui.UpdateStatus("Writing rewrite report...")
+
+	switch RepoReport {
+	case "file":
+		ui.LogInfo("Committing %s to the new repository", repoReportFile)
+		if err := services.CommitFileToRepo(newRepoPath, repoReportFile, report, "docs: add GitRewrite rewrite report"); err != nil {
+			ui.LogError("Failed to commit rewrite report: %v", err)
+			return
+		}
+	case "notes":
+		head := appliedCommits[len(appliedCommits)-1].NewID
+		ui.LogInfo("Storing rewrite report under refs/notes/%s on %s", repoReportNotesRef, head[:8])
+		if err := services.AddNote(newRepoPath, repoReportNotesRef, head, report); err != nil {
+			ui.LogError("Failed to store rewrite report: %v", err)
+			return
+		}
+	}
+	ui.LogSuccess("Rewrite report recorded in the new repository")
+}
+
+// recordProvenance remembers the original hash and message of a commit rewritten now
+func recordProvenance(repo *git.Repository, commitID string) {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitID))
+	if err != nil {
+		ui.LogWarning("Failed to read the original message of commit %s: %v", commitID[:8], err)
+		return
+	}
+	rewriteProvenance[commitID] = models.RewriteProvenance{
+		OriginalCommit:  commitID,
+		OriginalMessage: commit.Message,
+		RewrittenAt:     time.Now().UTC(),
+		Tool:            "gitrewrite " + Version,
+	}
+}
+
+// writeProvenanceNotes attaches a JSON note with its provenance to every rewritten
+// commit under refs/notes/gitrewrite. An empty model means the messages were applied
+// from a changes file.
+func writeProvenanceNotes(newRepoPath, model string) {
+	if len(rewriteProvenance) == 0 {
+		return
+	}
+	ui.UpdateStatus("Adding provenance notes...")
+	added := 0
+	for _, mapping := range appliedCommits {
+		provenance, ok := rewriteProvenance[mapping.OriginalID]
+		if !ok {
+			continue
+		}
+		if model != "" {
+			temperature := Temperature
+			provenance.Model, provenance.Temperature = model, &temperature
+		}
+		note, err := json.MarshalIndent(provenance, "", "  ")
+		if err != nil {
+			ui.LogError("Failed to marshal provenance note: %v", err)
+			return
+		}
+		if err := services.AddNote(newRepoPath, provenanceNotesRef, mapping.NewID, string(note)+"\n"); err != nil {
+			ui.LogError("Failed to add provenance notes: %v", err)
+			return
+		}
+		added++
+	}
+	ui.LogSuccess("Attached provenance notes to %d rewritten commits under refs/notes/%s", added, provenanceNotesRef)
+}
@@ -0,0 +1,352 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/MrLemur/gitrewrite/pkg/events"
+	"github.com/go-git/go-git/v5"
+)
+
+// failedCommits collects the generation failures of the current run
+var failedCommits []models.FailedCommit
+
+// recordFailure adds a commit to the failure report. If the commit was applied to the
+// new repository with its original message, the new hash is kept so it can be reworded.
+func recordFailure(commitID, reason string, err error) {
+	failure := models.FailedCommit{CommitID: commitID, Reason: reason}
+	if err != nil {
+		failure.Error = err.Error()
+	}
+	if len(appliedCommits) > 0 && appliedCommits[len(appliedCommits)-1].OriginalID == commitID {
+		failure.NewID = appliedCommits[len(appliedCommits)-1].NewID
+	}
+	failedCommits = append(failedCommits, failure)
+}
+
+// failureReportPath returns where the failure report of this run is written
+func failureReportPath() string {
+	if FailuresFile != "" {
+		return FailuresFile
+	}
+	return fmt.Sprintf("%s-rewrite-failures.json", services.GetRepoName(RepoPath))
+}
+
+// writeFailureReport saves the failures of this run, if there were any
+func writeFailureReport(newRepoPath, changesFile string) {
+	if len(failedCommits) == 0 {
+		return
+	}
+
+	report := models.FailureReport{RepoPath: RepoPath, Failures: failedCommits}
+	if DryRun {
+		report.ChangesFile = changesFile
+	} else {
+		report.TargetRepoPath = newRepoPath
+	}
+	if err := saveFailureReport(failureReportPath(), report); err != nil {
+		ui.LogError("%v", err)
+		return
+	}
+	ui.LogWarning("%d commits failed, see %s. Re-run with -retry-failed=%s to retry only those commits",
+		len(failedCommits), failureReportPath(), failureReportPath())
+}
+
+// saveFailureReport writes a failure report as JSON
+func saveFailureReport(path string, report models.FailureReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal failure report: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write failure report: %v", err)
+	}
+	return nil
+}
+
+// RetryFailedMode regenerates messages for the commits listed in a failure report and
+// patches them into the changes file or new repository produced by that run
+func RetryFailedMode(repoPath, reportFile string) {
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		ui.LogError("Failed to read failure report: %v", err)
+		ui.UpdateStatus("Error: Failed to read failure report")
+		return
+	}
+	var report models.FailureReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		ui.LogError("Failed to parse failure report: %v", err)
+		ui.UpdateStatus("Error: Failed to parse failure report")
+		return
+	}
+	if report.ChangesFile == "" && report.TargetRepoPath == "" {
+		ui.LogError("Failure report %s names neither a changes file nor a target repository", reportFile)
+		ui.UpdateStatus("Error: Invalid failure report")
+		return
+	}
+
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		ui.LogError("Failed to open repository: %v", err)
+		ui.UpdateStatus("Error: Failed to open repository")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Failed to open repository at %s: %v", repoPath, err)
+	}
+
+	var excludePattern *regexp.Regexp
+	if ExcludeFiles != "" {
+		excludePattern, err = regexp.Compile(ExcludeFiles)
+		if err != nil {
+			ui.LogError("Invalid exclude pattern: %v", err)
+			ui.UpdateStatus("Error: Invalid exclude pattern")
+			return
+		}
+	}
+
+	ui.TotalCommits = len(report.Failures)
+	ui.ProcessedCommits = 0
+	ui.StartTime = time.Now()
+	ui.CommitTimings = make([]time.Duration, 0, ui.TotalCommits)
+	ui.SetPhase("Retry")
+	startProgressWebhook("retry-failed")
+
+	// Regenerate every failed commit, remembering the ones that fail again
+	messages := make(map[string]string)
+	changed := make(map[string]int)
+	var stillFailing []models.FailedCommit
+	for _, failure := range report.Failures {
+		ui.WaitWhilePaused()
+		shortID := failure.CommitID[:8]
+		ui.UpdateStatus(fmt.Sprintf("Retrying commit %s...", shortID))
+		ui.LogInfo("Retrying commit %s (previously failed: %s)", shortID, failure.Reason)
+
+		commit, err := services.GetCommitDetails(repo, failure.CommitID, MaxDiffLength, AppConfig.DiffLimits)
+		if err != nil {
+			ui.LogError("Failed to read commit %s: %v", shortID, err)
+			stillFailing = append(stillFailing, failure)
+			continue
+		}
+		if excludePattern != nil {
+			var filteredFiles []models.File
+			for _, file := range commit.Files {
+				if !shouldExcludeFile(file.Path, excludePattern) {
+					filteredFiles = append(filteredFiles, file)
+				}
+			}
+			commit.Files = filteredFiles
+		}
+
+		ui.UpdateCommitDetails(commit.CommitID, len(commit.Files), -1, strings.TrimSpace(commit.Message), "Processing...")
+		events.Started(events.CommitStarted{CommitID: commit.CommitID, Message: commit.Message})
+		ui.LastCommitStartTime = time.Now()
+
+		var newMessage string
+		var timedOut bool
+		if len(commit.Files) > MaxFilesPerCommit {
+			newMessage, timedOut, err = generateSummary(commit)
+		} else {
+			var newCommit models.NewCommitMessage
+			newCommit, timedOut, err = generateCommitMessage(commit)
+			if err == nil {
+				newMessage = assembleCommitMessage(newCommit, commit)
+			}
+		}
+		ui.RecordCommitTiming(time.Since(ui.LastCommitStartTime))
+
+		if err != nil {
+			ui.LogError("Commit %s failed again: %v", shortID, err)
+			failure.Error = err.Error()
+			if timedOut {
+				failure.Reason = "timeout"
+			}
+			stillFailing = append(stillFailing, failure)
+		} else {
+			newMessage = addFooters(newMessage, commit)
+			ui.UpdateCommitDetails(commit.CommitID, len(commit.Files), -1, strings.TrimSpace(commit.Message), newMessage)
+			ui.LogSuccess("Generated new message for commit %s", shortID)
+			messages[failure.CommitID] = newMessage
+			auditMessage(failure.CommitID, newMessage)
+			changed[failure.CommitID] = len(commit.Files)
+		}
+		ui.ProcessedCommits++
+		ui.UpdateProgressBar()
+	}
+
+	if report.ChangesFile != "" {
+		patchChangesFile(report.ChangesFile, messages, changed)
+	} else {
+		stillFailing = patchTargetRepo(report, messages, stillFailing)
+	}
+
+	// Keep only what still needs another attempt
+	if len(stillFailing) > 0 {
+		report.Failures = stillFailing
+		if err := saveFailureReport(reportFile, report); err != nil {
+			ui.LogError("%v", err)
+		}
+		ui.LogWarning("%d commits still failing, kept in %s", len(stillFailing), reportFile)
+	} else if err := os.Remove(reportFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		ui.LogWarning("Failed to remove failure report %s: %v", reportFile, err)
+	} else {
+		ui.LogSuccess("All failed commits were retried successfully")
+	}
+	finishProgressWebhook("finished")
+	ui.UpdateStatus("Retry completed. Press Ctrl+C to exit")
+}
+
+// patchChangesFile replaces or adds the dry run entries of the retried commits
+func patchChangesFile(changesFile string, messages map[string]string, filesChanged map[string]int) {
+	outputs, _ := loadExistingDryRunResults(changesFile)
+	for i, output := range outputs {
+		if message, ok := messages[output.CommitID]; ok {
+			outputs[i].RewrittenMsg = message
+			outputs[i].FilesChanged = filesChanged[output.CommitID]
+			outputs[i].Skipped = false
+			outputs[i].SkipReason = ""
+			delete(messages, output.CommitID)
+		}
+	}
+
+	repo, err := git.PlainOpen(RepoPath)
+	if err != nil {
+		ui.LogError("Failed to open repository: %v", err)
+		return
+	}
+	for commitID, message := range messages {
+		original := ""
+		if commit, err := services.GetCommitDetails(repo, commitID, 0, nil); err == nil {
+			original = strings.TrimSpace(commit.Message)
+		}
+		outputs = append(outputs, models.RewriteOutput{
+			CommitID:     commitID,
+			OriginalMsg:  original,
+			RewrittenMsg: message,
+			FilesChanged: filesChanged[commitID],
+		})
+	}
+
+	savePartialDryRunResults(changesFile, outputs)
+	ui.LogSuccess("Patched retried commits into %s", changesFile)
+}
+
+// patchTargetRepo rewords the retried commits in the new repository of the previous run.
+// Commits are reworded newest first, since rewording a commit rewrites every commit
+// after it but leaves older hashes valid. failing lists the commits that failed again,
+// and is returned with the ones that cannot be patched, their new hashes and the commit
+// map updated to the reworded history.
+func patchTargetRepo(report models.FailureReport, messages map[string]string, failing []models.FailedCommit) []models.FailedCommit {
+	if err := checkPatchableTarget(report.TargetRepoPath); err != nil {
+		ui.LogError("Cannot patch %s: %v", report.TargetRepoPath, err)
+		for _, failure := range report.Failures {
+			if _, ok := messages[failure.CommitID]; ok {
+				failure.Error = err.Error()
+				failing = append(failing, failure)
+			}
+		}
+		return failing
+	}
+	before, err := services.ListCommits(report.TargetRepoPath)
+	if err != nil {
+		ui.LogWarning("Commit map and failure report will not be updated: %v", err)
+	}
+
+	for i := len(report.Failures) - 1; i >= 0; i-- {
+		failure := report.Failures[i]
+		message, ok := messages[failure.CommitID]
+		if !ok {
+			continue
+		}
+		if failure.NewID == "" {
+			ui.LogError("Commit %s was never applied to %s and cannot be patched", failure.CommitID[:8], report.TargetRepoPath)
+			failing = append(failing, failure)
+			continue
+		}
+
+		ui.UpdateStatus(fmt.Sprintf("Rewording commit %s in target repository...", failure.NewID[:8]))
+		if err := services.RewordCommit(report.TargetRepoPath, failure.NewID, message); err != nil {
+			ui.LogError("Failed to reword commit %s: %v", failure.NewID[:8], err)
+			failure.Error = err.Error()
+			failing = append(failing, failure)
+			continue
+		}
+		ui.LogSuccess("Reworded commit %s in %s", failure.NewID[:8], report.TargetRepoPath)
+	}
+
+	if before != nil {
+		remapTargetCommits(report.TargetRepoPath, before, failing)
+	}
+	return failing
+}
+
+// checkPatchableTarget returns why the commits of a target repository cannot be
+// reworded, or nil if they can. Rewording rebases the current branch, which needs a
+// working tree, would flatten merges and leaves replace refs and notes on the old hashes.
+func checkPatchableTarget(targetRepoPath string) error {
+	if services.IsBareRepository(targetRepoPath) {
+		return fmt.Errorf("it is bare, and commits can only be reworded in a working tree. Clone it, retry against the clone, then push it back")
+	}
+	if _, err := os.Stat(filepath.Join(targetRepoPath, ".git")); err != nil {
+		return fmt.Errorf("not found: %v", err)
+	}
+	merges, err := services.CountMergeCommits(targetRepoPath)
+	if err != nil {
+		return err
+	}
+	if merges > 0 {
+		return fmt.Errorf("it has %d merge commits, which rewording would flatten", merges)
+	}
+	refs, err := services.ListRefs(targetRepoPath, "refs/replace/", "refs/notes/")
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 {
+		return fmt.Errorf("it has replace refs or notes, e.g. %s, which would keep pointing at the old commits", refs[0])
+	}
+	return nil
+}
+
+// remapTargetCommits points the commit map and the failing commits at the new hashes of
+// the target repository. before lists its commits ahead of the rewording, oldest first,
+// and rewording keeps their order on the linear history.
+func remapTargetCommits(targetRepoPath string, before []string, failing []models.FailedCommit) {
+	after, err := services.ListCommits(targetRepoPath)
+	if err != nil || len(after) != len(before) {
+		ui.LogWarning("Commit map and failure report could not be updated to the reworded hashes of %s", targetRepoPath)
+		return
+	}
+	newIDs := make(map[string]string, len(before))
+	for i, id := range before {
+		newIDs[id] = after[i]
+	}
+	for i, failure := range failing {
+		if newID, ok := newIDs[failure.NewID]; ok {
+			failing[i].NewID = newID
+		}
+	}
+
+	hashMap, err := services.ReadCommitMap(commitMapPath())
+	if err != nil {
+		return
+	}
+	for originalID, newID := range hashMap {
+		if id, ok := newIDs[newID]; ok {
+			hashMap[originalID] = id
+		}
+	}
+	if err := services.WriteCommitMap(commitMapPath(), hashMap); err != nil {
+		ui.LogError("%v", err)
+		return
+	}
+	ui.LogInfo("Commit map %s updated to the reworded hashes", commitMapPath())
+}
@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+)
+
+// ReviewChangesMode walks through the proposed messages of a dry run changes file in
+// the TUI, showing each next to the original message and the changed files. Accepted
+// and edited messages are kept, rejected ones are marked as skipped so the commit keeps
+// its original message, and the curated changes are written back to changesFile, or to
+// -output when it is set. Aborting keeps the remaining entries as they are.
+func ReviewChangesMode(repoPath, changesFile string) error {
+	if ui.Headless {
+		ui.LogError("Reviewing a changes file is not available with -no-tui")
+		ui.UpdateStatus("Error: Reviewing requires the TUI")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Reviewing a changes file is not available with -no-tui")
+	}
+
+	ui.UpdateStatus("Loading changes file...")
+	data, err := os.ReadFile(changesFile)
+	if err != nil {
+		ui.LogError("Failed to read changes file: %v", err)
+		ui.UpdateStatus("Error: Failed to read changes file")
+		return err
+	}
+	var changes []models.RewriteOutput
+	if err := json.Unmarshal(data, &changes); err != nil {
+		ui.LogError("Failed to parse changes file: %v", err)
+		ui.UpdateStatus("Error: Failed to parse changes file")
+		return err
+	}
+	ui.LogInfo("Loaded %d change entries from %s", len(changes), changesFile)
+
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		ui.LogError("Failed to open repository: %v", err)
+		ui.UpdateStatus("Error: Failed to open repository")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Failed to open repository at %s: %v", repoPath, err)
+	}
+
+	ui.SetPhase("Review")
+	ui.TotalCommits = len(changes)
+	ui.ProcessedCommits = 0
+	ui.StartTime = time.Now()
+	ui.UpdateProgressBar()
+
+	accepted, edited, rejected := 0, 0, 0
+	for i := range changes {
+		change := &changes[i]
+		if change.Skipped {
+			ui.ProcessedCommits++
+			continue
+		}
+
+		files, err := services.ChangedFiles(repo, change.CommitID)
+		if err != nil {
+			ui.LogWarning("Failed to list changed files for %s: %v", change.CommitID[:8], err)
+		}
+		ui.UpdateStatus(fmt.Sprintf("Review the message for commit %s", change.CommitID[:8]))
+		action, message := ui.ReviewMessage(change.CommitID, files, change.OriginalMsg, change.RewrittenMsg)
+		if action == ui.ReviewAbort {
+			ui.LogInfo("Review aborted, keeping the remaining %d entries as they are", len(changes)-i)
+			break
+		}
+
+		switch {
+		case action == ui.ReviewSkip:
+			change.RewrittenMsg = strings.TrimSpace(change.OriginalMsg)
+			change.Skipped = true
+			change.SkipReason = "rejected"
+			rejected++
+		case message != strings.TrimSpace(change.RewrittenMsg):
+			change.RewrittenMsg = message
+			edited++
+		default:
+			accepted++
+		}
+		ui.ProcessedCommits++
+		ui.UpdateProgressBar()
+	}
+
+	outputPath := changesFile
+	if OutputFile != "" {
+		outputPath = OutputFile
+	}
+	outputData, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		ui.LogError("Failed to marshal reviewed changes: %v", err)
+		ui.UpdateStatus("Error: Failed to save reviewed changes")
+		return err
+	}
+	if err := os.WriteFile(outputPath, outputData, 0644); err != nil {
+		ui.LogError("Failed to write reviewed changes: %v", err)
+		ui.UpdateStatus("Error: Failed to save reviewed changes")
+		return err
+	}
+	ui.LogSuccess("Reviewed changes saved to %s: %d accepted, %d edited, %d rejected", outputPath, accepted, edited, rejected)
+	writeHTMLReport(HTMLReportFile, changes)
+	writeReport(ReportFile, changes)
+	ui.UpdateStatus("Review completed. Press Ctrl+C to exit")
+	return nil
+}
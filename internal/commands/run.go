@@ -1,13 +1,15 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"syscall"
 	"time"
@@ -15,12 +17,27 @@ import (
 	"github.com/MrLemur/gitrewrite/internal/models"
 	"github.com/MrLemur/gitrewrite/internal/services"
 	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/MrLemur/gitrewrite/pkg/events"
+	"github.com/MrLemur/gitrewrite/pkg/helpers"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 // Local reference to the model context size
 var modelContextSize int
 
+// appliedCommits records every commit applied to the new repository, oldest first
+var appliedCommits []models.CommitMapping
+
+// rewrittenIDs maps the applied commits' original hashes to their new hashes
+var rewrittenIDs = make(map[string]string)
+
+// appliedMessages maps the applied commits' original hashes to their new messages
+var appliedMessages = make(map[string]string)
+
+// importer streams applied commits into the new repository with -apply-method=fast-import
+var importer *services.FastImporter
+
 // Helper function to check if a file should be excluded
 func shouldExcludeFile(path string, excludePattern *regexp.Regexp) bool {
 	if excludePattern == nil {
@@ -29,32 +46,413 @@ func shouldExcludeFile(path string, excludePattern *regexp.Regexp) bool {
 	return excludePattern.MatchString(path)
 }
 
+// filterExcludedFiles returns the files whose paths don't match excludePattern
+func filterExcludedFiles(files []models.File, excludePattern *regexp.Regexp) []models.File {
+	if excludePattern == nil {
+		return files
+	}
+	var filteredFiles []models.File
+	for _, file := range files {
+		if !shouldExcludeFile(file.Path, excludePattern) {
+			filteredFiles = append(filteredFiles, file)
+		}
+	}
+	return filteredFiles
+}
+
 // RunApplication runs the main application logic
 func RunApplication() {
+	runStarted = time.Now()
 	if RepoPath == "" {
 		fmt.Println("Please provide a path to a git repository using -repo=/path/to/repo")
 		os.Exit(1)
 	}
+	for _, warning := range deprecationWarnings {
+		ui.LogWarning("%s", warning)
+	}
+
+	if ConfigFile != "" {
+		if err := LoadConfig(ConfigFile); err != nil {
+			ui.LogError("%v", err)
+			ui.UpdateStatus("Error: Failed to load config file")
+			time.Sleep(2 * time.Second)
+			ui.Stop()
+			log.Fatalf("Failed to load config file: %v", err)
+		}
+		ui.LogInfo("Loaded configuration from %s", ConfigFile)
+	}
+
+	if CommitterName == "" {
+		CommitterName = AppConfig.CommitterName
+	}
+	if CommitterEmail == "" {
+		CommitterEmail = AppConfig.CommitterEmail
+	}
+	services.CommitterName, services.CommitterEmail = CommitterName, CommitterEmail
+	services.ResetCommitter = ResetCommitter
+	if CommitterName != "" || CommitterEmail != "" {
+		ui.LogInfo("Overriding committer identity of applied commits (name: %q, email: %q)", CommitterName, CommitterEmail)
+	}
+	if AuthorMapFile != "" {
+		authors, err := services.LoadAuthorMap(AuthorMapFile)
+		if err != nil {
+			ui.LogError("%v", err)
+			ui.UpdateStatus("Error: Failed to load author map")
+			time.Sleep(2 * time.Second)
+			ui.Stop()
+			log.Fatalf("Failed to load author map: %v", err)
+		}
+		services.Authors = authors
+		ui.LogInfo("Mapping identities of applied commits with %d entries from %s", authors.Len(), AuthorMapFile)
+	}
+
+	// Canned messages of the mock generator are kept out of the cache
+	services.MessageCacheDir = CacheDir
+	if MockLLM {
+		services.MessageCacheDir = ""
+	} else if CacheDir != "" && NoCache {
+		ui.LogInfo("Regenerating every message, new results replace those cached in %s", CacheDir)
+	} else if CacheDir != "" {
+		ui.LogInfo("Caching generated messages in %s", CacheDir)
+	}
+	services.RefreshCache = NoCache
+
+	services.LLMRetries, services.LLMRetryBackoff = LLMRetries, LLMRetryBackoff
+	services.KeepAlive = KeepAlive
+	services.GenerateBody = GenerateBody
+	services.Style = Style
+	services.MapReduce = MapReduce
+	tokens, err := services.NewTokenCounter(Tokenizer)
+	if err != nil {
+		ui.LogError("%v", err)
+		ui.UpdateStatus("Error: Invalid -tokenizer value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("%v", err)
+	}
+	services.Tokens = tokens
+	services.Language = strings.TrimSpace(Language)
+	if services.Language != "" {
+		ui.LogInfo("Writing messages in %s", services.LanguageName(services.Language))
+	}
+
+	if PromptFile == "" {
+		PromptFile = AppConfig.PromptFile
+	}
+	if PromptFile != "" {
+		if err := services.LoadPromptTemplate(PromptFile); err != nil {
+			ui.LogError("%v", err)
+			ui.UpdateStatus("Error: Failed to load prompt file")
+			time.Sleep(2 * time.Second)
+			ui.Stop()
+			log.Fatalf("%v", err)
+		}
+		ui.LogInfo("Using the prompt template in %s", PromptFile)
+	}
+
+	if MessageTemplate == "" {
+		MessageTemplate = AppConfig.MessageTemplate
+	}
+	if MessageTemplate != "" {
+		if err := loadMessageTemplate(MessageTemplate); err != nil {
+			ui.LogError("%v", err)
+			ui.UpdateStatus("Error: Invalid message template")
+			time.Sleep(2 * time.Second)
+			ui.Stop()
+			log.Fatalf("%v", err)
+		}
+	}
+	if Style == services.StyleCustom && (PromptFile == "" || MessageTemplate == "") {
+		ui.LogError("-style=custom needs a -prompt-file and a -message-template")
+		ui.UpdateStatus("Error: Incomplete custom style")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("-style=custom needs a -prompt-file and a -message-template")
+	}
+	if MessageSeparator != "" {
+		separator, err := parseSubjectSeparator(MessageSeparator)
+		if err != nil {
+			ui.LogError("%v", err)
+			ui.UpdateStatus("Error: Invalid message separator")
+			time.Sleep(2 * time.Second)
+			ui.Stop()
+			log.Fatalf("%v", err)
+		}
+		subjectSeparator = separator
+	}
+
+	if FilterPaths != "" {
+		filterPattern, err := regexp.Compile(FilterPaths)
+		if err != nil {
+			ui.LogError("Invalid -filter-paths pattern: %v", err)
+			ui.UpdateStatus("Error: Invalid -filter-paths pattern")
+			time.Sleep(2 * time.Second)
+			ui.Stop()
+			log.Fatalf("Invalid -filter-paths pattern: %v", err)
+		}
+		services.FilteredPaths = filterPattern
+		ui.LogInfo("Removing paths matching %s from the applied commits", FilterPaths)
+	}
+	if ForceWithLease && PushTo == "" {
+		ui.LogError("-force-with-lease needs a -push-to remote")
+		ui.UpdateStatus("Error: -force-with-lease needs -push-to")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("-force-with-lease needs a -push-to remote")
+	}
+	if PushTo != "" && DryRun {
+		ui.LogWarning("-push-to is ignored in a dry run, nothing is pushed")
+	}
+	if KeepOriginal != "" && KeepOriginal != "trailer" && KeepOriginal != "footer" && KeepOriginal != "note" {
+		ui.LogError("Invalid -keep-original value %q: must be 'trailer', 'footer' or 'note'", KeepOriginal)
+		ui.UpdateStatus("Error: Invalid -keep-original value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -keep-original value %q: must be 'trailer', 'footer' or 'note'", KeepOriginal)
+	}
+	if ReportFile != "" && reportFormat(ReportFile) == "" {
+		ui.LogError("Invalid -report file %q: must end in .md or .html", ReportFile)
+		ui.UpdateStatus("Error: Invalid -report file")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -report file %q: must end in .md or .html", ReportFile)
+	}
+	if RepoReport != "" && RepoReport != "file" && RepoReport != "notes" {
+		ui.LogError("Invalid -repo-report value %q: must be 'file' or 'notes'", RepoReport)
+		ui.UpdateStatus("Error: Invalid -repo-report value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -repo-report value %q: must be 'file' or 'notes'", RepoReport)
+	}
+
+	if err := resolveScanLimits(RepoPath); err != nil {
+		ui.LogError("%v", err)
+		ui.UpdateStatus("Error: Invalid commit selection")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid commit selection: %v", err)
+	}
+
+	if ApplyMethod != "fast-import" && ApplyMethod != "worktree" {
+		ui.LogError("Invalid -apply-method value %q: must be 'fast-import' or 'worktree'", ApplyMethod)
+		ui.UpdateStatus("Error: Invalid -apply-method value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -apply-method value %q: must be 'fast-import' or 'worktree'", ApplyMethod)
+	}
+	if AllRefs && (ApplyMethod != "fast-import" || FirstParent) {
+		ui.LogError("-all-refs can't be combined with -first-parent or -apply-method=worktree")
+		ui.UpdateStatus("Error: Invalid -all-refs combination")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("-all-refs requires -apply-method=fast-import and can't be combined with -first-parent")
+	}
+	if Bare && (ApplyMethod != "fast-import" || InPlace || RepoReport == "file") {
+		ui.LogError("-bare requires -apply-method=fast-import and can't be combined with -in-place or -repo-report=file")
+		ui.UpdateStatus("Error: Invalid -bare combination")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("-bare requires -apply-method=fast-import and can't be combined with -in-place or -repo-report=file")
+	}
+	if (SignCommits || SigningKey != "") && ApplyMethod != "worktree" {
+		ui.LogError("-sign requires -apply-method=worktree, git fast-import can't sign commits")
+		ui.UpdateStatus("Error: -sign requires -apply-method=worktree")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("-sign requires -apply-method=worktree, git fast-import can't sign commits")
+	}
+	services.SignCommits = SignCommits || SigningKey != ""
+	services.SigningKey = SigningKey
+	if WebhookURL != "" && WebhookInterval <= 0 {
+		ui.LogError("Invalid -webhook-interval value %s: must be positive", WebhookInterval)
+		ui.UpdateStatus("Error: Invalid -webhook-interval value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -webhook-interval value %s: must be positive", WebhookInterval)
+	}
+	if Concurrency < 1 {
+		ui.LogError("Invalid -concurrency value %d: must be at least 1", Concurrency)
+		ui.UpdateStatus("Error: Invalid -concurrency value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -concurrency value %d: must be at least 1", Concurrency)
+	}
+	if BatchSize < 1 || BatchTokens < 1 {
+		ui.LogError("Invalid -batch-size %d or -batch-tokens %d: both must be at least 1", BatchSize, BatchTokens)
+		ui.UpdateStatus("Error: Invalid -batch-size or -batch-tokens value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -batch-size %d or -batch-tokens %d: both must be at least 1", BatchSize, BatchTokens)
+	}
+	if BatchSize > 1 && PromptFile != "" {
+		ui.LogWarning("-batch-size is not available with -prompt-file, sending one commit per request")
+		BatchSize = 1
+	}
+	if err := applyPhases(); err != nil {
+		ui.LogError("Invalid -phases value: %v", err)
+		ui.UpdateStatus("Error: Invalid -phases value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -phases value: %v", err)
+	}
+	if InPlace && (ApplyMethod != "fast-import" || OutputRepoName != "" || OutputDir != "") {
+		ui.LogError("-in-place requires -apply-method=fast-import and can't be combined with -output-repo or -output-dir")
+		ui.UpdateStatus("Error: Invalid -in-place combination")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("-in-place requires -apply-method=fast-import and can't be combined with -output-repo or -output-dir")
+	}
+	switch Select {
+	case services.SelectLength, services.SelectNonConventional, services.SelectLLMScore, services.SelectAll:
+	default:
+		ui.LogError("Invalid -select value %q: must be 'length', 'non-conventional', 'llm-score' or 'all'", Select)
+		ui.UpdateStatus("Error: Invalid -select value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -select value %q: must be 'length', 'non-conventional', 'llm-score' or 'all'", Select)
+	}
+	if Reverts != "canonical" && Reverts != "keep" && Reverts != "rewrite" {
+		ui.LogError("Invalid -reverts value %q: must be 'canonical', 'keep' or 'rewrite'", Reverts)
+		ui.UpdateStatus("Error: Invalid -reverts value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -reverts value %q: must be 'canonical', 'keep' or 'rewrite'", Reverts)
+	}
+	if Style != services.StyleConventional && Style != services.StyleGitmoji && Style != services.StylePlain && Style != services.StyleCustom {
+		ui.LogError("Invalid -style value %q: must be 'conventional', 'gitmoji', 'plain' or 'custom'", Style)
+		ui.UpdateStatus("Error: Invalid -style value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -style value %q: must be 'conventional', 'gitmoji', 'plain' or 'custom'", Style)
+	}
+	if _, ok := changelogSections[ChangelogFormat]; !ok {
+		ui.LogError("Invalid -changelog-format value %q: must be 'keep-a-changelog' or 'conventional'", ChangelogFormat)
+		ui.UpdateStatus("Error: Invalid -changelog-format value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -changelog-format value %q: must be 'keep-a-changelog' or 'conventional'", ChangelogFormat)
+	}
+	if Select == services.SelectLLMScore && (scanOnly || HistoryDiffFile != "") {
+		ui.LogError("-select=llm-score grades messages with the model and can't be combined with -phases=scan or -history-diff")
+		ui.UpdateStatus("Error: Invalid -select combination")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("-select=llm-score grades messages with the model and can't be combined with -phases=scan or -history-diff")
+	}
+	if Select == services.SelectLLMScore && (MinScore < 1 || MinScore > 10) {
+		ui.LogError("Invalid -min-score value %d: must be between 1 and 10", MinScore)
+		ui.UpdateStatus("Error: Invalid -min-score value")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid -min-score value %d: must be between 1 and 10", MinScore)
+	}
+	if VerifyOnly && ApplyChangesFile == "" && ReplayFile == "" {
+		ui.LogError("-verify-only requires -apply-changes or -replay")
+		ui.UpdateStatus("Error: -verify-only requires -apply-changes or -replay")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("-verify-only requires -apply-changes or -replay")
+	}
+
+	if AuditLogFile != "" {
+		if err := services.OpenAuditLog(AuditLogFile); err != nil {
+			ui.LogError("%v", err)
+			ui.UpdateStatus("Error: Failed to open audit log")
+			time.Sleep(2 * time.Second)
+			ui.Stop()
+			log.Fatalf("Failed to open audit log %s: %v", AuditLogFile, err)
+		}
+		ui.LogInfo("Recording prompts, responses and messages in %s", AuditLogFile)
+	}
+
+	if ProgressTarget != "" {
+		if err := openProgressStream(ProgressTarget); err != nil {
+			ui.LogError("%v", err)
+			ui.UpdateStatus("Error: Failed to open progress stream")
+			time.Sleep(2 * time.Second)
+			ui.Stop()
+			log.Fatalf("Failed to open progress stream: %v", err)
+		}
+	}
+
+	// If a history comparison is requested, render it without rewriting anything
+	if HistoryDiffFile != "" {
+		ui.LogInfo("Comparing source and planned history, writing to %s", HistoryDiffFile)
+		HistoryDiffMode(RepoPath, HistoryDiffFile)
+		ui.WaitForExit()
+	}
+
+	// verify-trees checks an earlier rewrite without rewriting anything
+	if verifyTreesOnly {
+		VerifyTreesMode(RepoPath, verifyTreesTarget)
+		ui.WaitForExit()
+	}
+
+	// -phases=scan only scans the history and writes the run plan
+	if scanOnly {
+		ui.LogInfo("Scanning only, writing the run plan to %s", PlanOutputFile)
+		ScanMode(RepoPath)
+		ui.WaitForExit()
+	}
+
+	// If message statistics are requested, analyze the history without rewriting anything
+	if StatsFile != "" {
+		ui.LogInfo("Analyzing commit message quality, writing to %s", StatsFile)
+		StatsMode(RepoPath, StatsFile)
+		ui.WaitForExit()
+	}
+
+	// Replaying an audit log applies its messages like a changes file
+	if ReplayFile != "" {
+		ui.LogInfo("Running in replay mode using audit log: %s", ReplayFile)
+		ReplayMode(RepoPath, ReplayFile)
+		ui.UpdateStatus("Press Ctrl+C to exit")
+		ui.WaitForExit()
+	}
+
+	// Reviewing a changes file curates it without applying anything
+	if ReviewChangesFile != "" {
+		ui.LogInfo("Reviewing changes file: %s", ReviewChangesFile)
+		ReviewChangesMode(RepoPath, ReviewChangesFile)
+		ui.WaitForExit()
+	}
 
 	// If apply-changes mode is specified, run that mode and exit afterward.
 	if ApplyChangesFile != "" {
 		ui.LogInfo("Running in apply-changes mode using file: %s", ApplyChangesFile)
 		ApplyChangesMode(RepoPath, ApplyChangesFile)
 		ui.UpdateStatus("Press Ctrl+C to exit")
-		select {}
+		ui.WaitForExit()
 	}
 
-	// Check Ollama availability and get model context size
-	ui.UpdateStatus("Checking Ollama availability...")
-	ui.LogInfo("Checking if Ollama is available...")
-	if err := services.CheckOllamaAvailability(); err != nil {
-		ui.LogError("Failed to connect to Ollama: %v", err)
-		ui.UpdateStatus("Error: Failed to connect to Ollama")
+	// Check backend availability and get model context size
+	client, err := newLLMClient()
+	if err != nil {
+		ui.LogError("Invalid backend configuration: %v", err)
+		ui.UpdateStatus("Error: Invalid backend configuration")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Invalid backend configuration: %v", err)
+	}
+	if MockLLM {
+		ui.LogWarning("Using the mock generator instead of %s, messages are made up from the changed files", client.Name())
+		client = services.MockClient{}
+		Model = "mock-llm"
+	}
+	services.Client = client
+	ui.UpdateStatus(fmt.Sprintf("Checking %s availability...", client.Name()))
+	ui.LogInfo("Checking if %s is available...", client.Name())
+	if err := client.CheckAvailability(); err != nil {
+		ui.LogError("Failed to connect to %s: %v", client.Name(), err)
+		ui.UpdateStatus(fmt.Sprintf("Error: Failed to connect to %s", client.Name()))
 		time.Sleep(2 * time.Second)
-		ui.App.Stop()
-		log.Fatalf("Failed to connect to Ollama: %v", err)
+		ui.Stop()
+		log.Fatalf("Failed to connect to %s: %v", client.Name(), err)
 	}
 
+	checkWorktree(RepoPath)
+	lockRepository(RepoPath)
+
 	// Verify the repository is on the main branch before proceeding
 	ui.UpdateStatus("Checking repository branch...")
 	ui.LogInfo("Verifying repository is on the main branch...")
@@ -63,7 +461,7 @@ func RunApplication() {
 		ui.LogError("Failed to determine current branch: %v", err)
 		ui.UpdateStatus("Error: Failed to determine current branch")
 		time.Sleep(2 * time.Second)
-		ui.App.Stop()
+		ui.Stop()
 		log.Fatalf("Failed to determine current branch: %v", err)
 	}
 	
@@ -78,61 +476,66 @@ func RunApplication() {
 		ui.LogError("Repository must be on the default branch (%s) to proceed. Currently on: %s", defaultBranch, currentBranch)
 		ui.UpdateStatus(fmt.Sprintf("Error: Repository must be on %s branch", defaultBranch))
 		time.Sleep(2 * time.Second)
-		ui.App.Stop()
+		ui.Stop()
 		log.Fatalf("Repository must be on the default branch (%s) to proceed. Please checkout the default branch first.", defaultBranch)
 	}
 	ui.LogInfo("Verified repository is on the default branch: %s", defaultBranch)
 
+	if ollamaClient, ok := client.(services.OllamaClient); ok {
+		ensureOllamaModel(ollamaClient)
+	}
+
 	ui.UpdateStatus("Getting model information...")
 	ui.LogInfo("Getting context size for model: %s", Model)
-	contextSize, err := services.GetModelContextSize(Model)
+	contextSize, err := client.ContextSize(Model)
 	if err != nil {
 		ui.LogError("Failed to get context size for model %s: %v", Model, err)
 		ui.UpdateStatus("Error: Failed to determine model context size")
 		time.Sleep(2 * time.Second)
-		ui.App.Stop()
+		ui.Stop()
 		log.Fatalf("Failed to determine context size for model %s: %v", Model, err)
 	}
 	modelContextSize = contextSize // Use our local variable
 	ui.LogInfo("Using context size of %d tokens for model %s", modelContextSize, Model)
 
-	// Determine the output repository name
-	var newRepoName string
-	if OutputRepoName != "" {
-		// Use the specified output repository name
-		newRepoName = OutputRepoName
-	} else {
-		// Use the default name based on the original repository name
-		repoName := services.GetRepoName(RepoPath)
-		newRepoName = repoName + "-rewritten"
+	// Load the model up front so the first commit's timing isn't skewed by the cold start
+	if WarmUpModel {
+		warmUpModel(client)
+	}
+
+	// If a failure report is given, only retry the commits it lists
+	if RetryFailedFile != "" {
+		ui.LogInfo("Retrying failed commits from %s", RetryFailedFile)
+		RetryFailedMode(RepoPath, RetryFailedFile)
+		ui.WaitForExit()
 	}
 
+	// Determine the output repository name
+	newRepoName := newRepositoryName(RepoPath)
+
 	// We need the new repo path for later operations
 	var newRepoPath string
 
 	if DryRun {
 		ui.LogInfo("Running in dry run mode - changes will not be applied")
+	} else if InPlace {
+		newRepoPath = inPlaceRepoPath(RepoPath)
+		ui.LogInfo("Rewriting the history of %s in place", newRepoPath)
 	} else {
 		ui.UpdateStatus("Creating new repository...")
 		ui.LogInfo("Creating new repository with name %s", newRepoName)
-		if err := services.CreateNewRepository(RepoPath, newRepoName, defaultBranch); err != nil {
+		newRepoPath = services.NewRepositoryPath(RepoPath, OutputDir, newRepoName)
+		if StateDBFile != "" {
+			removePartialRepository(newRepoPath)
+		}
+		if err := services.CreateNewRepository(newRepoPath, defaultBranch, Bare); err != nil {
 			ui.LogError("Failed to create new repository: %v", err)
 			ui.UpdateStatus("Error: Failed to create new repository")
 			time.Sleep(2 * time.Second)
-			ui.App.Stop()
+			ui.Stop()
 			log.Fatalf("Failed to create new repository: %v", err)
 		}
-		
-		// Get the full path to the new repository
-		absSourcePath, err := filepath.Abs(RepoPath)
-		if err != nil {
-			ui.LogWarning("Failed to get absolute path for source repository: %v", err)
-			absSourcePath = filepath.Clean(RepoPath)
-		} else {
-			absSourcePath = filepath.Clean(absSourcePath)
-		}
-		sourceParentDir := filepath.Dir(absSourcePath)
-		newRepoPath = filepath.Join(sourceParentDir, newRepoName)
+		lockRepository(newRepoPath)
 		ui.LogInfo("New repository located at %s", newRepoPath)
 		
 		// Configure the new repository with same branch name and remote as source
@@ -163,7 +566,7 @@ func RunApplication() {
 		ui.LogError("Failed to open repository: %v", err)
 		ui.UpdateStatus("Error: Failed to open repository")
 		time.Sleep(2 * time.Second)
-		ui.App.Stop()
+		ui.Stop()
 		log.Fatalf("Failed to open repository at %s: %v", RepoPath, err)
 	}
 
@@ -176,7 +579,7 @@ func RunApplication() {
 			ui.LogError("Invalid exclude pattern: %v", err)
 			ui.UpdateStatus("Error: Invalid exclude pattern")
 			time.Sleep(2 * time.Second)
-			ui.App.Stop()
+			ui.Stop()
 			log.Fatalf("Invalid exclude pattern: %v", err)
 		}
 		ui.LogInfo("Using exclude pattern: %s", ExcludeFiles)
@@ -184,14 +587,32 @@ func RunApplication() {
 
 	// Get commits to rewrite in chronological order (oldest to newest)
 	ui.UpdateStatus("Getting commits in chronological order...")
-	allCommits, commitsToRewrite, err := services.GetCommitsChronological(repo, MaxMsgLength, MaxDiffLength)
+	opts := scanOptions()
+	opts.MessagesOnly = lazyDiffExtraction()
+	if Select == services.SelectLLMScore {
+		ui.LogInfo("Grading existing messages with %s, rewriting those below %d of 10", Model, MinScore)
+		opts.Score = scoreMessage
+	}
+	allCommits, commitsToRewrite, err := services.GetCommitsChronological(repo, opts)
 	if err != nil {
 		ui.LogError("Failed to get commits in chronological order: %v", err)
 		ui.UpdateStatus("Error: Failed to get commits")
 		time.Sleep(2 * time.Second)
-		ui.App.Stop()
+		ui.Stop()
 		log.Fatalf("Failed to get commits from repository at %s: %v", RepoPath, err)
 	}
+	rememberSideBranchCommits(allCommits)
+	if StateDBFile != "" {
+		openRunState(allCommits, newRepoPath)
+	}
+	for _, commit := range allCommits {
+		events.Scanned(events.CommitScanned{
+			CommitID:     commit.CommitID,
+			Message:      commit.Message,
+			NeedsRewrite: commit.NeedsRewrite,
+			SideBranch:   commit.SideBranch,
+		})
+	}
 
 	ui.TotalCommits = len(allCommits)
 	ui.ProcessedCommits = 0
@@ -203,15 +624,17 @@ func RunApplication() {
 	if ui.TotalCommits == 0 {
 		ui.LogInfo("No commits to process. Exiting.")
 		ui.UpdateStatus("No commits to process. Press Ctrl+C to exit")
-		select {}
+		ui.WaitForExit()
 	}
 
 	// Set up a channel to catch interrupt signals for clean exit
+	stopEarlySignals()
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
 	// Set up a tracker for completion
 	done := make(chan bool, 1)
+	aborted := make(chan bool, 1)
 
 	var rewriteOutputs []models.RewriteOutput
 
@@ -240,34 +663,135 @@ func RunApplication() {
 
 	// If not in dry run mode, calculate the new repo path for the confirmation message
 	if !DryRun && newRepoPath == "" {
-		absSourcePath, err := filepath.Abs(RepoPath)
-		if err != nil {
-			ui.LogWarning("Failed to get absolute path for source repository: %v", err)
-			absSourcePath = filepath.Clean(RepoPath)
+		newRepoPath = services.NewRepositoryPath(RepoPath, OutputDir, newRepoName)
+	}
+
+	// Let the user toggle commits out of the rewrite set before starting
+	excludedCommits := make(map[string]bool)
+	if BrowseCommits {
+		if ui.Headless {
+			ui.LogWarning("The commit browser is not available with -no-tui, rewriting all commits")
 		} else {
-			absSourcePath = filepath.Clean(absSourcePath)
+			excludedCommits = browseCommitsToRewrite(repo, commitsToRewrite)
+			var selected []models.CommitOutput
+			for _, commit := range commitsToRewrite {
+				if !excludedCommits[commit.CommitID] {
+					selected = append(selected, commit)
+				}
+			}
+			commitsToRewrite = selected
+		}
+	}
+
+	mode, targetPath := "rewrite", newRepoPath
+	if DryRun {
+		mode, targetPath = "dry-run", ""
+	}
+	plan := buildRunPlan(mode, targetPath, Model, ui.TotalCommits, len(commitsToRewrite), commitsToRewrite)
+	for _, commit := range allCommits {
+		if excludedCommits[commit.CommitID] {
+			plan.ExcludedCommits = append(plan.ExcludedCommits, commit.CommitID)
 		}
-		sourceParentDir := filepath.Dir(absSourcePath)
-		newRepoPath = filepath.Join(sourceParentDir, newRepoName)
+	}
+	if err := writeRunPlan(plan); err != nil {
+		ui.LogError("%v", err)
+	}
+	if MaxTotalTokens > 0 && plan.EstimatedTokens > MaxTotalTokens {
+		ui.LogWarning("Estimated %d prompt tokens exceed the budget of %d, the run will stop before all commits are rewritten", plan.EstimatedTokens, MaxTotalTokens)
 	}
 
 	// Add confirmation dialog if not in dry run mode
 	if !DryRun {
-		confirmMessage := fmt.Sprintf("%d total commits found, %d will be rewritten with improved messages. All commits will be applied to a new repository at %s.\n\nThis operation will create a new repository with the same files but improved commit messages.\n\n'No' is selected by default. Use Tab to select 'Yes' if you want to proceed.", ui.TotalCommits, len(commitsToRewrite), newRepoPath)
-		confirmed := ui.ShowConfirmationDialog(confirmMessage)
+		var rewriteIDs []string
+		for _, commit := range commitsToRewrite {
+			rewriteIDs = append(rewriteIDs, commit.CommitID)
+		}
+		checkPublishedHistory(rewriteIDs)
+
+		confirmMessage := fmt.Sprintf("%d total commits found, %d will be rewritten with improved messages. All commits will be applied to %s.\n\nThe files stay the same, only the commit messages are improved.", ui.TotalCommits, len(commitsToRewrite), applyTarget(newRepoPath))
+		confirmed := AssumeYes || ui.ShowConfirmationDialog(confirmMessage)
 		if !confirmed {
 			ui.LogInfo("User cancelled the operation. Exiting.")
-			ui.App.Stop()
+			ui.Stop()
 			os.Exit(0)
 		}
+		if InPlace {
+			prepareInPlace(newRepoPath)
+		}
+	}
+
+	if ReviewCommits && ui.Headless {
+		ui.LogWarning("Reviewing messages is not available with -no-tui, using generated messages as they are")
+		ReviewCommits = false
+	}
+
+	if DryRun {
+		ui.SetPhase("Generate")
+	} else {
+		ui.SetPhase("Rewrite")
+	}
+	startProgressWebhook(mode)
+
+	// With -concurrency or -batch-size, messages for the regular commits are generated
+	// ahead in a worker pool while the loop below still applies commits in order
+	var pool *services.GenerationPool
+	if Concurrency > 1 || BatchSize > 1 {
+		var pending []models.CommitOutput
+		for _, commit := range allCommits {
+			if !commit.NeedsRewrite || excludedCommits[commit.CommitID] || resumable(commit.CommitID) {
+				continue
+			}
+			commit.Files = filterExcludedFiles(commit.Files, excludePattern)
+			if len(commit.Files) <= MaxFilesPerCommit {
+				pending = append(pending, commit)
+			}
+		}
+		if BatchSize > 1 {
+			batches := services.PackCommitBatches(pending, BatchSize, BatchTokens)
+			ui.LogInfo("Generating messages for %d commits in %d requests with %d workers", len(pending), len(batches), Concurrency)
+			pool = services.NewBatchGenerationPool(batches, Concurrency, func(batch []models.CommitOutput) []services.GenerationResult {
+				// Paused workers start no new generations, so the model is left idle
+				ui.WaitWhilePaused()
+				return generateBatchMessages(batch)
+			})
+		} else {
+			ui.LogInfo("Generating messages for %d commits with %d workers", len(pending), Concurrency)
+			pool = services.NewGenerationPool(pending, Concurrency, func(commit models.CommitOutput) services.GenerationResult {
+				ui.WaitWhilePaused()
+				newCommit, timedOut, err := generateCommitMessage(commit)
+				return services.GenerationResult{Message: newCommit, TimedOut: timedOut, Err: err}
+			})
+		}
 	}
 
-	ui.LastCommitDetails.SetText("[yellow]No commits processed yet[white]")
+	// Without the diffs from the scan, those of the upcoming commits are extracted
+	// ahead on -diff-workers while earlier ones are generated
+	var diffPool *services.DiffPool
+	if workers := diffWorkers(); opts.MessagesOnly && workers > 1 {
+		var pending []string
+		for _, commit := range allCommits {
+			if commit.NeedsRewrite && !resumable(commit.CommitID) {
+				pending = append(pending, commit.CommitID)
+			}
+		}
+		if diffPool, err = services.NewDiffPool(RepoPath, pending, workers, opts); err != nil {
+			ui.LogWarning("%v, extracting diffs one commit at a time", err)
+			diffPool = nil
+		}
+	}
 
 	// Start a goroutine to process all commits
 	go func() {
+		if pool != nil {
+			defer pool.Stop()
+		}
+		if diffPool != nil {
+			defer diffPool.Stop()
+		}
 		for _, commit := range allCommits {
+			ui.WaitWhilePaused()
 			shortID := commit.CommitID[:8]
+			ui.CurrentCommit = commit.CommitID
 
 			// For commits that don't need rewriting, just apply them with the original message
 			if !commit.NeedsRewrite {
@@ -275,7 +799,7 @@ func RunApplication() {
 					ui.LogInfo("Applying commit %s with original message (no rewrite needed)...", shortID)
 					ui.UpdateStatus(fmt.Sprintf("Applying commit %s...", shortID))
 
-					if err := services.ApplyCommitToNewRepo(repo, newRepoPath, commit.CommitID, commit.Message); err != nil {
+					if err := applyCommit(repo, newRepoPath, commit.CommitID, commit.Message, false); err != nil {
 						ui.LogError("Failed to apply commit %s to new repository: %v", shortID, err)
 						continue
 					}
@@ -287,16 +811,44 @@ func RunApplication() {
 				continue
 			}
 
+			// Diffs are extracted one commit at a time, right before they are needed
+			if opts.MessagesOnly && !resumable(commit.CommitID) {
+				var err error
+				if diffPool != nil && diffPool.Has(commit.CommitID) {
+					commit.Files, err = diffPool.Files(commit.CommitID)
+				} else {
+					err = services.LoadCommitFiles(repo, &commit, opts)
+				}
+				if err != nil {
+					ui.LogError("Failed to extract the changes of commit %s: %v", shortID, err)
+					failCommit(repo, newRepoPath, commit, false, err, &rewriteOutputs)
+					ui.ProcessedCommits++
+					ui.UpdateProgressBar()
+					continue
+				}
+				recordCommitState(commit.CommitID, func(state *models.CommitState) { state.FilesChanged = len(commit.Files) })
+			}
+
+			// Commits toggled out in the commit browser keep their original message
+			if excludedCommits[commit.CommitID] {
+				keepOriginalMessage(repo, newRepoPath, commit, "excluded", &rewriteOutputs)
+				ui.ProcessedCommits++
+				ui.UpdateProgressBar()
+				continue
+			}
+
+			// Commits an earlier run finished keep the outcome recorded in -state-db
+			if resumeCommit(repo, newRepoPath, commit, &rewriteOutputs) {
+				ui.ProcessedCommits++
+				ui.UpdateProgressBar()
+				continue
+			}
+
 			// For commits that need rewriting, process them
 
 			// Apply file exclusion pattern if needed
 			if excludePattern != nil {
-				var filteredFiles []models.File
-				for _, file := range commit.Files {
-					if !shouldExcludeFile(file.Path, excludePattern) {
-						filteredFiles = append(filteredFiles, file)
-					}
-				}
+				filteredFiles := filterExcludedFiles(commit.Files, excludePattern)
 
 				skipCount := len(commit.Files) - len(filteredFiles)
 				if skipCount > 0 {
@@ -311,18 +863,42 @@ func RunApplication() {
 					ui.UpdateStatus(fmt.Sprintf("Processing oversized commit %s...", shortID))
 
 					ui.UpdateCommitDetails(commit.CommitID, len(commit.Files), -1, commit.Message, "Processing...")
+					events.Started(events.CommitStarted{CommitID: commit.CommitID, Message: commit.Message})
 					ui.LastCommitStartTime = time.Now()
 
-					newMessage, err := services.GenerateSimplifiedCommitMessage(commit, Model, Temperature, modelContextSize)
+					newMessage, timedOut, err := generateSummary(commit)
 					commitProcessingTime := time.Since(ui.LastCommitStartTime)
 
 					if err != nil {
-						ui.LogError("Failed to generate simplified commit message for %s: %v", shortID, err)
+						if !errors.Is(err, errTokenBudgetExceeded) {
+							ui.LogError("Failed to generate simplified commit message for %s: %v", shortID, err)
+						} else if DryRun {
+							// Stop here, the saved results let a later run resume
+							break
+						}
+						failCommit(repo, newRepoPath, commit, timedOut, err, &rewriteOutputs)
+						ui.ProcessedCommits++
+						ui.UpdateProgressBar()
 						continue
 					}
 
+					newMessage = addFooters(newMessage, commit)
 					ui.UpdateCommitDetails(commit.CommitID, len(commit.Files), -1, strings.TrimSpace(commit.Message), newMessage)
 					ui.LogInfo("Simplified commit message for %s generated successfully", shortID)
+					events.Generated(events.MessageGenerated{CommitID: commit.CommitID, OriginalMessage: commit.Message, Message: newMessage})
+
+					action, newMessage := reviewRewrite(commit, newMessage)
+					if action == ui.ReviewAbort {
+						aborted <- true
+						return
+					}
+					if action == ui.ReviewSkip {
+						keepOriginalMessage(repo, newRepoPath, commit, "rejected", &rewriteOutputs)
+						ui.ProcessedCommits++
+						ui.UpdateProgressBar()
+						continue
+					}
+					recordRewrite(commit.CommitID, newMessage)
 
 					if DryRun {
 						rewriteOutput := models.RewriteOutput{
@@ -333,17 +909,17 @@ func RunApplication() {
 							IsApplied:    false,
 						}
 						rewriteOutputs = append(rewriteOutputs, rewriteOutput)
+						auditMessage(commit.CommitID, newMessage)
 						ui.LogInfo("Added oversized commit %s to dry run output", shortID)
 					} else {
 						// Apply the commit to the new repository
 						ui.UpdateStatus(fmt.Sprintf("Applying oversized commit %s to new repository...", shortID))
-						if err := services.ApplyCommitToNewRepo(repo, newRepoPath, commit.CommitID, newMessage); err != nil {
+						if err := applyCommit(repo, newRepoPath, commit.CommitID, newMessage, true); err != nil {
 							ui.LogError("Failed to apply oversized commit %s to new repository: %v", shortID, err)
 							continue
 						}
 
-						ui.TotalProcessingTime += commitProcessingTime
-						ui.CommitTimings = append(ui.CommitTimings, commitProcessingTime)
+						ui.RecordCommitTiming(commitProcessingTime)
 						ui.LogSuccess("Successfully applied oversized commit %s to new repository", shortID)
 					}
 					ui.ProcessedCommits++
@@ -367,24 +943,39 @@ func RunApplication() {
 				}
 
 				ui.UpdateCommitDetails(commit.CommitID, len(commit.Files), totalDiffSize, commit.Message, "Processing...")
+				events.Started(events.CommitStarted{CommitID: commit.CommitID, Message: commit.Message})
 				ui.LastCommitStartTime = time.Now()
-				newCommit, err := services.GenerateNewCommitMessage(commit, Model, Temperature, modelContextSize)
+				newCommit, timedOut, err := nextCommitMessage(pool, commit)
 				commitProcessingTime := time.Since(ui.LastCommitStartTime)
 				if err != nil {
-					ui.LogError("Failed to generate new commit message for %s: %v", shortID, err)
-					continue
-				}
-				var newMessageLines []string
-				for _, msg := range newCommit.Messages {
-					if !(msg["type"] == "feat" || msg["type"] == "fix" || msg["type"] == "chore" || msg["type"] == "docs" || msg["type"] == "refactor" || msg["type"] == "perf") {
-						continue
+					if !errors.Is(err, errTokenBudgetExceeded) {
+						ui.LogError("Failed to generate new commit message for %s: %v", shortID, err)
+					} else if DryRun {
+						// Stop here, the saved results let a later run resume
+						break
 					}
-					line := fmt.Sprintf("%s: %s (%s)", msg["type"], msg["description"], msg["affected_app"])
-					newMessageLines = append(newMessageLines, line)
+					failCommit(repo, newRepoPath, commit, timedOut, err, &rewriteOutputs)
+					ui.ProcessedCommits++
+					ui.UpdateProgressBar()
+					continue
 				}
-				newMessage := strings.Join(newMessageLines, "\n\r")
+				newMessage := addFooters(assembleCommitMessage(newCommit, commit), commit)
 				ui.UpdateCommitDetails(commit.CommitID, len(commit.Files), totalDiffSize, strings.TrimSpace(commit.Message), newMessage)
 				ui.LogInfo("New commit message for %s generated successfully", shortID)
+				events.Generated(events.MessageGenerated{CommitID: commit.CommitID, OriginalMessage: commit.Message, Message: newMessage})
+
+				action, newMessage := reviewRewrite(commit, newMessage)
+				if action == ui.ReviewAbort {
+					aborted <- true
+					return
+				}
+				if action == ui.ReviewSkip {
+					keepOriginalMessage(repo, newRepoPath, commit, "rejected", &rewriteOutputs)
+					ui.ProcessedCommits++
+					ui.UpdateProgressBar()
+					continue
+				}
+				recordRewrite(commit.CommitID, newMessage)
 
 				if DryRun {
 					rewriteOutput := models.RewriteOutput{
@@ -395,6 +986,7 @@ func RunApplication() {
 						IsApplied:    false,
 					}
 					rewriteOutputs = append(rewriteOutputs, rewriteOutput)
+					auditMessage(commit.CommitID, newMessage)
 					ui.LogInfo("Added commit %s to dry run output", shortID)
 
 					// Save progress periodically (every 5 commits)
@@ -404,20 +996,28 @@ func RunApplication() {
 				} else {
 					// Apply the commit to the new repository
 					ui.UpdateStatus(fmt.Sprintf("Applying commit %s to new repository...", shortID))
-					if err := services.ApplyCommitToNewRepo(repo, newRepoPath, commit.CommitID, newMessage); err != nil {
+					if err := applyCommit(repo, newRepoPath, commit.CommitID, newMessage, true); err != nil {
 						ui.LogError("Failed to apply commit %s to new repository: %v", shortID, err)
 						continue
 					}
 
 					// Update timing statistics
-					ui.TotalProcessingTime += commitProcessingTime
-					ui.CommitTimings = append(ui.CommitTimings, commitProcessingTime)
+					ui.RecordCommitTiming(commitProcessingTime)
 					ui.LogSuccess("Successfully applied commit %s to new repository", shortID)
 				}
 				ui.ProcessedCommits++
 				ui.UpdateProgressBar()
 			}
 		}
+		ui.CurrentCommit = ""
+
+		if tokenBudgetExhausted() {
+			prompt, response := services.TokensUsed()
+			ui.LogWarning("Token budget of %d exhausted after %d prompt and %d response tokens", MaxTotalTokens, prompt, response)
+			if DryRun {
+				ui.LogInfo("Run again with a higher -max-total-tokens to resume from the saved results")
+			}
+		}
 
 		if DryRun && len(rewriteOutputs) > 0 {
 			ui.UpdateStatus("Saving dry run results...")
@@ -433,68 +1033,732 @@ func RunApplication() {
 					ui.UpdateStatus("Error: Failed to save dry run results")
 				} else {
 					ui.LogSuccess("Dry run results saved successfully to %s", outputFilePath)
+					writeHTMLReport(HTMLReportFile, rewriteOutputs)
+					writeReport(ReportFile, rewriteOutputs)
 					ui.UpdateStatus("Dry run completed. Press Ctrl+C to exit")
 				}
 			}
-		} else if !DryRun {
-			ui.UpdateStatus("All commits processed. New repository created at " + newRepoPath + ". Press Ctrl+C to exit")
-			ui.LogInfo("Finished creating new repository with rewritten commits at %s", newRepoPath)
+		}
+		writeFailureReport(newRepoPath, outputFilePath)
+		finishRunState()
+		closeRunState()
+		if !DryRun {
+			finalizeNewRepository(newRepoPath, Model)
+			if InPlace {
+				ui.UpdateStatus("All commits processed. History of " + newRepoPath + " rewritten in place. Press Ctrl+C to exit")
+				ui.LogInfo("Finished rewriting the history of %s in place, the original branches and tags are under refs/original/", newRepoPath)
+			} else {
+				ui.UpdateStatus("All commits processed. New repository created at " + newRepoPath + ". Press Ctrl+C to exit")
+				ui.LogInfo("Finished creating new repository with rewritten commits at %s", newRepoPath)
+			}
 		}
 
+		showRunSummary(rewriteOutputs)
+		finishProgressWebhook("finished")
+		closeProgressStream("finished")
+
 		// Signal that we're done processing
 		done <- true
 	}()
 
-	// Wait for either completion or interrupt
+	// Wait for completion, an interrupt or the review being aborted
 	select {
 	case <-sigs:
 		// Handle clean shutdown on interrupt
 		ui.LogInfo("Received interrupt signal, shutting down...")
-		if DryRun && len(rewriteOutputs) > 0 {
-			ui.UpdateStatus("Saving partial dry run results...")
-			ui.LogInfo("Saving partial dry run results to %s", outputFilePath)
-			savePartialDryRunResults(outputFilePath, rewriteOutputs)
-		}
-		ui.App.Stop()
-		os.Exit(0)
+		stopEarly(newRepoPath, outputFilePath, rewriteOutputs)
+	case <-aborted:
+		ui.LogInfo("Review aborted, shutting down...")
+		stopEarly(newRepoPath, outputFilePath, rewriteOutputs)
 	case <-done:
 		// Wait for user to exit
-		select {}
+		ui.WaitForExit()
 	}
 }
 
-// Helper function to check if a commit ID is in a slice
-func containsCommitID(ids []string, id string) bool {
-	for _, existingID := range ids {
-		if existingID == id {
-			return true
-		}
+// lazyDiffExtraction reports whether the scan can leave out the diffs, so they are
+// extracted one commit at a time while the commits are processed and never all held
+// at once. The worker pool and batches of -concurrency and -batch-size, and the token
+// estimate of -plan-output and -max-total-tokens, need every diff up front.
+func lazyDiffExtraction() bool {
+	return Concurrency <= 1 && BatchSize <= 1 && PlanOutputFile == "" && MaxTotalTokens == 0
+}
+
+// diffWorkers returns the number of -diff-workers, defaulting to the number of CPUs
+func diffWorkers() int {
+	if DiffWorkers > 0 {
+		return DiffWorkers
 	}
-	return false
+	return runtime.NumCPU()
 }
 
-// Helper function to load existing dry run results
-func loadExistingDryRunResults(filePath string) ([]models.RewriteOutput, []string) {
-	var outputs []models.RewriteOutput
-	var commitIDs []string
+// newLLMClient returns the client of the -backend with the connection flags
+func newLLMClient() (services.LLMClient, error) {
+	return services.NewLLMClient(Backend, APIBase, APIKey, services.OllamaOptions{
+		Host:               OllamaHost,
+		Headers:            OllamaHeaders,
+		CACert:             OllamaCACert,
+		ClientCert:         OllamaClientCert,
+		ClientKey:          OllamaClientKey,
+		InsecureSkipVerify: OllamaInsecure,
+		Timeout:            OllamaTimeout,
+	})
+}
 
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		// File doesn't exist or can't be read, return empty results
-		return outputs, commitIDs
+// stopEarly saves what a run has done so far and exits before all commits are processed
+func stopEarly(newRepoPath, outputFilePath string, rewriteOutputs []models.RewriteOutput) {
+	finishProgressWebhook("stopped")
+	closeProgressStream("stopped")
+	if DryRun && len(rewriteOutputs) > 0 {
+		ui.UpdateStatus("Saving partial dry run results...")
+		ui.LogInfo("Saving partial dry run results to %s", outputFilePath)
+		savePartialDryRunResults(outputFilePath, rewriteOutputs)
+		writeHTMLReport(HTMLReportFile, rewriteOutputs)
+		writeReport(ReportFile, rewriteOutputs)
 	}
+	writeFailureReport(newRepoPath, outputFilePath)
+	closeRunState()
+	closeImporter()
+	ui.Stop()
+	os.Exit(0)
+}
 
-	if err := json.Unmarshal(data, &outputs); err != nil {
-		ui.LogError("Failed to parse existing dry run file: %v", err)
-		return outputs, commitIDs
+// reviewRewrite lets the user accept, edit or reject a rewritten message when -review
+// is set, and returns the decision with the message to use
+func reviewRewrite(commit models.CommitOutput, message string) (ui.ReviewAction, string) {
+	if !ReviewCommits {
+		return ui.ReviewAccept, message
 	}
-
-	// Extract commit IDs for quick lookup
-	for _, output := range outputs {
-		commitIDs = append(commitIDs, output.CommitID)
+	ui.UpdateStatus(fmt.Sprintf("Review the message for commit %s", commit.CommitID[:8]))
+	files := make([]string, 0, len(commit.Files))
+	for _, file := range commit.Files {
+		files = append(files, file.Path)
 	}
-
-	return outputs, commitIDs
+	action, reviewed := ui.ReviewMessage(commit.CommitID, files, commit.Message, message)
+	if action == ui.ReviewAccept && reviewed != strings.TrimSpace(message) {
+		ui.LogInfo("Using edited message for commit %s", commit.CommitID[:8])
+	}
+	return action, reviewed
+}
+
+// assembleCommitMessage joins the model's messages into the final commit message.
+// When no message survives the type filter, a chore: version of the original
+// message is used instead so the commit never ends up with an empty message.
+func assembleCommitMessage(newCommit models.NewCommitMessage, commit models.CommitOutput) string {
+	var usable []map[string]string
+	var newMessageLines []string
+	for _, msg := range newCommit.Messages {
+		if !(msg["type"] == "feat" || msg["type"] == "fix" || msg["type"] == "chore" || msg["type"] == "docs" || msg["type"] == "refactor" || msg["type"] == "perf") {
+			continue
+		}
+		usable = append(usable, msg)
+		newMessageLines = append(newMessageLines, formatSubject(msg))
+	}
+	if Style == services.StylePlain && len(usable) > 1 {
+		// A plain message is a single sentence; the prompt asks for one message only
+		usable, newMessageLines = usable[:1], newMessageLines[:1]
+	}
+	if SingleSubject && len(usable) > 1 {
+		return withBodyAndFooters(dominantSubjectMessage(usable), newCommit)
+	}
+	if len(newMessageLines) > 0 {
+		return withBodyAndFooters(strings.Join(newMessageLines, subjectSeparator), newCommit)
+	}
+
+	original := strings.TrimSpace(commit.Message)
+	ui.LogWarning("No usable messages generated for %s, falling back to the original message", commit.CommitID[:8])
+	if original == "" {
+		original = "update files"
+	}
+	if strings.HasPrefix(original, "chore:") {
+		return original
+	}
+	return formatSubject(map[string]string{"type": "chore", "description": original})
+}
+
+// withBodyAndFooters appends the body paragraph and footers the model returned with -body
+func withBodyAndFooters(message string, newCommit models.NewCommitMessage) string {
+	if body := strings.TrimSpace(newCommit.Body); body != "" {
+		message += "\n\n" + body
+	}
+	var footers []string
+	for _, footer := range newCommit.Footers {
+		if footer = strings.TrimSpace(footer); footer != "" {
+			footers = append(footers, footer)
+		}
+	}
+	if len(footers) > 0 {
+		message += "\n\n" + strings.Join(footers, "\n")
+	}
+	return message
+}
+
+// subjectTypePriority ranks commit types when -single-subject picks the subject
+var subjectTypePriority = map[string]int{"feat": 0, "fix": 1, "perf": 2, "refactor": 3, "docs": 4, "chore": 5}
+
+// dominantSubjectMessage builds a message with a single subject from several model
+// messages. The highest ranked message of the affected app most messages name becomes
+// the subject, with ties between apps going to the higher ranked type. The others are
+// listed as body bullet points in the order the model returned them.
+func dominantSubjectMessage(msgs []map[string]string) string {
+	scopeCounts := make(map[string]int)
+	for _, msg := range msgs {
+		scopeCounts[msg["affected_app"]]++
+	}
+	dominant := 0
+	for i, msg := range msgs {
+		best := msgs[dominant]
+		count, bestCount := scopeCounts[msg["affected_app"]], scopeCounts[best["affected_app"]]
+		if count > bestCount || (count == bestCount && subjectTypePriority[msg["type"]] < subjectTypePriority[best["type"]]) {
+			dominant = i
+		}
+	}
+
+	subject := msgs[dominant]
+	lines := []string{formatSubject(subject), ""}
+	for i, msg := range msgs {
+		if i != dominant {
+			lines = append(lines, "- "+formatSubject(msg))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// nextCommitMessage returns the model's messages for a commit, waiting for the worker
+// pool when it generates them
+func nextCommitMessage(pool *services.GenerationPool, commit models.CommitOutput) (models.NewCommitMessage, bool, error) {
+	if pool == nil || !pool.Has(commit.CommitID) {
+		return generateCommitMessage(commit)
+	}
+	result := pool.Wait(commit.CommitID)
+	return result.Message, result.TimedOut, result.Err
+}
+
+// generateCommitMessage returns the model's messages for a commit, reusing a cached
+// result when one exists. timedOut reports whether -commit-timeout was exceeded.
+func generateCommitMessage(commit models.CommitOutput) (newCommit models.NewCommitMessage, timedOut bool, err error) {
+	if cached, ok := services.GetCachedCommitMessage(commit, Model); ok {
+		ui.LogInfo("Using cached message for commit %s", commit.CommitID[:8])
+		return cached, false, nil
+	}
+	if tokenBudgetExhausted() {
+		return newCommit, false, errTokenBudgetExceeded
+	}
+
+	ctx, cancel := generationContext()
+	defer cancel()
+	newCommit, err = services.GenerateNewCommitMessage(ctx, commit, Model, Temperature, modelContextSize)
+	if err != nil {
+		return newCommit, ctx.Err() == context.DeadlineExceeded, err
+	}
+	services.PutCachedCommitMessage(commit, Model, newCommit)
+	return newCommit, false, nil
+}
+
+// generateBatchMessages returns the model's messages for a batch of -batch-size commits.
+// The commits without a cached result are sent in one request; those the model leaves
+// out of its reply, or all of them when the request fails, are generated one by one.
+func generateBatchMessages(commits []models.CommitOutput) []services.GenerationResult {
+	var uncached []models.CommitOutput
+	for _, commit := range commits {
+		if _, ok := services.GetCachedCommitMessage(commit, Model); !ok {
+			uncached = append(uncached, commit)
+		}
+	}
+
+	var generated map[string]models.NewCommitMessage
+	if len(uncached) > 1 && !tokenBudgetExhausted() {
+		ctx, cancel := generationContext()
+		batch, err := services.GenerateBatchCommitMessages(ctx, uncached, Model, Temperature, modelContextSize)
+		cancel()
+		if err != nil {
+			ui.LogWarning("Batched request for %d commits failed, generating them one by one: %v", len(uncached), err)
+		} else {
+			generated = batch
+			for _, commit := range uncached {
+				if _, ok := generated[commit.CommitID]; !ok {
+					ui.LogWarning("%s left commit %s out of its batched reply, generating it on its own", services.Client.Name(), commit.CommitID[:8])
+				}
+			}
+		}
+	}
+
+	results := make([]services.GenerationResult, len(commits))
+	for i, commit := range commits {
+		if newCommit, ok := generated[commit.CommitID]; ok {
+			services.PutCachedCommitMessage(commit, Model, newCommit)
+			results[i] = services.GenerationResult{Message: newCommit}
+			continue
+		}
+		newCommit, timedOut, err := generateCommitMessage(commit)
+		results[i] = services.GenerationResult{Message: newCommit, TimedOut: timedOut, Err: err}
+	}
+	return results
+}
+
+// scoreMessage grades a commit's existing message for -select=llm-score
+func scoreMessage(commitID, message string) (int, error) {
+	ui.UpdateStatus(fmt.Sprintf("Grading the message of commit %s...", commitID[:8]))
+	ctx, cancel := generationContext()
+	defer cancel()
+	score, err := services.ScoreCommitMessage(ctx, commitID, message, Model, Temperature)
+	if err == nil {
+		ui.LogInfo("Message of commit %s graded %d of 10", commitID[:8], score)
+	}
+	return score, err
+}
+
+// generateSummary returns a one-line summary for an oversized commit, reusing a
+// cached result when one exists. timedOut reports whether -commit-timeout was exceeded.
+// With -map-reduce the commit gets a full message instead, generated in parts when it
+// doesn't fit the context.
+func generateSummary(commit models.CommitOutput) (summary string, timedOut bool, err error) {
+	if MapReduce {
+		newCommit, timedOut, err := generateCommitMessage(commit)
+		if err != nil {
+			return "", timedOut, err
+		}
+		return assembleCommitMessage(newCommit, commit), false, nil
+	}
+	if cached, ok := services.GetCachedSummary(commit, Model); ok {
+		ui.LogInfo("Using cached summary for commit %s", commit.CommitID[:8])
+		return cached, false, nil
+	}
+	if tokenBudgetExhausted() {
+		return "", false, errTokenBudgetExceeded
+	}
+
+	ctx, cancel := generationContext()
+	defer cancel()
+	summary, err = services.GenerateSimplifiedCommitMessage(ctx, commit, Model, Temperature, modelContextSize)
+	if err != nil {
+		return summary, ctx.Err() == context.DeadlineExceeded, err
+	}
+	services.PutCachedSummary(commit, Model, summary)
+	return summary, false, nil
+}
+
+// warmUpModel sends a warm-up request and records how long loading the model took.
+// A failed warm-up is only logged, the first commit then measures the cold start.
+func warmUpModel(client services.LLMClient) {
+	ui.UpdateStatus(fmt.Sprintf("Loading model %s...", Model))
+	ui.LogInfo("Sending warm-up request to load model %s", Model)
+	elapsed, err := services.WarmUp(context.Background(), Model)
+	if err != nil {
+		ui.LogWarning("Warm-up request to %s failed: %v", client.Name(), err)
+		return
+	}
+	ui.ColdStartTime, ui.ColdStartMeasured = elapsed, true
+	ui.LogInfo("Model %s ready after %s (cold start, not counted in the ETA)", Model, elapsed.Round(time.Millisecond))
+}
+
+// generationContext returns the context for generating a single commit's message,
+// bounded by -commit-timeout when it is set
+func generationContext() (context.Context, context.CancelFunc) {
+	if CommitTimeout > 0 {
+		return context.WithTimeout(context.Background(), CommitTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// errTokenBudgetExceeded is returned instead of generating once -max-total-tokens is spent
+var errTokenBudgetExceeded = errors.New("token budget exhausted")
+
+// tokenBudgetExhausted reports whether this run has used up -max-total-tokens
+func tokenBudgetExhausted() bool {
+	if MaxTotalTokens <= 0 {
+		return false
+	}
+	prompt, response := services.TokensUsed()
+	return prompt+response >= MaxTotalTokens
+}
+
+// failCommit handles a commit whose message could not be generated. Outside of dry
+// runs the commit is applied with its original message so that -retry-failed can
+// reword it in place later; timed out commits are also recorded as skipped in dry
+// run output. Every failure is added to the failure report, including the commits
+// left over once the token budget is spent.
+func failCommit(repo *git.Repository, newRepoPath string, commit models.CommitOutput, timedOut bool, err error, outputs *[]models.RewriteOutput) {
+	reason := "error"
+	if timedOut {
+		reason = "timeout"
+	} else if errors.Is(err, errTokenBudgetExceeded) {
+		reason = "budget"
+	}
+	events.Failed(events.Error{CommitID: commit.CommitID, Err: err})
+	if timedOut || !DryRun {
+		keepOriginalMessage(repo, newRepoPath, commit, reason, outputs)
+	}
+	recordFailure(commit.CommitID, reason, err)
+	recordCommitState(commit.CommitID, func(state *models.CommitState) {
+		state.Status, state.SkipReason, state.Error = services.StateFailed, reason, fmt.Sprint(err)
+	})
+}
+
+// browseCommitsToRewrite shows the commit browser and returns the commits the user
+// excluded from the rewrite set
+func browseCommitsToRewrite(repo *git.Repository, commitsToRewrite []models.CommitOutput) map[string]bool {
+	var entries []ui.BrowserCommit
+	for _, commit := range commitsToRewrite {
+		files := len(commit.Files)
+		if commit.Files == nil {
+			// Without their diffs extracted yet, only the changed files are counted
+			if count, err := services.CountChangedFiles(repo, commit.CommitID); err == nil {
+				files = count
+			}
+		}
+		entries = append(entries, ui.BrowserCommit{
+			ID:      commit.CommitID,
+			Subject: strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0],
+			Files:   files,
+		})
+	}
+
+	ui.UpdateStatus("Select the commits to rewrite")
+	excluded := ui.ShowCommitBrowser(entries)
+	if len(excluded) > 0 {
+		ui.LogInfo("Excluded %d commits from the rewrite set, they keep their original messages", len(excluded))
+	}
+	return excluded
+}
+
+// keepOriginalMessage records a commit as skipped and keeps its original message,
+// either as a dry run entry or by applying it unchanged to the new repository
+func keepOriginalMessage(repo *git.Repository, newRepoPath string, commit models.CommitOutput, reason string, outputs *[]models.RewriteOutput) {
+	shortID := commit.CommitID[:8]
+	ui.LogWarning("Skipping commit %s (%s), keeping original message", shortID, reason)
+	ui.MarkCommitSkipped(commit.CommitID)
+	recordCommitState(commit.CommitID, func(state *models.CommitState) {
+		state.Status, state.RewrittenMsg, state.SkipReason, state.Error = services.StateSkipped, "", reason, ""
+	})
+
+	if DryRun {
+		*outputs = append(*outputs, models.RewriteOutput{
+			CommitID:     commit.CommitID,
+			OriginalMsg:  strings.TrimSpace(commit.Message),
+			RewrittenMsg: strings.TrimSpace(commit.Message),
+			FilesChanged: len(commit.Files),
+			IsApplied:    false,
+			Skipped:      true,
+			SkipReason:   reason,
+		})
+		return
+	}
+
+	ui.UpdateStatus(fmt.Sprintf("Applying commit %s with original message...", shortID))
+	if err := applyCommit(repo, newRepoPath, commit.CommitID, commit.Message, false); err != nil {
+		ui.LogError("Failed to apply commit %s to new repository: %v", shortID, err)
+		return
+	}
+	ui.LogSuccess("Successfully applied commit %s with original message", shortID)
+}
+
+// applyCommit applies a commit to the new repository and records the hash it was
+// rewritten to
+func applyCommit(repo *git.Repository, newRepoPath, commitID, message string, rewritten bool) (err error) {
+	defer func() {
+		if err != nil {
+			events.Failed(events.Error{CommitID: commitID, Err: err})
+		}
+	}()
+	if !rewritten && Reverts == "canonical" {
+		if revertMessage, ok := canonicalRevertMessage(message); ok {
+			message, rewritten = revertMessage, true
+		}
+	}
+	if rewritten {
+		auditMessage(commitID, message)
+	}
+	if rewritten && ProvenanceNotes {
+		recordProvenance(repo, commitID)
+	}
+	if rewritten && KeepOriginal != "" {
+		message = keepOriginal(repo, commitID, message)
+	}
+	if RewrittenFromTrailer {
+		message = helpers.AppendTrailer(message, "Rewritten-From", commitID)
+	}
+
+	if sideBranchCommits[commitID] {
+		return applySideBranchCommit(repo, newRepoPath, commitID, message, rewritten)
+	}
+
+	var mergeParents []string
+	if FirstParent {
+		if mergeParents, err = keptMergeParents(repo, newRepoPath, commitID); err != nil {
+			return err
+		}
+	}
+
+	var newID string
+	if ApplyMethod == "worktree" {
+		newID, err = services.ApplyCommitToNewRepo(repo, newRepoPath, commitID, message, mergeParents)
+	} else {
+		if err = startImporter(newRepoPath); err != nil {
+			return err
+		}
+		newID, err = importer.Import(repo, commitID, message, mergeParents)
+	}
+	if err != nil {
+		return err
+	}
+	recordAppliedCommit(commitID, newID, message, rewritten)
+	return nil
+}
+
+// canonicalRevertMessage points a git revert message at the new hash and subject of the
+// reverted commit. It reports false when the message isn't a revert or the reverted
+// commit wasn't applied in this run.
+func canonicalRevertMessage(message string) (string, bool) {
+	reverted, ok := helpers.RevertedCommit(message)
+	if !ok {
+		return "", false
+	}
+	originalID := ""
+	for id := range rewrittenIDs {
+		if strings.HasPrefix(id, reverted) {
+			originalID = id
+			break
+		}
+	}
+	if originalID == "" {
+		return "", false
+	}
+
+	lines := strings.Split(strings.TrimSpace(message), "\n")
+	subject := strings.TrimSpace(strings.SplitN(strings.TrimSpace(appliedMessages[originalID]), "\n", 2)[0])
+	lines[0] = "Revert \"" + subject + "\""
+	revertMessage := strings.Join(lines, "\n")
+	revertMessage = strings.ReplaceAll(revertMessage, "This reverts commit "+reverted, "This reverts commit "+rewrittenIDs[originalID])
+	return revertMessage, revertMessage != strings.TrimSpace(message)
+}
+
+// auditMessage records the message a commit was given in the audit log
+func auditMessage(commitID, message string) {
+	services.WriteAuditEntry(services.AuditEntry{
+		Type:     services.AuditMessage,
+		CommitID: commitID,
+		Model:    Model,
+		Message:  message,
+	})
+}
+
+// ensureOllamaModel checks that the Ollama server has the model and, with -auto-pull,
+// pulls it when it doesn't
+func ensureOllamaModel(client services.OllamaClient) {
+	available, err := client.HasModel(Model)
+	if err != nil {
+		ui.LogWarning("Could not check whether model %s is available: %v", Model, err)
+		return
+	}
+	if available {
+		return
+	}
+	if !AutoPull {
+		ui.LogError("Model %s is not available on the Ollama server; pull it with 'ollama pull %s' or pass -auto-pull", Model, Model)
+		ui.UpdateStatus("Error: Model not available")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Model %s is not available on the Ollama server; pull it with 'ollama pull %s' or pass -auto-pull", Model, Model)
+	}
+
+	ui.LogInfo("Model %s is not available, pulling it...", Model)
+	ui.UpdateStatus(fmt.Sprintf("Pulling model %s...", Model))
+	if err := client.PullModel(context.Background(), Model, ui.UpdateDownloadProgress); err != nil {
+		ui.LogError("%v", err)
+		ui.UpdateStatus("Error: Failed to pull model")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("%v", err)
+	}
+	ui.LogSuccess("Pulled model %s", Model)
+}
+
+// recordAppliedCommit remembers the hash and message a commit was rewritten to
+func recordAppliedCommit(commitID, newID, message string, rewritten bool) {
+	appliedCommits = append(appliedCommits, models.CommitMapping{
+		OriginalID: commitID,
+		NewID:      newID,
+		Rewritten:  rewritten,
+	})
+	rewrittenIDs[commitID] = newID
+	appliedMessages[commitID] = message
+	recordCommitState(commitID, func(state *models.CommitState) { state.NewID = newID })
+	events.Applied(events.CommitApplied{CommitID: commitID, NewCommitID: newID, Rewritten: rewritten, Message: message})
+}
+
+// keptMergeParents returns the merged-in parents of a commit on the first-parent chain.
+// They are kept as they are, so the original commits are fetched into the new repository.
+func keptMergeParents(repo *git.Repository, newRepoPath, commitID string) ([]string, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit object: %v", err)
+	}
+	if len(commit.ParentHashes) < 2 {
+		return nil, nil
+	}
+	var parents []string
+	for _, parent := range commit.ParentHashes[1:] {
+		parents = append(parents, parent.String())
+	}
+	if InPlace {
+		return parents, nil
+	}
+	if err := services.FetchCommits(RepoPath, newRepoPath, parents); err != nil {
+		return nil, err
+	}
+	return parents, nil
+}
+
+// startImporter starts the fast-import stream into the new repository, or into the
+// source repository with -in-place, unless it is already running
+func startImporter(newRepoPath string) error {
+	if importer != nil {
+		return nil
+	}
+	var err error
+	if InPlace {
+		importer, err = services.NewInPlaceImporter(newRepoPath)
+	} else {
+		importer, err = services.NewFastImporter(newRepoPath)
+	}
+	return err
+}
+
+// closeImporter finishes the fast-import stream, if one was started, so the imported
+// history is written and checked out in the new repository
+func closeImporter() {
+	if importer == nil {
+		return
+	}
+	ui.UpdateStatus("Writing imported history...")
+	if err := importer.Close(); err != nil {
+		ui.LogError("Failed to finish importing commits: %v", err)
+	}
+	importer = nil
+}
+
+// commitHashMap returns the original to new hash mapping of every applied commit
+func commitHashMap() map[string]string {
+	hashMap := make(map[string]string, len(appliedCommits))
+	for _, mapping := range appliedCommits {
+		hashMap[mapping.OriginalID] = mapping.NewID
+	}
+	return hashMap
+}
+
+// newRepositoryName returns the name of the new repository: -output-repo, or else the
+// source repository's name with "-rewritten", ending in ".git" when it is -bare
+func newRepositoryName(repoPath string) string {
+	if OutputRepoName != "" {
+		return OutputRepoName
+	}
+	name := services.GetRepoName(repoPath) + "-rewritten"
+	if Bare {
+		name += ".git"
+	}
+	return name
+}
+
+// commitMapPath returns where the commit map of this run is written
+func commitMapPath() string {
+	if CommitMapFile != "" {
+		return CommitMapFile
+	}
+	return fmt.Sprintf("%s-commit-map.json", services.GetRepoName(RepoPath))
+}
+
+// writeCommitMap saves the original to new hash mapping of the applied commits and,
+// with -replace-refs, records it as replace refs in the new repository
+func writeCommitMap(newRepoPath string) {
+	if len(appliedCommits) == 0 {
+		return
+	}
+	hashMap := commitHashMap()
+	if err := services.WriteCommitMap(commitMapPath(), hashMap); err != nil {
+		ui.LogError("%v", err)
+	} else {
+		ui.LogInfo("Commit map saved to %s", commitMapPath())
+	}
+
+	if ReplaceRefs {
+		ui.UpdateStatus("Creating replace refs...")
+		count, err := services.WriteReplaceRefs(newRepoPath, hashMap)
+		if err != nil {
+			ui.LogError("Failed to create replace refs: %v", err)
+			return
+		}
+		ui.LogSuccess("Created %d replace refs under refs/replace/ in the new repository", count)
+	}
+}
+
+// finalizeNewRepository carries over repository metadata that is not part of the
+// commit history once every commit has been applied to the new repository
+func finalizeNewRepository(newRepoPath, model string) {
+	if AllRefs {
+		copyRefs()
+	}
+	closeImporter()
+	writeCommitMap(newRepoPath)
+	treesVerified := true
+	if VerifyTrees && len(appliedCommits) > 0 {
+		treesVerified = verifyTrees(newRepoPath, commitHashMap())
+	}
+
+	if CopyNotes {
+		ui.UpdateStatus("Copying git notes...")
+		copied, err := services.CopyNotes(RepoPath, newRepoPath, commitHashMap())
+		if err != nil {
+			ui.LogError("Failed to copy git notes: %v", err)
+		} else if copied > 0 {
+			ui.LogSuccess("Copied %d git notes to the rewritten commits", copied)
+		}
+	}
+	writeOriginalMessageNotes(newRepoPath)
+	writeProvenanceNotes(newRepoPath, model)
+
+	writeRepoReport(newRepoPath, model)
+	writeChangelog()
+
+	if PushTo != "" && !treesVerified {
+		ui.LogError("Not pushing to %s because the rewritten trees differ from the original ones", PushTo)
+	} else if PushTo != "" {
+		pushNewRepository(newRepoPath)
+	}
+}
+
+// Helper function to check if a commit ID is in a slice
+func containsCommitID(ids []string, id string) bool {
+	for _, existingID := range ids {
+		if existingID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to load existing dry run results
+func loadExistingDryRunResults(filePath string) ([]models.RewriteOutput, []string) {
+	var outputs []models.RewriteOutput
+	var commitIDs []string
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		// File doesn't exist or can't be read, return empty results
+		return outputs, commitIDs
+	}
+
+	if err := json.Unmarshal(data, &outputs); err != nil {
+		ui.LogError("Failed to parse existing dry run file: %v", err)
+		return outputs, commitIDs
+	}
+
+	// Extract commit IDs for quick lookup
+	for _, output := range outputs {
+		commitIDs = append(commitIDs, output.CommitID)
+	}
+
+	return outputs, commitIDs
 }
 
 // Helper function to save partial dry run results
@@ -521,16 +1785,85 @@ func savePartialDryRunResults(filePath string, outputs []models.RewriteOutput) {
 // ApplyChangesMode reads a JSON file with rewrite outputs and applies each change
 func ApplyChangesMode(repoPath, changesFile string) error {
 	ui.UpdateStatus("Applying changes from file...")
+
+	// Read and parse the JSON file
+	data, err := os.ReadFile(changesFile)
+	if err != nil {
+		ui.LogError("Failed to read changes file: %v", err)
+		ui.UpdateStatus("Error: Failed to read changes file")
+		return err
+	}
+	var changes []models.RewriteOutput
+	if err := json.Unmarshal(data, &changes); err != nil {
+		ui.LogError("Failed to parse changes file: %v", err)
+		ui.UpdateStatus("Error: Failed to parse changes file")
+		return err
+	}
+	ui.LogInfo("Loaded %d change entries from %s", len(changes), changesFile)
+
+	return applyChanges(repoPath, changes)
+}
+
+// ReplayMode applies the final messages recorded in an audit log to a new repository,
+// without contacting a model
+func ReplayMode(repoPath, auditFile string) error {
+	ui.UpdateStatus("Replaying audit log...")
+	entries, err := services.ReadAuditLog(auditFile)
+	if err != nil {
+		ui.LogError("%v", err)
+		ui.UpdateStatus("Error: Failed to read audit log")
+		return err
+	}
+
+	// The last message recorded for a commit wins, e.g. after -retry-failed
+	latest := make(map[string]int)
+	var changes []models.RewriteOutput
+	for _, entry := range entries {
+		if entry.Type != services.AuditMessage {
+			continue
+		}
+		if i, ok := latest[entry.CommitID]; ok {
+			changes[i].RewrittenMsg = entry.Message
+			continue
+		}
+		latest[entry.CommitID] = len(changes)
+		changes = append(changes, models.RewriteOutput{CommitID: entry.CommitID, RewrittenMsg: entry.Message})
+	}
+	ui.LogInfo("Loaded %d commit messages from %d audit log entries in %s", len(changes), len(entries), auditFile)
+	if len(changes) == 0 {
+		ui.LogWarning("The audit log contains no commit messages, all commits keep their original messages")
+	}
+	return applyChanges(repoPath, changes)
+}
+
+// applyChanges applies the rewritten messages in changes to a new repository, copying
+// every other commit with its original message
+func applyChanges(repoPath string, changes []models.RewriteOutput) error {
 	ui.LogInfo("Opening repository at %s", repoPath)
 	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
 		ui.LogError("Failed to open repository: %v", err)
 		ui.UpdateStatus("Error: Failed to open repository")
 		time.Sleep(2 * time.Second)
-		ui.App.Stop()
+		ui.Stop()
 		log.Fatalf("Failed to open repository at %s: %v", repoPath, err)
 	}
 
+	// -verify-only checks the changes and prints the plan without creating anything
+	if VerifyOnly {
+		if err := verifyChanges(repo, changes); err != nil {
+			ui.LogError("%v", err)
+			ui.UpdateStatus("Error: Verification failed")
+			time.Sleep(2 * time.Second)
+			ui.Stop()
+			log.Fatalf("Verification failed: %v", err)
+		}
+		return nil
+	}
+
+	checkWorktree(repoPath)
+	lockRepository(repoPath)
+
 	// Verify the repository is on the main branch before proceeding
 	ui.UpdateStatus("Checking repository branch...")
 	ui.LogInfo("Verifying repository is on the main branch...")
@@ -539,9 +1872,10 @@ func ApplyChangesMode(repoPath, changesFile string) error {
 		ui.LogError("Failed to determine current branch: %v", err)
 		ui.UpdateStatus("Error: Failed to determine current branch")
 		time.Sleep(2 * time.Second)
-		ui.App.Stop()
+		ui.Stop()
 		log.Fatalf("Failed to determine current branch: %v", err)
 	}
+
 	
 	// Get the default branch name from the repository
 	defaultBranch, err := services.GetDefaultBranchName(repoPath)
@@ -554,83 +1888,63 @@ func ApplyChangesMode(repoPath, changesFile string) error {
 		ui.LogError("Repository must be on the default branch (%s) to proceed. Currently on: %s", defaultBranch, currentBranch)
 		ui.UpdateStatus(fmt.Sprintf("Error: Repository must be on %s branch", defaultBranch))
 		time.Sleep(2 * time.Second)
-		ui.App.Stop()
+		ui.Stop()
 		log.Fatalf("Repository must be on the default branch (%s) to proceed. Please checkout the default branch first.", defaultBranch)
 	}
 	ui.LogInfo("Verified repository is on the default branch: %s", defaultBranch)
 
-	// Read and parse the JSON file
-	data, err := os.ReadFile(changesFile)
-	if err != nil {
-		ui.LogError("Failed to read changes file: %v", err)
-		ui.UpdateStatus("Error: Failed to read changes file")
-		return err
-	}
-	var changes []models.RewriteOutput
-	if err := json.Unmarshal(data, &changes); err != nil {
-		ui.LogError("Failed to parse changes file: %v", err)
-		ui.UpdateStatus("Error: Failed to parse changes file")
-		return err
-	}
-	ui.LogInfo("Loaded %d change entries from %s", len(changes), changesFile)
-
-	// Determine the output repository name
-	var newRepoName string
-	if OutputRepoName != "" {
-		// Use the specified output repository name
-		newRepoName = OutputRepoName
+	var newRepoPath string
+	if InPlace {
+		newRepoPath = inPlaceRepoPath(repoPath)
+		ui.LogInfo("Rewriting the history of %s in place", newRepoPath)
 	} else {
-		// Use the default name based on the original repository name
-		repoName := services.GetRepoName(repoPath)
-		newRepoName = repoName + "-rewritten"
-	}
+		// Determine the output repository name
+		newRepoName := newRepositoryName(repoPath)
 
-	ui.UpdateStatus("Creating new repository...")
-	ui.LogInfo("Creating new repository with name %s", newRepoName)
-	if err := services.CreateNewRepository(repoPath, newRepoName, defaultBranch); err != nil {
-		ui.LogError("Failed to create new repository: %v", err)
-		ui.UpdateStatus("Error: Failed to create new repository")
-		time.Sleep(2 * time.Second)
-		ui.App.Stop()
-		log.Fatalf("Failed to create new repository: %v", err)
-	}
-	
-	// Get the full path to the new repository
-	absSourcePath, err := filepath.Abs(repoPath)
-	if err != nil {
-		ui.LogWarning("Failed to get absolute path for source repository: %v", err)
-		absSourcePath = filepath.Clean(repoPath)
-	} else {
-		absSourcePath = filepath.Clean(absSourcePath)
-	}
-	sourceParentDir := filepath.Dir(absSourcePath)
-	newRepoPath := filepath.Join(sourceParentDir, newRepoName)
-	ui.LogInfo("New repository located at %s", newRepoPath)
+		ui.UpdateStatus("Creating new repository...")
+		ui.LogInfo("Creating new repository with name %s", newRepoName)
+		newRepoPath = services.NewRepositoryPath(repoPath, OutputDir, newRepoName)
+		if err := services.CreateNewRepository(newRepoPath, defaultBranch, Bare); err != nil {
+			ui.LogError("Failed to create new repository: %v", err)
+			ui.UpdateStatus("Error: Failed to create new repository")
+			time.Sleep(2 * time.Second)
+			ui.Stop()
+			log.Fatalf("Failed to create new repository: %v", err)
+		}
+		lockRepository(newRepoPath)
+		ui.LogInfo("New repository located at %s", newRepoPath)
 	
-	// Configure the new repository with same branch name and remote as source
-	ui.UpdateStatus("Configuring new repository...")
-	ui.LogInfo("Configuring new repository to match source...")
-	if err := services.ConfigureNewRepository(repoPath, newRepoPath); err != nil {
-		ui.LogError("Failed to configure new repository: %v", err)
-		ui.UpdateStatus("Warning: Could not fully configure new repository")
-		// We continue here as this is not a critical error
+		// Configure the new repository with same branch name and remote as source
+		ui.UpdateStatus("Configuring new repository...")
+		ui.LogInfo("Configuring new repository to match source...")
+		if err := services.ConfigureNewRepository(repoPath, newRepoPath); err != nil {
+			ui.LogError("Failed to configure new repository: %v", err)
+			ui.UpdateStatus("Warning: Could not fully configure new repository")
+			// We continue here as this is not a critical error
+		}
 	}
 
 	// First get all commits to ensure we include those not being rewritten
 	ui.UpdateStatus("Getting all commits...")
-	allCommits, _, err := services.GetCommitsChronological(repo, MaxMsgLength, MaxDiffLength)
+	allCommits, _, err := services.GetCommitsChronological(repo, scanOptions())
 	if err != nil {
 		ui.LogError("Failed to get all commits: %v", err)
 		ui.UpdateStatus("Error: Failed to get all commits")
 		time.Sleep(2 * time.Second)
-		ui.App.Stop()
+		ui.Stop()
 		log.Fatalf("Failed to get all commits: %v", err)
 	}
+	rememberSideBranchCommits(allCommits)
 
-	// Build a map of commit IDs to their new messages
+	// Build a map of commit IDs to their new messages. Skipped entries keep their
+	// original message and are applied like any other unchanged commit.
 	rewriteMap := make(map[string]string)
+	var rewriteIDs []string
 	for _, change := range changes {
-		rewriteMap[change.CommitID] = change.RewrittenMsg
+		if !change.Skipped {
+			rewriteMap[change.CommitID] = change.RewrittenMsg
+			rewriteIDs = append(rewriteIDs, change.CommitID)
+		}
 	}
 
 	ui.TotalCommits = len(allCommits)
@@ -640,17 +1954,28 @@ func ApplyChangesMode(repoPath, changesFile string) error {
 	ui.CommitTimings = make([]time.Duration, 0, ui.TotalCommits)
 	ui.UpdateProgressBar()
 
+	if err := writeRunPlan(buildRunPlan("apply-changes", newRepoPath, "", ui.TotalCommits, len(rewriteIDs), nil)); err != nil {
+		ui.LogError("%v", err)
+	}
+
+	checkPublishedHistory(rewriteIDs)
+
 	if ui.TotalCommits > 0 {
-		confirmMessage := fmt.Sprintf("%d total commits will be processed, %d with improved messages from file. All will be applied to a new repository at %s.\n\nThis operation will create a new repository with the same files but improved commit messages.\n\n'No' is selected by default. Use Tab to select 'Yes' if you want to proceed.", ui.TotalCommits, len(changes), newRepoPath)
-		confirmed := ui.ShowConfirmationDialog(confirmMessage)
+		confirmMessage := fmt.Sprintf("%d total commits will be processed, %d with improved messages from file. All will be applied to %s.\n\nThe files stay the same, only the commit messages are improved.", ui.TotalCommits, len(rewriteIDs), applyTarget(newRepoPath))
+		confirmed := AssumeYes || ui.ShowConfirmationDialog(confirmMessage)
 		if !confirmed {
 			ui.LogInfo("User cancelled the operation. Exiting.")
-			ui.App.Stop()
+			ui.Stop()
 			os.Exit(0)
 		}
+		if InPlace {
+			prepareInPlace(newRepoPath)
+		}
 	}
 
 	// Process all commits in chronological order
+	ui.SetPhase("Apply")
+	startProgressWebhook("apply-changes")
 	for _, commit := range allCommits {
 		commitID := commit.CommitID
 		shortID := commitID[:8]
@@ -661,16 +1986,25 @@ func ApplyChangesMode(repoPath, changesFile string) error {
 		if hasRewrite {
 			ui.LogInfo("Applying commit %s with rewritten message...", shortID)
 			ui.UpdateStatus(fmt.Sprintf("Applying commit %s with rewritten message...", shortID))
-			ui.UpdateCommitDetails(commitID, 0, -1, commit.Message, newMessage)
 		} else {
 			ui.LogInfo("Applying commit %s with original message...", shortID)
 			ui.UpdateStatus(fmt.Sprintf("Applying commit %s with original message...", shortID))
 			newMessage = commit.Message
 		}
 
+		filesChanged, err := services.CountChangedFiles(repo, commitID)
+		if err != nil {
+			ui.LogWarning("Failed to count changed files for %s: %v", shortID, err)
+			filesChanged = -1
+		}
+		if ui.ProcessedCommits > 0 {
+			ui.MoveToLastCommit()
+		}
+		ui.UpdateApplyDetails(commitID, filesChanged, strings.TrimSpace(commit.Message), strings.TrimSpace(newMessage), hasRewrite, 0)
+
 		ui.LastCommitStartTime = time.Now()
 		// Apply the commit to the new repository
-		if err := services.ApplyCommitToNewRepo(repo, newRepoPath, commitID, newMessage); err != nil {
+		if err := applyCommit(repo, newRepoPath, commitID, newMessage, hasRewrite); err != nil {
 			ui.LogError("Failed to apply commit %s to new repository: %v", shortID, err)
 			continue
 		}
@@ -684,12 +2018,21 @@ func ApplyChangesMode(repoPath, changesFile string) error {
 		commitProcessingTime := time.Since(ui.LastCommitStartTime)
 		ui.TotalProcessingTime += commitProcessingTime
 		ui.CommitTimings = append(ui.CommitTimings, commitProcessingTime)
+		ui.UpdateApplyDetails(commitID, filesChanged, strings.TrimSpace(commit.Message), strings.TrimSpace(newMessage), hasRewrite, commitProcessingTime)
 
 		ui.ProcessedCommits++
 		ui.UpdateProgressBar()
 	}
 
-	ui.UpdateStatus("All changes applied. New repository created at " + newRepoPath + ". Press Ctrl+C to exit")
-	ui.LogInfo("Finished creating new repository with rewritten commits at %s", newRepoPath)
+	finalizeNewRepository(newRepoPath, "")
+	finishProgressWebhook("finished")
+	closeProgressStream("finished")
+	if InPlace {
+		ui.UpdateStatus("All changes applied. History of " + newRepoPath + " rewritten in place. Press Ctrl+C to exit")
+		ui.LogInfo("Finished rewriting the history of %s in place, the original branches and tags are under refs/original/", newRepoPath)
+	} else {
+		ui.UpdateStatus("All changes applied. New repository created at " + newRepoPath + ". Press Ctrl+C to exit")
+		ui.LogInfo("Finished creating new repository with rewritten commits at %s", newRepoPath)
+	}
 	return nil
 }
\ No newline at end of file
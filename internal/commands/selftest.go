@@ -0,0 +1,365 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// selftestMessage is the description the mock generator gives every rewritten commit
+const selftestMessage = "selftest rewrite"
+
+// selftestMaxMsgLength is the message length up to which synthetic commits are rewritten
+const selftestMaxMsgLength = 10
+
+// SelftestCommand builds a small synthetic repository with merges, renames, symlinks,
+// executable files and empty commits, rewrites it with both apply methods using a mock
+// generator instead of a model, and checks that the new history matches the original.
+//
+//	gitrewrite selftest [-v] [-keep]
+func SelftestCommand(args []string) error {
+	flags := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "Print the log output of each run")
+	keep := flags.Bool("keep", false, "Keep the synthetic repositories instead of deleting them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *verbose {
+		ui.SetupConsole()
+	} else {
+		ui.SetupQuietConsole()
+	}
+
+	// Kept repositories aren't tracked, so gitrewrite cleanup leaves them alone
+	var workDir string
+	var err error
+	if *keep {
+		workDir, err = os.MkdirTemp("", "gitrewrite-kept-selftest-")
+	} else {
+		workDir, err = services.NewTempDir("gitrewrite-selftest-")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	if *keep {
+		fmt.Printf("Keeping synthetic repositories in %s\n", workDir)
+	} else {
+		defer services.RemoveTemp(workDir)
+	}
+
+	sourcePath := filepath.Join(workDir, "source")
+	if err := buildSelftestRepo(sourcePath); err != nil {
+		return fmt.Errorf("failed to build synthetic repository: %v", err)
+	}
+
+	services.Client = services.MockClient{Message: selftestMessage}
+	services.MessageCacheDir = ""
+	Model = "selftest"
+	modelContextSize, _ = services.Client.ContextSize(Model)
+
+	failed := 0
+	for _, method := range []string{"fast-import", "worktree"} {
+		problems, err := runSelftest(sourcePath, method)
+		switch {
+		case err != nil:
+			fmt.Printf("FAIL %s: %v\n", method, err)
+			failed++
+		case len(problems) > 0:
+			fmt.Printf("FAIL %s:\n", method)
+			for _, problem := range problems {
+				fmt.Printf("  %s\n", problem)
+			}
+			failed++
+		default:
+			fmt.Printf("PASS %s\n", method)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of 2 apply methods failed", failed)
+	}
+	return nil
+}
+
+// selftestCommit is one step of the synthetic history. change runs in the working
+// tree before everything is staged and committed with message.
+type selftestCommit struct {
+	message string
+	change  func(dir string) error
+}
+
+// buildSelftestRepo creates the synthetic repository at repoPath. Every commit gets
+// its own author and committer date in a non-UTC time zone.
+func buildSelftestRepo(repoPath string) error {
+	if err := os.MkdirAll(repoPath, 0755); err != nil {
+		return err
+	}
+	step := 0
+	git := func(args ...string) error {
+		ui.LogShellCommand("git", args, repoPath)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoPath
+		date := fmt.Sprintf("%d +0530", 1700000000+step*3600)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Selftest Author",
+			"GIT_AUTHOR_EMAIL=author@selftest.invalid",
+			"GIT_AUTHOR_DATE="+date,
+			"GIT_COMMITTER_NAME=Selftest Committer",
+			"GIT_COMMITTER_EMAIL=committer@selftest.invalid",
+			"GIT_COMMITTER_DATE="+date,
+			"GIT_CONFIG_GLOBAL="+os.DevNull,
+			"GIT_CONFIG_NOSYSTEM=1",
+		)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s failed: %v, output: %s", strings.Join(args, " "), err, output)
+		}
+		return nil
+	}
+	commit := func(c selftestCommit) error {
+		step++
+		if c.change != nil {
+			if err := c.change(repoPath); err != nil {
+				return err
+			}
+		}
+		if err := git("add", "-A"); err != nil {
+			return err
+		}
+		return git("commit", "--allow-empty", "-q", "-m", c.message)
+	}
+	write := func(name, content string, mode os.FileMode) func(dir string) error {
+		return func(dir string) error {
+			target := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(target, []byte(content), mode); err != nil {
+				return err
+			}
+			return os.Chmod(target, mode)
+		}
+	}
+
+	if err := git("init", "-q", "--initial-branch=main"); err != nil {
+		return err
+	}
+	mainline := []selftestCommit{
+		{"init", func(dir string) error {
+			if err := write("README.md", "# Selftest\n", 0644)(dir); err != nil {
+				return err
+			}
+			if err := write("run.sh", "#!/bin/sh\necho selftest\n", 0755)(dir); err != nil {
+				return err
+			}
+			return os.Symlink("README.md", filepath.Join(dir, "readme-link"))
+		}},
+		{"mv", func(dir string) error {
+			if err := os.MkdirAll(filepath.Join(dir, "docs"), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(filepath.Join(dir, "README.md"), filepath.Join(dir, "docs", "README.md")); err != nil {
+				return err
+			}
+			if err := os.Remove(filepath.Join(dir, "readme-link")); err != nil {
+				return err
+			}
+			return os.Symlink("docs/README.md", filepath.Join(dir, "readme-link"))
+		}},
+		{"empty", nil},
+	}
+	for _, c := range mainline {
+		if err := commit(c); err != nil {
+			return err
+		}
+	}
+
+	if err := git("checkout", "-q", "-b", "feature"); err != nil {
+		return err
+	}
+	if err := commit(selftestCommit{"feature", write("notes/file with spaces ü.txt", "unicode name\n", 0644)}); err != nil {
+		return err
+	}
+	if err := git("checkout", "-q", "main"); err != nil {
+		return err
+	}
+	if err := commit(selftestCommit{"fix", write("run.sh", "#!/bin/sh\necho selftest fixed\n", 0755)}); err != nil {
+		return err
+	}
+	step++
+	if err := git("merge", "-q", "--no-ff", "-m", "Merge branch 'feature'", "feature"); err != nil {
+		return err
+	}
+
+	rest := []selftestCommit{
+		{"swap", write("swap", "a file for now\n", 0644)},
+		{"swap dir", func(dir string) error {
+			if err := os.Remove(filepath.Join(dir, "swap")); err != nil {
+				return err
+			}
+			if err := os.Remove(filepath.Join(dir, "run.sh")); err != nil {
+				return err
+			}
+			return write("swap/inner.txt", "now a directory\n", 0644)(dir)
+		}},
+		{"Describe the synthetic repository in detail\n\nThis message is long enough to be kept as it is,\nincluding its body.", write("docs/README.md", "# Selftest\n\nA synthetic repository.\n", 0644)},
+	}
+	for _, c := range rest {
+		if err := commit(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSelftest rewrites the synthetic repository with the given apply method and returns
+// the invariants the new repository violates
+func runSelftest(sourcePath, method string) ([]string, error) {
+	RepoPath = sourcePath
+	ApplyMethod = method
+	appliedCommits = nil
+	rewrittenIDs = make(map[string]string)
+	appliedMessages = make(map[string]string)
+	sideBranchCommits = make(map[string]bool)
+	importer = nil
+
+	repo, err := git.PlainOpen(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source repository: %v", err)
+	}
+	allCommits, commitsToRewrite, err := services.GetCommitsChronological(repo, services.ScanOptions{
+		MaxMsgLength:  selftestMaxMsgLength,
+		MaxDiffLength: 2048,
+	})
+	if err != nil {
+		return nil, err
+	}
+	toRewrite := make(map[string]models.CommitOutput, len(commitsToRewrite))
+	for _, commit := range commitsToRewrite {
+		toRewrite[commit.CommitID] = commit
+	}
+
+	targetName := "rewritten-" + method
+	newRepoPath := filepath.Join(filepath.Dir(sourcePath), targetName)
+	if err := services.CreateNewRepository(newRepoPath, "main", false); err != nil {
+		return nil, err
+	}
+
+	for _, commit := range allCommits {
+		message := commit.Message
+		rewrite, rewritten := toRewrite[commit.CommitID]
+		if rewritten {
+			newCommit, _, err := generateCommitMessage(rewrite)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate message for %s: %v", commit.CommitID[:8], err)
+			}
+			message = assembleCommitMessage(newCommit, rewrite)
+		}
+		if err := applyCommit(repo, newRepoPath, commit.CommitID, message, rewritten); err != nil {
+			return nil, fmt.Errorf("failed to apply commit %s: %v", commit.CommitID[:8], err)
+		}
+	}
+	closeImporter()
+
+	return checkSelftestInvariants(repo, sourcePath, newRepoPath, len(allCommits))
+}
+
+// checkSelftestInvariants compares every applied commit with its original
+func checkSelftestInvariants(repo *git.Repository, sourcePath, newRepoPath string, commitCount int) ([]string, error) {
+	newRepo, err := git.PlainOpen(newRepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open new repository: %v", err)
+	}
+
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(appliedCommits) != commitCount {
+		problem("applied %d of %d commits", len(appliedCommits), commitCount)
+	}
+	for _, mapping := range appliedCommits {
+		original, err := repo.CommitObject(plumbing.NewHash(mapping.OriginalID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get original commit %s: %v", mapping.OriginalID[:8], err)
+		}
+		rewritten, err := newRepo.CommitObject(plumbing.NewHash(mapping.NewID))
+		if err != nil {
+			problem("%s: new commit %s is missing: %v", mapping.OriginalID[:8], mapping.NewID, err)
+			continue
+		}
+		id := mapping.OriginalID[:8]
+
+		if rewritten.TreeHash != original.TreeHash {
+			problem("%s: tree %s differs from the original tree %s", id, rewritten.TreeHash.String()[:8], original.TreeHash.String()[:8])
+		}
+		if rewritten.Author.Name != original.Author.Name || rewritten.Author.Email != original.Author.Email {
+			problem("%s: author %s <%s> differs from %s <%s>", id, rewritten.Author.Name, rewritten.Author.Email, original.Author.Name, original.Author.Email)
+		}
+		if !sameSignatureTime(rewritten.Author.When, original.Author.When) {
+			problem("%s: author date %s differs from %s", id, rewritten.Author.When, original.Author.When)
+		}
+		if rewritten.Committer.Name != original.Committer.Name || rewritten.Committer.Email != original.Committer.Email {
+			problem("%s: committer %s <%s> differs from %s <%s>", id, rewritten.Committer.Name, rewritten.Committer.Email, original.Committer.Name, original.Committer.Email)
+		}
+		if !sameSignatureTime(rewritten.Committer.When, original.Committer.When) {
+			problem("%s: committer date %s differs from %s", id, rewritten.Committer.When, original.Committer.When)
+		}
+
+		message := strings.TrimSpace(rewritten.Message)
+		if mapping.Rewritten && !strings.Contains(message, selftestMessage) {
+			problem("%s: rewritten message %q lacks the generated message", id, message)
+		}
+		if !mapping.Rewritten && message != strings.TrimSpace(original.Message) {
+			problem("%s: kept message %q differs from the original", id, message)
+		}
+	}
+
+	// The rewritten history is linear, so every applied commit is reachable from HEAD
+	count, err := services.GetCommandOutput("git", []string{"rev-list", "--count", "HEAD"}, newRepoPath)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(count) != fmt.Sprint(commitCount) {
+		problem("HEAD reaches %s commits instead of %d", strings.TrimSpace(count), commitCount)
+	}
+
+	status, err := services.GetCommandOutput("git", []string{"status", "--porcelain"}, newRepoPath)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(status) != "" {
+		problem("working tree is not clean:\n%s", status)
+	}
+	originalHead, err := services.GetCommandOutput("git", []string{"rev-parse", "HEAD^{tree}"}, sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	newHead, err := services.GetCommandOutput("git", []string{"rev-parse", "HEAD^{tree}"}, newRepoPath)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(originalHead) != strings.TrimSpace(newHead) {
+		problem("HEAD tree differs from the original HEAD tree")
+	}
+	return problems, nil
+}
+
+// sameSignatureTime reports whether two signature times are the same instant in the
+// same time zone offset
+func sameSignatureTime(a, b time.Time) bool {
+	_, offsetA := a.Zone()
+	_, offsetB := b.Zone()
+	return a.Unix() == b.Unix() && offsetA == offsetB
+}
@@ -0,0 +1,535 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+)
+
+// serveJobOptions are the flags clients may set for a job with query parameters. The
+// arguments given to serve after "--" apply to every job, e.g. the backend to use.
+var serveJobOptions = map[string]bool{
+	"model": true, "temperature": true, "style": true, "language": true, "body": true,
+	"message-template": true, "single-subject": true, "max-length": true, "max-files": true,
+	"summarize-oversized": true, "map-reduce": true, "first-parent": true,
+}
+
+// Files in a job's directory
+const (
+	jobUpload  = "upload.bundle"
+	jobLog     = "log.jsonl"
+	jobChanges = "changes.json"
+	jobBundle  = "rewritten.bundle"
+)
+
+// jobServer runs the rewrite jobs submitted to gitrewrite serve. Every job runs this
+// binary on a clone of its repository in a directory of its own and reports progress
+// from the JSON log lines of -log-format=json.
+type jobServer struct {
+	executable string
+	dataDir    string
+	repoRoot   string
+	token      string
+	maxUpload  int64
+	retention  time.Duration
+	jobArgs    []string
+	slots      chan struct{}
+
+	mu    sync.Mutex
+	jobs  map[string]*serveJob
+	order []string
+}
+
+// serveJob is a job and what the server needs to run it. The embedded state is
+// guarded by the server's mutex.
+type serveJob struct {
+	models.ServeJob
+	dir    string
+	source string
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ServeCommand runs gitrewrite as an HTTP service, so teams can rewrite histories on
+// a shared machine with a model instead of installing one locally. Clients submit a
+// repository path under -repo-root or upload a git bundle, poll the job and download
+// the dry run's changes file or a bundle of the rewritten repository:
+//
+//	POST   /jobs?repo=<path>&dry-run=true&model=...   start a job for a repository path
+//	POST   /jobs?dry-run=false                         start a job for the uploaded bundle
+//	GET    /jobs, GET /jobs/{id}                       list the jobs, poll a job
+//	DELETE /jobs/{id}                                  cancel a job
+//	GET    /jobs/{id}/log, /changes, /bundle           download the log and results
+//
+//	gitrewrite serve [-listen=127.0.0.1:8080] [-repo-root=dir] [-max-jobs=1] [-- flags for every job]
+func ServeCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := flags.String("listen", "127.0.0.1:8080", "Address to serve the API on; any address but a loopback one requires -token")
+	dataDir := flags.String("data-dir", filepath.Join(os.TempDir(), "gitrewrite-serve"), "Directory for the jobs' clones, logs and results")
+	repoRoot := flags.String("repo-root", "", "Accept jobs for repository paths under this directory; without it only bundle uploads are accepted")
+	maxJobs := flags.Int("max-jobs", 1, "Number of jobs run at the same time; further jobs wait in a queue")
+	token := flags.String("token", os.Getenv("GITREWRITE_SERVE_TOKEN"), "Require this bearer token on every request (default: $GITREWRITE_SERVE_TOKEN)")
+	maxUpload := flags.Int64("max-upload", 1024, "Largest bundle upload in MiB")
+	retention := flags.Duration("retention", 24*time.Hour, "How long a finished, failed or canceled job and its files are kept (0 keeps them until the server stops)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *maxJobs < 1 {
+		return fmt.Errorf("invalid -max-jobs value %d: must be at least 1", *maxJobs)
+	}
+	if *retention < 0 {
+		return fmt.Errorf("invalid -retention value %s: must not be negative", *retention)
+	}
+	if *token == "" && !loopbackAddress(*listen) {
+		return fmt.Errorf("refusing to serve on %s without -token: anyone who can reach it could submit jobs and read the repositories under -repo-root", *listen)
+	}
+	for _, arg := range flags.Args() {
+		if !strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unexpected argument %q: flags for every job go after \"--\"", arg)
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the gitrewrite executable: %v", err)
+	}
+	if err := os.MkdirAll(*dataDir, 0700); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	server := &jobServer{
+		executable: executable,
+		dataDir:    *dataDir,
+		token:      *token,
+		maxUpload:  *maxUpload << 20,
+		retention:  *retention,
+		jobArgs:    flags.Args(),
+		slots:      make(chan struct{}, *maxJobs),
+		jobs:       make(map[string]*serveJob),
+	}
+	if *repoRoot != "" {
+		if server.repoRoot, err = filepath.Abs(*repoRoot); err == nil {
+			server.repoRoot, err = filepath.EvalSymlinks(server.repoRoot)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid -repo-root: %v", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", server.createJob)
+	mux.HandleFunc("GET /jobs", server.listJobs)
+	mux.HandleFunc("GET /jobs/{id}", server.getJob)
+	mux.HandleFunc("DELETE /jobs/{id}", server.cancelJob)
+	mux.HandleFunc("GET /jobs/{id}/log", server.download("log", jobLog, "application/x-ndjson"))
+	mux.HandleFunc("GET /jobs/{id}/changes", server.download("changes", jobChanges, "application/json"))
+	mux.HandleFunc("GET /jobs/{id}/bundle", server.download("bundle", jobBundle, "application/x-git-bundle"))
+
+	log.Printf("Serving the rewrite API on %s, job data in %s", *listen, *dataDir)
+	return http.ListenAndServe(*listen, server.authorize(mux))
+}
+
+// loopbackAddress reports whether a listen address only accepts connections from this
+// machine. An empty host listens on every interface.
+func loopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorize rejects requests without the -token
+func (s *jobServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// createJob queues a job for a repository path or an uploaded bundle
+func (s *jobServer) createJob(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	job := &serveJob{ServeJob: models.ServeJob{
+		ID:        newJobID(),
+		State:     "queued",
+		DryRun:    true,
+		Options:   make(map[string]string),
+		CreatedAt: time.Now(),
+	}}
+	for name, values := range query {
+		switch {
+		case name == "repo":
+		case name == "dry-run":
+			dryRun, err := strconv.ParseBool(values[0])
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, "invalid dry-run value %q", values[0])
+				return
+			}
+			job.DryRun = dryRun
+		case serveJobOptions[name]:
+			job.Options[name] = values[0]
+		default:
+			writeAPIError(w, http.StatusBadRequest, "unknown option %q", name)
+			return
+		}
+	}
+
+	job.dir = filepath.Join(s.dataDir, job.ID)
+	if err := os.MkdirAll(job.dir, 0700); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "failed to create job directory: %v", err)
+		return
+	}
+	if repo := query.Get("repo"); repo != "" {
+		source, status, err := s.resolveRepo(repo)
+		if err != nil {
+			os.RemoveAll(job.dir)
+			writeAPIError(w, status, "%v", err)
+			return
+		}
+		job.Repo, job.source = repo, source
+	} else {
+		job.Repo, job.source = jobUpload, filepath.Join(job.dir, jobUpload)
+		if status, err := s.saveUpload(w, r, job.source); err != nil {
+			os.RemoveAll(job.dir)
+			writeAPIError(w, status, "%v", err)
+			return
+		}
+	}
+
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	snapshot := s.snapshot(job)
+	s.mu.Unlock()
+	log.Printf("Job %s queued for %s", job.ID, job.Repo)
+	go s.run(job)
+	writeAPIJSON(w, http.StatusAccepted, snapshot)
+}
+
+// resolveRepo returns the path of a repository under -repo-root, with the HTTP
+// status to answer when it can't be used. Symlinks are resolved first, so a link
+// under the root can't lead outside of it.
+func (s *jobServer) resolveRepo(repo string) (string, int, error) {
+	if s.repoRoot == "" {
+		return "", http.StatusForbidden, fmt.Errorf("repository paths are not accepted by this server, upload a git bundle instead")
+	}
+	path := repo
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.repoRoot, path)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", http.StatusNotFound, fmt.Errorf("repository %s not found", repo)
+	}
+	if rel, err := filepath.Rel(s.repoRoot, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", http.StatusForbidden, fmt.Errorf("repository %s is outside of the repository root", repo)
+	}
+	return path, 0, nil
+}
+
+// saveUpload writes the request body, a git bundle, to path
+func (s *jobServer) saveUpload(w http.ResponseWriter, r *http.Request, path string) (int, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to store upload: %v", err)
+	}
+	defer file.Close()
+	size, err := io.Copy(file, http.MaxBytesReader(w, r.Body, s.maxUpload))
+	if err != nil {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("failed to read upload: %v", err)
+	}
+	if size == 0 {
+		return http.StatusBadRequest, fmt.Errorf("give a repo parameter or upload a git bundle")
+	}
+	return 0, nil
+}
+
+// run waits for a free slot and runs a job to the end
+func (s *jobServer) run(job *serveJob) {
+	defer job.cancel()
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-job.ctx.Done():
+		s.finish(job, "canceled", "")
+		return
+	}
+	s.mu.Lock()
+	started := time.Now()
+	job.State, job.StartedAt = "running", &started
+	s.mu.Unlock()
+	log.Printf("Job %s started", job.ID)
+
+	if err := services.ExecuteCommand("git", []string{"clone", "--quiet", job.source, "repo"}, job.dir); err != nil {
+		s.finish(job, "failed", fmt.Sprintf("failed to clone %s: %v", job.Repo, err))
+		return
+	}
+	if err := s.rewrite(job); err != nil {
+		if job.ctx.Err() != nil {
+			s.finish(job, "canceled", "")
+		} else {
+			s.finish(job, "failed", err.Error())
+		}
+		return
+	}
+	if !job.DryRun {
+		if err := services.ExecuteCommand("git", []string{"bundle", "create", filepath.Join("..", jobBundle), "--all"}, filepath.Join(job.dir, "rewritten")); err != nil {
+			s.finish(job, "failed", fmt.Sprintf("failed to bundle the rewritten repository: %v", err))
+			return
+		}
+	}
+	s.finish(job, "finished", "")
+}
+
+// rewrite runs gitrewrite for a job, following its progress in the JSON log lines,
+// which are also kept as the job's log
+func (s *jobServer) rewrite(job *serveJob) error {
+	args := []string{"-repo=repo", "-no-tui", "-yes", "-log-format=json", "-rewrite-published"}
+	if job.DryRun {
+		args = append(args, "-dry-run", "-output="+jobChanges)
+	} else {
+		args = append(args, "-output-repo=rewritten")
+	}
+	args = append(args, s.jobArgs...)
+	names := make([]string, 0, len(job.Options))
+	for name := range job.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("-%s=%s", name, job.Options[name]))
+	}
+
+	logFile, err := os.Create(filepath.Join(job.dir, jobLog))
+	if err != nil {
+		return fmt.Errorf("failed to create job log: %v", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.CommandContext(job.ctx, s.executable, args...)
+	cmd.Dir = job.dir
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start gitrewrite: %v", err)
+	}
+
+	lastError := ""
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		fmt.Fprintln(logFile, scanner.Text())
+		var event struct {
+			Level     string `json:"level"`
+			Message   string `json:"message"`
+			Processed int    `json:"processed"`
+			Total     int    `json:"total"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			continue
+		}
+		s.mu.Lock()
+		switch event.Level {
+		case "PROGRESS":
+			job.Processed, job.Total = event.Processed, event.Total
+		case "DETAILS":
+		default:
+			job.Message = event.Message
+		}
+		s.mu.Unlock()
+		if event.Level == "ERROR" {
+			lastError = event.Message
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if lastError == "" {
+			lastError, _, _ = strings.Cut(strings.TrimSpace(stderr.String()), "\n")
+		}
+		if lastError == "" {
+			lastError = err.Error()
+		}
+		return fmt.Errorf("%s", lastError)
+	}
+	return nil
+}
+
+// finish records the end of a job
+func (s *jobServer) finish(job *serveJob, state, reason string) {
+	s.mu.Lock()
+	finished := time.Now()
+	job.State, job.Error, job.FinishedAt = state, reason, &finished
+	if state == "finished" {
+		job.Processed = job.Total
+	}
+	s.mu.Unlock()
+	if reason != "" {
+		log.Printf("Job %s %s: %s", job.ID, state, reason)
+	} else {
+		log.Printf("Job %s %s", job.ID, state)
+	}
+	if s.retention > 0 {
+		time.AfterFunc(s.retention, func() { s.remove(job) })
+	}
+}
+
+// remove forgets a job once -retention has passed and deletes its directory
+func (s *jobServer) remove(job *serveJob) {
+	s.mu.Lock()
+	delete(s.jobs, job.ID)
+	for i, id := range s.order {
+		if id == job.ID {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	if err := os.RemoveAll(job.dir); err != nil {
+		log.Printf("Failed to remove job %s: %v", job.ID, err)
+		return
+	}
+	log.Printf("Job %s removed after %s", job.ID, s.retention)
+}
+
+// snapshot copies a job's state with its progress and downloads for a response. The
+// caller holds the mutex.
+func (s *jobServer) snapshot(job *serveJob) models.ServeJob {
+	snapshot := job.ServeJob
+	if snapshot.Total > 0 {
+		snapshot.Percent = float64(snapshot.Processed) / float64(snapshot.Total) * 100
+	}
+	snapshot.Downloads = map[string]string{}
+	if job.StartedAt != nil {
+		snapshot.Downloads["log"] = "/jobs/" + job.ID + "/log"
+	}
+	if job.State == "finished" && job.DryRun {
+		snapshot.Downloads["changes"] = "/jobs/" + job.ID + "/changes"
+	}
+	if job.State == "finished" && !job.DryRun {
+		snapshot.Downloads["bundle"] = "/jobs/" + job.ID + "/bundle"
+	}
+	return snapshot
+}
+
+// lookup returns the job of a request's {id}, answering 404 when there is none
+func (s *jobServer) lookup(w http.ResponseWriter, r *http.Request) (*serveJob, bool) {
+	s.mu.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "job %s not found", r.PathValue("id"))
+	}
+	return job, ok
+}
+
+func (s *jobServer) listJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]models.ServeJob, 0, len(s.order))
+	for _, id := range s.order {
+		jobs = append(jobs, s.snapshot(s.jobs[id]))
+	}
+	s.mu.Unlock()
+	writeAPIJSON(w, http.StatusOK, jobs)
+}
+
+func (s *jobServer) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	snapshot := s.snapshot(job)
+	s.mu.Unlock()
+	writeAPIJSON(w, http.StatusOK, snapshot)
+}
+
+// cancelJob stops a queued or running job
+func (s *jobServer) cancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	state := job.State
+	snapshot := s.snapshot(job)
+	s.mu.Unlock()
+	if state != "queued" && state != "running" {
+		writeAPIError(w, http.StatusConflict, "job %s is already %s", job.ID, state)
+		return
+	}
+	job.cancel()
+	writeAPIJSON(w, http.StatusAccepted, snapshot)
+}
+
+// download serves a file of a job's directory once it is listed in the job's downloads
+func (s *jobServer) download(key, name, contentType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := s.lookup(w, r)
+		if !ok {
+			return
+		}
+		s.mu.Lock()
+		available := s.snapshot(job).Downloads[key] != ""
+		s.mu.Unlock()
+		if !available {
+			writeAPIError(w, http.StatusNotFound, "%s of job %s is not available", key, job.ID)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		if name == jobBundle {
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.ID+".bundle"))
+		}
+		http.ServeFile(w, r, filepath.Join(job.dir, name))
+	}
+}
+
+// newJobID returns a random job ID
+func newJobID() string {
+	id := make([]byte, 6)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// writeAPIJSON writes a JSON response
+func writeAPIJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeAPIError writes an {"error": "..."} response
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeAPIJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,177 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+)
+
+// openRunState opens the -state-db database and records the scanned commits and the
+// new repository at newRepoPath, if the run creates one. The results of an earlier
+// run of the same history with the same message settings are kept, so it resumes
+// where that run stopped; -no-cache starts over.
+func openRunState(allCommits []models.CommitOutput, newRepoPath string) {
+	state, finished, err := prepareRunState(allCommits)
+	if err != nil {
+		ui.LogError("%v", err)
+		ui.UpdateStatus("Error: Failed to open state database")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Failed to open state database: %v", err)
+	}
+	services.State = state
+	if newRepoPath != "" && !InPlace {
+		if err := state.SetOutputRepo(newRepoPath); err != nil {
+			ui.LogError("%v", err)
+		}
+	}
+	if finished > 0 {
+		ui.LogInfo("Resuming from %s: %d commits were already rewritten or skipped by an earlier run", StateDBFile, finished)
+	} else {
+		ui.LogInfo("Recording the progress of this run in %s", StateDBFile)
+	}
+}
+
+// prepareRunState opens the state database for this run and returns it with the
+// number of commits an earlier run finished
+func prepareRunState(allCommits []models.CommitOutput) (*services.StateStore, int, error) {
+	head, err := services.GetHeadCommitID(RepoPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	state, err := services.OpenStateStore(StateDBFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	run := strings.Join([]string{head, Model, Style, Language, fmt.Sprint(GenerateBody), PromptFile, MessageTemplate}, "\x00")
+	if NoCache {
+		// No run has an empty identity, so this clears the earlier results
+		if _, err := state.Prepare(""); err != nil {
+			state.Close()
+			return nil, 0, err
+		}
+	}
+	finished, err := state.Prepare(run)
+	if err == nil {
+		err = state.AddCommits(allCommits)
+	}
+	if err != nil {
+		state.Close()
+		return nil, 0, err
+	}
+	return state, finished, nil
+}
+
+// removePartialRepository removes the new repository at newRepoPath when the -state-db
+// database records it as the one an earlier run was building, so a resumed run can
+// rebuild it. A directory the database doesn't name is left for CreateNewRepository
+// to refuse.
+func removePartialRepository(newRepoPath string) {
+	if _, err := os.Stat(newRepoPath); err != nil {
+		return
+	}
+	if _, err := os.Stat(StateDBFile); err != nil {
+		return
+	}
+	state, err := services.OpenStateStore(StateDBFile)
+	if err != nil {
+		// Reported when the run opens the database
+		return
+	}
+	output := state.OutputRepo()
+	state.Close()
+	if output != newRepoPath {
+		return
+	}
+	ui.LogInfo("Removing the partial repository %s of the earlier run, it is rebuilt", newRepoPath)
+	if err := os.RemoveAll(newRepoPath); err != nil {
+		ui.LogWarning("Failed to remove partial repository %s: %v", newRepoPath, err)
+	}
+}
+
+// finishRunState records that the new repository is complete, so running the same
+// command again doesn't replace it
+func finishRunState() {
+	if err := services.State.SetOutputRepo(""); err != nil {
+		ui.LogError("%v", err)
+	}
+}
+
+// closeRunState closes the -state-db database, so another run can open it
+func closeRunState() {
+	if err := services.State.Close(); err != nil {
+		ui.LogError("Failed to close state database: %v", err)
+	}
+	services.State = nil
+}
+
+// recordCommitState updates what the state database knows about a commit, logging
+// rather than failing the run when it can't be written
+func recordCommitState(commitID string, change func(*models.CommitState)) {
+	if err := services.State.Update(commitID, change); err != nil {
+		ui.LogError("%v", err)
+	}
+}
+
+// recordRewrite records the message a commit is rewritten to
+func recordRewrite(commitID, message string) {
+	recordCommitState(commitID, func(state *models.CommitState) {
+		state.Status, state.RewrittenMsg, state.SkipReason, state.Error = services.StateRewritten, message, "", ""
+	})
+}
+
+// resumable reports whether an earlier run recorded the outcome of a commit, so it
+// doesn't need generating again
+func resumable(commitID string) bool {
+	state, ok := services.State.Get(commitID)
+	return ok && (state.Status == services.StateRewritten && state.RewrittenMsg != "" || state.Status == services.StateSkipped && state.SkipReason == "rejected")
+}
+
+// resumeCommit rewrites or skips a commit the way an earlier run recorded in the state
+// database, and reports whether it did. Failed and unfinished commits are generated
+// again, as are those skipped for reasons that may not hold any more.
+func resumeCommit(repo *git.Repository, newRepoPath string, commit models.CommitOutput, outputs *[]models.RewriteOutput) bool {
+	if !resumable(commit.CommitID) {
+		return false
+	}
+	state, _ := services.State.Get(commit.CommitID)
+	shortID := commit.CommitID[:8]
+	if DryRun {
+		// Commits resumed from the changes file are already in the output
+		for _, output := range *outputs {
+			if output.CommitID == commit.CommitID {
+				return true
+			}
+		}
+	}
+	if state.Status == services.StateSkipped {
+		keepOriginalMessage(repo, newRepoPath, commit, state.SkipReason, outputs)
+		return true
+	}
+
+	ui.LogInfo("Using the message an earlier run recorded for commit %s", shortID)
+	if DryRun {
+		*outputs = append(*outputs, models.RewriteOutput{
+			CommitID:     commit.CommitID,
+			OriginalMsg:  strings.TrimSpace(commit.Message),
+			RewrittenMsg: state.RewrittenMsg,
+			FilesChanged: state.FilesChanged,
+			IsApplied:    false,
+		})
+		return true
+	}
+	ui.UpdateStatus(fmt.Sprintf("Applying commit %s to new repository...", shortID))
+	if err := applyCommit(repo, newRepoPath, commit.CommitID, state.RewrittenMsg, true); err != nil {
+		ui.LogError("Failed to apply commit %s to new repository: %v", shortID, err)
+		return true
+	}
+	ui.LogSuccess("Successfully applied commit %s to new repository", shortID)
+	return true
+}
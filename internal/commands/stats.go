@@ -0,0 +1,265 @@
+package commands
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/MrLemur/gitrewrite/pkg/helpers"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// statsHistogramWidth is the width of the longest bar in text histograms
+const statsHistogramWidth = 40
+
+// subjectLengthBuckets are the upper bounds of the subject length histogram buckets
+var subjectLengthBuckets = []int{10, 20, 30, 50, 72}
+
+// messageStats aggregates message quality over a set of commits
+type messageStats struct {
+	Commits      int
+	TotalLength  int
+	Conventional int
+	Types        map[string]int
+}
+
+func (s *messageStats) add(subject string) {
+	if s.Types == nil {
+		s.Types = make(map[string]int)
+	}
+	s.Commits++
+	s.TotalLength += len(subject)
+	if match := helpers.ConventionalSubject.FindStringSubmatch(subject); match != nil {
+		s.Conventional++
+		s.Types[strings.ToLower(match[1])]++
+	}
+}
+
+func (s *messageStats) averageLength() float64 {
+	if s.Commits == 0 {
+		return 0
+	}
+	return float64(s.TotalLength) / float64(s.Commits)
+}
+
+func (s *messageStats) conventionalPercent() float64 {
+	if s.Commits == 0 {
+		return 0
+	}
+	return float64(s.Conventional) / float64(s.Commits) * 100
+}
+
+// StatsMode writes commit message length, Conventional Commit compliance and type
+// distribution per month to outputFile. A .csv extension writes CSV rows instead of
+// text histograms. The repository is only read, never modified.
+func StatsMode(repoPath, outputFile string) {
+	ui.UpdateStatus("Analyzing commit messages...")
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		ui.LogError("Failed to open repository: %v", err)
+		ui.UpdateStatus("Error: Failed to open repository")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Failed to open repository at %s: %v", repoPath, err)
+	}
+
+	ref, err := repo.Head()
+	if err != nil {
+		ui.LogError("Failed to get HEAD reference: %v", err)
+		ui.UpdateStatus("Error: Failed to get HEAD reference")
+		return
+	}
+	commitIter, err := repo.Log(&git.LogOptions{From: ref.Hash()})
+	if err != nil {
+		ui.LogError("Failed to get commit history: %v", err)
+		ui.UpdateStatus("Error: Failed to get commit history")
+		return
+	}
+
+	var overall messageStats
+	lengths := make([]int, len(subjectLengthBuckets)+1)
+	periods := make(map[string]*messageStats)
+	err = commitIter.ForEach(func(c *object.Commit) error {
+		subject := strings.TrimSpace(strings.SplitN(strings.TrimSpace(c.Message), "\n", 2)[0])
+		overall.add(subject)
+		lengths[lengthBucket(len(subject))]++
+
+		period := c.Author.When.Format("2006-01")
+		if periods[period] == nil {
+			periods[period] = &messageStats{}
+		}
+		periods[period].add(subject)
+		return nil
+	})
+	if err != nil {
+		ui.LogError("Failed to read commit history: %v", err)
+		ui.UpdateStatus("Error: Failed to read commit history")
+		return
+	}
+
+	var data []byte
+	if strings.HasSuffix(strings.ToLower(outputFile), ".csv") {
+		data, err = statsCSV(periods, overall)
+		if err != nil {
+			ui.LogError("Failed to build CSV statistics: %v", err)
+			ui.UpdateStatus("Error: Failed to build CSV statistics")
+			return
+		}
+	} else {
+		data = []byte(statsText(repoPath, overall, lengths, periods))
+	}
+
+	if err := os.WriteFile(outputFile, data, 0644); err != nil {
+		ui.LogError("Failed to write statistics: %v", err)
+		ui.UpdateStatus("Error: Failed to write statistics")
+		return
+	}
+
+	ui.LogInfo("%d commits, average subject length %.1f, %.1f%% Conventional Commits",
+		overall.Commits, overall.averageLength(), overall.conventionalPercent())
+	ui.LogSuccess("Message statistics written to %s", outputFile)
+	ui.UpdateStatus("Message statistics written to " + outputFile + ". Press Ctrl+C to exit")
+}
+
+// lengthBucket returns the histogram bucket index for a subject length
+func lengthBucket(length int) int {
+	for i, limit := range subjectLengthBuckets {
+		if length < limit {
+			return i
+		}
+	}
+	return len(subjectLengthBuckets)
+}
+
+// lengthBucketLabel returns the label of a subject length histogram bucket
+func lengthBucketLabel(bucket int) string {
+	if bucket == len(subjectLengthBuckets) {
+		return fmt.Sprintf("%d+", subjectLengthBuckets[bucket-1])
+	}
+	lower := 0
+	if bucket > 0 {
+		lower = subjectLengthBuckets[bucket-1]
+	}
+	return fmt.Sprintf("%d-%d", lower, subjectLengthBuckets[bucket]-1)
+}
+
+// histogramBar returns a bar proportional to count, scaled so max fills the width
+func histogramBar(count, max int) string {
+	if max == 0 {
+		return ""
+	}
+	width := count * statsHistogramWidth / max
+	if width == 0 && count > 0 {
+		width = 1
+	}
+	return strings.Repeat("#", width)
+}
+
+// sortedPeriods returns the period keys in chronological order
+func sortedPeriods(periods map[string]*messageStats) []string {
+	keys := make([]string, 0, len(periods))
+	for period := range periods {
+		keys = append(keys, period)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedTypes returns the commit types by descending count
+func sortedTypes(types map[string]int) []string {
+	keys := make([]string, 0, len(types))
+	for t := range types {
+		keys = append(keys, t)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if types[keys[i]] != types[keys[j]] {
+			return types[keys[i]] > types[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// statsText renders the statistics as text histograms
+func statsText(repoPath string, overall messageStats, lengths []int, periods map[string]*messageStats) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Commit message statistics for %s\n", repoPath)
+	fmt.Fprintf(&b, "Generated %s\n\n", time.Now().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "%d commits, average subject length %.1f, %d (%.1f%%) follow Conventional Commits\n",
+		overall.Commits, overall.averageLength(), overall.Conventional, overall.conventionalPercent())
+
+	b.WriteString("\n== Subject length ==\n\n")
+	maxCount := 0
+	for _, count := range lengths {
+		if count > maxCount {
+			maxCount = count
+		}
+	}
+	for i, count := range lengths {
+		fmt.Fprintf(&b, "%-8s %6d %s\n", lengthBucketLabel(i), count, histogramBar(count, maxCount))
+	}
+
+	b.WriteString("\n== Commit types ==\n\n")
+	types := sortedTypes(overall.Types)
+	maxCount = overall.Commits - overall.Conventional
+	for _, t := range types {
+		if overall.Types[t] > maxCount {
+			maxCount = overall.Types[t]
+		}
+	}
+	for _, t := range types {
+		fmt.Fprintf(&b, "%-10s %6d %s\n", t, overall.Types[t], histogramBar(overall.Types[t], maxCount))
+	}
+	nonConventional := overall.Commits - overall.Conventional
+	fmt.Fprintf(&b, "%-10s %6d %s\n", "(none)", nonConventional, histogramBar(nonConventional, maxCount))
+
+	b.WriteString("\n== By month ==\n\n")
+	fmt.Fprintf(&b, "%-8s %7s %10s %13s  %s\n", "Month", "Commits", "Avg length", "Conventional", "Compliance")
+	for _, period := range sortedPeriods(periods) {
+		stats := periods[period]
+		percent := stats.conventionalPercent()
+		fmt.Fprintf(&b, "%-8s %7d %10.1f %12.1f%%  %s\n", period, stats.Commits, stats.averageLength(),
+			percent, histogramBar(int(percent), 100))
+	}
+	return b.String()
+}
+
+// statsCSV renders one CSV row per month, with a column per commit type
+func statsCSV(periods map[string]*messageStats, overall messageStats) ([]byte, error) {
+	types := sortedTypes(overall.Types)
+	header := []string{"period", "commits", "avg_subject_length", "conventional_commits", "conventional_percent"}
+	for _, t := range types {
+		header = append(header, "type_"+t)
+	}
+
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	for _, period := range sortedPeriods(periods) {
+		stats := periods[period]
+		row := []string{
+			period,
+			strconv.Itoa(stats.Commits),
+			strconv.FormatFloat(stats.averageLength(), 'f', 1, 64),
+			strconv.Itoa(stats.Conventional),
+			strconv.FormatFloat(stats.conventionalPercent(), 'f', 1, 64),
+		}
+		for _, t := range types {
+			row = append(row, strconv.Itoa(stats.Types[t]))
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return []byte(b.String()), w.Error()
+}
@@ -0,0 +1,223 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// subcommand is a CLI mode with its own flag set, built from the global flags it accepts
+type subcommand struct {
+	name    string
+	args    string
+	summary string
+	// flags are the global flags the subcommand accepts, nil for all but modeFlags
+	flags []string
+	// setup checks the positional arguments and selects the mode
+	setup func(args []string) error
+}
+
+// modeFlags are the flags that switch a flat invocation into another mode. They are
+// replaced by subcommands and only kept as aliases for one release.
+var modeFlags = map[string]string{
+	"apply-changes":  "apply",
+	"review-changes": "review",
+	"verify-only":    "verify",
+	"replay":         "apply -replay",
+}
+
+// Flags shared by several subcommands
+var (
+	commonFlags  = []string{"repo", "config", "no-tui", "yes", "debug-log", "theme", "theme-file"}
+	scopeFlags   = []string{"first-parent", "all-refs", "since", "until", "range", "author", "skip-message-pattern"}
+	newRepoFlags = []string{"output-repo", "output-dir", "bare", "apply-method", "committer-name", "committer-email", "reset-committer",
+		"sign", "signing-key", "commit-map", "replace-refs", "copy-notes", "repo-report", "rewritten-from-trailer", "keep-original", "provenance-notes", "push-to", "force-with-lease", "author-map", "filter-paths", "allow-dirty",
+		"plan-output", "webhook-url", "webhook-interval", "rewrite-published", "in-place", "verify-trees"}
+)
+
+// subcommands lists the CLI modes in the order they are shown in the help text
+var subcommands = []subcommand{
+	{
+		name:    "rewrite",
+		summary: "Generate new messages for the repository's commits and apply them to a new repository",
+		setup: func(args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			return nil
+		},
+	},
+	{
+		name:    "apply",
+		args:    "<changes.json>",
+		summary: "Apply the messages of a changes file, or of an audit log with -replay, to a new repository",
+		flags:   joinFlags(commonFlags, scopeFlags, newRepoFlags, []string{"replay"}),
+		setup: func(args []string) error {
+			if ReplayFile != "" && len(args) > 0 {
+				return fmt.Errorf("give either a changes file or -replay, not both")
+			}
+			return changesFileArg(args, ReplayFile != "")
+		},
+	},
+	{
+		name:    "review",
+		args:    "<changes.json>",
+		summary: "Accept, edit or reject the messages of a changes file in the TUI",
+		flags:   []string{"repo", "config", "output", "html-report", "report", "debug-log", "theme", "theme-file"},
+		setup: func(args []string) error {
+			if err := changesFileArg(args, false); err != nil {
+				return err
+			}
+			ReviewChangesFile, ApplyChangesFile = ApplyChangesFile, ""
+			return nil
+		},
+	},
+	{
+		name:    "verify",
+		args:    "<changes.json>",
+		summary: "Check a changes file, or an audit log with -replay, and print the plan without applying it",
+		flags:   joinFlags(commonFlags, scopeFlags, []string{"replay"}),
+		setup: func(args []string) error {
+			VerifyOnly = true
+			if ReplayFile != "" && len(args) > 0 {
+				return fmt.Errorf("give either a changes file or -replay, not both")
+			}
+			return changesFileArg(args, ReplayFile != "")
+		},
+	},
+	{
+		name:    "verify-trees",
+		args:    "[<rewritten-repo>]",
+		summary: "Check that every commit of an earlier rewrite has the tree of its original commit",
+		flags:   []string{"repo", "config", "output-repo", "output-dir", "bare", "commit-map", "filter-paths", "no-tui", "debug-log", "theme", "theme-file"},
+		setup: func(args []string) error {
+			verifyTreesOnly = true
+			switch len(args) {
+			case 0:
+			case 1:
+				verifyTreesTarget = args[0]
+			default:
+				return fmt.Errorf("unexpected arguments: %s", strings.Join(args[1:], " "))
+			}
+			return nil
+		},
+	},
+}
+
+// joinFlags concatenates flag name lists
+func joinFlags(lists ...[]string) []string {
+	var names []string
+	for _, list := range lists {
+		names = append(names, list...)
+	}
+	return names
+}
+
+// changesFileArg takes the changes file from the positional arguments. It may be
+// omitted when optional is set, e.g. because -replay names an audit log instead.
+func changesFileArg(args []string, optional bool) error {
+	switch {
+	case len(args) == 1:
+		ApplyChangesFile = args[0]
+	case len(args) == 0 && optional:
+	case len(args) == 0:
+		return fmt.Errorf("missing changes file")
+	default:
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args[1:], " "))
+	}
+	return nil
+}
+
+// deprecationWarnings are logged once the UI is up
+var deprecationWarnings []string
+
+// parseCommandLine parses the arguments of a subcommand, or the flat flags of earlier
+// releases when the first argument is not a subcommand name
+func parseCommandLine() {
+	flag.Usage = printUsage
+	if len(os.Args) > 1 {
+		for _, sub := range subcommands {
+			if os.Args[1] == sub.name {
+				parseSubcommand(sub, os.Args[2:])
+				return
+			}
+		}
+		if os.Args[1] == "help" {
+			printUsage()
+			os.Exit(0)
+		}
+	}
+
+	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if replacement, ok := modeFlags[f.Name]; ok {
+			deprecationWarnings = append(deprecationWarnings,
+				fmt.Sprintf("-%s is deprecated and will be removed in the next release, use 'gitrewrite %s' instead", f.Name, replacement))
+		}
+	})
+}
+
+// parseSubcommand parses a subcommand's flags into the global flag variables. Flags and
+// positional arguments may be mixed, e.g. gitrewrite apply changes.json -repo=.
+func parseSubcommand(sub subcommand, args []string) {
+	flags := flag.NewFlagSet(sub.name, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		if sub.accepts(f.Name) {
+			flags.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gitrewrite %s [flags] %s\n\n%s.\n\nFlags:\n", sub.name, sub.args, sub.summary)
+		flags.PrintDefaults()
+	}
+
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if err := sub.setup(positional); err != nil {
+		fmt.Fprintf(flags.Output(), "gitrewrite %s: %v\n", sub.name, err)
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// accepts reports whether a global flag belongs to the subcommand's flag set
+func (sub subcommand) accepts(name string) bool {
+	if sub.flags == nil {
+		_, isMode := modeFlags[name]
+		return !isMode
+	}
+	for _, accepted := range sub.flags {
+		if accepted == name {
+			return true
+		}
+	}
+	return false
+}
+
+// printUsage lists the subcommands followed by the flat flags of earlier releases
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: gitrewrite <command> [flags]\n\nCommands:\n")
+	for _, sub := range subcommands {
+		fmt.Fprintf(out, "  %-13s %s\n", sub.name, sub.summary)
+	}
+	fmt.Fprintf(out, "  %-13s %s\n", "login", "Store the API key of an OpenAI-compatible API in the OS keychain")
+	fmt.Fprintf(out, "  %-13s %s\n", "selftest", "Rewrite a synthetic repository with a mock model and check the result")
+	fmt.Fprintf(out, "  %-13s %s\n", "lint", "Report the commits whose messages don't follow Conventional Commits, failing if there are any")
+	fmt.Fprintf(out, "  %-13s %s\n", "changelog", "Write a changelog with a release per tag from the history's Conventional Commits messages")
+	fmt.Fprintf(out, "  %-13s %s\n", "cleanup", "Remove temporary files left behind by crashed runs")
+	fmt.Fprintf(out, "  %-13s %s\n", "suggest", "Generate a message for the staged changes")
+	fmt.Fprintf(out, "  %-13s %s\n", "install-hook", "Install a prepare-commit-msg hook that pre-fills commit messages with suggest")
+	fmt.Fprintf(out, "  %-13s %s\n", "serve", "Run rewrite jobs submitted over an HTTP API")
+	fmt.Fprintf(out, "\nRun 'gitrewrite <command> -h' for the flags of a command. Running without a command\n"+
+		"accepts every flag, as in earlier releases:\n\n")
+	flag.PrintDefaults()
+}
@@ -0,0 +1,184 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// suggestFlags are the global flags suggest accepts: those choosing the model and the
+// form of the message
+var suggestFlags = []string{
+	"repo", "config", "model", "temperature", "max-diff", "exclude", "commit-timeout", "tokenizer", "map-reduce",
+	"backend", "api-base", "api-key", "mock-llm", "llm-retries", "llm-retry-backoff", "keep-alive",
+	"ollama-host", "ollama-header", "ollama-ca-cert", "ollama-client-cert", "ollama-client-key", "ollama-timeout",
+	"ollama-insecure-skip-verify", "style", "language", "body", "prompt-file", "message-template",
+	"message-separator", "single-subject",
+}
+
+// SuggestCommand generates a message for the changes staged in the repository, for
+// one commit before it is made rather than for the history afterwards. The message is
+// printed, committed with -commit, or with -message-file written in front of the
+// file's content, which is how the prepare-commit-msg hook of install-hook pre-fills
+// the commit editor.
+//
+//	gitrewrite suggest [-repo=.] [-commit | -message-file=.git/COMMIT_EDITMSG] [-model=...]
+func SuggestCommand(args []string) error {
+	defineFlags()
+	RepoPath = "."
+	flags := flag.NewFlagSet("suggest", flag.ContinueOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		for _, name := range suggestFlags {
+			if f.Name == name {
+				flags.Var(f.Value, f.Name, f.Usage)
+			}
+		}
+	})
+	messageFile := flags.String("message-file", "", "Write the message in front of this file's content instead of printing it, e.g. the file git passes to a prepare-commit-msg hook")
+	commitStaged := flags.Bool("commit", false, "Commit the staged changes with the message instead of printing it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	if *commitStaged && *messageFile != "" {
+		return fmt.Errorf("give either -commit or -message-file, not both")
+	}
+
+	ui.SetupQuietConsole()
+	message, err := suggestMessage()
+	if err != nil {
+		return err
+	}
+	if *commitStaged {
+		return commitWithMessage(RepoPath, message)
+	}
+	if *messageFile == "" {
+		fmt.Println(message)
+		return nil
+	}
+	existing, err := os.ReadFile(*messageFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read message file: %v", err)
+	}
+	if err := os.WriteFile(*messageFile, []byte(message+"\n"+string(existing)), 0644); err != nil {
+		return fmt.Errorf("failed to write message file: %v", err)
+	}
+	return nil
+}
+
+// commitWithMessage commits the staged changes of repoPath with message, running the
+// repository's commit hooks as git commit -m would
+func commitWithMessage(repoPath, message string) error {
+	args := []string{"commit", "--quiet", "--file=-"}
+	ui.LogShellCommand("git", args, repoPath)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(message + "\n")
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to commit: %v", err)
+	}
+	fmt.Println(message)
+	return nil
+}
+
+// suggestMessage generates the message of the staged changes
+func suggestMessage() (string, error) {
+	if ConfigFile != "" {
+		if err := LoadConfig(ConfigFile); err != nil {
+			return "", err
+		}
+	}
+	if err := configureSuggestion(); err != nil {
+		return "", err
+	}
+
+	commit, err := services.GetStagedChanges(RepoPath, MaxDiffLength, AppConfig.DiffLimits)
+	if err != nil {
+		return "", err
+	}
+	if ExcludeFiles != "" {
+		excludePattern, err := regexp.Compile(ExcludeFiles)
+		if err != nil {
+			return "", fmt.Errorf("invalid -exclude pattern: %v", err)
+		}
+		commit.Files = filterExcludedFiles(commit.Files, excludePattern)
+	}
+	if len(commit.Files) == 0 {
+		return "", fmt.Errorf("no staged changes in %s, stage them with git add first", RepoPath)
+	}
+
+	client, err := newLLMClient()
+	if err != nil {
+		return "", fmt.Errorf("invalid backend configuration: %v", err)
+	}
+	if MockLLM {
+		client = services.MockClient{}
+		Model = "mock-llm"
+	}
+	services.Client = client
+	if modelContextSize, err = client.ContextSize(Model); err != nil {
+		return "", fmt.Errorf("failed to determine context size for model %s: %v", Model, err)
+	}
+
+	newCommit, _, err := generateCommitMessage(commit)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate a message: %v", err)
+	}
+	return assembleCommitMessage(newCommit, commit), nil
+}
+
+// configureSuggestion applies the flags that shape the message, as a rewrite does
+func configureSuggestion() error {
+	if Style != services.StyleConventional && Style != services.StyleGitmoji && Style != services.StylePlain && Style != services.StyleCustom {
+		return fmt.Errorf("invalid -style value %q: must be 'conventional', 'gitmoji', 'plain' or 'custom'", Style)
+	}
+	tokens, err := services.NewTokenCounter(Tokenizer)
+	if err != nil {
+		return err
+	}
+	services.Tokens = tokens
+	services.MessageCacheDir = ""
+	services.LLMRetries, services.LLMRetryBackoff = LLMRetries, LLMRetryBackoff
+	services.KeepAlive = KeepAlive
+	services.GenerateBody = GenerateBody
+	services.Style = Style
+	services.MapReduce = MapReduce
+	services.Language = strings.TrimSpace(Language)
+
+	if PromptFile == "" {
+		PromptFile = AppConfig.PromptFile
+	}
+	if PromptFile != "" {
+		if err := services.LoadPromptTemplate(PromptFile); err != nil {
+			return err
+		}
+	}
+	if MessageTemplate == "" {
+		MessageTemplate = AppConfig.MessageTemplate
+	}
+	if MessageTemplate != "" {
+		if err := loadMessageTemplate(MessageTemplate); err != nil {
+			return err
+		}
+	}
+	if Style == services.StyleCustom && (PromptFile == "" || MessageTemplate == "") {
+		return fmt.Errorf("-style=custom needs a -prompt-file and a -message-template")
+	}
+	if MessageSeparator != "" {
+		separator, err := parseSubjectSeparator(MessageSeparator)
+		if err != nil {
+			return err
+		}
+		subjectSeparator = separator
+	}
+	return nil
+}
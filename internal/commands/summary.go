@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// runStarted is when the run began, for the wall-clock time of its summary
+var runStarted time.Time
+
+// runSummary is the outcome of a run, shown when it finishes and saved with -summary-file.
+// Durations are in seconds.
+type runSummary struct {
+	Processed      int     `json:"processed"`
+	Total          int     `json:"total"`
+	Rewritten      int     `json:"rewritten"`
+	Skipped        int     `json:"skipped"`
+	Failed         int     `json:"failed"`
+	LLMRequests    int     `json:"llm_requests"`
+	LLMTotal       float64 `json:"llm_latency_total_seconds"`
+	LLMAverage     float64 `json:"llm_latency_average_seconds"`
+	LLMMedian      float64 `json:"llm_latency_median_seconds"`
+	PromptTokens   int     `json:"prompt_tokens"`
+	ResponseTokens int     `json:"response_tokens"`
+	WallClock      float64 `json:"wall_clock_seconds"`
+}
+
+// summarizeRun collects the summary of the run so far. Commits kept with their original
+// message, whether they didn't need a rewrite or were excluded or rejected, are skipped.
+func summarizeRun(outputs []models.RewriteOutput) runSummary {
+	summary := runSummary{
+		Processed: ui.ProcessedCommits,
+		Total:     ui.TotalCommits,
+		Failed:    len(failedCommits),
+		WallClock: time.Since(runStarted).Seconds(),
+	}
+	if DryRun {
+		for _, output := range outputs {
+			if !output.Skipped {
+				summary.Rewritten++
+			}
+		}
+	} else {
+		for _, mapping := range appliedCommits {
+			if mapping.Rewritten {
+				summary.Rewritten++
+			}
+		}
+	}
+	summary.Skipped = max(summary.Total-summary.Rewritten-summary.Failed, 0)
+
+	latencies := services.RequestLatencies()
+	summary.LLMRequests = len(latencies)
+	if len(latencies) > 0 {
+		var total time.Duration
+		for _, latency := range latencies {
+			total += latency
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		median := latencies[len(latencies)/2]
+		if len(latencies)%2 == 0 {
+			median = (latencies[len(latencies)/2-1] + median) / 2
+		}
+		summary.LLMTotal = total.Seconds()
+		summary.LLMAverage = (total / time.Duration(len(latencies))).Seconds()
+		summary.LLMMedian = median.Seconds()
+	}
+	summary.PromptTokens, summary.ResponseTokens = services.TokensUsed()
+	return summary
+}
+
+// summaryText renders a run summary as the lines shown when the run finishes
+func summaryText(summary runSummary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Commits processed: %d of %d\n", summary.Processed, summary.Total)
+	fmt.Fprintf(&b, "Rewritten: %d\n", summary.Rewritten)
+	fmt.Fprintf(&b, "Skipped: %d\n", summary.Skipped)
+	fmt.Fprintf(&b, "Failed: %d\n", summary.Failed)
+	if summary.LLMRequests > 0 {
+		fmt.Fprintf(&b, "LLM requests: %d, latency total %s, average %s, median %s\n", summary.LLMRequests,
+			summaryDuration(summary.LLMTotal), summaryDuration(summary.LLMAverage), summaryDuration(summary.LLMMedian))
+	} else {
+		b.WriteString("LLM requests: 0\n")
+	}
+	fmt.Fprintf(&b, "Tokens: %d (%d prompt, %d response)\n", summary.PromptTokens+summary.ResponseTokens, summary.PromptTokens, summary.ResponseTokens)
+	fmt.Fprintf(&b, "Wall-clock time: %s\n", summaryDuration(summary.WallClock))
+	return b.String()
+}
+
+// summaryDuration formats a number of seconds, e.g. 1m4.2s or 350ms
+func summaryDuration(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// showRunSummary shows the summary of the finished run and writes it to -summary-file.
+// A .json file gets the summary as a JSON object, any other file the shown lines.
+func showRunSummary(outputs []models.RewriteOutput) {
+	summary := summarizeRun(outputs)
+	text := summaryText(summary)
+	ui.LogInfo("Run summary:")
+	ui.ShowRunSummary(text)
+
+	if SummaryFile == "" {
+		return
+	}
+	data := []byte(text)
+	if strings.HasSuffix(strings.ToLower(SummaryFile), ".json") {
+		var err error
+		data, err = json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			ui.LogError("Failed to marshal run summary: %v", err)
+			return
+		}
+	}
+	if err := os.WriteFile(SummaryFile, data, 0644); err != nil {
+		ui.LogError("Failed to write run summary: %v", err)
+		return
+	}
+	ui.LogInfo("Run summary written to %s", SummaryFile)
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/MrLemur/gitrewrite/pkg/helpers"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// maxVerifiedSubjectLength is the subject length above which -verify-only warns
+const maxVerifiedSubjectLength = 100
+
+// verifyChanges checks a changes file against the repository without creating the new
+// repository: every entry must name a commit in the history being rewritten, at most
+// once, with a non-empty message. Subjects that don't follow Conventional Commits or
+// are overly long only produce warnings. The resulting plan is logged one line per
+// commit, and an error is returned when any entry fails a check.
+func verifyChanges(repo *git.Repository, changes []models.RewriteOutput) error {
+	ui.UpdateStatus("Verifying changes...")
+	allCommits, _, err := services.GetCommitsChronological(repo, scanOptions())
+	if err != nil {
+		ui.LogError("Failed to get all commits: %v", err)
+		ui.UpdateStatus("Error: Failed to get all commits")
+		return err
+	}
+	inHistory := make(map[string]bool, len(allCommits))
+	for _, commit := range allCommits {
+		inHistory[commit.CommitID] = true
+	}
+
+	problems := 0
+	problem := func(format string, args ...interface{}) {
+		ui.LogError(format, args...)
+		problems++
+	}
+
+	byID := make(map[string]models.RewriteOutput, len(changes))
+	for i, change := range changes {
+		entry := fmt.Sprintf("entry %d (%s)", i+1, change.CommitID)
+		if _, err := repo.CommitObject(plumbing.NewHash(change.CommitID)); len(change.CommitID) != 40 || err != nil {
+			problem("%s: commit does not exist in the repository", entry)
+			continue
+		}
+		if !inHistory[change.CommitID] {
+			problem("%s: commit is not part of the history being rewritten", entry)
+			continue
+		}
+		if _, ok := byID[change.CommitID]; ok {
+			problem("%s: commit is listed more than once", entry)
+			continue
+		}
+		byID[change.CommitID] = change
+
+		message := strings.TrimSpace(change.RewrittenMsg)
+		if change.Skipped {
+			continue
+		}
+		if message == "" {
+			problem("%s: rewritten message is empty", entry)
+			continue
+		}
+		if strings.ContainsRune(message, 0) {
+			problem("%s: rewritten message contains a NUL byte", entry)
+			continue
+		}
+		subject := strings.TrimSpace(strings.SplitN(message, "\n", 2)[0])
+		if !helpers.ConventionalSubject.MatchString(subject) {
+			ui.LogWarning("%s: subject %q does not follow Conventional Commits", entry, subject)
+		}
+		if len(subject) > maxVerifiedSubjectLength {
+			ui.LogWarning("%s: subject is %d characters long", entry, len(subject))
+		}
+	}
+
+	rewritten := 0
+	ui.LogInfo("Plan for %d commits:", len(allCommits))
+	for _, commit := range allCommits {
+		change, ok := byID[commit.CommitID]
+		switch {
+		case !ok:
+			ui.LogInfo("  %s keep     %s", commit.CommitID[:8], firstLine(commit.Message))
+		case change.Skipped:
+			ui.LogInfo("  %s skipped  %s (%s)", commit.CommitID[:8], firstLine(commit.Message), change.SkipReason)
+		default:
+			rewritten++
+			ui.LogInfo("  %s rewrite  %s -> %s", commit.CommitID[:8], firstLine(commit.Message), firstLine(change.RewrittenMsg))
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("verification found %d problems in %d change entries", problems, len(changes))
+	}
+	ui.LogSuccess("All %d change entries are valid: %d of %d commits would be rewritten", len(changes), rewritten, len(allCommits))
+	return nil
+}
+
+// firstLine returns the trimmed first line of a commit message
+func firstLine(message string) string {
+	return strings.TrimSpace(strings.SplitN(strings.TrimSpace(message), "\n", 2)[0])
+}
@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+)
+
+// verifyTreesOnly is set by the verify-trees subcommand, which checks an earlier
+// rewrite against its commit map; verifyTreesTarget is the rewritten repository
+var (
+	verifyTreesOnly   bool
+	verifyTreesTarget string
+)
+
+// verifyTrees checks that every rewritten commit has the tree of the commit it
+// replaces and logs each divergence. It reports whether all trees match.
+func verifyTrees(newRepoPath string, hashMap map[string]string) bool {
+	ui.UpdateStatus("Verifying rewritten trees...")
+	repo, err := git.PlainOpen(RepoPath)
+	if err != nil {
+		ui.LogError("Failed to open repository to verify trees: %v", err)
+		return false
+	}
+	mismatches, err := services.CompareTrees(repo, newRepoPath, hashMap)
+	if err != nil {
+		ui.LogError("Failed to verify trees: %v", err)
+		return false
+	}
+	for _, mismatch := range mismatches {
+		ui.LogError("Tree of %s differs from original %s: %s", mismatch.NewID[:8], mismatch.OriginalID[:8], mismatch.Reason)
+	}
+	if len(mismatches) > 0 {
+		ui.LogError("%d of %d rewritten commits don't have the tree of their original commit", len(mismatches), len(hashMap))
+		return false
+	}
+	if services.FilteredPaths != nil {
+		ui.LogSuccess("Verified that all %d rewritten commits have the tree of their original commit without the filtered paths", len(hashMap))
+		return true
+	}
+	ui.LogSuccess("Verified that all %d rewritten commits have the tree of their original commit", len(hashMap))
+	return true
+}
+
+// VerifyTreesMode compares the trees of an earlier rewrite, given by its commit map,
+// with the source repository and fails when any of them differ
+func VerifyTreesMode(repoPath, newRepoPath string) {
+	if newRepoPath == "" {
+		newRepoPath = services.NewRepositoryPath(repoPath, OutputDir, newRepositoryName(repoPath))
+	}
+	ui.LogInfo("Comparing the trees of %s with %s using %s", newRepoPath, repoPath, commitMapPath())
+
+	hashMap, err := services.ReadCommitMap(commitMapPath())
+	if err != nil {
+		ui.LogError("%v", err)
+		ui.UpdateStatus("Error: Failed to read commit map")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("%v", err)
+	}
+	if !verifyTrees(newRepoPath, hashMap) {
+		ui.UpdateStatus("Error: Tree verification failed")
+		time.Sleep(2 * time.Second)
+		ui.Stop()
+		log.Fatalf("Tree verification failed, see the log for the diverging commits")
+	}
+	ui.UpdateStatus(fmt.Sprintf("All %d trees match. Press Ctrl+C to exit", len(hashMap)))
+}
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/services"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// progressWebhook holds the state of the -webhook-url progress reporter
+var progressWebhook struct {
+	sync.Mutex
+	mode string
+	stop chan struct{}
+}
+
+// startProgressWebhook POSTs a progress update to -webhook-url right away and then
+// every -webhook-interval until finishProgressWebhook is called
+func startProgressWebhook(mode string) {
+	if WebhookURL == "" {
+		return
+	}
+	progressWebhook.Lock()
+	defer progressWebhook.Unlock()
+	if progressWebhook.stop != nil {
+		close(progressWebhook.stop)
+	}
+	progressWebhook.mode = mode
+	stop := make(chan struct{})
+	progressWebhook.stop = stop
+	ui.LogInfo("Sending progress updates to %s every %s", WebhookURL, WebhookInterval)
+
+	go func() {
+		sendProgressUpdate("progress")
+		ticker := time.NewTicker(WebhookInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				sendProgressUpdate("progress")
+			}
+		}
+	}()
+}
+
+// finishProgressWebhook stops the periodic updates and sends a last update for event,
+// "finished" or "stopped"
+func finishProgressWebhook(event string) {
+	progressWebhook.Lock()
+	stop := progressWebhook.stop
+	progressWebhook.stop = nil
+	progressWebhook.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	sendProgressUpdate(event)
+}
+
+// sendProgressUpdate POSTs the current progress. Failures are only logged, a
+// monitoring endpoint being down never stops a run.
+func sendProgressUpdate(event string) {
+	progressWebhook.Lock()
+	mode := progressWebhook.mode
+	progressWebhook.Unlock()
+
+	update := models.ProgressUpdate{
+		Event:         event,
+		Time:          time.Now(),
+		Repo:          services.GetRepoName(RepoPath),
+		Mode:          mode,
+		Phase:         ui.Phase,
+		Processed:     ui.ProcessedCommits,
+		Total:         ui.TotalCommits,
+		CurrentCommit: ui.CurrentCommit,
+	}
+	if update.Total > 0 {
+		update.Percent = float64(update.Processed) / float64(update.Total) * 100
+	}
+	if remaining, ok := ui.EstimatedTimeRemaining(); ok && event == "progress" {
+		update.ETASeconds = int(remaining.Seconds())
+	}
+	if err := services.PostWebhook(WebhookURL, update); err != nil {
+		ui.LogWarning("Failed to send progress update: %v", err)
+	}
+}
@@ -1,11 +1,17 @@
 package models
 
+import "time"
+
 // CommitOutput represents the structure of a git commit with its details
 type CommitOutput struct {
 	CommitID     string `json:"commit_id"`
 	Message      string `json:"message"`
 	Files        []File `json:"files"`
 	NeedsRewrite bool   `json:"needs_rewrite"`
+	// Hints describe the kind of change for the model, e.g. "Go module dependency update"
+	Hints []string `json:"hints,omitempty"`
+	// SideBranch marks commits only reachable from branches and tags other than HEAD
+	SideBranch bool `json:"-"`
 }
 
 // File represents a single file change in a commit
@@ -18,6 +24,10 @@ type File struct {
 type NewCommitMessage struct {
 	CommitID string              `json:"commit_id"`
 	Messages []map[string]string `json:"messages"`
+	// Body and Footers are only requested with -body: an explanatory paragraph and
+	// lines like "BREAKING CHANGE: ..." or "Refs: #123"
+	Body    string   `json:"body,omitempty"`
+	Footers []string `json:"footers,omitempty"`
 }
 
 // RewriteOutput represents an entry in the dry run output file
@@ -27,6 +37,183 @@ type RewriteOutput struct {
 	RewrittenMsg string `json:"rewritten_message"`
 	FilesChanged int    `json:"files_changed"`
 	IsApplied    bool   `json:"is_applied"`
+	Skipped      bool   `json:"skipped,omitempty"`
+	SkipReason   string `json:"skip_reason,omitempty"`
+}
+
+// CommitMapping links a commit in the source repository to the commit it was
+// rewritten to in the new repository
+type CommitMapping struct {
+	OriginalID string `json:"original_id"`
+	NewID      string `json:"new_id"`
+	Rewritten  bool   `json:"rewritten"`
+}
+
+// RewriteProvenance is the note -provenance-notes attaches to a rewritten commit
+type RewriteProvenance struct {
+	OriginalCommit  string `json:"original_commit"`
+	OriginalMessage string `json:"original_message"`
+	// Model and Temperature are left out when the messages came from a changes file
+	Model       string    `json:"model,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	RewrittenAt time.Time `json:"rewritten_at"`
+	Tool        string    `json:"tool"`
+}
+
+// FailedCommit records a commit whose message could not be generated
+type FailedCommit struct {
+	CommitID string `json:"commit_id"`
+	// NewID is the commit in the new repository that kept the original message
+	NewID  string `json:"new_id,omitempty"`
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+// FailureReport lists the failed commits of a run and where their results went,
+// so a later run can retry only those commits
+type FailureReport struct {
+	RepoPath       string         `json:"repo_path"`
+	TargetRepoPath string         `json:"target_repo_path,omitempty"`
+	ChangesFile    string         `json:"changes_file,omitempty"`
+	Failures       []FailedCommit `json:"failures"`
+}
+
+// PathThreshold overrides the rewrite message length threshold for files under a path
+type PathThreshold struct {
+	Path      string `json:"path"`
+	MaxLength int    `json:"max_length"`
+}
+
+// DiffLimit overrides the diff length limit for files matching a glob pattern, so
+// low-signal files like lockfiles don't use up the prompt
+type DiffLimit struct {
+	// Pattern is matched against the file name, or against the whole path when it
+	// contains a slash, e.g. "*.json" or "docs/*.md"
+	Pattern string `json:"pattern"`
+	MaxDiff int    `json:"max_diff"`
+}
+
+// FooterRule appends a "Key: value" footer to rewritten messages for every match of
+// Pattern in the commit's branch name and/or original message
+type FooterRule struct {
+	Key     string `json:"key"`
+	Pattern string `json:"pattern"`
+	// From is "branch", "message" or empty to search both
+	From string `json:"from,omitempty"`
+}
+
+// RunPlan summarizes what a run is about to do, as written by -plan-output
+type RunPlan struct {
+	RepoPath         string `json:"repo_path"`
+	TargetPath       string `json:"target_path,omitempty"`
+	Mode             string `json:"mode"`
+	Model            string `json:"model,omitempty"`
+	TotalCommits     int    `json:"total_commits"`
+	RewriteCommits   int    `json:"rewrite_commits"`
+	EstimatedTokens  int    `json:"estimated_tokens"`
+	TokenBudget      int    `json:"token_budget,omitempty"`
+	EstimatedSeconds int    `json:"estimated_seconds"`
+	// ExcludedCommits are the commits toggled out of the rewrite set in the commit browser
+	ExcludedCommits []string `json:"excluded_commits,omitempty"`
+	// RewriteCommitIDs lists the commits to rewrite, written by -phases=scan
+	RewriteCommitIDs []string `json:"rewrite_commit_ids,omitempty"`
+}
+
+// LintReport lists the commits whose messages don't follow Conventional Commits, as
+// written by gitrewrite lint
+type LintReport struct {
+	Repo string `json:"repo"`
+	// Checked counts the linted commits, Ignored the merge, revert and fixup commits
+	// left out of the check
+	Checked    int             `json:"checked"`
+	Ignored    int             `json:"ignored"`
+	Types      map[string]int  `json:"types"`
+	Violations []LintViolation `json:"violations"`
+}
+
+// LintViolation is a commit whose message breaks Conventional Commits rules
+type LintViolation struct {
+	CommitID string   `json:"commit_id"`
+	Subject  string   `json:"subject"`
+	Problems []string `json:"problems"`
+}
+
+// ProgressUpdate is the payload POSTed to -webhook-url while a run is in progress
+type ProgressUpdate struct {
+	// Event is "progress" for periodic updates, "finished" or "stopped" at the end of a run
+	Event         string    `json:"event"`
+	Time          time.Time `json:"time"`
+	Repo          string    `json:"repo"`
+	Mode          string    `json:"mode"`
+	Phase         string    `json:"phase,omitempty"`
+	Processed     int       `json:"processed"`
+	Total         int       `json:"total"`
+	Percent       float64   `json:"percent"`
+	ETASeconds    int       `json:"eta_seconds,omitempty"`
+	CurrentCommit string    `json:"current_commit,omitempty"`
+}
+
+// ProgressEvent is a line of the -progress stream
+type ProgressEvent struct {
+	// Event is "started", "generated", "applied", "failed" or "progress" during a run,
+	// "finished" or "stopped" at its end
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+	CommitID    string    `json:"commit_id,omitempty"`
+	NewCommitID string    `json:"new_commit_id,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Rewritten   bool      `json:"rewritten,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Processed   int       `json:"processed"`
+	Total       int       `json:"total"`
+	Percent     float64   `json:"percent"`
+	ETASeconds  int       `json:"eta_seconds,omitempty"`
+}
+
+// ServeJob is a rewrite job of gitrewrite serve, as returned by its API
+type ServeJob struct {
+	ID string `json:"id"`
+	// State is "queued", "running", "finished", "failed" or "canceled"
+	State  string `json:"state"`
+	Repo   string `json:"repo"`
+	DryRun bool   `json:"dry_run"`
+	// Options are the flags given for the job, without the leading dash
+	Options   map[string]string `json:"options,omitempty"`
+	Processed int               `json:"processed"`
+	Total     int               `json:"total"`
+	Percent   float64           `json:"percent"`
+	// Message is the last line the job logged, Error why it failed
+	Message    string     `json:"message,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Downloads are the paths of the job's results, once they exist
+	Downloads map[string]string `json:"downloads,omitempty"`
+}
+
+// CommitState is what the -state-db database records about a commit of a run
+type CommitState struct {
+	CommitID string `json:"commit_id"`
+	// Index is the position of the commit in the history, oldest first
+	Index        int    `json:"index"`
+	Message      string `json:"message"`
+	NeedsRewrite bool   `json:"needs_rewrite"`
+	SideBranch   bool   `json:"side_branch,omitempty"`
+	FilesChanged int    `json:"files_changed"`
+	// Status is "pending", "rewritten", "skipped" or "failed"
+	Status       string    `json:"status"`
+	RewrittenMsg string    `json:"rewritten_message,omitempty"`
+	SkipReason   string    `json:"skip_reason,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	NewID        string    `json:"new_id,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ReleaseTag is a tag marking a release, with the date it was tagged
+type ReleaseTag struct {
+	Name string
+	Date time.Time
 }
 
 // OllamaOutputFormat defines the JSON schema for Ollama API responses
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// Types of audit log entries
+const (
+	// AuditExchange records a request sent to the model and its response
+	AuditExchange = "exchange"
+	// AuditMessage records the message a commit was finally given
+	AuditMessage = "message"
+)
+
+// AuditEntry is one line of an audit log
+type AuditEntry struct {
+	Time     time.Time     `json:"time"`
+	Type     string        `json:"type"`
+	CommitID string        `json:"commit_id"`
+	Backend  string        `json:"backend,omitempty"`
+	Model    string        `json:"model,omitempty"`
+	Prompt   []ChatMessage `json:"prompt,omitempty"`
+	Response string        `json:"response,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Message  string        `json:"message,omitempty"`
+}
+
+// auditLog is the open audit log, nil when auditing is disabled
+var auditLog struct {
+	sync.Mutex
+	file *os.File
+}
+
+// OpenAuditLog starts appending audit entries to the JSON lines file at path
+func OpenAuditLog(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	auditLog.file = file
+	return nil
+}
+
+// WriteAuditEntry appends an entry to the audit log, if one is open
+func WriteAuditEntry(entry AuditEntry) {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	if auditLog.file == nil {
+		return
+	}
+	entry.Time = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		ui.LogWarning("Failed to marshal audit entry: %v", err)
+		return
+	}
+	if _, err := auditLog.file.Write(append(data, '\n')); err != nil {
+		ui.LogWarning("Failed to write audit log: %v", err)
+	}
+}
+
+// ReadAuditLog reads every entry of an audit log
+func ReadAuditLog(path string) ([]AuditEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log %s line %d: %v", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	return entries, nil
+}
+
+// auditedChat sends a chat request for a commit through the active backend and
+// records the exchange in the audit log
+func auditedChat(ctx context.Context, commitID, model string, messages []ChatMessage, format json.RawMessage, temperature float64) (string, error) {
+	start := time.Now()
+	resp, err := Client.Chat(ctx, model, messages, format, temperature)
+	recordRequestLatency(time.Since(start))
+	entry := AuditEntry{
+		Type:     AuditExchange,
+		CommitID: commitID,
+		Backend:  Client.Name(),
+		Model:    model,
+		Prompt:   messages,
+		Response: resp,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	WriteAuditEntry(entry)
+	return resp, err
+}
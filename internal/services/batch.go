@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// batchPromptRule is added to the system prompt when several commits are sent at once
+const batchPromptRule = "\nThe input is a JSON object whose commits array holds several independent commits. " +
+	"Reply with a JSON object whose commits array holds one result per commit, each with the commit_id it describes " +
+	"and its own messages, following the rules above for every commit separately."
+
+// batchInput is the commit data of a batched request
+type batchInput struct {
+	Commits []models.CommitOutput `json:"commits"`
+}
+
+// batchReply is the reply to a batched request
+type batchReply struct {
+	Commits []models.NewCommitMessage `json:"commits"`
+}
+
+// PackCommitBatches splits commits, in order, into batches of at most maxCommits
+// commits with at most maxTokens estimated tokens of commit data together. A commit
+// larger than maxTokens is a batch of its own.
+func PackCommitBatches(commits []models.CommitOutput, maxCommits, maxTokens int) [][]models.CommitOutput {
+	var batches [][]models.CommitOutput
+	var batch []models.CommitOutput
+	used := 0
+	for _, commit := range commits {
+		tokens := commitDataTokens(commit)
+		if len(batch) > 0 && (len(batch) >= maxCommits || used+tokens > maxTokens) {
+			batches = append(batches, batch)
+			batch, used = nil, 0
+		}
+		batch = append(batch, commit)
+		used += tokens
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// commitDataTokens estimates the tokens of a commit in the prompt, with its hints
+func commitDataTokens(commit models.CommitOutput) int {
+	commit.Hints = ChangeHints(commit.Files)
+	data, _ := json.Marshal(commit)
+	return EstimateTokenCount(string(data))
+}
+
+// GenerateBatchCommitMessages generates the messages of several commits in one request.
+// The results are keyed by commit ID; commits the model left out of its reply are
+// missing and have to be generated on their own.
+func GenerateBatchCommitMessages(ctx context.Context, commits []models.CommitOutput, model string, temperature float64, contextSize int) (map[string]models.NewCommitMessage, error) {
+	ui.UpdateStatus(fmt.Sprintf("Generating messages for %d commits...", len(commits)))
+	input := batchInput{}
+	for _, commit := range commits {
+		commit.Hints = ChangeHints(commit.Files)
+		input.Commits = append(input.Commits, commit)
+	}
+	systemPrompt := withPromptOptions(defaultPrompt()) + batchPromptRule
+	format := models.OllamaOutputFormat{
+		Type: "object",
+		Properties: map[string]interface{}{
+			"commits": map[string]interface{}{"type": "array", "items": commitMessageFormat()},
+		},
+		Required: []string{"commits"},
+	}
+
+	inputJSON, _ := json.Marshal(input)
+	formatJSON, _ := json.Marshal(format)
+	totalTokens := EstimateTokenCount(systemPrompt) + EstimateTokenCount(string(inputJSON)) + EstimateTokenCount(string(formatJSON))
+	if totalTokens+contextSize/4 > contextSize {
+		return nil, fmt.Errorf("%w (%d tokens needed, %d available)", ErrContextExceeded, totalTokens+contextSize/4, contextSize)
+	}
+
+	messages := []ChatMessage{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: string(inputJSON)},
+	}
+	ui.LogInfo("Sending %d commits to %s in one request (est. %d tokens)", len(commits), Client.Name(), totalTokens)
+	var reply batchReply
+	if err := requestJSON(ctx, commits[0].CommitID, model, messages, json.RawMessage(formatJSON), temperature, &reply); err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]models.NewCommitMessage, len(commits))
+	for _, newCommit := range reply.Commits {
+		if len(newCommit.Messages) == 0 {
+			continue
+		}
+		// Models sometimes shorten the IDs, which still identify the commit
+		for _, commit := range commits {
+			if len(newCommit.CommitID) >= 7 && strings.HasPrefix(commit.CommitID, newCommit.CommitID) {
+				newCommit.CommitID = commit.CommitID
+				results[commit.CommitID] = newCommit
+				break
+			}
+		}
+	}
+	ui.UpdateStatus("Ready")
+	return results, nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// MessageCacheDir is the user-level directory where generated messages are cached
+// across runs and repositories. An empty value disables the cache.
+var MessageCacheDir string
+
+// RefreshCache ignores the cached entries while still writing new results, so a run
+// regenerates every message and replaces what was cached for it
+var RefreshCache bool
+
+// Kinds of cached generation results
+const (
+	cacheKindMessage = "message"
+	cacheKindSummary = "summary"
+)
+
+// cacheEntry is a single cached generation result
+type cacheEntry struct {
+	Model     string              `json:"model"`
+	Messages  []map[string]string `json:"messages,omitempty"`
+	Body      string              `json:"body,omitempty"`
+	Footers   []string            `json:"footers,omitempty"`
+	Summary   string              `json:"summary,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+// DefaultCacheDir returns the per-user cache directory for gitrewrite, or an empty
+// string when the platform has none
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gitrewrite")
+}
+
+// cacheKey hashes the model, the custom prompt, the prompt options and the diffs the
+// model sees, so identical changes in forks or mirrors of a repository map to the same
+// entry regardless of commit hash
+func cacheKey(kind, model string, commit models.CommitOutput) string {
+	h := sha256.New()
+	h.Write([]byte(kind + "\x00" + model + "\x00"))
+	if promptSource != "" {
+		h.Write([]byte(promptSource + "\x00"))
+	}
+	if GenerateBody {
+		h.Write([]byte("body\x00"))
+	}
+	if Style == StylePlain {
+		h.Write([]byte("plain\x00"))
+	}
+	if Language != "" {
+		h.Write([]byte("language " + LanguageName(Language) + "\x00"))
+	}
+	for _, file := range commit.Files {
+		h.Write([]byte(file.Path + "\x00" + file.Diff + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachePath returns the file holding the entry for key
+func cachePath(key string) string {
+	return filepath.Join(MessageCacheDir, key[:2], key+".json")
+}
+
+// readCache loads the entry for a commit, reporting whether one exists. Nothing is read
+// with RefreshCache or for commits without any diff, whose key would be meaningless.
+func readCache(kind, model string, commit models.CommitOutput) (cacheEntry, bool) {
+	var entry cacheEntry
+	if MessageCacheDir == "" || RefreshCache || len(commit.Files) == 0 {
+		return entry, false
+	}
+	data, err := os.ReadFile(cachePath(cacheKey(kind, model, commit)))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		ui.LogWarning("Ignoring corrupt cache entry for %s: %v", commit.CommitID[:8], err)
+		return entry, false
+	}
+	return entry, true
+}
+
+// writeCache stores the entry for a commit; failures are logged but never fatal
+func writeCache(kind, model string, commit models.CommitOutput, entry cacheEntry) {
+	if MessageCacheDir == "" || len(commit.Files) == 0 {
+		return
+	}
+	path := cachePath(cacheKey(kind, model, commit))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		ui.LogWarning("Failed to create cache directory: %v", err)
+		return
+	}
+	entry.Model = model
+	entry.CreatedAt = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		ui.LogWarning("Failed to encode cache entry: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		ui.LogWarning("Failed to write cache entry: %v", err)
+	}
+}
+
+// GetCachedCommitMessage returns a previously generated message for a commit with the same diffs
+func GetCachedCommitMessage(commit models.CommitOutput, model string) (models.NewCommitMessage, bool) {
+	entry, ok := readCache(cacheKindMessage, model, commit)
+	if !ok || len(entry.Messages) == 0 {
+		return models.NewCommitMessage{}, false
+	}
+	return models.NewCommitMessage{CommitID: commit.CommitID, Messages: entry.Messages, Body: entry.Body, Footers: entry.Footers}, true
+}
+
+// PutCachedCommitMessage caches a generated message for a commit
+func PutCachedCommitMessage(commit models.CommitOutput, model string, message models.NewCommitMessage) {
+	writeCache(cacheKindMessage, model, commit, cacheEntry{Messages: message.Messages, Body: message.Body, Footers: message.Footers})
+}
+
+// GetCachedSummary returns a previously generated one-line summary for an oversized commit
+func GetCachedSummary(commit models.CommitOutput, model string) (string, bool) {
+	entry, ok := readCache(cacheKindSummary, model, commit)
+	if !ok || entry.Summary == "" {
+		return "", false
+	}
+	return entry.Summary, true
+}
+
+// PutCachedSummary caches a generated one-line summary for an oversized commit
+func PutCachedSummary(commit models.CommitOutput, model, summary string) {
+	writeCache(cacheKindSummary, model, commit, cacheEntry{Summary: summary})
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keyringService is the service name API keys are stored under in the OS keychain
+const keyringService = "gitrewrite"
+
+// StoreAPIKey saves the API key for an API base URL in the OS keychain
+func StoreAPIKey(apiBase, key string) error {
+	if err := keyring.Set(keyringService, apiBase, key); err != nil {
+		return fmt.Errorf("failed to store API key in keychain: %v", err)
+	}
+	return nil
+}
+
+// LookupAPIKey returns the API key stored for an API base URL, or an empty string
+// when none is stored
+func LookupAPIKey(apiBase string) (string, error) {
+	key, err := keyring.Get(keyringService, apiBase)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read API key from keychain: %v", err)
+	}
+	return key, nil
+}
+
+// DeleteAPIKey removes the API key stored for an API base URL
+func DeleteAPIKey(apiBase string) error {
+	err := keyring.Delete(keyringService, apiBase)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("no API key stored for %s", apiBase)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete API key from keychain: %v", err)
+	}
+	return nil
+}
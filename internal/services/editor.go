@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SequenceEditorArg runs the gitrewrite binary as the sequence and message editor of
+// the rebases done by RewordCommit, so no sed or shell script is needed
+const SequenceEditorArg = "--internal-sequence-editor"
+
+// Environment variables passing the rebase's instructions to the editor
+const (
+	rewordCommitEnv  = "GITREWRITE_REWORD_COMMIT"
+	rewordMessageEnv = "GITREWRITE_REWORD_MESSAGE"
+)
+
+// RunSequenceEditor edits the file git hands to its editor. Given the rebase todo
+// list, the commit to reword is marked "reword"; given a commit message, the new
+// message replaces it.
+func RunSequenceEditor(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected the file to edit, got %d arguments", len(args))
+	}
+	path := args[0]
+	if filepath.Base(path) != "git-rebase-todo" {
+		message, err := os.ReadFile(os.Getenv(rewordMessageEnv))
+		if err != nil {
+			return fmt.Errorf("failed to read new commit message: %v", err)
+		}
+		return os.WriteFile(path, message, 0644)
+	}
+
+	target := os.Getenv(rewordCommitEnv)
+	if target == "" {
+		return fmt.Errorf("%s is not set", rewordCommitEnv)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read rebase todo list: %v", err)
+	}
+	lines := strings.Split(string(data), "\n")
+	found := false
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		// The todo list names commits by abbreviated hash
+		if len(fields) >= 2 && (fields[0] == "pick" || fields[0] == "p") && strings.HasPrefix(target, fields[1]) {
+			lines[i] = "reword" + strings.TrimPrefix(strings.TrimLeft(line, " \t"), fields[0])
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("commit %s is not in the rebase todo list", target)
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+// sequenceEditorEnv returns the environment that makes git rebase run this binary as
+// its editors to reword targetCommit with the message in messagePath
+func sequenceEditorEnv(targetCommit, messagePath string) ([]string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the gitrewrite executable: %v", err)
+	}
+	// git runs editors through a shell, also on Windows, which accepts forward slashes
+	quoted := "'" + strings.ReplaceAll(filepath.ToSlash(executable), "'", `'\''`) + "' " + SequenceEditorArg
+	return append(os.Environ(),
+		"GIT_SEQUENCE_EDITOR="+quoted,
+		"GIT_EDITOR="+quoted,
+		rewordCommitEnv+"="+targetCommit,
+		rewordMessageEnv+"="+messagePath,
+	), nil
+}
@@ -0,0 +1,461 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// fastImportCheckpointInterval is how many commits are imported between checkpoints,
+// which write the imported objects and refs to disk
+const fastImportCheckpointInterval = 1000
+
+// identityPattern extracts "Name <email>" from the output of git var GIT_COMMITTER_IDENT
+var identityPattern = regexp.MustCompile(`^(.*<[^>]*>)`)
+
+// FastImporter streams commits into a new repository through a single git fast-import
+// process. Only the files that changed since the previously imported commit are sent,
+// so applying a commit costs the size of its diff rather than the size of its tree.
+type FastImporter struct {
+	repoPath   string
+	ref        string
+	committer  string
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	writer     *bufio.Writer
+	reader     *bufio.Reader
+	stderr     bytes.Buffer
+	lastTree   *object.Tree
+	mark       int
+	broken     error
+	hasParent  bool
+	parentHash string
+	sideBranch bool
+	marks      map[string]int
+	inPlace    bool
+	bare       bool
+}
+
+// NewFastImporter starts git fast-import in the new repository. Commits are imported
+// onto the branch HEAD points to, after any commits it already has.
+func NewFastImporter(repoPath string) (*FastImporter, error) {
+	return startFastImporter(repoPath, false)
+}
+
+// NewInPlaceImporter starts git fast-import in the source repository itself. The
+// imported history replaces that of the branch HEAD points to instead of continuing
+// it, and existing branches and tags are overwritten, so they must be backed up first.
+func NewInPlaceImporter(repoPath string) (*FastImporter, error) {
+	return startFastImporter(repoPath, true)
+}
+
+// startFastImporter starts git fast-import in repoPath, replacing the history of the
+// branch HEAD points to when inPlace is set
+func startFastImporter(repoPath string, inPlace bool) (*FastImporter, error) {
+	ref, err := GetCommandOutput("git", []string{"symbolic-ref", "HEAD"}, repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine branch of new repository: %v", err)
+	}
+
+	// With -reset-committer, git var resolves the identity from git config and the
+	// committer overrides once for all commits
+	var committer string
+	if ResetCommitter {
+		ui.LogShellCommand("git", []string{"var", "GIT_COMMITTER_IDENT"}, repoPath)
+		identCmd := exec.Command("git", "var", "GIT_COMMITTER_IDENT")
+		identCmd.Dir = repoPath
+		identCmd.Env = committerEnv(object.Signature{})
+		identOutput, err := identCmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine committer identity: %v", err)
+		}
+		ident := string(identOutput)
+		match := identityPattern.FindStringSubmatch(ident)
+		if match == nil {
+			return nil, fmt.Errorf("failed to parse committer identity %q", ident)
+		}
+		committer = strings.TrimSpace(match[1])
+	}
+
+	importer := &FastImporter{
+		repoPath:  repoPath,
+		ref:       strings.TrimSpace(ref),
+		committer: committer,
+		marks:     make(map[string]int),
+		inPlace:   inPlace,
+		bare:      IsBareRepository(repoPath),
+	}
+	args := []string{"fast-import", "--quiet"}
+	if inPlace {
+		// The rewritten branches don't contain their old tips
+		args = append(args, "--force")
+	} else if head, err := GetCommandOutput("git", []string{"rev-parse", "--verify", "-q", importer.ref}, repoPath); err == nil && head != "" {
+		importer.hasParent = true
+		importer.parentHash = strings.TrimSpace(head)
+	}
+
+	ui.LogShellCommand("git", args, repoPath)
+	importer.cmd = exec.Command("git", args...)
+	importer.cmd.Dir = repoPath
+	importer.cmd.Stderr = &importer.stderr
+	importer.stdin, err = importer.cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open fast-import input: %v", err)
+	}
+	stdout, err := importer.cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open fast-import output: %v", err)
+	}
+	if err := importer.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start git fast-import: %v", err)
+	}
+	importer.writer = bufio.NewWriterSize(importer.stdin, 1<<20)
+	importer.reader = bufio.NewReader(stdout)
+	return importer, nil
+}
+
+// sideBranchRef is the scratch ref commits of other branches are imported onto; the
+// real branch and tag refs are written afterwards by CopyRefs
+const sideBranchRef = "refs/gitrewrite/side-branch"
+
+// Import writes a commit with the tree of commitID and the given message, preserving
+// author and committer dates including their time zones, and returns its new hash.
+// mergeParents are added as further parents; they must already exist in the repository.
+func (f *FastImporter) Import(originalRepo *git.Repository, commitID, message string, mergeParents []string) (string, error) {
+	from := ""
+	if f.hasParent {
+		from = f.parentHash
+	}
+	tree, hash, err := f.importCommit(originalRepo, commitID, message, f.ref, from, mergeParents, f.lastTree, f.lastTree == nil)
+	if err != nil {
+		return "", err
+	}
+	f.hasParent = false
+	f.lastTree = tree
+	return hash, nil
+}
+
+// ImportOnto writes a commit of another branch onto parents, the new hashes of its
+// original parents, and returns its new hash. A commit without parents starts a new root.
+func (f *FastImporter) ImportOnto(originalRepo *git.Repository, commitID, message string, parents []string) (string, error) {
+	commit, err := originalRepo.CommitObject(plumbing.NewHash(commitID))
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit object: %v", err)
+	}
+	// The new first parent has the same tree as the original one, so only the
+	// difference to that tree needs to be sent
+	var base *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return "", fmt.Errorf("failed to get parent commit: %v", err)
+		}
+		if base, err = parent.Tree(); err != nil {
+			return "", fmt.Errorf("failed to get tree for parent commit: %v", err)
+		}
+	}
+
+	from := ""
+	var merges []string
+	if len(parents) > 0 {
+		from, merges = parents[0], parents[1:]
+	}
+	// Reset the scratch ref so a commit without parents doesn't continue its tip
+	fmt.Fprintf(f.writer, "reset %s\n\n", sideBranchRef)
+	f.sideBranch = true
+	_, hash, err := f.importCommit(originalRepo, commitID, message, sideBranchRef, from, merges, base, base == nil)
+	return hash, err
+}
+
+// importCommit writes one commit onto ref. from and merges are the parents, an empty
+// from continues the ref. Only the difference between base and the commit's tree is
+// sent, or the whole tree when fullTree is set.
+func (f *FastImporter) importCommit(originalRepo *git.Repository, commitID, message, ref, from string, merges []string, base *object.Tree, fullTree bool) (*object.Tree, string, error) {
+	if f.broken != nil {
+		return nil, "", fmt.Errorf("fast-import stream is unusable after an earlier error: %v", f.broken)
+	}
+
+	commit, err := originalRepo.CommitObject(plumbing.NewHash(commitID))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get commit object: %v", err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get tree for commit: %v", err)
+	}
+
+	// Work out the file operations before writing anything, so a failure here leaves
+	// the stream intact
+	var deletes []string
+	var modifies []*object.TreeEntry
+	var modifyPaths []string
+	if fullTree {
+		walker := object.NewTreeWalker(tree, true, nil)
+		for {
+			name, entry, err := walker.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				walker.Close()
+				return nil, "", fmt.Errorf("failed to list files: %v", err)
+			}
+			if entry.Mode == filemode.Dir || isFilteredPath(name) {
+				continue
+			}
+			modifies = append(modifies, &entry)
+			modifyPaths = append(modifyPaths, name)
+		}
+		walker.Close()
+	} else {
+		changes, err := object.DiffTree(base, tree)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to diff trees: %v", err)
+		}
+		for _, change := range changes {
+			if change.From.Name != "" && change.From.Name != change.To.Name && !isFilteredPath(change.From.Name) {
+				deletes = append(deletes, change.From.Name)
+			}
+			if change.To.Name != "" && !isFilteredPath(change.To.Name) {
+				entry := change.To.TreeEntry
+				modifies = append(modifies, &entry)
+				modifyPaths = append(modifyPaths, change.To.Name)
+			}
+		}
+	}
+
+	f.mark++
+	w := f.writer
+	fmt.Fprintf(w, "commit %s\n", ref)
+	fmt.Fprintf(w, "mark :%d\n", f.mark)
+	author := mapSignature(commit.Author)
+	fmt.Fprintf(w, "author %s <%s> %s\n", author.Name, author.Email, formatSignatureTime(author))
+	fmt.Fprintf(w, "committer %s %s\n", f.committerFor(commit.Committer), formatSignatureTime(commit.Committer))
+	message = cleanupCommitMessage(message)
+	fmt.Fprintf(w, "data %d\n%s\n", len(message), message)
+	if from != "" {
+		fmt.Fprintf(w, "from %s\n", f.commitish(from))
+	}
+	for _, parent := range merges {
+		fmt.Fprintf(w, "merge %s\n", f.commitish(parent))
+	}
+	if fullTree {
+		w.WriteString("deleteall\n")
+	}
+	for _, name := range deletes {
+		fmt.Fprintf(w, "D %s\n", quoteFastImportPath(name))
+	}
+	for i, entry := range modifies {
+		if err := f.writeModify(originalRepo, modifyPaths[i], entry); err != nil {
+			f.broken = err
+			return nil, "", err
+		}
+	}
+	w.WriteString("\n")
+
+	// Ask for the hash of the commit just written
+	fmt.Fprintf(w, "get-mark :%d\n", f.mark)
+	if f.mark%fastImportCheckpointInterval == 0 {
+		w.WriteString("checkpoint\n")
+	}
+	if err := w.Flush(); err != nil {
+		f.broken = fmt.Errorf("failed to write to fast-import: %v, output: %s", err, f.stderr.String())
+		return nil, "", f.broken
+	}
+	line, err := f.reader.ReadString('\n')
+	if err != nil {
+		f.broken = fmt.Errorf("fast-import failed: %v, output: %s", err, f.stderr.String())
+		return nil, "", f.broken
+	}
+
+	hash := strings.TrimSpace(line)
+	f.marks[hash] = f.mark
+	return tree, hash, nil
+}
+
+// committerFor returns the committer line identity for a commit whose original
+// committer is original
+func (f *FastImporter) committerFor(original object.Signature) string {
+	if ResetCommitter {
+		return f.committer
+	}
+	name, email := committerIdentity(original)
+	return fmt.Sprintf("%s <%s>", name, email)
+}
+
+// commitish returns how to refer to a commit in the stream: commits imported in this
+// session are only known to fast-import by their mark until it finishes
+func (f *FastImporter) commitish(hash string) string {
+	if mark, ok := f.marks[hash]; ok {
+		return fmt.Sprintf(":%d", mark)
+	}
+	return hash
+}
+
+// writeModify writes a file modification, streaming blob contents inline
+func (f *FastImporter) writeModify(originalRepo *git.Repository, name string, entry *object.TreeEntry) error {
+	fileMode := entry.Mode
+	if fileMode == filemode.Deprecated {
+		// fast-import only accepts the canonical modes
+		fileMode = filemode.Regular
+	}
+	mode := fmt.Sprintf("%06o", uint32(fileMode))
+	if entry.Mode == filemode.Submodule {
+		fmt.Fprintf(f.writer, "M %s %s %s\n", mode, entry.Hash, quoteFastImportPath(name))
+		return nil
+	}
+
+	blob, err := originalRepo.BlobObject(entry.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to read blob for %s: %v", name, err)
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return fmt.Errorf("failed to open blob for %s: %v", name, err)
+	}
+	defer reader.Close()
+
+	fmt.Fprintf(f.writer, "M %s inline %s\n", mode, quoteFastImportPath(name))
+	fmt.Fprintf(f.writer, "data %d\n", blob.Size)
+	if _, err := io.Copy(f.writer, reader); err != nil {
+		return fmt.Errorf("failed to stream blob for %s: %v", name, err)
+	}
+	f.writer.WriteString("\n")
+	return nil
+}
+
+// CopyRefs recreates the original repository's branches and tags on the rewritten
+// commits, except the branch being imported. Annotated tags keep their tagger and
+// message. It returns the number of refs written.
+func (f *FastImporter) CopyRefs(originalRepo *git.Repository, hashMap map[string]string) (int, error) {
+	refs, err := originalRepo.References()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list references: %v", err)
+	}
+
+	count := 0
+	w := f.writer
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name()
+		if ref.Type() != plumbing.HashReference || name.String() == f.ref || !(name.IsBranch() || name.IsTag()) {
+			return nil
+		}
+
+		tag, err := originalRepo.TagObject(ref.Hash())
+		if err != nil {
+			// A branch or lightweight tag points straight at a commit
+			target, ok := hashMap[ref.Hash().String()]
+			if !ok {
+				ui.LogWarning("Skipping %s: its commit was not rewritten", name)
+				return nil
+			}
+			fmt.Fprintf(w, "reset %s\nfrom %s\n\n", name, f.commitish(target))
+			count++
+			return nil
+		}
+
+		if tag.TargetType != plumbing.CommitObject {
+			ui.LogWarning("Skipping tag %s: it points at a %s, not a commit", name.Short(), tag.TargetType)
+			return nil
+		}
+		target, ok := hashMap[tag.Target.String()]
+		if !ok {
+			ui.LogWarning("Skipping tag %s: its commit was not rewritten", name.Short())
+			return nil
+		}
+		if tag.PGPSignature != "" {
+			ui.LogWarning("Tag %s is recreated without its signature", name.Short())
+		}
+		fmt.Fprintf(w, "tag %s\nfrom %s\n", name.Short(), f.commitish(target))
+		if tagger := mapSignature(tag.Tagger); tagger.Name != "" || tagger.Email != "" {
+			fmt.Fprintf(w, "tagger %s <%s> %s\n", tagger.Name, tagger.Email, formatSignatureTime(tagger))
+		}
+		fmt.Fprintf(w, "data %d\n%s\n", len(tag.Message), tag.Message)
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// Close ends the import, waits for git fast-import to write everything and checks
+// out the imported branch in the new repository's working tree. In place, only the
+// index is reset, so uncommitted changes in the working tree are kept; a bare
+// repository has nothing to check out.
+func (f *FastImporter) Close() error {
+	flushErr := f.writer.Flush()
+	f.stdin.Close()
+	if err := f.cmd.Wait(); err != nil {
+		return fmt.Errorf("git fast-import failed: %v, output: %s", err, f.stderr.String())
+	}
+	if flushErr != nil {
+		return fmt.Errorf("failed to write to fast-import: %v", flushErr)
+	}
+	if f.mark == 0 {
+		return nil
+	}
+
+	if !f.bare {
+		resetArgs := []string{"reset", "--hard", "-q", "HEAD"}
+		if f.inPlace {
+			resetArgs = []string{"reset", "-q", "HEAD"}
+		}
+		if err := ExecuteCommand("git", resetArgs, f.repoPath); err != nil {
+			return fmt.Errorf("failed to check out imported history: %v", err)
+		}
+	}
+	if f.sideBranch {
+		if err := ExecuteCommand("git", []string{"update-ref", "-d", sideBranchRef}, f.repoPath); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", sideBranchRef, err)
+		}
+	}
+	return nil
+}
+
+// formatSignatureTime formats a signature's time as "<unix seconds> <+hhmm>"
+func formatSignatureTime(signature object.Signature) string {
+	return fmt.Sprintf("%d %s", signature.When.Unix(), signature.When.Format("-0700"))
+}
+
+// quoteFastImportPath quotes a path the way fast-import expects when it contains a
+// newline or starts with a double quote
+func quoteFastImportPath(name string) string {
+	if !strings.Contains(name, "\n") && !strings.HasPrefix(name, `"`) {
+		return name
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + replacer.Replace(name) + `"`
+}
+
+// cleanupCommitMessage normalizes a message the way git commit -m does: trailing
+// whitespace is removed from every line, runs of blank lines are collapsed and
+// leading and trailing blank lines are dropped
+func cleanupCommitMessage(message string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
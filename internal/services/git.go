@@ -1,19 +1,30 @@
 package services
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/MrLemur/gitrewrite/internal/models"
 	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/MrLemur/gitrewrite/pkg/helpers"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
 // MockUpdateStatusForTests is a flag that can be set to disable UI updates during testing
@@ -50,40 +61,22 @@ func RewordCommit(repoPath, targetCommit, newMessage string) error {
 		base = strings.TrimSpace(string(parentOutput))
 	}
 
-	// Get abbreviated hash for target commit
-	ui.LogShellCommand("git", []string{"rev-parse", "--short", targetCommit}, repoPath)
-	abbrCmd := exec.Command("git", "rev-parse", "--short", targetCommit)
-	abbrCmd.Dir = repoPath
-	abbrOutput, err := abbrCmd.Output()
+	// Resolve the full hash, which the editor matches against the todo list
+	ui.LogShellCommand("git", []string{"rev-parse", targetCommit}, repoPath)
+	targetCmd := exec.Command("git", "rev-parse", targetCommit)
+	targetCmd.Dir = repoPath
+	targetOutput, err := targetCmd.Output()
 	if err != nil {
-		return fmt.Errorf("failed to get abbreviated hash for commit: %v", err)
+		return fmt.Errorf("failed to resolve commit %s: %v", targetCommit, err)
 	}
-
-	var shortHash string
-	if err != nil {
-		shortHash = targetCommit
-	} else {
-		shortHash = strings.TrimSpace(string(abbrOutput))
-	}
-
-	// Set up GIT_SEQUENCE_EDITOR to change "pick" to "reword" for the target commit
-	escapedTarget := regexp.QuoteMeta(targetCommit)
-	sedExpr := fmt.Sprintf("s/^pick \\(%s\\|%s\\)/reword \\1/", escapedTarget, shortHash)
-	gitSeqEditor := fmt.Sprintf("sed -i -e \"%s\"", sedExpr)
-
-	// Create temporary editor script to provide the new commit message
-	tempEditor, err := os.CreateTemp("", "git-editor-")
-	if err != nil {
-		return fmt.Errorf("failed to create temp editor script: %v", err)
-	}
-	defer os.Remove(tempEditor.Name())
+	targetHash := strings.TrimSpace(string(targetOutput))
 
 	// Create a temporary file to store the new commit message
-	tempFile, err := os.CreateTemp("", "new-commit-message-")
+	tempFile, err := NewTempFile("new-commit-message-")
 	if err != nil {
 		return fmt.Errorf("failed to create temp file for new commit message: %v", err)
 	}
-	defer os.Remove(tempFile.Name())
+	defer RemoveTemp(tempFile.Name())
 
 	// Write the new commit message to a temporary file
 	if _, err := tempFile.WriteString(newMessage); err != nil {
@@ -93,23 +86,13 @@ func RewordCommit(repoPath, targetCommit, newMessage string) error {
 		return fmt.Errorf("failed to close temp file: %v", err)
 	}
 
-	editorContent := fmt.Sprintf("#!/bin/sh\ncat %s > \"$1\"\n", tempFile.Name())
-	if _, err := tempEditor.WriteString(editorContent); err != nil {
-		return fmt.Errorf("failed to write temp editor: %v", err)
-	}
-	if err := tempEditor.Close(); err != nil {
-		return fmt.Errorf("failed to close temp editor: %v", err)
-	}
-	if err := os.Chmod(tempEditor.Name(), 0755); err != nil {
-		return fmt.Errorf("failed to make editor executable: %v", err)
+	// This binary is the sequence editor, changing "pick" to "reword" for the target
+	// commit, and the editor providing the new commit message
+	env, err := sequenceEditorEnv(targetHash, tempFile.Name())
+	if err != nil {
+		return err
 	}
 
-	// Prepare environment with our custom editors
-	env := append(os.Environ(),
-		"GIT_SEQUENCE_EDITOR="+gitSeqEditor,
-		"GIT_EDITOR="+tempEditor.Name(),
-	)
-
 	// Remove any existing rebase-merge directory
 	mergeDir := filepath.Join(repoPath, ".git", "rebase-merge")
 	if _, err := os.Stat(mergeDir); err == nil {
@@ -132,8 +115,8 @@ func RewordCommit(repoPath, targetCommit, newMessage string) error {
 		}
 	}
 
-	// Execute rebase to rewrite the commit message
-	args := []string{"rebase", "-i"}
+	// Execute rebase to rewrite the commit message, re-signing the rebased commits
+	args := append([]string{"rebase", "-i"}, signArgs()...)
 	if base == "--root" {
 		args = append(args, "--root")
 	} else {
@@ -146,10 +129,7 @@ func RewordCommit(repoPath, targetCommit, newMessage string) error {
 	rebaseCmd.Env = env
 
 	ui.LogInfo("Command dir: %s", rebaseCmd.Dir)
-	ui.LogInfo("Command env: %v", rebaseCmd.Env)
-	// show temp editor content
-	ui.LogInfo("Temp editor content: %s", editorContent)
-	ui.LogInfo("Temp file content: %s", newMessage)
+	ui.LogInfo("Rewording %s to: %s", targetHash[:8], newMessage)
 
 	output, err = rebaseCmd.CombinedOutput()
 	if err != nil {
@@ -254,6 +234,8 @@ func GetRepoName(repoPath string) string {
 
 	repoName := filepath.Base(repoPath)
 	repoName = strings.TrimRight(repoName, "/\\")
+	// Bare repositories are usually named like "repo.git"
+	repoName = strings.TrimSuffix(repoName, ".git")
 	if repoName == "" || repoName == ".." {
 		return "git-repo"
 	}
@@ -279,16 +261,17 @@ func GetCommandOutput(command string, args []string, dir string) (string, error)
 	return out.String(), err
 }
 
-// GetCurrentBranchName gets the name of the current branch
+// GetCurrentBranchName gets the name of the current branch. HEAD is read with go-git,
+// so this works in bare repositories, e.g. mirrors, and with any git version.
 func GetCurrentBranchName(repoPath string) (string, error) {
-	ui.LogShellCommand("git", []string{"branch", "--show-current"}, repoPath)
-	cmd := exec.Command("git", "branch", "--show-current")
-	cmd.Dir = repoPath
-	output, err := cmd.Output()
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return "main", fmt.Errorf("failed to get current branch name: %v", err)
+	}
+	branchName, err := headBranch(repo)
 	if err != nil {
 		return "main", fmt.Errorf("failed to get current branch name: %v", err)
 	}
-	branchName := strings.TrimSpace(string(output))
 	if branchName == "" {
 		// If we can't get the branch name or it's empty, default to "main"
 		return "main", nil
@@ -296,6 +279,26 @@ func GetCurrentBranchName(repoPath string) (string, error) {
 	return branchName, nil
 }
 
+// headBranch returns the name of the branch HEAD points to, which doesn't need to
+// have commits yet, or "" when HEAD is detached
+func headBranch(repo *git.Repository) (string, error) {
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", fmt.Errorf("failed to read HEAD: %v", err)
+	}
+	if head.Type() != plumbing.SymbolicReference || !head.Target().IsBranch() {
+		return "", nil
+	}
+	return head.Target().Short(), nil
+}
+
+// IsBareRepository reports whether the repository at repoPath is bare, i.e. has no
+// working tree
+func IsBareRepository(repoPath string) bool {
+	output, err := GetCommandOutput("git", []string{"rev-parse", "--is-bare-repository"}, repoPath)
+	return err == nil && strings.TrimSpace(output) == "true"
+}
+
 // GetRemoteOriginURL gets the URL of the remote origin
 func GetRemoteOriginURL(repoPath string) (string, error) {
 	ui.LogShellCommand("git", []string{"remote", "get-url", "origin"}, repoPath)
@@ -318,10 +321,15 @@ func ConfigureNewRepository(sourceRepoPath, newRepoPath string) error {
 		branchName = "main"
 	}
 
-	// If we're not on the default branch (usually main or master), create it
+	// If we're not on the default branch (usually main or master), create it. A bare
+	// repository has nothing to check out, so only its HEAD is pointed at the branch.
 	ui.LogInfo("Creating branch '%s' in the new repository", branchName)
-	ui.LogShellCommand("git", []string{"checkout", "-b", branchName}, newRepoPath)
-	cmd := exec.Command("git", "checkout", "-b", branchName)
+	branchArgs := []string{"checkout", "-b", branchName}
+	if IsBareRepository(newRepoPath) {
+		branchArgs = []string{"symbolic-ref", "HEAD", "refs/heads/" + branchName}
+	}
+	ui.LogShellCommand("git", branchArgs, newRepoPath)
+	cmd := exec.Command("git", branchArgs...)
 	cmd.Dir = newRepoPath
 	if output, err := cmd.CombinedOutput(); err != nil {
 		ui.LogError("Failed to create branch: %v, output: %s", err, output)
@@ -333,7 +341,7 @@ func ConfigureNewRepository(sourceRepoPath, newRepoPath string) error {
 	// Try to get the remote origin URL from the source repository
 	remoteURL, err := GetRemoteOriginURL(sourceRepoPath)
 	if err != nil {
-		ui.LogError("Failed to get remote origin URL: %v", err)
+		ui.LogWarning("Failed to get remote origin URL: %v", err)
 		ui.LogInfo("No remote origin will be added to the new repository")
 		return nil // This is not a critical error, so we return nil
 	}
@@ -355,313 +363,1788 @@ func ConfigureNewRepository(sourceRepoPath, newRepoPath string) error {
 	return nil
 }
 
-// GetCommitsChronological returns ALL commits from oldest to newest
-func GetCommitsChronological(repo *git.Repository, maxMsgLength, maxDiffLength int) ([]models.CommitOutput, []models.CommitOutput, error) {
-	safeUpdateStatus("Getting commits in chronological order...")
-
-	// Get all commits
-	iter, err := repo.Log(&git.LogOptions{})
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get repository log: %v", err)
-	}
+// Commit selection strategies of ScanOptions.Select
+const (
+	// SelectLength rewrites messages up to MaxMsgLength characters
+	SelectLength = "length"
+	// SelectNonConventional rewrites messages whose subject isn't a Conventional Commit
+	SelectNonConventional = "non-conventional"
+	// SelectLLMScore rewrites messages the model grades below MinScore
+	SelectLLMScore = "llm-score"
+	// SelectAll rewrites every message
+	SelectAll = "all"
+)
 
-	var allCommits []models.CommitOutput
-	var commitsToRewrite []models.CommitOutput
+// ScanOptions controls how commits are selected for rewriting and how much of
+// their diffs is captured
+type ScanOptions struct {
+	// Select is the selection strategy, one of the Select constants; empty selects by
+	// length. Path thresholds only apply to length selection.
+	Select        string
+	MaxMsgLength  int
+	MaxDiffLength int
+	// Score grades a message from 1 to 10 for SelectLLMScore; messages graded below
+	// MinScore are rewritten. Without it every commit is selected, which is all the
+	// modes applying existing messages need.
+	Score    func(commitID, message string) (int, error)
+	MinScore int
+	// MessagesOnly leaves out the diffs of the selected commits, for modes that only
+	// read their messages or extract them one at a time with LoadCommitFiles
+	MessagesOnly bool
+	// PathThresholds override MaxMsgLength for files matching a path prefix;
+	// the first matching rule applies to a file
+	PathThresholds []models.PathThreshold
+	// DiffLimits override MaxDiffLength for files matching a pattern; the first
+	// matching rule applies to a file
+	DiffLimits []models.DiffLimit
+	// Since and Until limit rewriting to commits committed in that period; a zero
+	// time leaves that end unbounded. Until is included unless UntilExclusive is set,
+	// e.g. for the midnight after a date-only -until.
+	Since          time.Time
+	Until          time.Time
+	UntilExclusive bool
+	// InRange, when set, limits rewriting to the listed commits
+	InRange map[string]bool
+	// Author, when set, limits rewriting to commits whose author "Name <email>" matches
+	Author *regexp.Regexp
+	// SkipMessage, when set, keeps commits whose message matches it from being
+	// rewritten regardless of length, e.g. release and dependency bump commits
+	SkipMessage *regexp.Regexp
+	// KeepReverts keeps commits made by git revert from being rewritten by the model
+	KeepReverts bool
+	// FirstParent walks only the first-parent chain of HEAD. Commits on merged branches
+	// are neither listed nor rewritten.
+	FirstParent bool
+	// AllRefs also lists the commits only reachable from other branches and tags,
+	// after HEAD's commits and marked as SideBranch
+	AllRefs bool
+}
 
-	err = iter.ForEach(func(c *object.Commit) error {
-		output := models.CommitOutput{
-			CommitID:     c.Hash.String(),
-			Message:      c.Message,
-			NeedsRewrite: len(c.Message) <= maxMsgLength,
+// inScope reports whether a commit falls inside the configured date and commit range,
+// is by a selected author and doesn't have a skipped message or revert message.
+// Commits outside it are still copied, but never rewritten.
+func (o ScanOptions) inScope(c *object.Commit) bool {
+	if !o.Since.IsZero() && c.Committer.When.Before(o.Since) {
+		return false
+	}
+	if !o.Until.IsZero() {
+		if o.UntilExclusive && !c.Committer.When.Before(o.Until) {
+			return false
 		}
+		if !o.UntilExclusive && c.Committer.When.After(o.Until) {
+			return false
+		}
+	}
+	if o.InRange != nil && !o.InRange[c.Hash.String()] {
+		return false
+	}
+	if o.Author != nil && !o.Author.MatchString(c.Author.Name+" <"+c.Author.Email+">") {
+		return false
+	}
+	if o.SkipMessage != nil && o.SkipMessage.MatchString(c.Message) {
+		return false
+	}
+	if o.KeepReverts && helpers.IsRevert(c.Message) {
+		return false
+	}
+	return true
+}
 
-		// If commit needs rewriting, get the diff information
-		if output.NeedsRewrite {
-			parentCommits := c.Parents()
-			var changes object.Changes
-			firstParent, err := parentCommits.Next()
-			if err == nil {
-				parentTree, err := firstParent.Tree()
-				if err != nil {
-					return fmt.Errorf("failed to get parent tree for commit %s: %v", c.Hash.String(), err)
-				}
-				currentTree, err := c.Tree()
-				if err != nil {
-					return fmt.Errorf("failed to get current tree for commit %s: %v", c.Hash.String(), err)
-				}
-				changes, err = parentTree.Diff(currentTree)
-				if err != nil {
-					return fmt.Errorf("failed to compute diff for commit %s: %v", c.Hash.String(), err)
-				}
-			} else if err == io.EOF {
-				currentTree, err := c.Tree()
-				if err != nil {
-					return fmt.Errorf("failed to get current tree for initial commit %s: %v", c.Hash.String(), err)
-				}
-				changes, err = object.DiffTree(nil, currentTree)
-				if err != nil {
-					return fmt.Errorf("failed to compute diff for initial commit %s: %v", c.Hash.String(), err)
-				}
-			} else {
-				return fmt.Errorf("error getting parent commits for %s: %v", c.Hash.String(), err)
-			}
-
-			for _, change := range changes {
-				_, _, err := change.Files()
-				if err != nil {
-					return fmt.Errorf("failed to get files for change: %v", err)
-				}
-				var path string
-				if change.From.Name != "" {
-					path = change.From.Name
-				} else if change.To.Name != "" {
-					path = change.To.Name
-				} else {
-					continue
-				}
-				patch, err := change.Patch()
-				if err != nil {
-					return fmt.Errorf("failed to generate patch for %s: %v", path, err)
-				}
-				diffContent := patch.String()
-				if len(diffContent) > maxDiffLength {
-					diffContent = diffContent[:maxDiffLength]
-				}
-				output.Files = append(output.Files, models.File{
-					Path: path,
-					Diff: diffContent,
-				})
+// thresholdFor returns the message length at or below which a commit touching the
+// given paths needs rewriting: the highest threshold applying to any of its files. A
+// commit without files is held to -max-length.
+func (o ScanOptions) thresholdFor(paths []string) int {
+	if len(paths) == 0 {
+		return o.MaxMsgLength
+	}
+	threshold := 0
+	for _, path := range paths {
+		pathThreshold := o.MaxMsgLength
+		for _, rule := range o.PathThresholds {
+			if strings.HasPrefix(path, rule.Path) {
+				pathThreshold = rule.MaxLength
+				break
 			}
-
-			commitsToRewrite = append(commitsToRewrite, output)
 		}
-
-		allCommits = append(allCommits, output)
-		return nil
-	})
-
-	if err != nil {
-		return nil, nil, err
+		if pathThreshold > threshold {
+			threshold = pathThreshold
+		}
 	}
+	return threshold
+}
 
-	// Reverse the order of commits to get chronological order (oldest first)
-	reverseCommits := func(commits []models.CommitOutput) {
-		for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
-			commits[i], commits[j] = commits[j], commits[i]
+// selects reports whether the selection strategy picks an in-scope commit for rewriting
+func (o ScanOptions) selects(c *object.Commit) bool {
+	switch o.Select {
+	case SelectAll:
+		return true
+	case SelectNonConventional:
+		subject := strings.TrimSpace(strings.SplitN(strings.TrimSpace(c.Message), "\n", 2)[0])
+		return !helpers.ConventionalSubject.MatchString(subject)
+	case SelectLLMScore:
+		if o.Score == nil {
+			return true
+		}
+		score, err := o.Score(c.Hash.String(), c.Message)
+		if err != nil {
+			ui.LogWarning("%v, selecting it by length instead", err)
+			return len(c.Message) <= o.MaxMsgLength
 		}
+		return score < o.MinScore
+	default:
+		return len(c.Message) <= o.MaxMsgLength
 	}
+}
 
-	reverseCommits(allCommits)
-	reverseCommits(commitsToRewrite)
-
-	return allCommits, commitsToRewrite, nil
+// maxThreshold returns the highest threshold any commit could be held to
+func (o ScanOptions) maxThreshold() int {
+	threshold := o.MaxMsgLength
+	for _, rule := range o.PathThresholds {
+		if rule.MaxLength > threshold {
+			threshold = rule.MaxLength
+		}
+	}
+	return threshold
 }
 
-// ApplyCommitToNewRepo applies a commit from the original repo to the new repo
-func ApplyCommitToNewRepo(originalRepo *git.Repository, newRepoPath, commitID, newMessage string) error {
-	// Get the commit
-	hash := plumbing.NewHash(commitID)
-	commit, err := originalRepo.CommitObject(hash)
+// commitChanges returns the changes a commit introduces relative to its first parent,
+// or relative to an empty tree for the initial commit
+func commitChanges(c *object.Commit) (object.Changes, error) {
+	currentTree, err := c.Tree()
 	if err != nil {
-		return fmt.Errorf("failed to get commit object: %v", err)
+		return nil, fmt.Errorf("failed to get current tree for commit %s: %v", c.Hash.String(), err)
 	}
 
-	// Get author info and timestamps
-	authorName := commit.Author.Name
-	authorEmail := commit.Author.Email
-	authorWhen := commit.Author.When.Unix()
-	committerWhen := commit.Committer.When.Unix()
+	firstParent, err := c.Parents().Next()
+	if err == io.EOF {
+		changes, err := object.DiffTree(nil, currentTree)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute diff for initial commit %s: %v", c.Hash.String(), err)
+		}
+		return changes, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting parent commits for %s: %v", c.Hash.String(), err)
+	}
 
-	// Get the tree for this commit
-	tree, err := commit.Tree()
+	parentTree, err := firstParent.Tree()
 	if err != nil {
-		return fmt.Errorf("failed to get tree for commit: %v", err)
+		return nil, fmt.Errorf("failed to get parent tree for commit %s: %v", c.Hash.String(), err)
 	}
-
-	// Create a temporary directory
-	tmpDir, err := os.MkdirTemp("", "gitrewrite-")
+	changes, err := parentTree.Diff(currentTree)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %v", err)
+		return nil, fmt.Errorf("failed to compute diff for commit %s: %v", c.Hash.String(), err)
 	}
-	defer os.RemoveAll(tmpDir)
-
-	// Extract all files from the tree to the temp directory
-	err = tree.Files().ForEach(func(f *object.File) error {
-		// Get file contents
-		content, err := f.Contents()
-		if err != nil {
-			return fmt.Errorf("failed to get contents of file %s: %v", f.Name, err)
-		}
+	return changes, nil
+}
 
-		// Create the target path
-		targetPath := filepath.Join(tmpDir, f.Name)
+// changePath returns the path a change applies to, preferring the original name
+func changePath(change *object.Change) string {
+	if change.From.Name != "" {
+		return change.From.Name
+	}
+	return change.To.Name
+}
 
-		// Create the directory for the file
-		err = os.MkdirAll(filepath.Dir(targetPath), 0755)
-		if err != nil {
-			return fmt.Errorf("failed to create directory for file %s: %v", f.Name, err)
+// diffLimitFor returns how much of a file's diff is sent to the model: the limit of
+// the first rule matching the file, or maxDiffLength. ruled is false when no rule matched.
+func diffLimitFor(filePath string, maxDiffLength int, limits []models.DiffLimit) (limit int, ruled bool) {
+	for _, rule := range limits {
+		name := path.Base(filePath)
+		if strings.Contains(rule.Pattern, "/") {
+			name = filePath
 		}
-
-		// Write the file
-		err = os.WriteFile(targetPath, []byte(content), 0644)
-		if err != nil {
-			return fmt.Errorf("failed to write file %s: %v", f.Name, err)
+		if matched, _ := path.Match(rule.Pattern, name); matched {
+			return rule.MaxDiff, true
 		}
-
-		return nil
-	})
-
-	if err != nil {
-		return fmt.Errorf("failed to extract files: %v", err)
-	}
-
-	// Remove all files in the new repo (except .git)
-	newRepoFiles, err := os.ReadDir(newRepoPath)
-	if err != nil {
-		return fmt.Errorf("failed to read new repo directory: %v", err)
 	}
+	return maxDiffLength, false
+}
 
-	for _, file := range newRepoFiles {
-		if file.Name() != ".git" {
-			pathToRemove := filepath.Join(newRepoPath, file.Name())
-			err := os.RemoveAll(pathToRemove)
-			if err != nil {
-				return fmt.Errorf("failed to remove file %s: %v", pathToRemove, err)
-			}
+// diffBudgets decides how much of each file's diff is sent to the model. Files matching
+// a diffLimits rule get its limit. The others share maxDiffLength per file between them:
+// diffs within an even share are sent whole, and what they leave over goes to the
+// larger diffs in proportion to their size.
+func diffBudgets(files []models.File, maxDiffLength int, diffLimits []models.DiffLimit) []int {
+	budgets := make([]int, len(files))
+	var pooled []int
+	for i, file := range files {
+		limit, ruled := diffLimitFor(file.Path, maxDiffLength, diffLimits)
+		if ruled {
+			budgets[i] = min(limit, len(file.Diff))
+		} else {
+			pooled = append(pooled, i)
 		}
 	}
 
-	// Copy all files from the temp directory to the new repo
-	err = filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	pool := maxDiffLength * len(pooled)
+	for len(pooled) > 0 && pool > 0 {
+		total := 0
+		for _, i := range pooled {
+			total += len(files[i].Diff)
 		}
-
-		// Skip the root directory
-		if path == tmpDir {
-			return nil
+		if total <= pool {
+			for _, i := range pooled {
+				budgets[i] = len(files[i].Diff)
+			}
+			break
 		}
-
-		// Get the relative path
-		relPath, err := filepath.Rel(tmpDir, path)
-		if err != nil {
-			return fmt.Errorf("failed to get relative path: %v", err)
+		// Send the diffs within an even share whole and share out the rest again;
+		// once all remaining diffs exceed it, split it in proportion to their size
+		var larger []int
+		used, share := 0, pool/len(pooled)
+		for _, i := range pooled {
+			if len(files[i].Diff) <= share {
+				budgets[i] = len(files[i].Diff)
+				used += budgets[i]
+			} else {
+				larger = append(larger, i)
+			}
 		}
-
-		// Create the target path
-		targetPath := filepath.Join(newRepoPath, relPath)
-
-		if info.IsDir() {
-			// Create directory
-			return os.MkdirAll(targetPath, info.Mode())
-		} else {
-			// Copy file
-			data, err := os.ReadFile(path)
-			if err != nil {
-				return fmt.Errorf("failed to read file %s: %v", path, err)
+		if len(larger) == len(pooled) {
+			for _, i := range pooled {
+				budgets[i] = pool * len(files[i].Diff) / total
 			}
-
-			return os.WriteFile(targetPath, data, info.Mode())
+			break
 		}
-	})
-
-	if err != nil {
-		return fmt.Errorf("failed to copy files: %v", err)
+		pooled, pool = larger, pool-used
 	}
+	return budgets
+}
 
-	// Add all files to the new repo
-	ui.LogShellCommand("git", []string{"add", "-A"}, newRepoPath)
-	addCmd := exec.Command("git", "add", "-A")
-	addCmd.Dir = newRepoPath
-	if output, err := addCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to add files to new repo: %v, output: %s", err, output)
+// truncatePatch shortens a file's patch to about limit bytes without cutting hunks or
+// lines. The file header comes first. Hunks that don't fit whole are sent without
+// their context lines, then with only their added lines, and are otherwise left out
+// and counted in a closing note.
+func truncatePatch(patch string, limit int) string {
+	if len(patch) <= limit {
+		return patch
+	}
+	header, hunks := splitHunks(patch)
+	if len(header) >= limit {
+		return cutAtLine(header, limit)
 	}
 
-	// Format the commit command with author info and timestamps
-	authorArg := fmt.Sprintf("--author=%s <%s>", authorName, authorEmail)
-	dateArg := fmt.Sprintf("--date=%d", authorWhen)
-
-	// Commit with the new message and preserve author info and date
-	commitCmd := exec.Command("git", "commit", "--allow-empty", authorArg, dateArg, "-m", newMessage)
-	commitCmd.Dir = newRepoPath
-
-	// Set GIT_COMMITTER_DATE to preserve the commit date as well
-	commitCmd.Env = append(os.Environ(), fmt.Sprintf("GIT_COMMITTER_DATE=%d", committerWhen))
-
-	ui.LogShellCommand("git", []string{"commit", "--allow-empty", authorArg, dateArg, "-m", newMessage}, newRepoPath)
-
-	if output, err := commitCmd.CombinedOutput(); err != nil {
-		if strings.Contains(string(output), "nothing to commit") {
-			ui.LogInfo("No changes to commit for %s", commitID[:8])
-			return nil
+	var b strings.Builder
+	b.WriteString(header)
+	omitted, omittedAdded, omittedRemoved := 0, 0, 0
+	for _, hunk := range hunks {
+		room := limit - b.Len()
+		kept := ""
+		for _, candidate := range []string{hunk, filterHunk(hunk, "+-"), filterHunk(hunk, "+")} {
+			if len(candidate) <= room {
+				kept = candidate
+				break
+			}
 		}
-		return fmt.Errorf("failed to commit to new repo: %v, output: %s", err, output)
+		if kept == "" && b.Len() == len(header) {
+			// Not even the added lines of the first hunk fit: send as many whole
+			// added lines as fit rather than only the header
+			kept = cutAtLine(filterHunk(hunk, "+"), room)
+		}
+		if kept == "" {
+			added, removed := countHunkLines(hunk)
+			omitted++
+			omittedAdded += added
+			omittedRemoved += removed
+		}
+		b.WriteString(kept)
 	}
-
-	return nil
+	if omitted == 1 {
+		fmt.Fprintf(&b, "[1 more hunk with +%d/-%d lines not shown]\n", omittedAdded, omittedRemoved)
+	} else if omitted > 1 {
+		fmt.Fprintf(&b, "[%d more hunks with +%d/-%d lines not shown]\n", omitted, omittedAdded, omittedRemoved)
+	}
+	return b.String()
 }
 
-// GetDefaultBranchName gets the default branch name of the repository
-func GetDefaultBranchName(repoPath string) (string, error) {
-	// First try to get the remote's default branch (usually main or master)
-	ui.LogShellCommand("git", []string{"symbolic-ref", "refs/remotes/origin/HEAD", "--short"}, repoPath)
-	cmd := exec.Command("git", "symbolic-ref", "refs/remotes/origin/HEAD", "--short")
-	cmd.Dir = repoPath
-	output, err := cmd.Output()
-
-	if err == nil {
-		// Extract branch name from "origin/main" format
-		branchWithPrefix := strings.TrimSpace(string(output))
-		parts := strings.Split(branchWithPrefix, "/")
-		if len(parts) > 1 {
-			return parts[len(parts)-1], nil
+// splitHunks splits a patch into its file header and its "@@" hunks
+func splitHunks(patch string) (string, []string) {
+	lines := strings.SplitAfter(patch, "\n")
+	header := ""
+	var hunks []string
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			hunks = append(hunks, line)
+		case len(hunks) == 0:
+			header += line
+		default:
+			hunks[len(hunks)-1] += line
 		}
-		return branchWithPrefix, nil
 	}
+	return header, hunks
+}
 
-	// If that fails, try to get the default branch from git config
-	ui.LogShellCommand("git", []string{"config", "--get", "init.defaultBranch"}, repoPath)
-	cmd = exec.Command("git", "config", "--get", "init.defaultBranch")
-	cmd.Dir = repoPath
-	output, err = cmd.Output()
+// filterHunk keeps a hunk's "@@" line and the lines starting with one of the prefixes
+func filterHunk(hunk, prefixes string) string {
+	lines := strings.SplitAfter(hunk, "\n")
+	kept := lines[0]
+	for _, line := range lines[1:] {
+		if line != "" && strings.ContainsRune(prefixes, rune(line[0])) {
+			kept += line
+		}
+	}
+	return kept
+}
 
-	if err == nil && len(output) > 0 {
-		return strings.TrimSpace(string(output)), nil
+// cutAtLine shortens text to at most limit bytes, ending after a whole line
+func cutAtLine(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	if end := strings.LastIndex(text[:limit], "\n"); end >= 0 {
+		return text[:end+1]
 	}
+	return ""
+}
 
-	// If we still don't have a default branch, fall back to checking if we have main or master
-	for _, branch := range []string{"main", "master"} {
-		ui.LogShellCommand("git", []string{"show-ref", "--verify", "--quiet", "refs/heads/" + branch}, repoPath)
-		cmd = exec.Command("git", "show-ref", "--verify", "--quiet", "refs/heads/"+branch)
-		cmd.Dir = repoPath
-		if cmd.Run() == nil {
-			return branch, nil
+// countHunkLines counts the added and removed lines of a hunk
+func countHunkLines(hunk string) (added, removed int) {
+	for _, line := range strings.Split(hunk, "\n")[1:] {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
 		}
 	}
+	return added, removed
+}
 
-	// If all else fails, return an error
-	return "", fmt.Errorf("could not determine default branch name")
+// changeFiles renders the patch of every change, truncated by hunks to the budget
+// diffBudgets gives each file
+func changeFiles(changes object.Changes, maxDiffLength int, diffLimits []models.DiffLimit) ([]models.File, error) {
+	var files []models.File
+	for _, change := range changes {
+		_, _, err := change.Files()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get files for change: %v", err)
+		}
+		path := changePath(change)
+		if path == "" {
+			continue
+		}
+		patch, err := change.Patch()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate patch for %s: %v", path, err)
+		}
+		files = append(files, models.File{
+			Path: path,
+			Diff: patch.String(),
+		})
+	}
+	for i, budget := range diffBudgets(files, maxDiffLength, diffLimits) {
+		files[i].Diff = truncatePatch(files[i].Diff, budget)
+	}
+	return files, nil
 }
 
-// CreateNewRepository creates a new empty git repository at the specified path with the given default branch name
-// The new repository is created as a sibling directory to the source repository
-func CreateNewRepository(sourceRepoPath string, targetRepoName string, defaultBranch string) error {
-	// Ensure we have an absolute path for the source repository
-	absSourcePath, err := filepath.Abs(sourceRepoPath)
+// GetCommitDetails returns a single commit with the diff of every file it changes
+func GetCommitDetails(repo *git.Repository, commitID string, maxDiffLength int, diffLimits []models.DiffLimit) (models.CommitOutput, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitID))
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path for source repository: %v", err)
+		return models.CommitOutput{}, fmt.Errorf("failed to get commit object: %v", err)
+	}
+	changes, err := commitChanges(commit)
+	if err != nil {
+		return models.CommitOutput{}, err
 	}
+	files, err := changeFiles(changes, maxDiffLength, diffLimits)
+	if err != nil {
+		return models.CommitOutput{}, err
+	}
+	return models.CommitOutput{
+		CommitID:     commitID,
+		Message:      commit.Message,
+		Files:        files,
+		NeedsRewrite: true,
+	}, nil
+}
 
-	// Clean the path to remove any trailing slashes
-	absSourcePath = filepath.Clean(absSourcePath)
+// LoadCommitFiles extracts the diff of every file a commit changes, truncated as a scan
+// with opts would, for a commit scanned with MessagesOnly
+func LoadCommitFiles(repo *git.Repository, commit *models.CommitOutput, opts ScanOptions) error {
+	c, err := repo.CommitObject(plumbing.NewHash(commit.CommitID))
+	if err != nil {
+		return fmt.Errorf("failed to get commit object: %v", err)
+	}
+	changes, err := commitChanges(c)
+	if err != nil {
+		return err
+	}
+	commit.Files, err = changeFiles(changes, opts.MaxDiffLength, opts.DiffLimits)
+	return err
+}
 
-	// Get the parent directory of the source repository
-	parentDir := filepath.Dir(absSourcePath)
+// diffResult is the outcome of extracting the diffs of one commit
+type diffResult struct {
+	files []models.File
+	err   error
+}
 
-	// Create the full path for the new repository
-	newRepoPath := filepath.Join(parentDir, targetRepoName)
+// DiffPool extracts the diffs of upcoming commits on a bounded number of workers,
+// ahead of the caller that sends them to the model. Commits are started in list order
+// and at most twice as many results as there are workers are held before they are
+// collected. go-git's object storage is not safe for concurrent use, so every worker
+// reads the repository through its own handle.
+type DiffPool struct {
+	results map[string]chan diffResult
+	slots   chan struct{}
+	stop    chan struct{}
+	once    sync.Once
+}
 
-	ui.LogInfo("Creating new repository at %s (sibling to %s)", newRepoPath, absSourcePath)
+// NewDiffPool starts extracting the diffs of commitIDs, scanned with MessagesOnly,
+// from the repository at repoPath with the given number of workers
+func NewDiffPool(repoPath string, commitIDs []string, workers int, opts ScanOptions) (*DiffPool, error) {
+	repos := make([]*git.Repository, workers)
+	for i := range repos {
+		repo, err := git.PlainOpen(repoPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open repository for diff extraction: %v", err)
+		}
+		repos[i] = repo
+	}
+	pool := &DiffPool{
+		results: make(map[string]chan diffResult, len(commitIDs)),
+		slots:   make(chan struct{}, workers*2),
+		stop:    make(chan struct{}),
+	}
+	for _, commitID := range commitIDs {
+		pool.results[commitID] = make(chan diffResult, 1)
+	}
+
+	jobs := make(chan string)
+	go func() {
+		defer close(jobs)
+		for _, commitID := range commitIDs {
+			select {
+			case pool.slots <- struct{}{}:
+			case <-pool.stop:
+				return
+			}
+			select {
+			case jobs <- commitID:
+			case <-pool.stop:
+				return
+			}
+		}
+	}()
+	for _, repo := range repos {
+		go func(repo *git.Repository) {
+			for commitID := range jobs {
+				commit := models.CommitOutput{CommitID: commitID}
+				err := LoadCommitFiles(repo, &commit, opts)
+				pool.results[commitID] <- diffResult{files: commit.Files, err: err}
+			}
+		}(repo)
+	}
+	return pool, nil
+}
+
+// Has reports whether the pool extracts the diffs of a commit
+func (p *DiffPool) Has(commitID string) bool {
+	_, ok := p.results[commitID]
+	return ok
+}
+
+// Files blocks until the diffs of a commit are extracted and returns them. Each
+// commit's diffs can only be collected once.
+func (p *DiffPool) Files(commitID string) ([]models.File, error) {
+	result := <-p.results[commitID]
+	<-p.slots
+	return result.files, result.err
+}
+
+// Stop stops starting new extractions
+func (p *DiffPool) Stop() {
+	p.once.Do(func() { close(p.stop) })
+}
+
+// StagedChangesID stands in for the commit ID of the staged changes, which have none yet
+var StagedChangesID = plumbing.ZeroHash.String()
+
+// GetStagedChanges returns the changes staged in the index of repoPath as a commit to
+// generate a message for, with the diff of every file truncated like a commit's
+func GetStagedChanges(repoPath string, maxDiffLength int, diffLimits []models.DiffLimit) (models.CommitOutput, error) {
+	output, err := GetCommandOutput("git", []string{"diff", "--cached", "--no-color", "--no-ext-diff", "--find-renames"}, repoPath)
+	if err != nil {
+		return models.CommitOutput{}, fmt.Errorf("failed to get staged changes: %v", err)
+	}
+	var files []models.File
+	for _, patch := range strings.SplitAfter(output, "\ndiff --git ") {
+		patch = strings.TrimSuffix(patch, "diff --git ")
+		if !strings.HasPrefix(patch, "diff --git ") {
+			patch = "diff --git " + patch
+		}
+		if path := stagedPatchPath(patch); path != "" {
+			files = append(files, models.File{Path: path, Diff: patch})
+		}
+	}
+	for i, budget := range diffBudgets(files, maxDiffLength, diffLimits) {
+		files[i].Diff = truncatePatch(files[i].Diff, budget)
+	}
+	return models.CommitOutput{
+		CommitID:     StagedChangesID,
+		Files:        files,
+		NeedsRewrite: true,
+	}, nil
+}
+
+// stagedPatchPath returns the path a file's patch of git diff changes: the new path,
+// or the old one for deleted files
+func stagedPatchPath(patch string) string {
+	header, _, _ := strings.Cut(patch, "\n")
+	path := ""
+	if i := strings.LastIndex(header, " b/"); i >= 0 {
+		path = header[i+len(" b/"):]
+	}
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			break
+		}
+		if strings.HasPrefix(line, "+++ b/") {
+			return strings.TrimPrefix(line, "+++ b/")
+		}
+		if strings.HasPrefix(line, "--- a/") {
+			path = strings.TrimPrefix(line, "--- a/")
+		}
+	}
+	return path
+}
+
+// CountChangedFiles returns the number of files a commit changes relative to its first parent
+func CountChangedFiles(repo *git.Repository, commitID string) (int, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get commit object: %v", err)
+	}
+	changes, err := commitChanges(commit)
+	if err != nil {
+		return 0, err
+	}
+	return len(changes), nil
+}
+
+// ChangedFiles returns the paths of the files a commit changes relative to its first parent
+func ChangedFiles(repo *git.Repository, commitID string) ([]string, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit object: %v", err)
+	}
+	changes, err := commitChanges(commit)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		paths = append(paths, name)
+	}
+	return paths, nil
+}
+
+// GetCommitsChronological returns ALL commits from oldest to newest
+func GetCommitsChronological(repo *git.Repository, opts ScanOptions) ([]models.CommitOutput, []models.CommitOutput, error) {
+	safeUpdateStatus("Getting commits in chronological order...")
+
+	// Get all commits
+	var iter object.CommitIter
+	var err error
+	if opts.FirstParent {
+		iter, err = newFirstParentIter(repo)
+	} else {
+		iter, err = repo.Log(&git.LogOptions{})
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get repository log: %v", err)
+	}
+
+	var allCommits []models.CommitOutput
+	var commitsToRewrite []models.CommitOutput
+
+	visit := func(c *object.Commit) error {
+		inScope := opts.inScope(c)
+		output := models.CommitOutput{
+			CommitID:     c.Hash.String(),
+			Message:      c.Message,
+			NeedsRewrite: inScope && opts.selects(c),
+		}
+
+		// Path thresholds replace -max-length for the files under matching paths, so
+		// they can raise or lower the limit of a commit
+		var changes object.Changes
+		lengthSelection := opts.Select == "" || opts.Select == SelectLength
+		if inScope && lengthSelection && len(opts.PathThresholds) > 0 {
+			output.NeedsRewrite = false
+			if len(c.Message) <= opts.maxThreshold() {
+				changes, err = commitChanges(c)
+				if err != nil {
+					return err
+				}
+				var paths []string
+				for _, change := range changes {
+					paths = append(paths, changePath(change))
+				}
+				output.NeedsRewrite = len(c.Message) <= opts.thresholdFor(paths)
+			}
+		}
+
+		// If commit needs rewriting, get the diff information
+		if output.NeedsRewrite && opts.MessagesOnly {
+			commitsToRewrite = append(commitsToRewrite, output)
+		} else if output.NeedsRewrite {
+			if changes == nil {
+				changes, err = commitChanges(c)
+				if err != nil {
+					return err
+				}
+			}
+
+			output.Files, err = changeFiles(changes, opts.MaxDiffLength, opts.DiffLimits)
+			if err != nil {
+				return err
+			}
+
+			commitsToRewrite = append(commitsToRewrite, output)
+		}
+
+		allCommits = append(allCommits, output)
+		return nil
+	}
+
+	if err := iter.ForEach(visit); err != nil {
+		return nil, nil, err
+	}
+
+	// Reverse the order of commits to get chronological order (oldest first)
+	reverseCommits := func(commits []models.CommitOutput) {
+		for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+			commits[i], commits[j] = commits[j], commits[i]
+		}
+	}
+
+	reverseCommits(allCommits)
+	reverseCommits(commitsToRewrite)
+
+	if opts.AllRefs {
+		mainline := make(map[string]bool, len(allCommits))
+		for _, commit := range allCommits {
+			mainline[commit.CommitID] = true
+		}
+		side, err := sideBranchCommits(repo, mainline)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list commits of other branches and tags: %v", err)
+		}
+		rewriteCount := len(commitsToRewrite)
+		for _, c := range side {
+			if err := visit(c); err != nil {
+				return nil, nil, err
+			}
+			allCommits[len(allCommits)-1].SideBranch = true
+			if len(commitsToRewrite) > rewriteCount && commitsToRewrite[len(commitsToRewrite)-1].CommitID == c.Hash.String() {
+				commitsToRewrite[len(commitsToRewrite)-1].SideBranch = true
+			}
+		}
+	}
+
+	return allCommits, commitsToRewrite, nil
+}
+
+// sideBranchCommits returns the commits reachable from branches and tags but not from
+// HEAD, parents before children
+func sideBranchCommits(repo *git.Repository, mainline map[string]bool) ([]*object.Commit, error) {
+	refs, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	var tips []*object.Commit
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !(ref.Name().IsBranch() || ref.Name().IsTag()) {
+			return nil
+		}
+		commit, err := peelToCommit(repo, ref.Hash())
+		if err != nil {
+			ui.LogWarning("Skipping %s: %v", ref.Name(), err)
+			return nil
+		}
+		tips = append(tips, commit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Iterative depth-first post-order, so every parent is listed before its children
+	type frame struct {
+		commit   *object.Commit
+		expanded bool
+	}
+	visited := make(map[plumbing.Hash]bool)
+	var ordered []*object.Commit
+	for _, tip := range tips {
+		stack := []frame{{commit: tip}}
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.expanded {
+				ordered = append(ordered, top.commit)
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			if visited[top.commit.Hash] || mainline[top.commit.Hash.String()] {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			visited[top.commit.Hash] = true
+			top.expanded = true
+			commit := top.commit
+			for i := commit.NumParents() - 1; i >= 0; i-- {
+				parent, err := commit.Parent(i)
+				if err != nil {
+					return nil, err
+				}
+				stack = append(stack, frame{commit: parent})
+			}
+		}
+	}
+	return ordered, nil
+}
+
+// peelToCommit resolves a commit or annotated tag hash to the commit it points at
+func peelToCommit(repo *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	for {
+		if tag, err := repo.TagObject(hash); err == nil {
+			if tag.TargetType != plumbing.CommitObject && tag.TargetType != plumbing.TagObject {
+				return nil, fmt.Errorf("tag points at a %s, not a commit", tag.TargetType)
+			}
+			hash = tag.Target
+			continue
+		}
+		return repo.CommitObject(hash)
+	}
+}
+
+// ReleaseTags returns the tags of the repository by the hash of the commit they point
+// at, sorted by name. Annotated tags are dated when they were tagged, lightweight tags
+// by their commit.
+func ReleaseTags(repo *git.Repository) (map[string][]models.ReleaseTag, error) {
+	refs, err := repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %v", err)
+	}
+	tags := make(map[string][]models.ReleaseTag)
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		commit, err := peelToCommit(repo, ref.Hash())
+		if err != nil {
+			// Tags of trees and blobs don't mark a release
+			return nil
+		}
+		tag := models.ReleaseTag{Name: ref.Name().Short(), Date: commit.Committer.When}
+		if annotated, err := repo.TagObject(ref.Hash()); err == nil {
+			tag.Date = annotated.Tagger.When
+		}
+		tags[commit.Hash.String()] = append(tags[commit.Hash.String()], tag)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags: %v", err)
+	}
+	for _, commitTags := range tags {
+		sort.Slice(commitTags, func(i, j int) bool { return commitTags[i].Name < commitTags[j].Name })
+	}
+	return tags, nil
+}
+
+// firstParentIter walks from HEAD along first parents only, like git log --first-parent
+type firstParentIter struct {
+	next *object.Commit
+}
+
+func newFirstParentIter(repo *git.Repository) (*firstParentIter, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return &firstParentIter{next: commit}, nil
+}
+
+func (i *firstParentIter) Next() (*object.Commit, error) {
+	if i.next == nil {
+		return nil, io.EOF
+	}
+	commit := i.next
+	i.next = nil
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		i.next = parent
+	}
+	return commit, nil
+}
+
+func (i *firstParentIter) ForEach(cb func(*object.Commit) error) error {
+	for {
+		commit, err := i.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := cb(commit); err != nil {
+			if err == storer.ErrStop {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (i *firstParentIter) Close() {}
+
+// FetchCommits copies commits and everything they reference from the source
+// repository into the new repository without creating any refs
+func FetchCommits(sourceRepoPath, newRepoPath string, commitIDs []string) error {
+	absSourcePath, err := filepath.Abs(sourceRepoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for source repository: %v", err)
+	}
+	args := append([]string{"fetch", "--quiet", "--no-tags", "--no-write-fetch-head", absSourcePath}, commitIDs...)
+	ui.LogShellCommand("git", args, newRepoPath)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = newRepoPath
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to fetch original commits: %v, output: %s", err, output)
+	}
+	return nil
+}
+
+// ApplyCommitToNewRepo applies a commit from the original repo to the new repo
+// and returns the hash of the commit created in the new repo. mergeParents are
+// added as further parents after the new repo's HEAD; they must already exist there.
+func ApplyCommitToNewRepo(originalRepo *git.Repository, newRepoPath, commitID, newMessage string, mergeParents []string) (string, error) {
+	// Get the commit
+	hash := plumbing.NewHash(commitID)
+	commit, err := originalRepo.CommitObject(hash)
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit object: %v", err)
+	}
+
+	// Get author info and timestamps
+	authorName, authorEmail := Authors.Map(commit.Author.Name, commit.Author.Email)
+
+	// Get the tree for this commit
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get tree for commit: %v", err)
+	}
+
+	// Build a manifest of every file in the commit's tree
+	var files []*object.File
+	manifest := make(map[string]bool)
+	manifestDirs := make(map[string]bool)
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if isFilteredPath(f.Name) {
+			return nil
+		}
+		files = append(files, f)
+		manifest[f.Name] = true
+		for dir := path.Dir(f.Name); dir != "."; dir = path.Dir(dir) {
+			manifestDirs[dir] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list files: %v", err)
+	}
+	submodules, err := treeSubmodules(tree)
+	if err != nil {
+		return "", err
+	}
+
+	// Remove everything in the new repo (except .git) that is not part of the tree
+	if err := removeUnlistedFiles(newRepoPath, manifest, manifestDirs); err != nil {
+		return "", err
+	}
+
+	// Write the tree's files directly into the new repo's working tree
+	if err := writeTreeFiles(newRepoPath, files); err != nil {
+		return "", fmt.Errorf("failed to extract files: %v", err)
+	}
+
+	// Add all files to the new repo
+	ui.LogShellCommand("git", []string{"add", "-A"}, newRepoPath)
+	addCmd := exec.Command("git", "add", "-A")
+	addCmd.Dir = newRepoPath
+	if output, err := addCmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to add files to new repo: %v, output: %s", err, output)
+	}
+
+	// Submodules have no files to write, their commits are staged directly
+	for name, hash := range submodules {
+		args := []string{"update-index", "--add", "--cacheinfo", fmt.Sprintf("%o,%s,%s", uint32(filemode.Submodule), hash, name)}
+		if err := ExecuteCommand("git", args, newRepoPath); err != nil {
+			return "", fmt.Errorf("failed to stage submodule %s: %v", name, err)
+		}
+	}
+
+	// git commit records the commits listed in MERGE_HEAD as further parents
+	if len(mergeParents) > 0 {
+		mergeHead := filepath.Join(newRepoPath, ".git", "MERGE_HEAD")
+		if err := os.WriteFile(mergeHead, []byte(strings.Join(mergeParents, "\n")+"\n"), 0644); err != nil {
+			return "", fmt.Errorf("failed to record merge parents: %v", err)
+		}
+	}
+
+	// Format the commit command with author info and timestamps, keeping the time
+	// zone offsets the dates were recorded with
+	authorArg := fmt.Sprintf("--author=%s <%s>", authorName, authorEmail)
+	dateArg := "--date=" + formatSignatureTime(commit.Author)
+
+	// Commit with the new message and preserve author info and date. The message is
+	// passed on stdin, so its length and quoting don't matter.
+	commitArgs := append([]string{"commit", "--allow-empty", authorArg, dateArg, "-F", "-"}, signArgs()...)
+	commitCmd := exec.Command("git", commitArgs...)
+	commitCmd.Dir = newRepoPath
+	commitCmd.Stdin = strings.NewReader(newMessage)
+
+	// Set GIT_COMMITTER_DATE to preserve the commit date as well, and keep the original
+	// committer unless it is overridden or reset
+	commitCmd.Env = append(committerEnv(commit.Committer), "GIT_COMMITTER_DATE="+formatSignatureTime(commit.Committer))
+
+	ui.LogShellCommand("git", commitArgs, newRepoPath)
+
+	if output, err := commitCmd.CombinedOutput(); err != nil {
+		if strings.Contains(string(output), "nothing to commit") {
+			ui.LogInfo("No changes to commit for %s", commitID[:8])
+			return GetHeadCommitID(newRepoPath)
+		}
+		return "", fmt.Errorf("failed to commit to new repo: %v, output: %s", err, output)
+	}
+
+	return GetHeadCommitID(newRepoPath)
+}
+
+// removeUnlistedFiles deletes files and directories in the working tree at repoPath
+// that are not listed in the manifest, leaving the .git directory untouched
+func removeUnlistedFiles(repoPath string, manifest, manifestDirs map[string]bool) error {
+	return filepath.WalkDir(repoPath, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fullPath == repoPath {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(repoPath, fullPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %v", err)
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if entry.IsDir() {
+			if relPath == ".git" {
+				return filepath.SkipDir
+			}
+			if !manifestDirs[relPath] {
+				if err := os.RemoveAll(fullPath); err != nil {
+					return fmt.Errorf("failed to remove directory %s: %v", fullPath, err)
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !manifest[relPath] {
+			if err := os.Remove(fullPath); err != nil {
+				return fmt.Errorf("failed to remove file %s: %v", fullPath, err)
+			}
+		}
+		return nil
+	})
+}
+
+// treeSubmodules returns the submodule entries of a tree, which tree.Files skips,
+// mapped to the commits they point at
+func treeSubmodules(tree *object.Tree) (map[string]string, error) {
+	submodules := make(map[string]string)
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			return submodules, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tree entries: %v", err)
+		}
+		if entry.Mode == filemode.Submodule && !isFilteredPath(name) {
+			submodules[name] = entry.Hash.String()
+		}
+	}
+}
+
+// treeFileWrite is a single file to be written into a working tree
+type treeFileWrite struct {
+	name    string
+	mode    filemode.FileMode
+	content []byte
+}
+
+// writeTreeFiles writes files into the working tree at repoPath. Blob contents are
+// read sequentially, since go-git's object storage is not safe for concurrent use,
+// while a pool of workers creates directories and writes the files.
+func writeTreeFiles(repoPath string, files []*object.File) error {
+	workers := runtime.NumCPU()
+	jobs := make(chan treeFileWrite, workers)
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				targetPath := filepath.Join(repoPath, filepath.FromSlash(job.name))
+				if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+					reportWriteError(errs, fmt.Errorf("failed to create directory for file %s: %v", job.name, err))
+					continue
+				}
+				if err := writeTreeFile(targetPath, job); err != nil {
+					reportWriteError(errs, fmt.Errorf("failed to write file %s: %v", job.name, err))
+				}
+			}
+		}()
+	}
+
+	var readErr error
+	for _, f := range files {
+		if len(errs) > 0 {
+			break
+		}
+		reader, err := f.Reader()
+		if err != nil {
+			readErr = fmt.Errorf("failed to get contents of file %s: %v", f.Name, err)
+			break
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			readErr = fmt.Errorf("failed to get contents of file %s: %v", f.Name, err)
+			break
+		}
+		jobs <- treeFileWrite{name: f.Name, mode: f.Mode, content: content}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if readErr != nil {
+		return readErr
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// writeTreeFile writes a file the way its tree entry describes it: a symlink to its
+// content for mode 120000, otherwise a regular file that is executable for mode
+// 100755. A symlink already at the path is replaced rather than written through.
+func writeTreeFile(targetPath string, job treeFileWrite) error {
+	if info, err := os.Lstat(targetPath); err == nil && (job.mode == filemode.Symlink || info.Mode()&os.ModeSymlink != 0) {
+		if err := os.Remove(targetPath); err != nil {
+			return err
+		}
+	}
+	if job.mode == filemode.Symlink {
+		return os.Symlink(string(job.content), targetPath)
+	}
+
+	perm := os.FileMode(0644)
+	if job.mode == filemode.Executable {
+		perm = 0755
+	}
+	if err := os.WriteFile(targetPath, job.content, perm); err != nil {
+		return err
+	}
+	// WriteFile keeps the permissions of a file that already exists
+	return os.Chmod(targetPath, perm)
+}
+
+// reportWriteError records the first error reported by a file writer
+func reportWriteError(errs chan error, err error) {
+	select {
+	case errs <- err:
+	default:
+	}
+}
+
+// GetHeadCommitID returns the full hash of the commit HEAD points to
+func GetHeadCommitID(repoPath string) (string, error) {
+	output, err := GetCommandOutput("git", []string{"rev-parse", "HEAD"}, repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %v", err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// CommitterName and CommitterEmail, when set, replace the committer identity of the
+// commits applied to the new repository. Otherwise each commit keeps its original
+// committer, unless ResetCommitter is set and the identity comes from git config.
+var (
+	CommitterName  string
+	CommitterEmail string
+	ResetCommitter bool
+)
+
+// SignCommits signs the commits created in the new repository with the gpg or ssh key
+// configured in git (user.signingkey, gpg.format), or with SigningKey when it is set
+var (
+	SignCommits bool
+	SigningKey  string
+)
+
+// signArgs returns the git commit and rebase arguments that sign the created commits
+func signArgs() []string {
+	if !SignCommits {
+		return nil
+	}
+	if SigningKey != "" {
+		return []string{"--gpg-sign=" + SigningKey}
+	}
+	return []string{"--gpg-sign"}
+}
+
+// FilteredPaths, when set, removes the matching paths from the trees of the commits
+// applied to the new repository, like git filter-repo --invert-paths
+var FilteredPaths *regexp.Regexp
+
+// isFilteredPath reports whether the file at name is left out of the new repository
+func isFilteredPath(name string) bool {
+	return FilteredPaths != nil && FilteredPaths.MatchString(name)
+}
+
+// committerIdentity returns the committer name and email for a commit applied on
+// behalf of original, mapped by -author-map. Empty values are left to git config.
+func committerIdentity(original object.Signature) (name, email string) {
+	original = mapSignature(original)
+	name, email = CommitterName, CommitterEmail
+	if !ResetCommitter {
+		if name == "" {
+			name = original.Name
+		}
+		if email == "" {
+			email = original.Email
+		}
+	}
+	return name, email
+}
+
+// committerEnv returns os.Environ with the committer identity for a commit applied on
+// behalf of original
+func committerEnv(original object.Signature) []string {
+	env := os.Environ()
+	name, email := committerIdentity(original)
+	if name != "" {
+		env = append(env, "GIT_COMMITTER_NAME="+name)
+	}
+	if email != "" {
+		env = append(env, "GIT_COMMITTER_EMAIL="+email)
+	}
+	return env
+}
+
+// toolIdentityEnv returns environment variables that attribute commits and notes
+// created by gitrewrite itself, independent of the user's git configuration
+func toolIdentityEnv() []string {
+	return append(os.Environ(),
+		"GIT_AUTHOR_NAME=GitRewrite",
+		"GIT_AUTHOR_EMAIL=gitrewrite@localhost",
+		"GIT_COMMITTER_NAME=GitRewrite",
+		"GIT_COMMITTER_EMAIL=gitrewrite@localhost",
+	)
+}
+
+// CommitFileToRepo writes a file into the repository's working tree and commits it
+// on top of the current HEAD using the gitrewrite identity
+func CommitFileToRepo(repoPath, fileName, content, message string) error {
+	if err := os.WriteFile(filepath.Join(repoPath, fileName), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", fileName, err)
+	}
+
+	ui.LogShellCommand("git", []string{"add", "--", fileName}, repoPath)
+	addCmd := exec.Command("git", "add", "--", fileName)
+	addCmd.Dir = repoPath
+	if output, err := addCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to stage %s: %v, output: %s", fileName, err, output)
+	}
+
+	commitArgs := append([]string{"commit", "-F", "-"}, signArgs()...)
+	ui.LogShellCommand("git", commitArgs, repoPath)
+	commitCmd := exec.Command("git", commitArgs...)
+	commitCmd.Dir = repoPath
+	commitCmd.Stdin = strings.NewReader(message)
+	commitCmd.Env = toolIdentityEnv()
+	if output, err := commitCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to commit %s: %v, output: %s", fileName, err, output)
+	}
+	return nil
+}
+
+// AddNote attaches content as a git note on the given commit under refs/notes/<notesRef>,
+// replacing any existing note on that commit
+func AddNote(repoPath, notesRef, commitID, content string) error {
+	args := []string{"notes", "--ref=" + notesRef, "add", "-f", "-F", "-", commitID}
+	ui.LogShellCommand("git", args, repoPath)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	cmd.Env = toolIdentityEnv()
+	cmd.Stdin = strings.NewReader(content)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add note to %s: %v, output: %s", commitID, err, output)
+	}
+	return nil
+}
+
+// WriteCommitMap writes a JSON object mapping every original commit hash to the hash
+// of the commit it was rewritten to, for updating references kept outside the repository
+func WriteCommitMap(path string, hashMap map[string]string) error {
+	data, err := json.MarshalIndent(hashMap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal commit map: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write commit map: %v", err)
+	}
+	return nil
+}
+
+// ReadCommitMap reads a commit map written by WriteCommitMap
+func ReadCommitMap(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit map: %v", err)
+	}
+	var hashMap map[string]string
+	if err := json.Unmarshal(data, &hashMap); err != nil {
+		return nil, fmt.Errorf("failed to parse commit map %s: %v", path, err)
+	}
+	return hashMap, nil
+}
+
+// TreeMismatch is a rewritten commit whose tree differs from the original commit's
+type TreeMismatch struct {
+	OriginalID string
+	NewID      string
+	// Reason describes the difference, e.g. the paths whose content or mode differ
+	Reason string
+}
+
+// maxMismatchPaths is how many differing paths a TreeMismatch lists
+const maxMismatchPaths = 5
+
+// CompareTrees checks that every commit in newRepoPath has the same tree hash as the
+// original commit hashMap maps to it, so only the messages changed. Paths removed with
+// FilteredPaths may be missing. Mismatches are returned oldest original commit first.
+func CompareTrees(originalRepo *git.Repository, newRepoPath string, hashMap map[string]string) ([]TreeMismatch, error) {
+	newRepo, err := git.PlainOpen(newRepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository at %s: %v", newRepoPath, err)
+	}
+
+	type datedMismatch struct {
+		TreeMismatch
+		when time.Time
+	}
+	var mismatches []datedMismatch
+	for originalID, newID := range hashMap {
+		original, err := originalRepo.CommitObject(plumbing.NewHash(originalID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get original commit %s: %v", originalID, err)
+		}
+		mismatch := datedMismatch{TreeMismatch: TreeMismatch{OriginalID: originalID, NewID: newID}, when: original.Committer.When}
+
+		rewritten, err := newRepo.CommitObject(plumbing.NewHash(newID))
+		if err != nil {
+			mismatch.Reason = "the rewritten commit is missing"
+			mismatches = append(mismatches, mismatch)
+			continue
+		}
+		if rewritten.TreeHash == original.TreeHash {
+			continue
+		}
+		if mismatch.Reason = describeTreeDifference(original, rewritten); mismatch.Reason != "" {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].when.Before(mismatches[j].when) })
+	result := make([]TreeMismatch, len(mismatches))
+	for i, mismatch := range mismatches {
+		result[i] = mismatch.TreeMismatch
+	}
+	return result, nil
+}
+
+// describeTreeDifference lists the paths whose content or mode differ between the
+// trees of two commits, or returns "" when the only difference is the paths removed
+// with FilteredPaths
+func describeTreeDifference(original, rewritten *object.Commit) string {
+	originalTree, err := original.Tree()
+	if err != nil {
+		return fmt.Sprintf("tree %s differs from %s", rewritten.TreeHash.String()[:8], original.TreeHash.String()[:8])
+	}
+	rewrittenTree, err := rewritten.Tree()
+	if err != nil {
+		return fmt.Sprintf("tree %s differs from %s", rewritten.TreeHash.String()[:8], original.TreeHash.String()[:8])
+	}
+	diff, err := object.DiffTree(originalTree, rewrittenTree)
+	if err != nil || len(diff) == 0 {
+		// Trees with the same entries can still differ, e.g. in how git sorts them
+		return fmt.Sprintf("tree %s differs from %s", rewritten.TreeHash.String()[:8], original.TreeHash.String()[:8])
+	}
+
+	var changes object.Changes
+	for _, change := range diff {
+		if change.To.Name == "" && isFilteredPath(change.From.Name) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var paths []string
+	for _, change := range changes {
+		if len(paths) == maxMismatchPaths {
+			paths = append(paths, fmt.Sprintf("and %d more", len(changes)-maxMismatchPaths))
+			break
+		}
+		switch {
+		case change.From.Name == "":
+			paths = append(paths, change.To.Name+" (added)")
+		case change.To.Name == "":
+			paths = append(paths, change.From.Name+" (missing)")
+		case change.From.TreeEntry.Mode != change.To.TreeEntry.Mode:
+			paths = append(paths, fmt.Sprintf("%s (mode %o instead of %o)", change.To.Name, uint32(change.To.TreeEntry.Mode), uint32(change.From.TreeEntry.Mode)))
+		default:
+			paths = append(paths, change.To.Name+" (content)")
+		}
+	}
+	return "differing paths: " + strings.Join(paths, ", ")
+}
+
+// WriteReplaceRefs creates a refs/replace/<original hash> ref for every rewritten commit
+// in the new repository, pointing at its new hash, so git commands given an original
+// hash show the rewritten commit once the refs are fetched next to the original objects.
+// Commits whose hash didn't change are skipped. It returns the number of refs created.
+func WriteReplaceRefs(repoPath string, hashMap map[string]string) (int, error) {
+	var input strings.Builder
+	count := 0
+	for originalID, newID := range hashMap {
+		if originalID == newID {
+			continue
+		}
+		fmt.Fprintf(&input, "update refs/replace/%s %s\n", originalID, newID)
+		count++
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	args := []string{"update-ref", "--stdin"}
+	ui.LogShellCommand("git", args, repoPath)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(input.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("failed to create replace refs: %v, output: %s", err, output)
+	}
+	return count, nil
+}
+
+// BackupRefs records every branch and tag of a repository under refs/original/, as
+// git filter-branch does, before its history is rewritten in place. It refuses to
+// overwrite the backup of an earlier rewrite. It returns the number of refs backed up.
+func BackupRefs(repoPath string) (int, error) {
+	existing, err := GetCommandOutput("git", []string{"for-each-ref", "--count=1", "--format=%(refname)", "refs/original/"}, repoPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list backup refs: %v", err)
+	}
+	if strings.TrimSpace(existing) != "" {
+		return 0, fmt.Errorf("refs/original/ already holds the backup of an earlier rewrite (e.g. %s); delete it first", strings.TrimSpace(existing))
+	}
+
+	output, err := GetCommandOutput("git", []string{"for-each-ref", "--format=%(objectname) %(refname)", "refs/heads/", "refs/tags/"}, repoPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list branches and tags: %v", err)
+	}
+	var input strings.Builder
+	count := 0
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		hash, ref, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&input, "create refs/original/%s %s\n", ref, hash)
+		count++
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	args := []string{"update-ref", "--stdin"}
+	ui.LogShellCommand("git", args, repoPath)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(input.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("failed to back up refs: %v, output: %s", err, output)
+	}
+	return count, nil
+}
+
+// ListRefs returns the full names of a repository's refs under the given prefixes,
+// e.g. refs/heads/ and refs/tags/
+func ListRefs(repoPath string, prefixes ...string) ([]string, error) {
+	output, err := GetCommandOutput("git", append([]string{"for-each-ref", "--format=%(refname)"}, prefixes...), repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %v", err)
+	}
+	return strings.Fields(output), nil
+}
+
+// ListCommits returns the hashes of the commits reachable from HEAD, oldest first
+func ListCommits(repoPath string) ([]string, error) {
+	output, err := GetCommandOutput("git", []string{"rev-list", "--reverse", "HEAD", "--"}, repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %v", err)
+	}
+	return strings.Fields(output), nil
+}
+
+// CountMergeCommits returns how many of the commits reachable from HEAD are merges
+func CountMergeCommits(repoPath string) (int, error) {
+	output, err := GetCommandOutput("git", []string{"rev-list", "--merges", "--count", "HEAD", "--"}, repoPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count merge commits: %v", err)
+	}
+	return strconv.Atoi(strings.TrimSpace(output))
+}
+
+// ResolveRemote returns the URL of a remote given by name or URL, and the name of the
+// remote of repoPath it refers to, empty when it isn't one of them
+func ResolveRemote(repoPath, remote string) (url, name string) {
+	if output, err := GetCommandOutput("git", []string{"remote", "get-url", remote}, repoPath); err == nil {
+		return strings.TrimSpace(output), remote
+	}
+	output, err := GetCommandOutput("git", []string{"remote"}, repoPath)
+	if err != nil {
+		return remote, ""
+	}
+	for _, name := range strings.Fields(output) {
+		if url, err := GetCommandOutput("git", []string{"remote", "get-url", name}, repoPath); err == nil && strings.TrimSpace(url) == remote {
+			return remote, name
+		}
+	}
+	return remote, ""
+}
+
+// RemoteTrackingBranches returns the branches of a remote as of the last fetch into
+// repoPath, by their name on the remote (refs/heads/...)
+func RemoteTrackingBranches(repoPath, remote string) (map[string]string, error) {
+	prefix := "refs/remotes/" + remote + "/"
+	output, err := GetCommandOutput("git", []string{"for-each-ref", "--format=%(objectname) %(refname)", prefix}, repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote-tracking branches of %s: %v", remote, err)
+	}
+	branches := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		hash, ref, ok := strings.Cut(line, " ")
+		if !ok || ref == prefix+"HEAD" {
+			continue
+		}
+		branches["refs/heads/"+strings.TrimPrefix(ref, prefix)] = hash
+	}
+	return branches, nil
+}
+
+// PushRefs pushes refs of repoPath to the same names on remote. With leases, each
+// branch replaces the remote's one only while it still points at the hash given for
+// it, an empty hash meaning it must not exist yet, and tags replace the remote's tags.
+// Without leases nothing on the remote is overwritten.
+func PushRefs(repoPath, remote string, refs []string, leases map[string]string) error {
+	args := []string{"push"}
+	var refspecs []string
+	for _, ref := range refs {
+		refspec := ref + ":" + ref
+		if leases != nil && strings.HasPrefix(ref, "refs/tags/") {
+			refspec = "+" + refspec
+		} else if leases != nil {
+			args = append(args, "--force-with-lease="+ref+":"+leases[ref])
+		}
+		refspecs = append(refspecs, refspec)
+	}
+	args = append(append(args, remote), refspecs...)
+	ui.LogShellCommand("git", args, repoPath)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// CopyNotes re-attaches every note under refs/notes/* in the source repository to
+// the corresponding rewritten commit in the new repository. hashMap maps original
+// commit hashes to new ones; notes on objects without a mapping are skipped.
+// It returns the number of notes copied.
+func CopyNotes(sourceRepoPath, newRepoPath string, hashMap map[string]string) (int, error) {
+	refsOutput, err := GetCommandOutput("git", []string{"for-each-ref", "--format=%(refname)", "refs/notes/"}, sourceRepoPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list notes refs: %v", err)
+	}
+
+	copied := 0
+	for _, notesRef := range strings.Fields(refsOutput) {
+		listOutput, err := GetCommandOutput("git", []string{"notes", "--ref=" + notesRef, "list"}, sourceRepoPath)
+		if err != nil {
+			return copied, fmt.Errorf("failed to list notes in %s: %v", notesRef, err)
+		}
+
+		skipped := 0
+		for _, line := range strings.Split(strings.TrimSpace(listOutput), "\n") {
+			// Each line is "<note blob> <annotated object>"
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			newID, ok := hashMap[fields[1]]
+			if !ok {
+				skipped++
+				continue
+			}
+
+			content, err := GetCommandOutput("git", []string{"cat-file", "blob", fields[0]}, sourceRepoPath)
+			if err != nil {
+				return copied, fmt.Errorf("failed to read note %s: %v", fields[0], err)
+			}
+			if err := AddNote(newRepoPath, notesRef, newID, content); err != nil {
+				return copied, err
+			}
+			copied++
+		}
+
+		if skipped > 0 {
+			ui.LogWarning("Skipped %d notes in %s attached to objects that were not rewritten", skipped, notesRef)
+		}
+	}
+
+	return copied, nil
+}
+
+// ResolveRevisionRange returns the commits selected by a git revision range such as
+// "abc123..HEAD", as listed by git rev-list
+func ResolveRevisionRange(repoPath, revisionRange string) (map[string]bool, error) {
+	output, err := GetCommandOutput("git", []string{"rev-list", revisionRange, "--"}, repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve revision range %q: %v", revisionRange, err)
+	}
+	commits := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line != "" {
+			commits[line] = true
+		}
+	}
+	return commits, nil
+}
+
+// PublishedCommits returns which of commitIDs are reachable from a branch or tag on the
+// origin remote, as listed by git ls-remote. Remote tips that aren't in the local
+// repository are skipped, since their history can't be walked without fetching. If
+// the remote can't be reached, the remote-tracking branches of the last fetch are used
+// instead. A repository without an origin remote has no published commits.
+func PublishedCommits(repoPath string, commitIDs []string) ([]string, error) {
+	if _, err := GetRemoteOriginURL(repoPath); err != nil {
+		return nil, nil
+	}
+
+	var tips []string
+	output, err := GetCommandOutput("git", []string{"ls-remote", "--heads", "--tags", "origin"}, repoPath)
+	if err == nil {
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 {
+				tips = append(tips, fields[0])
+			}
+		}
+	} else {
+		ui.LogWarning("Failed to list the refs of origin, using the remote-tracking branches of the last fetch: %v", err)
+		output, err = GetCommandOutput("git", []string{"for-each-ref", "--format=%(objectname)", "refs/remotes/origin/"}, repoPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list remote-tracking branches: %v", err)
+		}
+		tips = strings.Fields(output)
+	}
+
+	var known []string
+	for _, tip := range tips {
+		if ExecuteCommand("git", []string{"cat-file", "-e", tip + "^{commit}"}, repoPath) == nil {
+			known = append(known, tip)
+		}
+	}
+	if len(known) == 0 {
+		return nil, nil
+	}
+
+	output, err = GetCommandOutput("git", append([]string{"rev-list"}, append(known, "--")...), repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list published commits: %v", err)
+	}
+	reachable := make(map[string]bool)
+	for _, line := range strings.Fields(output) {
+		reachable[line] = true
+	}
+	var published []string
+	for _, commitID := range commitIDs {
+		if reachable[commitID] {
+			published = append(published, commitID)
+		}
+	}
+	return published, nil
+}
+
+// mergedBranchPatterns extract the merged branch name from merge commit subjects
+// written by git, GitHub and GitLab
+var mergedBranchPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^Merge branch '([^']+)'`),
+	regexp.MustCompile(`^Merge remote-tracking branch '(?:[^/']+/)?([^']+)'`),
+	regexp.MustCompile(`^Merge pull request #\d+ from [^/\s]+/(\S+)`),
+}
+
+// GetCommitBranches returns the branch each commit of HEAD's history was made on,
+// as far as merge commit subjects record it. Commits of nested branches get the
+// innermost branch; commits made directly on the current branch are not listed.
+func GetCommitBranches(repoPath string) (map[string]string, error) {
+	output, err := GetCommandOutput("git", []string{"log", "--merges", "--reverse", "--format=%H %P%x09%s", "HEAD"}, repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list merge commits: %v", err)
+	}
+
+	branches := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		hashes, subject, found := strings.Cut(line, "\t")
+		parents := strings.Fields(hashes)
+		if !found || len(parents) < 3 {
+			continue
+		}
+		var branch string
+		for _, pattern := range mergedBranchPatterns {
+			if match := pattern.FindStringSubmatch(subject); match != nil {
+				branch = match[1]
+				break
+			}
+		}
+		if branch == "" {
+			continue
+		}
+
+		// The commits the merge brought in are those reachable from the merged parent only
+		merged, err := GetCommandOutput("git", []string{"rev-list", parents[2], "^" + parents[1]}, repoPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list commits merged by %s: %v", parents[0][:8], err)
+		}
+		for _, commitID := range strings.Fields(merged) {
+			if _, ok := branches[commitID]; !ok {
+				branches[commitID] = branch
+			}
+		}
+	}
+	return branches, nil
+}
+
+// GetHistoryGraph renders the source repository's history as a text graph, newest first
+func GetHistoryGraph(repoPath string) (string, error) {
+	output, err := GetCommandOutput("git", []string{"log", "--graph", "--topo-order", "--format=%h %s", "--abbrev=8"}, repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to render history graph: %v", err)
+	}
+	return output, nil
+}
+
+// GetDefaultBranchName gets the default branch name of the repository
+func GetDefaultBranchName(repoPath string) (string, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %v", err)
+	}
+
+	// A bare repository has no branch checked out, so its HEAD, which a mirror takes
+	// from the repository it was cloned from, names the default branch
+	if _, err := repo.Worktree(); err == git.ErrIsBareRepository {
+		if branch, err := headBranch(repo); err == nil && branch != "" {
+			return branch, nil
+		}
+	}
+
+	// First try to get the remote's default branch (usually main or master)
+	if ref, err := repo.Storer.Reference("refs/remotes/origin/HEAD"); err == nil && ref.Type() == plumbing.SymbolicReference {
+		return strings.TrimPrefix(ref.Target().String(), "refs/remotes/origin/"), nil
+	}
+
+	// If that fails, try to get the default branch from git config
+	ui.LogShellCommand("git", []string{"config", "--get", "init.defaultBranch"}, repoPath)
+	cmd := exec.Command("git", "config", "--get", "init.defaultBranch")
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
+
+	if err == nil && len(output) > 0 {
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	// If we still don't have a default branch, fall back to checking if we have main or master
+	for _, branch := range []string{"main", "master"} {
+		if _, err := repo.Reference(plumbing.NewBranchReferenceName(branch), false); err == nil {
+			return branch, nil
+		}
+	}
+
+	// If all else fails, return an error
+	return "", fmt.Errorf("could not determine default branch name")
+}
+
+// NewRepositoryPath returns the path of the new repository named targetRepoName. It is
+// created in outputDir when that is set, e.g. on a filesystem with more space, and as
+// a sibling directory to the source repository otherwise.
+func NewRepositoryPath(sourceRepoPath, outputDir, targetRepoName string) string {
+	dir := outputDir
+	if dir == "" {
+		dir = filepath.Dir(filepath.Clean(sourceRepoPath))
+		if absSourcePath, err := filepath.Abs(sourceRepoPath); err == nil {
+			dir = filepath.Dir(filepath.Clean(absSourcePath))
+		}
+	} else if absDir, err := filepath.Abs(dir); err == nil {
+		dir = absDir
+	}
+	return filepath.Join(dir, targetRepoName)
+}
+
+// CreateNewRepository creates a new empty git repository at newRepoPath with the given
+// default branch name, creating its parent directories as needed. A bare repository
+// has no working tree, e.g. to be served by a git server directly.
+func CreateNewRepository(newRepoPath string, defaultBranch string, bare bool) error {
+	ui.LogInfo("Creating new repository at %s", newRepoPath)
 
 	// Check if the directory already exists
 	if _, err := os.Stat(newRepoPath); err == nil {
@@ -674,15 +2157,19 @@ func CreateNewRepository(sourceRepoPath string, targetRepoName string, defaultBr
 	}
 
 	// Initialize the repository
-	ui.LogShellCommand("git", []string{"init", "--initial-branch=" + defaultBranch}, newRepoPath)
-	cmd := exec.Command("git", "init", "--initial-branch="+defaultBranch)
+	initArgs := []string{"init"}
+	if bare {
+		initArgs = append(initArgs, "--bare")
+	}
+	ui.LogShellCommand("git", append(initArgs, "--initial-branch="+defaultBranch), newRepoPath)
+	cmd := exec.Command("git", append(initArgs, "--initial-branch="+defaultBranch)...)
 	cmd.Dir = newRepoPath
 	if _, err := cmd.CombinedOutput(); err != nil {
 		// If the --initial-branch flag fails (older git versions), fall back to regular init
 		// and then rename the branch
 		ui.LogWarning("Failed to initialize with specific branch name, trying alternative method: %v", err)
-		ui.LogShellCommand("git", []string{"init"}, newRepoPath)
-		initCmd := exec.Command("git", "init")
+		ui.LogShellCommand("git", initArgs, newRepoPath)
+		initCmd := exec.Command("git", initArgs...)
 		initCmd.Dir = newRepoPath
 		if output, err := initCmd.CombinedOutput(); err != nil {
 			return fmt.Errorf("failed to initialize git repository: %v, output: %s", err, output)
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestThresholdFor(t *testing.T) {
+	opts := ScanOptions{
+		MaxMsgLength: 30,
+		PathThresholds: []models.PathThreshold{
+			{Path: "docs/", MaxLength: 5},
+			{Path: "docs/api/", MaxLength: 80},
+			{Path: "vendor/", MaxLength: 50},
+		},
+	}
+	tests := []struct {
+		paths []string
+		want  int
+	}{
+		{nil, 30},
+		{[]string{"main.go"}, 30},
+		{[]string{"docs/usage.md"}, 5},
+		// The first matching rule applies, so docs/api/ is held to the docs/ threshold
+		{[]string{"docs/api/index.md"}, 5},
+		{[]string{"docs/usage.md", "main.go"}, 30},
+		{[]string{"docs/usage.md", "vendor/lib.go"}, 50},
+	}
+	for _, tt := range tests {
+		if got := opts.thresholdFor(tt.paths); got != tt.want {
+			t.Errorf("thresholdFor(%v) = %d, want %d", tt.paths, got, tt.want)
+		}
+	}
+}
+
+func TestGetCommitsChronologicalPathThresholds(t *testing.T) {
+	ui.SetupQuietConsole()
+	repoPath := t.TempDir()
+	repo, err := git.PlainInit(repoPath, false)
+	if err != nil {
+		t.Fatalf("PlainInit: %v", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Worktree: %v", err)
+	}
+
+	commits := []struct {
+		path    string
+		message string
+		want    bool
+	}{
+		// 20 characters, above the docs/ threshold and below -max-length
+		{"docs/usage.md", "update the usage doc", false},
+		{"main.go", "update the main file", true},
+		{"vendor/lib.go", "bump the vendored library to 1.2", true},
+		{"main.go", "rework the main file to read its flags", false},
+	}
+	when := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, commit := range commits {
+		path := filepath.Join(repoPath, commit.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(commit.message), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := worktree.Add(commit.path); err != nil {
+			t.Fatalf("Add(%s): %v", commit.path, err)
+		}
+		signature := &object.Signature{Name: "a", Email: "a@a", When: when.Add(time.Duration(i) * time.Hour)}
+		if _, err := worktree.Commit(commit.message, &git.CommitOptions{Author: signature, Committer: signature}); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+
+	allCommits, _, err := GetCommitsChronological(repo, ScanOptions{
+		MaxMsgLength: 30,
+		MessagesOnly: true,
+		PathThresholds: []models.PathThreshold{
+			{Path: "docs/", MaxLength: 5},
+			{Path: "vendor/", MaxLength: 50},
+		},
+	})
+	if err != nil {
+		t.Fatalf("GetCommitsChronological: %v", err)
+	}
+	if len(allCommits) != len(commits) {
+		t.Fatalf("got %d commits, want %d", len(allCommits), len(commits))
+	}
+	for i, commit := range commits {
+		if allCommits[i].NeedsRewrite != commit.want {
+			t.Errorf("commit %q touching %s: NeedsRewrite = %v, want %v", commit.message, commit.path, allCommits[i].NeedsRewrite, commit.want)
+		}
+	}
+}
+
+func TestDiffBudgets(t *testing.T) {
+	diff := func(size int) string { return strings.Repeat("x", size) }
+	tests := []struct {
+		sizes  []int
+		paths  []string
+		limits []models.DiffLimit
+		want   []int
+	}{
+		// Everything fits in the pooled budget
+		{[]int{10, 20}, []string{"a", "b"}, nil, []int{10, 20}},
+		// The small diff is sent whole and leaves its share to the larger ones
+		{[]int{10, 100, 100}, []string{"a", "b", "c"}, nil, []int{10, 70, 70}},
+		// Once all diffs exceed an even share, the pool is split by size
+		{[]int{60, 120}, []string{"a", "b"}, nil, []int{33, 66}},
+		// Files matching a rule get its limit and stay out of the pool
+		{
+			[]int{100, 30, 100},
+			[]string{"data/x.json", "docs/a.md", "main.go"},
+			[]models.DiffLimit{{Pattern: "*.json", MaxDiff: 5}, {Pattern: "docs/*.md", MaxDiff: 1000}},
+			[]int{5, 30, 50},
+		},
+	}
+	for _, tt := range tests {
+		var files []models.File
+		for i, size := range tt.sizes {
+			files = append(files, models.File{Path: tt.paths[i], Diff: diff(size)})
+		}
+		got := diffBudgets(files, 50, tt.limits)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("diffBudgets(%v, 50, %v) = %v, want %v", tt.sizes, tt.limits, got, tt.want)
+		}
+	}
+}
+
+func TestTruncatePatch(t *testing.T) {
+	const (
+		header = "--- a/f\n+++ b/f\n"
+		hunk1  = "@@ -1,3 +1,3 @@\n a\n-b\n+c\n d\n"
+		hunk2  = "@@ -10,2 +10,2 @@\n x\n-y\n+z\n"
+		added  = "@@ -1 +1,3 @@\n+aa\n+bb\n+cc\n"
+	)
+	patch := header + hunk1 + hunk2
+	tests := []struct {
+		patch string
+		limit int
+		want  string
+	}{
+		{patch, 100, patch},
+		// Not even the header fits
+		{patch, 10, "--- a/f\n"},
+		// The second hunk is left out and counted
+		{patch, len(header + hunk1), header + hunk1 + "[1 more hunk with +1/-1 lines not shown]\n"},
+		// The second hunk only fits with its added lines
+		{patch, len(header+hunk1) + 21, header + hunk1 + "@@ -10,2 +10,2 @@\n+z\n"},
+		// The first hunk fits without its context lines
+		{patch, len(header) + 22, header + "@@ -1,3 +1,3 @@\n-b\n+c\n" + "[1 more hunk with +1/-1 lines not shown]\n"},
+		// The first hunk's added lines don't fit whole, so as many lines as fit are sent
+		{header + added, len(header) + 22, header + "@@ -1 +1,3 @@\n+aa\n+bb\n"},
+		// Nothing of either hunk fits
+		{patch, len(header) + 4, header + "[2 more hunks with +2/-2 lines not shown]\n"},
+	}
+	for _, tt := range tests {
+		if got := truncatePatch(tt.patch, tt.limit); got != tt.want {
+			t.Errorf("truncatePatch(%q, %d) = %q, want %q", tt.patch, tt.limit, got, tt.want)
+		}
+	}
+}
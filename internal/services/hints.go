@@ -0,0 +1,135 @@
+package services
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+)
+
+// languageByExtension names the language of a file extension for the primary language hint
+var languageByExtension = map[string]string{
+	".go":    "Go",
+	".py":    "Python",
+	".js":    "JavaScript",
+	".jsx":   "JavaScript",
+	".mjs":   "JavaScript",
+	".ts":    "TypeScript",
+	".tsx":   "TypeScript",
+	".rs":    "Rust",
+	".java":  "Java",
+	".kt":    "Kotlin",
+	".rb":    "Ruby",
+	".php":   "PHP",
+	".cs":    "C#",
+	".c":     "C",
+	".h":     "C",
+	".cc":    "C++",
+	".cpp":   "C++",
+	".hpp":   "C++",
+	".swift": "Swift",
+	".lua":   "Lua",
+	".sh":    "Shell",
+	".bash":  "Shell",
+	".ps1":   "PowerShell",
+	".tf":    "Terraform",
+	".nix":   "Nix",
+	".yaml":  "YAML",
+	".yml":   "YAML",
+	".json":  "JSON",
+	".toml":  "TOML",
+	".md":    "Markdown",
+	".html":  "HTML",
+	".css":   "CSS",
+	".scss":  "CSS",
+	".sql":   "SQL",
+}
+
+// changeHint is a kind of change recognized from the paths and diffs of a commit
+type changeHint struct {
+	hint  string
+	match func(file models.File, name string) bool
+}
+
+// changeHints are checked against every file of a commit, in this order
+var changeHints = []changeHint{
+	{"Go module dependency update", func(f models.File, name string) bool {
+		return name == "go.mod" || name == "go.sum"
+	}},
+	{"npm dependency update", func(f models.File, name string) bool {
+		return name == "package.json" || name == "package-lock.json" || name == "yarn.lock" || name == "pnpm-lock.yaml"
+	}},
+	{"Python dependency update", func(f models.File, name string) bool {
+		return name == "pyproject.toml" || name == "poetry.lock" || name == "Pipfile" || name == "Pipfile.lock" ||
+			(strings.HasPrefix(name, "requirements") && strings.HasSuffix(name, ".txt"))
+	}},
+	{"Rust crate dependency update", func(f models.File, name string) bool {
+		return name == "Cargo.toml" || name == "Cargo.lock"
+	}},
+	{"Dockerfile or Docker Compose change", func(f models.File, name string) bool {
+		return strings.HasPrefix(name, "Dockerfile") || strings.HasSuffix(name, ".dockerfile") ||
+			strings.HasPrefix(name, "docker-compose") || strings.HasPrefix(name, "compose.")
+	}},
+	{"Helm chart change", func(f models.File, name string) bool {
+		return name == "Chart.yaml" || name == "Chart.lock" || (isYAML(name) && strings.Contains(f.Path, "templates/"))
+	}},
+	{"Kubernetes manifest change", func(f models.File, name string) bool {
+		return isYAML(name) && strings.Contains(f.Diff, "apiVersion:") && strings.Contains(f.Diff, "kind:")
+	}},
+	{"Terraform infrastructure change", func(f models.File, name string) bool {
+		return strings.HasSuffix(name, ".tf") || strings.HasSuffix(name, ".tfvars") || name == ".terraform.lock.hcl"
+	}},
+	{"Ansible playbook or role change", func(f models.File, name string) bool {
+		return isYAML(name) && (strings.Contains(f.Path, "roles/") || strings.Contains(f.Path, "playbook"))
+	}},
+	{"CI pipeline change", func(f models.File, name string) bool {
+		return strings.HasPrefix(f.Path, ".github/workflows/") || name == ".gitlab-ci.yml" ||
+			name == "Jenkinsfile" || strings.HasPrefix(f.Path, ".circleci/")
+	}},
+	{"Documentation change", func(f models.File, name string) bool {
+		return strings.HasSuffix(name, ".md") || strings.HasPrefix(f.Path, "docs/")
+	}},
+}
+
+// isYAML reports whether a file name has a YAML extension
+func isYAML(name string) bool {
+	return strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")
+}
+
+// ChangeHints describes a commit's changed files for the model: the primary language by
+// file extension, followed by kinds of change recognized from well-known file names and
+// manifest contents, e.g. "Go module dependency update" or "Kubernetes manifest change".
+func ChangeHints(files []models.File) []string {
+	var hints []string
+
+	counts := make(map[string]int)
+	for _, file := range files {
+		if language, ok := languageByExtension[strings.ToLower(path.Ext(file.Path))]; ok {
+			counts[language]++
+		}
+	}
+	if len(counts) > 0 {
+		languages := make([]string, 0, len(counts))
+		for language := range counts {
+			languages = append(languages, language)
+		}
+		sort.Slice(languages, func(i, j int) bool {
+			if counts[languages[i]] != counts[languages[j]] {
+				return counts[languages[i]] > counts[languages[j]]
+			}
+			return languages[i] < languages[j]
+		})
+		hints = append(hints, "Primary language: "+languages[0])
+	}
+
+	for _, rule := range changeHints {
+		for _, file := range files {
+			if rule.match(file, path.Base(file.Path)) {
+				hints = append(hints, rule.hint)
+				break
+			}
+		}
+	}
+	return hints
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// ChatMessage is a single message of a chat request
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// LLMClient is a chat backend used to generate commit messages
+type LLMClient interface {
+	// Name returns the backend name used in log messages
+	Name() string
+	// Chat sends messages to the model and returns its reply. A non-nil format is a
+	// JSON schema the reply must follow.
+	Chat(ctx context.Context, model string, messages []ChatMessage, format json.RawMessage, temperature float64) (string, error)
+	// CheckAvailability checks that the backend can be reached
+	CheckAvailability() error
+	// ContextSize returns the context window of a model in tokens
+	ContextSize(model string) (int, error)
+}
+
+// Client is the backend used for message generation
+var Client LLMClient = OllamaClient{}
+
+// NewLLMClient returns the client for a -backend value. ollamaOpts only apply to the
+// Ollama backend.
+func NewLLMClient(backend, apiBase, apiKey string, ollamaOpts OllamaOptions) (LLMClient, error) {
+	switch backend {
+	case "", "ollama":
+		return NewOllamaClient(ollamaOpts)
+	case "openai":
+		return NewOpenAIClient(apiBase, apiKey), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q: must be 'ollama' or 'openai'", backend)
+	}
+}
+
+// LLMRetries is how often a failed request, or a reply that isn't valid JSON, is
+// retried. LLMRetryBackoff is the wait before the first retry of a failed request,
+// doubled for every further retry.
+var (
+	LLMRetries      int
+	LLMRetryBackoff time.Duration
+)
+
+// streamKey is the context key of the commit whose reply is shown while it streams in
+type streamKey struct{}
+
+// streamReply returns a context whose chat requests show the reply received so far in
+// the commit details of commitID, for backends that stream their replies
+func streamReply(ctx context.Context, commitID string) context.Context {
+	return context.WithValue(ctx, streamKey{}, commitID)
+}
+
+// showPartialReply shows the reply received so far when ctx was made by streamReply
+func showPartialReply(ctx context.Context, reply string) {
+	if commitID, ok := ctx.Value(streamKey{}).(string); ok {
+		ui.UpdatePartialMessage(commitID, reply)
+	}
+}
+
+// chatWithRetry sends a chat request, retrying failed requests up to LLMRetries times.
+// It gives up early once ctx is done, e.g. because -commit-timeout was hit.
+func chatWithRetry(ctx context.Context, commitID, model string, messages []ChatMessage, format json.RawMessage, temperature float64) (string, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := auditedChat(ctx, commitID, model, messages, format, temperature)
+		if err == nil || attempt >= LLMRetries || ctx.Err() != nil {
+			return resp, err
+		}
+
+		delay := LLMRetryBackoff << attempt
+		ui.LogWarning("%s request for commit %s failed: %v, retrying in %s (retry %d of %d)",
+			Client.Name(), commitID[:8], err, delay, attempt+1, LLMRetries)
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// WarmUp sends a minimal request so the backend loads the model before the first commit
+// is processed, and returns how long that took
+func WarmUp(ctx context.Context, model string) (time.Duration, error) {
+	start := time.Now()
+	messages := []ChatMessage{{Role: "user", Content: "Reply with OK."}}
+	if _, err := Client.Chat(ctx, model, messages, nil, 0); err != nil {
+		return time.Since(start), err
+	}
+	return time.Since(start), nil
+}
+
+// tokenUsage counts the tokens sent to and received from the backend during this run
+var tokenUsage struct {
+	sync.Mutex
+	prompt   int
+	response int
+}
+
+// recordTokenUsage adds the tokens of one chat request to the run's usage. Counts the
+// backend doesn't report (zero) are estimated from the request and reply text.
+func recordTokenUsage(messages []ChatMessage, format json.RawMessage, reply string, promptTokens, responseTokens int) {
+	if promptTokens == 0 {
+		for _, message := range messages {
+			promptTokens += EstimateTokenCount(message.Content)
+		}
+		promptTokens += EstimateTokenCount(string(format))
+	}
+	if responseTokens == 0 {
+		responseTokens = EstimateTokenCount(reply)
+	}
+
+	tokenUsage.Lock()
+	defer tokenUsage.Unlock()
+	tokenUsage.prompt += promptTokens
+	tokenUsage.response += responseTokens
+}
+
+// TokensUsed returns the prompt and response tokens used so far in this run
+func TokensUsed() (prompt, response int) {
+	tokenUsage.Lock()
+	defer tokenUsage.Unlock()
+	return tokenUsage.prompt, tokenUsage.response
+}
+
+// requestLatencies holds how long each chat request of this run took, failed ones included
+var requestLatencies struct {
+	sync.Mutex
+	durations []time.Duration
+}
+
+// recordRequestLatency adds the duration of one chat request to the run's latencies
+func recordRequestLatency(d time.Duration) {
+	requestLatencies.Lock()
+	defer requestLatencies.Unlock()
+	requestLatencies.durations = append(requestLatencies.durations, d)
+}
+
+// RequestLatencies returns the durations of the chat requests sent so far in this run
+func RequestLatencies() []time.Duration {
+	requestLatencies.Lock()
+	defer requestLatencies.Unlock()
+	return append([]time.Duration(nil), requestLatencies.durations...)
+}
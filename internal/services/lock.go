@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockFileName is the name of the lock file in a repository's git directory
+const lockFileName = "gitrewrite.lock"
+
+// RunLock is a lock file that keeps other runs from working on a repository at the
+// same time, e.g. one forgotten in another terminal still writing to the same output
+type RunLock struct {
+	path string
+}
+
+// LockRepository creates the lock file in the git directory of the repository at
+// repoPath. A lock left behind by a run that is no longer alive on this machine is
+// taken over; one held by a running process is an error naming it.
+func LockRepository(repoPath string) (*RunLock, error) {
+	output, err := GetCommandOutput("git", []string{"rev-parse", "--git-common-dir"}, repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the git directory of %s: %v", repoPath, err)
+	}
+	gitDir := strings.TrimSpace(output)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(repoPath, gitDir)
+	}
+	path := filepath.Join(gitDir, lockFileName)
+	hostname, _ := os.Hostname()
+	content := fmt.Sprintf("%d\n%s\n%s\n", os.Getpid(), hostname, time.Now().Format(time.RFC3339))
+
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.WriteString(content)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock file %s: %v", path, err)
+			}
+			return &RunLock{path: path}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file %s: %v", path, err)
+		}
+
+		holder, stale := readLockHolder(path, hostname)
+		if !stale {
+			return nil, fmt.Errorf("another run (%s) is using %s; wait for it to finish, or remove %s if it is no longer running", holder, repoPath, path)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale lock file %s: %v", path, err)
+		}
+	}
+	return nil, fmt.Errorf("failed to create lock file %s: another run keeps creating it", path)
+}
+
+// readLockHolder describes the run holding a lock file and reports whether it is
+// known to have ended. Runs on other machines, e.g. sharing the repository over a
+// network drive, are assumed to be alive.
+func readLockHolder(path, hostname string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", true
+	}
+	fields := strings.Split(strings.TrimSpace(string(data)), "\n")
+	pid, pidErr := strconv.Atoi(fields[0])
+	if err != nil || pidErr != nil || len(fields) < 3 {
+		return "unknown process", false
+	}
+	holder := fmt.Sprintf("process %d on %s, started %s", pid, fields[1], fields[2])
+	if fields[1] != hostname {
+		return holder, false
+	}
+	return holder, !processRunning(pid)
+}
+
+// Unlock removes the lock file
+func (l *RunLock) Unlock() error {
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove lock file %s: %v", l.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// mailmapLine matches the forms of a .mailmap line described in gitmailmap(5):
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+var mailmapLine = regexp.MustCompile(`^([^<#]*)<([^>]*)>\s*(?:([^<#]*)<([^>]*)>)?\s*(?:#.*)?$`)
+
+// mailmapEntry maps the identities with commitEmail, and commitName when it is set,
+// to a proper name and email. Empty proper values keep the original ones.
+type mailmapEntry struct {
+	properName, properEmail string
+	commitName, commitEmail string
+}
+
+// AuthorMap rewrites the author and committer identities of applied commits following
+// a .mailmap file
+type AuthorMap struct {
+	entries []mailmapEntry
+}
+
+// Authors maps the identities of applied commits with -author-map; nil keeps them
+var Authors *AuthorMap
+
+// LoadAuthorMap reads a file in the .mailmap format. Emails and names are matched
+// case-insensitively, as git does.
+func LoadAuthorMap(path string) (*AuthorMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read author map: %v", err)
+	}
+	authors := &AuthorMap{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		match := mailmapLine.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("invalid author map line %d: %q", i+1, line)
+		}
+		entry := mailmapEntry{properName: strings.TrimSpace(match[1]), commitEmail: strings.TrimSpace(match[2])}
+		if match[4] != "" {
+			entry.properEmail, entry.commitName, entry.commitEmail = entry.commitEmail, strings.TrimSpace(match[3]), strings.TrimSpace(match[4])
+		}
+		authors.entries = append(authors.entries, entry)
+	}
+	return authors, nil
+}
+
+// Len returns the number of entries of the map
+func (m *AuthorMap) Len() int {
+	return len(m.entries)
+}
+
+// Map returns the identity name and email are mapped to. An entry naming the commit
+// name as well takes precedence over one with the email alone; later lines win over
+// earlier ones, like in git.
+func (m *AuthorMap) Map(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+	var found *mailmapEntry
+	for i := range m.entries {
+		entry := &m.entries[i]
+		if !strings.EqualFold(entry.commitEmail, email) {
+			continue
+		}
+		if entry.commitName != "" && !strings.EqualFold(entry.commitName, name) {
+			continue
+		}
+		if found == nil || entry.commitName != "" || found.commitName == "" {
+			found = entry
+		}
+	}
+	if found == nil {
+		return name, email
+	}
+	if found.properName != "" {
+		name = found.properName
+	}
+	if found.properEmail != "" {
+		email = found.properEmail
+	}
+	return name, email
+}
+
+// mapSignature returns a signature with its identity mapped by Authors
+func mapSignature(signature object.Signature) object.Signature {
+	signature.Name, signature.Email = Authors.Map(signature.Name, signature.Email)
+	return signature
+}
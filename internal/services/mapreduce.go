@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// MapReduce generates the messages of commits that don't fit the model's context in
+// parts: the files are split into batches that fit, each batch gets its own messages
+// and a final request merges them into the commit's message
+var MapReduce bool
+
+// mergeSystemPrompt instructs the model how to merge the messages of a commit's parts
+const mergeSystemPrompt = "Act as a senior engineer enforcing Conventional Commits. A commit was too large to review at once, so its files were split into parts and each part got its own messages. " +
+	"Input: JSON with the commit ID, its original message, its number of files, hints about the kind of change and the messages of every part. " +
+	"Output: JSON with commit_id and messages array describing the whole commit. Each message object will contain the field type, description and affected app. Rules:\n" +
+	"1. Types: feat, fix, chore, docs, refactor, perf\n" +
+	"2. Max 100 characters\n" +
+	"3. Merge messages of different parts that describe the same change\n" +
+	"4. One message per logical change, the most important first\n" +
+	"5. Never use markdown/symbols\n" +
+	"6. Keep the affected app of the messages you merge\n" +
+	"7: Example: {'type':'chore','description':'upgrade Docker image to v21.3.1','affected_app':'hortusfox'}"
+
+// plainMergeRule replaces the multiple messages of a merge with a single sentence
+const plainMergeRule = "\nReturn exactly one message whose description is a single imperative sentence without a type prefix or final period, " +
+	"like 'Add retries to the upload client', summarizing the whole commit in at most 72 characters."
+
+// mergeInput is the commit data sent to merge the messages of its parts
+type mergeInput struct {
+	CommitID  string      `json:"commit_id"`
+	Message   string      `json:"message"`
+	FileCount int         `json:"file_count"`
+	Hints     []string    `json:"hints,omitempty"`
+	Parts     []mergePart `json:"parts"`
+}
+
+// mergePart is what the model wrote for one batch of a commit's files
+type mergePart struct {
+	Messages []map[string]string `json:"messages"`
+	Body     string              `json:"body,omitempty"`
+	Footers  []string            `json:"footers,omitempty"`
+}
+
+// generateChunkedCommitMessage generates the message of a commit too large for the
+// context: its files are packed into batches that fit, every batch is sent as a commit
+// of its own and the messages of the batches are merged by a final request
+func generateChunkedCommitMessage(ctx context.Context, commit models.CommitOutput, model string, temperature float64, contextSize int) (models.NewCommitMessage, error) {
+	systemPrompt, err := commitPrompt(commit)
+	if err != nil {
+		return models.NewCommitMessage{}, err
+	}
+	formatJSON, _ := json.Marshal(commitMessageFormat())
+	metadata := commit
+	metadata.Files = nil
+	metadataJSON, _ := json.Marshal(metadata)
+
+	// Keep the batches a tenth below the limit so the estimate of the whole batch,
+	// which is counted in one piece, still fits
+	budget := contextSize*3/4 - EstimateTokenCount(systemPrompt) - EstimateTokenCount(commitUserPrompt) -
+		EstimateTokenCount(string(formatJSON)) - EstimateTokenCount(string(metadataJSON))
+	budget = budget * 9 / 10
+	if budget <= 0 {
+		return models.NewCommitMessage{}, fmt.Errorf("%w: a context of %d tokens leaves no room for the files of commit %s",
+			ErrContextExceeded, contextSize, commit.CommitID[:8])
+	}
+
+	batches := fileBatches(commit.Files, budget)
+	if len(batches) == 1 {
+		ui.LogInfo("Commit %s exceeds the model context window, truncating its diff to fit", commit.CommitID[:8])
+		commit.Files = batches[0]
+		return generateNewCommitMessage(ctx, commit, model, temperature, contextSize, false)
+	}
+	ui.LogInfo("Commit %s exceeds the model context window, generating its message in %d parts", commit.CommitID[:8], len(batches))
+	parts := make([]models.NewCommitMessage, 0, len(batches))
+	for i, batch := range batches {
+		part := commit
+		part.Files = batch
+		ui.LogInfo("Generating part %d of %d of commit %s (%d files)", i+1, len(batches), commit.CommitID[:8], len(batch))
+		message, err := generateNewCommitMessage(ctx, part, model, temperature, contextSize, false)
+		if err != nil {
+			return models.NewCommitMessage{}, fmt.Errorf("failed to generate part %d of %d: %v", i+1, len(batches), err)
+		}
+		parts = append(parts, message)
+	}
+
+	ui.UpdateStatus("Merging the messages of the parts...")
+	newCommit, err := mergeCommitMessages(ctx, commit, parts, model, temperature, contextSize)
+	if err != nil {
+		return models.NewCommitMessage{}, err
+	}
+	newCommit.CommitID = commit.CommitID
+	ui.UpdateStatus("Ready")
+	return newCommit, nil
+}
+
+// fileBatches packs files, in order, into batches of at most budget tokens. A file
+// larger than the budget on its own is truncated to fit a batch by itself.
+func fileBatches(files []models.File, budget int) [][]models.File {
+	var batches [][]models.File
+	var batch []models.File
+	used := 0
+	for _, file := range files {
+		tokens := fileTokens(file)
+		if tokens > budget {
+			file = shrinkFile(file, budget)
+			tokens = fileTokens(file)
+		}
+		if len(batch) > 0 && used+tokens > budget {
+			batches = append(batches, batch)
+			batch, used = nil, 0
+		}
+		batch = append(batch, file)
+		used += tokens
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// fileTokens estimates the tokens of a file in the commit JSON, with its separator
+func fileTokens(file models.File) int {
+	data, _ := json.Marshal(file)
+	return EstimateTokenCount(string(data)) + 1
+}
+
+// shrinkFile truncates a file's diff at hunk boundaries until it fits budget tokens
+func shrinkFile(file models.File, budget int) models.File {
+	patch, limit := file.Diff, len(file.Diff)
+	for tokens := fileTokens(file); tokens > budget && limit > 0; tokens = fileTokens(file) {
+		limit = limit * budget / tokens * 9 / 10
+		file.Diff = truncatePatch(patch, limit)
+	}
+	return file
+}
+
+// mergeCommitMessages asks the model to merge the messages of a commit's parts into
+// the commit's message. When they don't fit the context together, each half is merged
+// first.
+func mergeCommitMessages(ctx context.Context, commit models.CommitOutput, parts []models.NewCommitMessage, model string, temperature float64, contextSize int) (models.NewCommitMessage, error) {
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+
+	systemPrompt := mergeSystemPrompt
+	if Style == StylePlain {
+		systemPrompt += plainMergeRule
+	}
+	systemPrompt = withPromptOptions(systemPrompt)
+	input := mergeInput{
+		CommitID:  commit.CommitID,
+		Message:   commit.Message,
+		FileCount: len(commit.Files),
+		Hints:     ChangeHints(commit.Files),
+	}
+	for _, part := range parts {
+		input.Parts = append(input.Parts, mergePart{Messages: part.Messages, Body: part.Body, Footers: part.Footers})
+	}
+	inputJSON, _ := json.Marshal(input)
+	formatJSON, _ := json.Marshal(commitMessageFormat())
+
+	totalTokens := EstimateTokenCount(systemPrompt) + EstimateTokenCount(string(inputJSON)) + EstimateTokenCount(string(formatJSON))
+	if len(parts) > 2 && totalTokens+contextSize/4 > contextSize {
+		half := len(parts) / 2
+		first, err := mergeCommitMessages(ctx, commit, parts[:half], model, temperature, contextSize)
+		if err != nil {
+			return models.NewCommitMessage{}, err
+		}
+		second, err := mergeCommitMessages(ctx, commit, parts[half:], model, temperature, contextSize)
+		if err != nil {
+			return models.NewCommitMessage{}, err
+		}
+		return mergeCommitMessages(ctx, commit, []models.NewCommitMessage{first, second}, model, temperature, contextSize)
+	}
+
+	messages := []ChatMessage{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: string(inputJSON)},
+	}
+	ui.LogInfo("Merging the messages of %d parts of commit %s (est. %d tokens)", len(parts), commit.CommitID[:8], totalTokens)
+	return requestCommitMessage(ctx, commit.CommitID, model, messages, json.RawMessage(formatJSON), temperature)
+}
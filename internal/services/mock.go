@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+)
+
+// MockClient answers every request with a canned message without contacting a model.
+// It is used by the selftest command and by -mock-llm to run the pipeline without a
+// backend.
+type MockClient struct {
+	// Message is the description of the single chore message returned for every
+	// commit. When it is empty the description is made up from the commit's files.
+	Message string
+}
+
+// Name returns the backend name used in log messages
+func (MockClient) Name() string {
+	return "mock generator"
+}
+
+// Chat returns a commit message response for the commit in the last message, or plain
+// text when no JSON format is requested
+func (c MockClient) Chat(ctx context.Context, model string, messages []ChatMessage, format json.RawMessage, temperature float64) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	var commit models.CommitOutput
+	var batch batchInput
+	if len(messages) > 0 {
+		json.Unmarshal([]byte(messages[len(messages)-1].Content), &commit)
+		json.Unmarshal([]byte(messages[len(messages)-1].Content), &batch)
+	}
+
+	if format == nil {
+		commitType, description, _ := c.message(commit)
+		return commitType + ": " + description, nil
+	}
+	if len(batch.Commits) > 0 {
+		var replies []map[string]any
+		for _, commit := range batch.Commits {
+			replies = append(replies, c.reply(commit))
+		}
+		response, err := json.Marshal(map[string]any{"commits": replies})
+		return string(response), err
+	}
+	response, err := json.Marshal(c.reply(commit))
+	return string(response), err
+}
+
+// message returns the canned message for a commit
+func (c MockClient) message(commit models.CommitOutput) (commitType, description, app string) {
+	if c.Message != "" {
+		return "chore", c.Message, "selftest"
+	}
+	return mockMessage(commit.Files)
+}
+
+// reply returns the reply object for a commit
+func (c MockClient) reply(commit models.CommitOutput) map[string]any {
+	commitType, description, app := c.message(commit)
+	return map[string]any{
+		"commit_id": commit.CommitID,
+		"messages": []map[string]string{
+			{"type": commitType, "description": description, "affected_app": app},
+		},
+	}
+}
+
+// mockMessage makes up a deterministic message for the changed files: docs when only
+// documentation changed and chore otherwise, a description naming the files and the
+// top-level directory of the first file as the affected app
+func mockMessage(files []models.File) (commitType, description, app string) {
+	if len(files) == 0 {
+		return "chore", "update repository", "repo"
+	}
+
+	commitType = "docs"
+	for _, file := range files {
+		if !strings.HasSuffix(file.Path, ".md") && !strings.HasPrefix(file.Path, "docs/") {
+			commitType = "chore"
+			break
+		}
+	}
+
+	names := make([]string, 0, 3)
+	for _, file := range files[:min(3, len(files))] {
+		names = append(names, filepath.Base(file.Path))
+	}
+	description = "update " + strings.Join(names, ", ")
+	if len(files) > 3 {
+		description += fmt.Sprintf(" and %d more files", len(files)-3)
+	}
+
+	app = "repo"
+	if dir, _, ok := strings.Cut(files[0].Path, "/"); ok {
+		app = dir
+	}
+	return commitType, description, app
+}
+
+// CheckAvailability always succeeds
+func (MockClient) CheckAvailability() error {
+	return nil
+}
+
+// ContextSize reports a context window large enough for any commit the mock generator
+// is given
+func (MockClient) ContextSize(model string) (int, error) {
+	return 32768, nil
+}
@@ -3,50 +3,76 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/MrLemur/gitrewrite/internal/models"
 	"github.com/MrLemur/gitrewrite/internal/ui"
 	ollama "github.com/ollama/ollama/api"
 )
 
-// SendOllamaMessage sends a request to the Ollama API
-func SendOllamaMessage(model string, messages []ollama.Message, format json.RawMessage, temperature float64) (string, error) {
-	client, err := ollama.ClientFromEnvironment()
+// OllamaClient talks to an Ollama server, by default the one configured through
+// OLLAMA_HOST. NewOllamaClient configures another host, TLS and headers.
+type OllamaClient struct {
+	// Host is the server URL, nil for OLLAMA_HOST
+	Host *url.URL
+	// HTTP sends the requests, nil for http.DefaultClient
+	HTTP *http.Client
+}
+
+// KeepAlive is how long Ollama keeps the model loaded after a request, 0 leaves it to
+// the server's default of a few minutes
+var KeepAlive time.Duration
+
+// Name returns the backend name used in log messages
+func (OllamaClient) Name() string {
+	return "Ollama"
+}
+
+// Chat sends a request to the Ollama API, aborting when ctx is cancelled
+func (c OllamaClient) Chat(ctx context.Context, model string, messages []ChatMessage, format json.RawMessage, temperature float64) (string, error) {
+	client := c.api()
 	if model == "" {
 		return "", fmt.Errorf("Ollama model must be specified")
 	}
-	if err != nil {
-		return "", err
+	ollamaMessages := make([]ollama.Message, 0, len(messages))
+	for _, message := range messages {
+		ollamaMessages = append(ollamaMessages, ollama.Message{Role: message.Role, Content: message.Content})
 	}
-	ctx := context.Background()
 	var response string
+	var metrics ollama.Metrics
 	respFunc := func(resp ollama.ChatResponse) error {
 		response += resp.Message.Content
+		if resp.Done {
+			metrics = resp.Metrics
+		} else {
+			showPartialReply(ctx, response)
+		}
 		return nil
 	}
-	err = client.Chat(
-		ctx,
-		&ollama.ChatRequest{Model: model, Messages: messages, Format: format, Options: map[string]any{"temperature": temperature}},
-		respFunc,
-	)
+	request := &ollama.ChatRequest{Model: model, Messages: ollamaMessages, Format: format, Options: map[string]any{"temperature": temperature}}
+	if KeepAlive != 0 {
+		request.KeepAlive = &ollama.Duration{Duration: KeepAlive}
+	}
+	err := client.Chat(ctx, request, respFunc)
 	if err != nil {
 		return "", err
 	}
+	recordTokenUsage(messages, format, response, metrics.PromptEvalCount, metrics.EvalCount)
 	return response, nil
 }
 
-// CheckOllamaAvailability checks if the Ollama server is available
-func CheckOllamaAvailability() error {
-	client, err := ollama.ClientFromEnvironment()
-	if err != nil {
-		return fmt.Errorf("failed to create Ollama client: %v", err)
-	}
+// CheckAvailability checks if the Ollama server is available
+func (c OllamaClient) CheckAvailability() error {
+	client := c.api()
 	
 	ctx := context.Background()
-	_, err = client.List(ctx)
+	_, err := client.List(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to connect to Ollama server: %v", err)
 	}
@@ -54,12 +80,9 @@ func CheckOllamaAvailability() error {
 	return nil
 }
 
-// GetModelContextSize retrieves the context window size for a model
-func GetModelContextSize(model string) (int, error) {
-	client, err := ollama.ClientFromEnvironment()
-	if err != nil {
-		return 0, fmt.Errorf("failed to create Ollama client: %v", err)
-	}
+// ContextSize retrieves the context window size for a model
+func (c OllamaClient) ContextSize(model string) (int, error) {
+	client := c.api()
 	
 	ctx := context.Background()
 	modelInfo, err := client.Show(ctx, &ollama.ShowRequest{Name: model})
@@ -109,49 +132,79 @@ func GetModelContextSize(model string) (int, error) {
 	return contextSize, nil
 }
 
-// EstimateTokenCount provides a rough estimate of token count for text
+// EstimateTokenCount counts the tokens of text with the configured TokenCounter
 func EstimateTokenCount(text string) int {
-	// Simple estimation: ~4 characters per token for English text
-	// This is a rough approximation and varies by tokenizer
-	return len(text) / 4
+	return Tokens.CountTokens(text)
+}
+
+// commitSystemPrompt instructs the model how to rewrite a single commit
+const commitSystemPrompt = "Act as a senior engineer enforcing Conventional Commits. Input: Commit data with ID/message/diffs. Output: JSON with commit_id and messages array. Each message object will contain the field type, desciption and affected app. Rules:\n" +
+	"1. Types: feat, fix, chore, docs, refactor, perf\n" +
+	"2. Max 100 characters\n" +
+	"3. Explain what changed + why\n" +
+	"4. One message per logical change\n" +
+	"5. Group related files under one message\n" +
+	"6. Never use markdown/symbols\n" +
+	"7. Distill affect app name from the file path.\n" +
+	"8. Use the hints, when present, to pick the type and affected app.\n" +
+	"9: Example: {'type':'chore','description':'upgrade Docker image to v21.3.1','affected_app':'hortusfox'}"
+
+// plainSystemPrompt instructs the model to describe a commit in one plain sentence
+const plainSystemPrompt = "Act as a senior engineer writing clear commit messages. Input: Commit data with ID/message/diffs. Output: JSON with commit_id and a messages array holding exactly one message object with the fields type, description and affected app. Rules:\n" +
+	"1. The description is a single imperative sentence without a type prefix or final period, like 'Add retries to the upload client'\n" +
+	"2. Max 72 characters\n" +
+	"3. Summarize the most important change + why\n" +
+	"4. Types: feat, fix, chore, docs, refactor, perf\n" +
+	"5. Never use markdown/symbols\n" +
+	"6. Distill affect app name from the file path.\n" +
+	"7. Use the hints, when present, to pick the type and affected app.\n" +
+	"8: Example: {'type':'chore','description':'Upgrade the Docker image to v21.3.1','affected_app':'hortusfox'}"
+
+// commitUserPrompt introduces the commit data sent after it
+const commitUserPrompt = "Generate a new commit message for the following commit:"
+
+// ErrContextExceeded is returned for commits whose prompt doesn't fit the model's context
+var ErrContextExceeded = errors.New("commit would exceed model context window")
+
+// invalidJSONPrompt asks the model to correct a reply that could not be parsed
+const invalidJSONPrompt = "Your previous reply was not valid JSON (%v). Reply again with only the JSON object, without any other text."
+
+// EstimateCommitTokens estimates the prompt tokens needed to rewrite a commit
+func EstimateCommitTokens(commit models.CommitOutput) int {
+	commit.Hints = ChangeHints(commit.Files)
+	commitJSON, _ := json.Marshal(commit)
+	systemPrompt, err := commitPrompt(commit)
+	if err != nil {
+		systemPrompt = defaultPrompt()
+	}
+	return EstimateTokenCount(systemPrompt) + EstimateTokenCount(string(commitJSON))
 }
 
-// GenerateNewCommitMessage generates a new commit message using Ollama
-func GenerateNewCommitMessage(commit models.CommitOutput, model string, temperature float64, contextSize int) (models.NewCommitMessage, error) {
+// GenerateNewCommitMessage generates a new commit message using the active LLM backend
+func GenerateNewCommitMessage(ctx context.Context, commit models.CommitOutput, model string, temperature float64, contextSize int) (models.NewCommitMessage, error) {
+	return generateNewCommitMessage(ctx, commit, model, temperature, contextSize, MapReduce)
+}
+
+// generateNewCommitMessage generates a commit's message, in parts when it doesn't fit
+// the context and splitOversized is set
+func generateNewCommitMessage(ctx context.Context, commit models.CommitOutput, model string, temperature float64, contextSize int, splitOversized bool) (models.NewCommitMessage, error) {
 	ui.UpdateStatus("Generating new commit message...")
-	systemPrompt := "Act as a senior engineer enforcing Conventional Commits. Input: Commit data with ID/message/diffs. Output: JSON with commit_id and messages array. Each message object will contain the field type, desciption and affected app. Rules:\n" +
-		"1. Types: feat, fix, chore, docs, refactor, perf\n" +
-		"2. Max 100 characters\n" +
-		"3. Explain what changed + why\n" +
-		"4. One message per logical change\n" +
-		"5. Group related files under one message\n" +
-		"6. Never use markdown/symbols\n" +
-		"7. Distill affect app name from the file path." +
-		"8: Example: {'type':'chore','description':'upgrade Docker image to v21.3.1','affected_app':'hortusfox'}"
-	
-	messages := []ollama.Message{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: "Generate a new commit message for the following commit:"},
+	commit.Hints = ChangeHints(commit.Files)
+	systemPrompt, err := commitPrompt(commit)
+	if err != nil {
+		ui.LogError("%v", err)
+		return models.NewCommitMessage{}, err
 	}
-	format := models.OllamaOutputFormat{
-		Type: "object",
-		Properties: map[string]interface{}{
-			"commit_id": map[string]interface{}{"type": "string"},
-			"messages": map[string]interface{}{
-				"type": "array",
-				"properties": map[string]interface{}{
-					"type":         map[string]interface{}{"type": "string"},
-					"description":  map[string]interface{}{"type": "string"},
-					"affected_app": map[string]interface{}{"type": "string"},
-				},
-			},
-		},
-		Required: []string{"commit_id", "messages"},
+
+	messages := []ChatMessage{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: commitUserPrompt},
 	}
+	format := commitMessageFormat()
 
 	// Estimate token count
 	systemTokens := EstimateTokenCount(systemPrompt)
-	userPromptTokens := EstimateTokenCount("Generate a new commit message for the following commit:")
+	userPromptTokens := EstimateTokenCount(commitUserPrompt)
 	
 	// Convert commit to JSON to estimate its token count
 	commitJSON, _ := json.Marshal(commit)
@@ -169,48 +222,105 @@ func GenerateNewCommitMessage(commit models.CommitOutput, model string, temperat
 	
 	// Check if we'll exceed the context window
 	if totalTokens + responseBuffer > contextSize {
+		if splitOversized {
+			return generateChunkedCommitMessage(ctx, commit, model, temperature, contextSize)
+		}
 		ui.LogError("Commit %s would exceed model context window (%d tokens needed, %d available)", 
 			commit.CommitID[:8], totalTokens + responseBuffer, contextSize)
-		return models.NewCommitMessage{}, fmt.Errorf("commit would exceed model context window (%d tokens needed, %d available)", 
-			totalTokens + responseBuffer, contextSize)
+		return models.NewCommitMessage{}, fmt.Errorf("%w (%d tokens needed, %d available)", 
+			ErrContextExceeded, totalTokens + responseBuffer, contextSize)
 	}
 	
-	formatRaw := json.RawMessage(formatJSON)
-	
 	// Add commit as user message
-	messages = append(messages, ollama.Message{Role: "user", Content: string(commitJSON)})
+	messages = append(messages, ChatMessage{Role: "user", Content: string(commitJSON)})
 
-	ui.LogInfo("Sending commit %s to Ollama for processing (est. %d tokens)", commit.CommitID[:8], totalTokens)
-	resp, err := SendOllamaMessage(model, messages, formatRaw, temperature)
+	ui.LogInfo("Sending commit %s to %s for processing (est. %d tokens)", commit.CommitID[:8], Client.Name(), totalTokens)
+	newCommit, err := requestCommitMessage(ctx, commit.CommitID, model, messages, json.RawMessage(formatJSON), temperature)
 	if err != nil {
-		ui.LogError("Failed to send Ollama message: %v", err)
-		return models.NewCommitMessage{}, fmt.Errorf("Failed to send Ollama message: %v", err)
+		return models.NewCommitMessage{}, err
 	}
+	ui.UpdateStatus("Ready")
+	return newCommit, nil
+}
 
+// commitMessageFormat returns the JSON schema of the model's reply for a commit
+func commitMessageFormat() models.OllamaOutputFormat {
+	format := models.OllamaOutputFormat{
+		Type: "object",
+		Properties: map[string]interface{}{
+			"commit_id": map[string]interface{}{"type": "string"},
+			"messages": map[string]interface{}{
+				"type": "array",
+				"properties": map[string]interface{}{
+					"type":         map[string]interface{}{"type": "string"},
+					"description":  map[string]interface{}{"type": "string"},
+					"affected_app": map[string]interface{}{"type": "string"},
+				},
+			},
+		},
+		Required: []string{"commit_id", "messages"},
+	}
+	if GenerateBody {
+		format.Properties["body"] = map[string]interface{}{"type": "string"}
+		format.Properties["footers"] = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+	}
+	return format
+}
+
+// requestCommitMessage sends a chat request for a commit's messages and parses the reply,
+// asking the model again when it isn't valid JSON
+func requestCommitMessage(ctx context.Context, commitID, model string, messages []ChatMessage, format json.RawMessage, temperature float64) (models.NewCommitMessage, error) {
 	var newCommit models.NewCommitMessage
-	err = json.Unmarshal([]byte(resp), &newCommit)
-	if err != nil {
+	if err := requestJSON(streamReply(ctx, commitID), commitID, model, messages, format, temperature, &newCommit); err != nil {
+		return models.NewCommitMessage{}, err
+	}
+	return newCommit, nil
+}
+
+// requestJSON sends a chat request and parses the reply into reply, asking the model
+// again when it isn't valid JSON
+func requestJSON(ctx context.Context, commitID, model string, messages []ChatMessage, format json.RawMessage, temperature float64, reply any) error {
+	for attempt := 0; ; attempt++ {
+		resp, err := chatWithRetry(ctx, commitID, model, messages, format, temperature)
+		if err != nil {
+			ui.LogError("Failed to send %s message: %v", Client.Name(), err)
+			return fmt.Errorf("Failed to send %s message: %v", Client.Name(), err)
+		}
+
+		err = json.Unmarshal([]byte(resp), reply)
+		if err == nil {
+			return nil
+		}
+
+		// Show the model its reply and the parse error, and ask again
+		if attempt < LLMRetries && ctx.Err() == nil {
+			ui.LogWarning("%s returned invalid JSON for commit %s: %v, asking again (retry %d of %d)",
+				Client.Name(), commitID[:8], err, attempt+1, LLMRetries)
+			messages = append(messages,
+				ChatMessage{Role: "assistant", Content: resp},
+				ChatMessage{Role: "user", Content: fmt.Sprintf(invalidJSONPrompt, err)},
+			)
+			continue
+		}
+
 		// Truncate the response if it's very large
 		truncatedResp := resp
 		if len(resp) > 1000 {
 			truncatedResp = resp[:997] + "..."
 		}
-		
+
 		// Log the raw response to provide more context for debugging
-		ui.LogError("Failed to unmarshal Ollama response: %v", err)
+		ui.LogError("Failed to unmarshal %s response: %v", Client.Name(), err)
 		ui.LogError("Raw response (truncated):")
 		for _, line := range strings.Split(truncatedResp, "\n") {
 			ui.LogError("  %s", line)
 		}
-		return models.NewCommitMessage{}, fmt.Errorf("Failed to unmarshal Ollama response: %v. Check logs for details", err)
+		return fmt.Errorf("Failed to unmarshal %s response: %v. Check logs for details", Client.Name(), err)
 	}
-
-	ui.UpdateStatus("Ready")
-	return newCommit, nil
 }
 
 // GenerateSimplifiedCommitMessage generates a one-line commit message for large commits
-func GenerateSimplifiedCommitMessage(commit models.CommitOutput, model string, temperature float64, contextSize int) (string, error) {
+func GenerateSimplifiedCommitMessage(ctx context.Context, commit models.CommitOutput, model string, temperature float64, contextSize int) (string, error) {
     ui.UpdateStatus("Generating simplified commit message...")
     
     systemPrompt := "Act as a senior engineer. You need to create a ONE-LINE commit message in Conventional Commits format for a large commit with many files. Follow these rules:\n" +
@@ -226,20 +336,22 @@ func GenerateSimplifiedCommitMessage(commit models.CommitOutput, model string, t
         Message:  commit.Message,
         // Include only a sample of files to avoid overwhelming the model
         Files:    commit.Files[:min(10, len(commit.Files))],
+        // Hints are taken from every file, not just the sample
+        Hints:    ChangeHints(commit.Files),
     }
     
     fileInfoMsg := fmt.Sprintf("Note: This commit contains %d files total. Only a sample is provided.", len(commit.Files))
     
-    messages := []ollama.Message{
+    messages := []ChatMessage{
         {Role: "system", Content: systemPrompt},
         {Role: "user", Content: "Generate a simple one-line commit message for this large commit:"},
         {Role: "user", Content: fileInfoMsg},
     }
     
     commitJSON, _ := json.Marshal(simplifiedCommit)
-    messages = append(messages, ollama.Message{Role: "user", Content: string(commitJSON)})
+    messages = append(messages, ChatMessage{Role: "user", Content: string(commitJSON)})
     
-    resp, err := SendOllamaMessage(model, messages, nil, temperature)
+    resp, err := chatWithRetry(streamReply(ctx, commit.CommitID), commit.CommitID, model, messages, nil, temperature)
     if err != nil {
         return "", err
     }
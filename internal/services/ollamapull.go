@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ollama "github.com/ollama/ollama/api"
+)
+
+// HasModel reports whether the Ollama server has a model, where a name without a tag
+// stands for its "latest" tag as in the ollama CLI
+func (c OllamaClient) HasModel(model string) (bool, error) {
+	list, err := c.api().List(context.Background())
+	if err != nil {
+		return false, fmt.Errorf("failed to list Ollama models: %v", err)
+	}
+	want := withDefaultTag(model)
+	for _, available := range list.Models {
+		if withDefaultTag(available.Name) == want || withDefaultTag(available.Model) == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// withDefaultTag adds the "latest" tag to a model name without one
+func withDefaultTag(model string) string {
+	if name := model[strings.LastIndex(model, "/")+1:]; !strings.Contains(name, ":") {
+		return model + ":latest"
+	}
+	return model
+}
+
+// PullModel downloads a model to the Ollama server. progress is called with the status
+// of every step and, while layers are downloaded, their completed and total bytes.
+// -ollama-timeout doesn't apply, since downloads easily take longer than a request.
+func (c OllamaClient) PullModel(ctx context.Context, model string, progress func(status string, completed, total int64)) error {
+	if c.HTTP != nil {
+		httpClient := *c.HTTP
+		httpClient.Timeout = 0
+		c.HTTP = &httpClient
+	}
+	err := c.api().Pull(ctx, &ollama.PullRequest{Model: model}, func(resp ollama.ProgressResponse) error {
+		progress(resp.Status, resp.Completed, resp.Total)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pull model %s: %v", model, err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// DefaultOpenAIBase is the API base used when -api-base is not set
+const DefaultOpenAIBase = "https://api.openai.com/v1"
+
+// defaultOpenAIContextSize is assumed when the server doesn't report a model's context window
+const defaultOpenAIContextSize = 8192
+
+// openAIRequestTimeout bounds requests other than chat completions
+const openAIRequestTimeout = 30 * time.Second
+
+// OpenAIClient talks to any OpenAI-compatible chat completions endpoint, e.g. OpenAI,
+// vLLM, LM Studio or the llama.cpp server
+type OpenAIClient struct {
+	APIBase string
+	APIKey  string
+	HTTP    *http.Client
+}
+
+// NewOpenAIClient creates a client for apiBase. An empty apiKey falls back to the
+// OPENAI_API_KEY environment variable, then to the key stored with the login command.
+func NewOpenAIClient(apiBase, apiKey string) *OpenAIClient {
+	apiBase = NormalizeAPIBase(apiBase)
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+	if apiKey == "" {
+		stored, err := LookupAPIKey(apiBase)
+		if err != nil {
+			ui.LogWarning("%v", err)
+		}
+		apiKey = stored
+	}
+	return &OpenAIClient{
+		APIBase: apiBase,
+		APIKey:  apiKey,
+		HTTP:    &http.Client{},
+	}
+}
+
+// NormalizeAPIBase returns the API base URL that keys are stored under
+func NormalizeAPIBase(apiBase string) string {
+	if apiBase == "" {
+		apiBase = DefaultOpenAIBase
+	}
+	return strings.TrimRight(apiBase, "/")
+}
+
+// Name returns the backend name used in log messages
+func (c *OpenAIClient) Name() string {
+	return "OpenAI-compatible API"
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIResponseFormat struct {
+	Type       string          `json:"type"`
+	JSONSchema *openAIJSONSpec `json:"json_schema,omitempty"`
+}
+
+type openAIJSONSpec struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+type openAIChatRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	Temperature    float64               `json:"temperature"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// Chat sends a chat completion request, aborting when ctx is cancelled
+func (c *OpenAIClient) Chat(ctx context.Context, model string, messages []ChatMessage, format json.RawMessage, temperature float64) (string, error) {
+	if model == "" {
+		return "", fmt.Errorf("model must be specified")
+	}
+
+	request := openAIChatRequest{Model: model, Temperature: temperature}
+	for _, message := range messages {
+		request.Messages = append(request.Messages, openAIMessage{Role: message.Role, Content: message.Content})
+	}
+	if format != nil {
+		request.ResponseFormat = &openAIResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &openAIJSONSpec{Name: "response", Schema: format},
+		}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal chat request: %v", err)
+	}
+	data, err := c.do(ctx, http.MethodPost, "/chat/completions", body)
+	if err != nil {
+		return "", err
+	}
+
+	var response openAIChatResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", fmt.Errorf("failed to parse chat response: %v", err)
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("chat response contained no choices")
+	}
+	reply := response.Choices[0].Message.Content
+	recordTokenUsage(messages, format, reply, response.Usage.PromptTokens, response.Usage.CompletionTokens)
+	return reply, nil
+}
+
+// CheckAvailability checks that the models endpoint can be reached with the API key
+func (c *OpenAIClient) CheckAvailability() error {
+	ctx, cancel := context.WithTimeout(context.Background(), openAIRequestTimeout)
+	defer cancel()
+	if _, err := c.do(ctx, http.MethodGet, "/models", nil); err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", c.APIBase, err)
+	}
+	return nil
+}
+
+// ContextSize reads a model's context window from the models endpoint. vLLM reports
+// max_model_len and some servers context_length; other servers, including OpenAI
+// itself, don't report it and a default is assumed.
+func (c *OpenAIClient) ContextSize(model string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), openAIRequestTimeout)
+	defer cancel()
+	data, err := c.do(ctx, http.MethodGet, "/models", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list models: %v", err)
+	}
+
+	var list struct {
+		Data []struct {
+			ID            string `json:"id"`
+			MaxModelLen   int    `json:"max_model_len"`
+			ContextLength int    `json:"context_length"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return 0, fmt.Errorf("failed to parse model list: %v", err)
+	}
+
+	for _, m := range list.Data {
+		if m.ID != model {
+			continue
+		}
+		if m.MaxModelLen > 0 {
+			ui.LogInfo("Found context size %d from max_model_len", m.MaxModelLen)
+			return m.MaxModelLen, nil
+		}
+		if m.ContextLength > 0 {
+			ui.LogInfo("Found context size %d from context_length", m.ContextLength)
+			return m.ContextLength, nil
+		}
+		ui.LogWarning("Server does not report the context size of %s, assuming %d tokens", model, defaultOpenAIContextSize)
+		return defaultOpenAIContextSize, nil
+	}
+	return 0, fmt.Errorf("model %s is not served by %s", model, c.APIBase)
+}
+
+// do sends a request to the API and returns the response body
+func (c *OpenAIClient) do(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.APIBase+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+)
+
+// GenerationResult is the outcome of generating a message for one commit
+type GenerationResult struct {
+	Message  models.NewCommitMessage
+	TimedOut bool
+	Err      error
+}
+
+// GenerationPool generates messages for a list of commits on a bounded number of
+// workers, ahead of the caller that applies them. Commits are started in list order
+// and at most twice as many results as there are workers are held before they are
+// collected, so a stopped run doesn't keep generating for the whole history.
+// With batches, each batch of commits is one job and counts as one result.
+type GenerationPool struct {
+	results map[string]chan GenerationResult
+	// lastOfBatch marks the commits whose collection frees the slot of their batch
+	lastOfBatch map[string]bool
+	slots       chan struct{}
+	stop        chan struct{}
+	once        sync.Once
+}
+
+// NewGenerationPool starts generating messages for commits with the given number of
+// workers. generate is called concurrently and must be safe for that.
+func NewGenerationPool(commits []models.CommitOutput, workers int, generate func(models.CommitOutput) GenerationResult) *GenerationPool {
+	batches := make([][]models.CommitOutput, len(commits))
+	for i, commit := range commits {
+		batches[i] = []models.CommitOutput{commit}
+	}
+	return NewBatchGenerationPool(batches, workers, func(batch []models.CommitOutput) []GenerationResult {
+		return []GenerationResult{generate(batch[0])}
+	})
+}
+
+// NewBatchGenerationPool starts generating messages for batches of commits with the
+// given number of workers. generate returns the results of a batch in the order of its
+// commits; it is called concurrently and must be safe for that.
+func NewBatchGenerationPool(batches [][]models.CommitOutput, workers int, generate func([]models.CommitOutput) []GenerationResult) *GenerationPool {
+	pool := &GenerationPool{
+		results:     make(map[string]chan GenerationResult),
+		lastOfBatch: make(map[string]bool, len(batches)),
+		slots:       make(chan struct{}, workers*2),
+		stop:        make(chan struct{}),
+	}
+	for _, batch := range batches {
+		for _, commit := range batch {
+			pool.results[commit.CommitID] = make(chan GenerationResult, 1)
+		}
+		pool.lastOfBatch[batch[len(batch)-1].CommitID] = true
+	}
+
+	jobs := make(chan []models.CommitOutput)
+	go func() {
+		defer close(jobs)
+		for _, batch := range batches {
+			select {
+			case pool.slots <- struct{}{}:
+			case <-pool.stop:
+				return
+			}
+			select {
+			case jobs <- batch:
+			case <-pool.stop:
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for batch := range jobs {
+				for i, result := range generate(batch) {
+					pool.results[batch[i].CommitID] <- result
+				}
+			}
+		}()
+	}
+	return pool
+}
+
+// Has reports whether the pool generates a message for a commit
+func (p *GenerationPool) Has(commitID string) bool {
+	_, ok := p.results[commitID]
+	return ok
+}
+
+// Wait blocks until the message for a commit is generated and returns it. Each
+// commit's result can only be collected once.
+func (p *GenerationPool) Wait(commitID string) GenerationResult {
+	result := <-p.results[commitID]
+	if p.lastOfBatch[commitID] {
+		<-p.slots
+	}
+	return result
+}
+
+// Stop stops starting new generations. Generations already running finish in the background.
+func (p *GenerationPool) Stop() {
+	p.once.Do(func() { close(p.stop) })
+}
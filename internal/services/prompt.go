@@ -0,0 +1,161 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+)
+
+// promptTemplate, when loaded with LoadPromptTemplate, renders the system prompt of
+// every commit instead of commitSystemPrompt. promptSource is part of the cache key,
+// so messages generated with another prompt are not reused.
+var (
+	promptTemplate *template.Template
+	promptSource   string
+)
+
+// promptData is what a prompt template can refer to, e.g. {{.Subject}} or
+// {{range .Files}}{{.Path}}{{end}}
+type promptData struct {
+	CommitID string
+	Message  string
+	Subject  string
+	Files    []models.File
+	Hints    []string
+	// DefaultPrompt is the built-in prompt, for templates that only add rules to it
+	DefaultPrompt string
+}
+
+// promptFuncs are the helper functions available in prompt templates
+var promptFuncs = template.FuncMap{
+	"join":      strings.Join,
+	"trim":      strings.TrimSpace,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+}
+
+// LoadPromptTemplate reads a Go text/template from path and uses it as the system
+// prompt for commit messages
+func LoadPromptTemplate(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read prompt file: %v", err)
+	}
+	tmpl, err := template.New(path).Funcs(promptFuncs).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse prompt file %s: %v", path, err)
+	}
+
+	// Render a sample commit so unknown fields are reported before the run starts
+	sample := promptData{CommitID: strings.Repeat("0", 40), Files: []models.File{{Path: "README.md"}}, DefaultPrompt: defaultPrompt()}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return fmt.Errorf("failed to render prompt file %s: %v", path, err)
+	}
+
+	promptTemplate = tmpl
+	promptSource = string(data)
+	return nil
+}
+
+// Message styles: Conventional Commits, Conventional Commits with gitmoji prefixes,
+// one plain sentence, or the prompt file and message template of the user
+const (
+	StyleConventional = "conventional"
+	StyleGitmoji      = "gitmoji"
+	StylePlain        = "plain"
+	StyleCustom       = "custom"
+)
+
+// Style is the message style the built-in prompt asks for
+var Style = StyleConventional
+
+// defaultPrompt returns the built-in prompt of the message style
+func defaultPrompt() string {
+	if Style == StylePlain {
+		return plainSystemPrompt
+	}
+	return commitSystemPrompt
+}
+
+// GenerateBody asks the model for a body paragraph and footers besides the messages
+var GenerateBody bool
+
+// bodyPromptRules are added to the system prompt with GenerateBody
+const bodyPromptRules = "\nAlso return body and footers. body is one short paragraph of plain sentences explaining why the change was made, " +
+	"left empty when the messages already say everything. footers lists lines like 'BREAKING CHANGE: <what no longer works>' " +
+	"for incompatible changes and 'Refs: #123' for issues the commit references, and is empty otherwise."
+
+// Language is the natural language messages are written in, a name or a code such as
+// "de"; empty leaves it to the prompt, which asks for English
+var Language string
+
+// languageNames spell out common language codes for the prompt
+var languageNames = map[string]string{
+	"ar": "Arabic", "cs": "Czech", "da": "Danish", "de": "German", "el": "Greek",
+	"en": "English", "es": "Spanish", "fi": "Finnish", "fr": "French", "he": "Hebrew",
+	"hi": "Hindi", "hu": "Hungarian", "id": "Indonesian", "it": "Italian", "ja": "Japanese",
+	"ko": "Korean", "nl": "Dutch", "no": "Norwegian", "pl": "Polish", "pt": "Portuguese",
+	"ro": "Romanian", "ru": "Russian", "sv": "Swedish", "th": "Thai", "tr": "Turkish",
+	"uk": "Ukrainian", "vi": "Vietnamese", "zh": "Chinese",
+}
+
+// languagePromptRule is added to the system prompt with a Language
+const languagePromptRule = "\nWrite every description, and any body, in %s. Keep the type values, the JSON field names " +
+	"and footer keys like BREAKING CHANGE in English."
+
+// LanguageName returns the name of a language code for the prompt, or the value itself
+// when it is not a known code
+func LanguageName(language string) string {
+	code := strings.ToLower(strings.SplitN(strings.ReplaceAll(language, "_", "-"), "-", 2)[0])
+	if name, ok := languageNames[code]; ok {
+		return name
+	}
+	return language
+}
+
+// commitPrompt returns the system prompt for rewriting commit
+func commitPrompt(commit models.CommitOutput) (string, error) {
+	prompt, err := basePrompt(commit)
+	if err != nil {
+		return prompt, err
+	}
+	return withPromptOptions(prompt), nil
+}
+
+// withPromptOptions adds the rules of -body and -language to a system prompt
+func withPromptOptions(prompt string) string {
+	if GenerateBody {
+		prompt += bodyPromptRules
+	}
+	if Language != "" {
+		prompt += fmt.Sprintf(languagePromptRule, LanguageName(Language))
+	}
+	return prompt
+}
+
+// basePrompt renders the prompt template for a commit, or returns the built-in prompt
+func basePrompt(commit models.CommitOutput) (string, error) {
+	if promptTemplate == nil {
+		return defaultPrompt(), nil
+	}
+	message := strings.TrimSpace(commit.Message)
+	data := promptData{
+		CommitID:      commit.CommitID,
+		Message:       message,
+		Subject:       strings.TrimSpace(strings.SplitN(message, "\n", 2)[0]),
+		Files:         commit.Files,
+		Hints:         commit.Hints,
+		DefaultPrompt: defaultPrompt(),
+	}
+	var b strings.Builder
+	if err := promptTemplate.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt for commit %s: %v", commit.CommitID[:8], err)
+	}
+	return b.String(), nil
+}
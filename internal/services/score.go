@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// scoreSystemPrompt asks the model to grade an existing commit message
+const scoreSystemPrompt = "Act as a senior engineer reviewing a repository's history. Grade the commit message " +
+	"you are given from 1 to 10 for how well it tells a reader what changed and why: 1 for messages " +
+	"like \"wip\" or \"fixed stuff\" that say nothing, 5 for a vague summary, 10 for a precise subject " +
+	"with a useful body. Length alone is not quality. Reply with only a JSON object such as {\"score\": 4}."
+
+// scoreFormat is the JSON schema of a grading reply
+var scoreFormat = json.RawMessage(`{"type":"object","properties":{"score":{"type":"integer"}},"required":["score"]}`)
+
+// ScoreCommitMessage asks the active LLM backend to grade a commit message from 1 to 10
+func ScoreCommitMessage(ctx context.Context, commitID, message, model string, temperature float64) (int, error) {
+	messages := []ChatMessage{
+		{Role: "system", Content: scoreSystemPrompt},
+		{Role: "user", Content: strings.TrimSpace(message)},
+	}
+	resp, err := chatWithRetry(ctx, commitID, model, messages, scoreFormat, temperature)
+	if err != nil {
+		return 0, fmt.Errorf("failed to grade message of %s: %v", commitID[:8], err)
+	}
+	var reply struct {
+		Score int `json:"score"`
+	}
+	if err := json.Unmarshal([]byte(resp), &reply); err != nil {
+		return 0, fmt.Errorf("failed to parse grade of %s: %v", commitID[:8], err)
+	}
+	return reply.Score, nil
+}
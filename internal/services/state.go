@@ -0,0 +1,218 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MrLemur/gitrewrite/internal/models"
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// Statuses of a commit in the state database
+const (
+	StatePending   = "pending"
+	StateRewritten = "rewritten"
+	StateSkipped   = "skipped"
+	StateFailed    = "failed"
+)
+
+// Buckets of the state database
+var (
+	stateMetaBucket    = []byte("meta")
+	stateCommitsBucket = []byte("commits")
+	stateRunKey        = []byte("run")
+	stateOutputKey     = []byte("output")
+)
+
+// State records the commits of a run with -state-db; nil disables it
+var State *StateStore
+
+// StateStore keeps the metadata, generation status and resulting message of every
+// commit of a run in an embedded bbolt database. Every change is written to disk in
+// its own transaction, so a run that crashes or is killed can resume with what it
+// had decided so far instead of holding it all in memory until the end.
+type StateStore struct {
+	db *bolt.DB
+}
+
+// OpenStateStore opens or creates the state database at path. Only one run can use
+// a database at a time.
+func OpenStateStore(path string) (*StateStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolterrors.ErrTimeout) {
+		return nil, fmt.Errorf("state database %s is in use by another run", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state database: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{stateMetaBucket, stateCommitsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize state database: %v", err)
+	}
+	return &StateStore{db: db}, nil
+}
+
+// Prepare ties the database to a run, identified by the source history and the
+// settings that shape its messages. The commits of an earlier run with another
+// identity are discarded, since its messages don't apply. It returns the number of
+// commits the earlier run had finished.
+func (s *StateStore) Prepare(run string) (int, error) {
+	finished := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(stateMetaBucket)
+		if string(meta.Get(stateRunKey)) != run {
+			if err := tx.DeleteBucket(stateCommitsBucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(stateCommitsBucket); err != nil {
+				return err
+			}
+			return meta.Put(stateRunKey, []byte(run))
+		}
+		return tx.Bucket(stateCommitsBucket).ForEach(func(_, value []byte) error {
+			var state models.CommitState
+			if err := json.Unmarshal(value, &state); err != nil {
+				return err
+			}
+			if state.Status == StateRewritten || state.Status == StateSkipped {
+				finished++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read state database: %v", err)
+	}
+	return finished, nil
+}
+
+// AddCommits records the scanned commits, oldest first. Commits already in the
+// database keep their status.
+func (s *StateStore) AddCommits(commits []models.CommitOutput) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(stateCommitsBucket)
+		for i, commit := range commits {
+			state := models.CommitState{Status: StatePending}
+			if value := bucket.Get([]byte(commit.CommitID)); value != nil {
+				if err := json.Unmarshal(value, &state); err != nil {
+					return err
+				}
+			}
+			state.CommitID = commit.CommitID
+			state.Index = i
+			state.Message = commit.Message
+			state.NeedsRewrite = commit.NeedsRewrite
+			state.SideBranch = commit.SideBranch
+			if commit.Files != nil {
+				state.FilesChanged = len(commit.Files)
+			}
+			if state.UpdatedAt.IsZero() {
+				state.UpdatedAt = time.Now()
+			}
+			value, err := json.Marshal(state)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(commit.CommitID), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record commits in state database: %v", err)
+	}
+	return nil
+}
+
+// Get returns the recorded state of a commit
+func (s *StateStore) Get(commitID string) (models.CommitState, bool) {
+	var state models.CommitState
+	if s == nil {
+		return state, false
+	}
+	found := false
+	s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(stateCommitsBucket).Get([]byte(commitID)); value != nil {
+			found = json.Unmarshal(value, &state) == nil
+		}
+		return nil
+	})
+	return state, found
+}
+
+// Update changes the recorded state of a commit
+func (s *StateStore) Update(commitID string, change func(*models.CommitState)) error {
+	if s == nil {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(stateCommitsBucket)
+		state := models.CommitState{CommitID: commitID, Status: StatePending}
+		if value := bucket.Get([]byte(commitID)); value != nil {
+			if err := json.Unmarshal(value, &state); err != nil {
+				return err
+			}
+		}
+		change(&state)
+		state.UpdatedAt = time.Now()
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(commitID), value)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update state of commit %s: %v", commitID, err)
+	}
+	return nil
+}
+
+// OutputRepo returns the new repository the run is building, empty when none is
+// recorded
+func (s *StateStore) OutputRepo() string {
+	var output string
+	s.db.View(func(tx *bolt.Tx) error {
+		output = string(tx.Bucket(stateMetaBucket).Get(stateOutputKey))
+		return nil
+	})
+	return output
+}
+
+// SetOutputRepo records the new repository the run is building, so a resumed run can
+// tell it apart from one it didn't create. An empty path clears it.
+func (s *StateStore) SetOutputRepo(path string) error {
+	if s == nil {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(stateMetaBucket)
+		if path == "" {
+			return meta.Delete(stateOutputKey)
+		}
+		return meta.Put(stateOutputKey, []byte(path))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record output repository in state database: %v", err)
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *StateStore) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
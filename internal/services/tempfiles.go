@@ -0,0 +1,199 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SessionManifest lists the temporary files and directories a gitrewrite process
+// has created and not yet removed. It only exists while there are any, so a manifest
+// left behind by a process that is no longer running belongs to a crashed run.
+type SessionManifest struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Paths   []string  `json:"paths"`
+}
+
+// tempPatterns are the name prefixes of the temporary files and directories gitrewrite
+// creates, used to find leftovers of versions that didn't write session manifests
+var tempPatterns = []string{"git-editor-", "new-commit-message-", "gitrewrite-selftest-"}
+
+// session tracks this process' temporary paths in its manifest
+var session struct {
+	sync.Mutex
+	started time.Time
+	paths   map[string]bool
+}
+
+// SessionsDir returns the directory holding the session manifests
+func SessionsDir() string {
+	return filepath.Join(os.TempDir(), "gitrewrite-sessions")
+}
+
+// manifestPath returns the manifest file of the process with the given pid
+func manifestPath(pid int) string {
+	return filepath.Join(SessionsDir(), strconv.Itoa(pid)+".json")
+}
+
+// NewTempFile creates a temporary file like os.CreateTemp and records it in the
+// session manifest until it is removed with RemoveTemp
+func NewTempFile(pattern string) (*os.File, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return nil, err
+	}
+	trackTemp(f.Name(), true)
+	return f, nil
+}
+
+// NewTempDir creates a temporary directory like os.MkdirTemp and records it in the
+// session manifest until it is removed with RemoveTemp
+func NewTempDir(pattern string) (string, error) {
+	dir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	trackTemp(dir, true)
+	return dir, nil
+}
+
+// RemoveTemp removes a temporary file or directory and drops it from the session manifest
+func RemoveTemp(path string) error {
+	err := os.RemoveAll(path)
+	if err == nil {
+		trackTemp(path, false)
+	}
+	return err
+}
+
+// trackTemp adds or removes a path and rewrites the manifest, which is deleted once
+// it would be empty. Manifest errors are ignored, tracking is best effort.
+func trackTemp(path string, add bool) {
+	session.Lock()
+	defer session.Unlock()
+	if session.paths == nil {
+		session.paths = make(map[string]bool)
+		session.started = time.Now()
+	}
+	if add {
+		session.paths[path] = true
+	} else {
+		delete(session.paths, path)
+	}
+
+	manifestFile := manifestPath(os.Getpid())
+	if len(session.paths) == 0 {
+		os.Remove(manifestFile)
+		return
+	}
+	manifest := SessionManifest{PID: os.Getpid(), Started: session.started}
+	for p := range session.paths {
+		manifest.Paths = append(manifest.Paths, p)
+	}
+	sort.Strings(manifest.Paths)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(SessionsDir(), 0700); err != nil {
+		return
+	}
+	os.WriteFile(manifestFile, data, 0600)
+}
+
+// CleanupResult is a leftover found by FindLeftovers
+type CleanupResult struct {
+	Path string
+	// Manifest is the session manifest listing the path, empty for paths found by name
+	Manifest string
+}
+
+// FindLeftovers returns the temporary paths of crashed runs: those listed in the
+// manifests of processes that are no longer running, and files and directories in the
+// temp directory named like gitrewrite's that are older than minAge
+func FindLeftovers(minAge time.Duration) ([]CleanupResult, []string, error) {
+	var leftovers []CleanupResult
+	var manifests []string
+	listed := make(map[string]bool)
+
+	entries, err := os.ReadDir(SessionsDir())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("failed to read session manifests: %v", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		manifestFile := filepath.Join(SessionsDir(), entry.Name())
+		data, err := os.ReadFile(manifestFile)
+		if err != nil {
+			continue
+		}
+		var manifest SessionManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			continue
+		}
+		if manifest.PID == os.Getpid() || processRunning(manifest.PID) {
+			for _, p := range manifest.Paths {
+				listed[p] = true
+			}
+			continue
+		}
+		manifests = append(manifests, manifestFile)
+		for _, p := range manifest.Paths {
+			listed[p] = true
+			if _, err := os.Lstat(p); err == nil {
+				leftovers = append(leftovers, CleanupResult{Path: p, Manifest: manifestFile})
+			}
+		}
+	}
+
+	tempEntries, err := os.ReadDir(os.TempDir())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read temp directory: %v", err)
+	}
+	for _, entry := range tempEntries {
+		p := filepath.Join(os.TempDir(), entry.Name())
+		if listed[p] || !hasTempPattern(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < minAge {
+			continue
+		}
+		leftovers = append(leftovers, CleanupResult{Path: p})
+	}
+	return leftovers, manifests, nil
+}
+
+// hasTempPattern reports whether name looks like one of gitrewrite's temporary paths
+func hasTempPattern(name string) bool {
+	for _, prefix := range tempPatterns {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// processRunning reports whether a process with the given pid is still running
+func processRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
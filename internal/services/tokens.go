@@ -0,0 +1,66 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// TokenCounter counts the tokens a text takes up in a model's context
+type TokenCounter interface {
+	CountTokens(text string) int
+}
+
+// Tokens is the counter used for context budgets and token usage estimates
+var Tokens TokenCounter = estimateCounter{}
+
+// Tokenizers that -tokenizer accepts
+const (
+	TokenizerEstimate = "estimate"
+	TokenizerCL100K   = "cl100k"
+	TokenizerO200K    = "o200k"
+)
+
+// NewTokenCounter returns the counter for a -tokenizer value. The BPE tokenizers are
+// embedded in the binary, so they work offline.
+func NewTokenCounter(name string) (TokenCounter, error) {
+	switch name {
+	case TokenizerEstimate:
+		return estimateCounter{}, nil
+	case TokenizerCL100K:
+		return newBPECounter("cl100k_base")
+	case TokenizerO200K:
+		return newBPECounter("o200k_base")
+	default:
+		return nil, fmt.Errorf("unknown tokenizer %q: must be 'cl100k', 'o200k' or 'estimate'", name)
+	}
+}
+
+// estimateCounter assumes about four characters per token, which is roughly right for
+// English prose but undercounts diffs full of symbols
+type estimateCounter struct{}
+
+func (estimateCounter) CountTokens(text string) int {
+	return len(text) / 4
+}
+
+// bpeCounter counts tokens with a tiktoken BPE encoding. Local models use their own
+// vocabularies, but ones of a similar size split text into similar numbers of tokens.
+type bpeCounter struct {
+	bpe *tiktoken.Tiktoken
+}
+
+// newBPECounter loads a tiktoken encoding from the embedded BPE files
+func newBPECounter(encoding string) (TokenCounter, error) {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	bpe, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the %s tokenizer: %v", encoding, err)
+	}
+	return bpeCounter{bpe: bpe}, nil
+}
+
+func (c bpeCounter) CountTokens(text string) int {
+	return len(c.bpe.EncodeOrdinary(text))
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/internal/ui"
+)
+
+// inProgressMarkers are the files git keeps in the repository directory while an
+// operation waits for the user to finish it
+var inProgressMarkers = []struct{ path, operation string }{
+	{"rebase-merge", "rebase"},
+	{"rebase-apply", "rebase or am"},
+	{"MERGE_HEAD", "merge"},
+	{"CHERRY_PICK_HEAD", "cherry-pick"},
+	{"REVERT_HEAD", "revert"},
+	{"BISECT_LOG", "bisect"},
+}
+
+// WorktreeState describes what of a repository's working tree makes rewriting its
+// history unsafe or surprising
+type WorktreeState struct {
+	// Operation is the git operation in progress, e.g. "rebase", or "" when there is none
+	Operation string
+	// DetachedHEAD is set when HEAD points at a commit rather than a branch
+	DetachedHEAD bool
+	// Changes lists the uncommitted changes to tracked files, as git status shows them
+	Changes []string
+}
+
+// InspectWorktree looks for an operation in progress, a detached HEAD and uncommitted
+// changes in the repository at repoPath. Untracked files don't count as changes,
+// since they are neither part of the history nor touched by the rewrite.
+func InspectWorktree(repoPath string) (WorktreeState, error) {
+	var state WorktreeState
+	for _, marker := range inProgressMarkers {
+		output, err := GetCommandOutput("git", []string{"rev-parse", "--git-path", marker.path}, repoPath)
+		if err != nil {
+			return state, fmt.Errorf("failed to locate the repository directory: %v", err)
+		}
+		path := strings.TrimSpace(output)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(repoPath, path)
+		}
+		if _, err := os.Stat(path); err == nil {
+			state.Operation = marker.operation
+			break
+		}
+	}
+
+	// symbolic-ref fails quietly with status 1 when HEAD is detached
+	ui.LogShellCommand("git", []string{"symbolic-ref", "-q", "HEAD"}, repoPath)
+	cmd := exec.Command("git", "symbolic-ref", "-q", "HEAD")
+	cmd.Dir = repoPath
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			return state, fmt.Errorf("failed to read HEAD: %v", err)
+		}
+		state.DetachedHEAD = true
+	}
+
+	// A bare repository has no working tree to have changes in
+	if IsBareRepository(repoPath) {
+		return state, nil
+	}
+	output, err := GetCommandOutput("git", []string{"status", "--porcelain", "--untracked-files=no"}, repoPath)
+	if err != nil {
+		return state, fmt.Errorf("failed to get working tree status: %v", err)
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) != "" {
+			state.Changes = append(state.Changes, line)
+		}
+	}
+	return state, nil
+}
@@ -0,0 +1,129 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// BrowserCommit is a commit listed in the pre-run commit browser
+type BrowserCommit struct {
+	ID      string
+	Subject string
+	Files   int
+}
+
+// ShowCommitBrowser lists the commits about to be rewritten and lets the user toggle
+// commits out of the rewrite set: Space toggles the selected commit, m marks it and
+// r toggles every commit between the mark and the selection. Enter finishes browsing.
+// It returns the IDs of the excluded commits and is only available in the TUI.
+func ShowCommitBrowser(commits []BrowserCommit) map[string]bool {
+	excluded := make(map[string]bool)
+	if len(commits) == 0 {
+		return excluded
+	}
+
+	done := false
+	mark := -1
+
+	table := tview.NewTable().
+		SetSelectable(true, false).
+		SetFixed(1, 0)
+	table.SetBorder(true)
+	table.SetTitleColor(widgetColor(theme.Heading))
+
+	for col, header := range []string{"", "Commit", "Files", "Message"} {
+		table.SetCell(0, col, tview.NewTableCell(header).
+			SetTextColor(widgetColor(theme.Heading)).
+			SetSelectable(false))
+	}
+
+	renderRow := func(i int) {
+		commit := commits[i]
+		state := "[x]"
+		if excluded[commit.ID] {
+			state = "[ ]"
+		}
+		if i == mark {
+			state += "*"
+		}
+		color := widgetColor(theme.Text)
+		if excluded[commit.ID] {
+			color = widgetColor(theme.Muted)
+		}
+		table.SetCell(i+1, 0, tview.NewTableCell(tview.Escape(state)).SetTextColor(color))
+		table.SetCell(i+1, 1, tview.NewTableCell(commit.ID[:8]).SetTextColor(color))
+		table.SetCell(i+1, 2, tview.NewTableCell(fmt.Sprintf("%d", commit.Files)).SetTextColor(color).SetAlign(tview.AlignRight))
+		table.SetCell(i+1, 3, tview.NewTableCell(tview.Escape(commit.Subject)).SetTextColor(color).SetExpansion(1))
+	}
+	for i := range commits {
+		renderRow(i)
+	}
+
+	updateTitle := func() {
+		table.SetTitle(fmt.Sprintf("Rewrite %d/%d commits | Space: toggle  m: mark  r: toggle from mark  Enter: continue",
+			len(commits)-len(excluded), len(commits)))
+	}
+	updateTitle()
+	table.Select(1, 0)
+
+	toggle := func(i int, exclude bool) {
+		if exclude {
+			excluded[commits[i].ID] = true
+		} else {
+			delete(excluded, commits[i].ID)
+		}
+		renderRow(i)
+	}
+
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		row, _ := table.GetSelection()
+		current := row - 1
+		if current < 0 || current >= len(commits) {
+			return event
+		}
+		switch {
+		case event.Key() == tcell.KeyEnter:
+			done = true
+			App.SetRoot(MainFlex, true)
+			return nil
+		case event.Rune() == ' ':
+			toggle(current, !excluded[commits[current].ID])
+		case event.Rune() == 'm':
+			previous := mark
+			mark = current
+			if previous >= 0 {
+				renderRow(previous)
+			}
+			renderRow(current)
+		case event.Rune() == 'r' && mark >= 0:
+			// Apply the opposite of the selected commit's state to the whole range
+			exclude := !excluded[commits[current].ID]
+			from, to := mark, current
+			if from > to {
+				from, to = to, from
+			}
+			for i := from; i <= to; i++ {
+				toggle(i, exclude)
+			}
+		default:
+			return event
+		}
+		updateTitle()
+		return nil
+	})
+
+	App.QueueUpdateDraw(func() {
+		App.SetRoot(table, true)
+		App.SetFocus(table)
+	})
+
+	// Wait until the user finishes browsing
+	for !done {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return excluded
+}
@@ -0,0 +1,108 @@
+package ui
+
+import (
+	"strings"
+
+	"github.com/MrLemur/gitrewrite/pkg/helpers"
+	"github.com/rivo/tview"
+)
+
+// messageComparison shows an original and a rewritten message side by side, with
+// their words marked by whether the rewrite kept them, took them from the changed
+// file paths or invented them, so made up scopes and app names stand out
+type messageComparison struct {
+	*tview.Flex
+	columns   *tview.Flex
+	original  *tview.TextView
+	rewritten *tview.TextView
+	right     tview.Primitive
+	paths     []string
+}
+
+// newMessageComparison creates a comparison of messages of a commit changing paths
+func newMessageComparison(paths []string) *messageComparison {
+	c := &messageComparison{paths: paths}
+	c.original = tview.NewTextView().
+		SetDynamicColors(true).
+		SetWrap(true)
+	c.original.SetBorder(true)
+	c.original.SetTitle("Original Message")
+	c.original.SetTitleColor(widgetColor(theme.Accent))
+
+	c.rewritten = tview.NewTextView().
+		SetDynamicColors(true).
+		SetWrap(true)
+	c.rewritten.SetBorder(true)
+	c.rewritten.SetTitle("Rewritten Message")
+	c.rewritten.SetTitleColor(widgetColor(theme.Success))
+
+	legend := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(markWord('=', "kept") + "  " + markWord('~', "from changed files") + "  " + markWord('!', "invented or dropped"))
+
+	c.right = c.rewritten
+	c.columns = tview.NewFlex().
+		AddItem(c.original, 0, 1, false).
+		AddItem(c.rewritten, 0, 1, true)
+	c.Flex = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(c.columns, 0, 1, true).
+		AddItem(legend, 1, 0, false)
+	return c
+}
+
+// SetMessages shows both messages with their words marked
+func (c *messageComparison) SetMessages(original, rewritten string) {
+	originalMarks, rewrittenMarks := helpers.CompareMessages(strings.TrimSpace(original), strings.TrimSpace(rewritten), c.paths)
+	c.original.SetText(renderMarks(originalMarks))
+	c.rewritten.SetText(renderMarks(rewrittenMarks))
+}
+
+// SetOriginal shows the original message and a note instead of a rewritten message
+// that doesn't exist (yet)
+func (c *messageComparison) SetOriginal(original, note string) {
+	c.original.SetText(tview.Escape(strings.TrimSpace(original)))
+	c.rewritten.SetText(tview.Escape(note))
+}
+
+// swapRewritten shows p, e.g. an editor, in place of the rewritten message, or the
+// rewritten message again when p is nil
+func (c *messageComparison) swapRewritten(p tview.Primitive) {
+	if p == nil {
+		p = c.rewritten
+	}
+	c.columns.RemoveItem(c.right)
+	c.columns.AddItem(p, 0, 1, true)
+	c.right = p
+}
+
+// renderMarks renders marked words with their theme colors
+func renderMarks(marks []helpers.WordMark) string {
+	var text strings.Builder
+	for _, mark := range marks {
+		text.WriteString(markWord(mark.Kind, tview.Escape(mark.Text)))
+	}
+	return text.String()
+}
+
+// markWord colors text by the kind of its mark. Without colors, invented and dropped
+// words are underlined and those from the changed files are in italics.
+func markWord(kind byte, text string) string {
+	if Monochrome {
+		switch kind {
+		case '!':
+			return "[::u]" + text + "[::-]"
+		case '~':
+			return "[::i]" + text + "[::-]"
+		}
+		return text
+	}
+	switch kind {
+	case '=':
+		return Colorize(theme.Success, text)
+	case '~':
+		return Colorize(theme.Accent, text)
+	case '!':
+		return Colorize(theme.Error, text)
+	}
+	return text
+}
@@ -0,0 +1,202 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Log levels besides slog's Info, Warn and Error
+const (
+	LevelProgress = slog.Level(1)
+	LevelSuccess  = slog.Level(2)
+	LevelDetails  = slog.Level(3)
+)
+
+// levelNames are the labels of the log levels in every output
+var levelNames = map[slog.Level]string{
+	slog.LevelInfo:  "INFO",
+	LevelProgress:   "PROGRESS",
+	LevelSuccess:    "SUCCESS",
+	LevelDetails:    "DETAILS",
+	slog.LevelWarn:  "WARNING",
+	slog.LevelError: "ERROR",
+}
+
+// levelColor returns the theme color of a level label in the TUI
+func levelColor(level slog.Level) string {
+	switch level {
+	case LevelSuccess:
+		return theme.Success
+	case slog.LevelWarn:
+		return theme.Warning
+	case slog.LevelError:
+		return theme.Error
+	}
+	return theme.Heading
+}
+
+// logger receives every Log* call and hands it to the active output and to the
+// log sinks: the debug log and the -log-file, when they are enabled
+var logger = slog.New(logHandler{})
+
+var (
+	logSinks   = []slog.Handler{debugLogHandler{}}
+	logSinksMu sync.Mutex
+)
+
+// SetupLogging applies -log-format and -log-file once the output is set up. With
+// console output, the json format replaces the log lines on stdout with JSON lines;
+// the TUI can only write JSON lines to a log file.
+func SetupLogging(format, path string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("invalid -log-format value %q: must be 'text' or 'json'", format)
+	}
+	if format == "json" {
+		console, ok := out.(*consoleOutput)
+		if !ok && path == "" {
+			return fmt.Errorf("-log-format=json needs -no-tui or a -log-file")
+		}
+		if ok {
+			console.structured = newJSONHandler(os.Stdout)
+			addLogSink(console.structured)
+		}
+	}
+	if path == "" {
+		return nil
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create log file directory: %v", err)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	if format == "json" {
+		addLogSink(newJSONHandler(file))
+	} else {
+		addLogSink(&lineHandler{w: file})
+	}
+	return nil
+}
+
+// addLogSink makes a handler receive every further log event
+func addLogSink(sink slog.Handler) {
+	logSinksMu.Lock()
+	logSinks = append(logSinks, sink)
+	logSinksMu.Unlock()
+}
+
+// newJSONHandler writes log events as JSON lines with the fields timestamp, level,
+// message and, while a commit is processed, commit_id
+func newJSONHandler(w io.Writer) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			switch a.Key {
+			case slog.TimeKey:
+				a.Key = "timestamp"
+			case slog.MessageKey:
+				a.Key = "message"
+			case slog.LevelKey:
+				a.Value = slog.StringValue(levelNames[a.Value.Any().(slog.Level)])
+			}
+			return a
+		},
+	})
+}
+
+// logHandler forwards log events to the active output and the log sinks
+type logHandler struct{}
+
+func (logHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (logHandler) Handle(ctx context.Context, record slog.Record) error {
+	out.Log(levelNames[record.Level], levelColor(record.Level), record.Message)
+
+	logSinksMu.Lock()
+	sinks := logSinks
+	logSinksMu.Unlock()
+	for _, sink := range sinks {
+		if err := sink.Handle(ctx, record.Clone()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h logHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h logHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// lineHandler writes log events as "[time] LEVEL: message" lines
+type lineHandler struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func (h *lineHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *lineHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintf(h.w, "[%s] %s: %s\n", record.Time.Format("2006-01-02 15:04:05.000"), levelNames[record.Level], record.Message)
+	return err
+}
+
+func (h *lineHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *lineHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// debugLogHandler writes log events to the -debug-log file while it is open
+type debugLogHandler struct{}
+
+func (debugLogHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (debugLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	debugLogMutex.Lock()
+	defer debugLogMutex.Unlock()
+	if !isDebugLogging {
+		return nil
+	}
+	_, err := fmt.Fprintf(debugLogger, "[%s] %s: %s\n", record.Time.Format("2006-01-02 15:04:05.000"), levelNames[record.Level], record.Message)
+	return err
+}
+
+func (h debugLogHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h debugLogHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// logLine logs a message at level, with the commit being processed, if any
+func logLine(level slog.Level, format string, args ...interface{}) {
+	var attrs []slog.Attr
+	if CurrentCommit != "" {
+		attrs = append(attrs, slog.String("commit_id", CurrentCommit))
+	}
+	logger.LogAttrs(context.Background(), level, fmt.Sprintf(format, args...), attrs...)
+}
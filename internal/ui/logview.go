@@ -0,0 +1,179 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// logEntry is a line of the log view
+type logEntry struct {
+	level string
+	// line is the rendered line with its color tags, text the plain line searched
+	line string
+	text string
+}
+
+// The log view keeps every line, so it can be filtered and searched. A search wraps
+// the matching lines in regions named after their index, and the current match is
+// highlighted.
+var (
+	logMu       sync.Mutex
+	logEntries  []logEntry
+	logProblems bool
+	logSearch   string
+	logMatches  []int
+	logMatch    int
+)
+
+// logPinned stops the log view from scrolling to new lines while a search shows a match
+var logPinned atomic.Bool
+
+// appendLogLine adds a line to the log view, unless the level filter hides it
+func appendLogLine(level, line, text string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	entry := logEntry{level: level, line: line, text: text}
+	logEntries = append(logEntries, entry)
+	if !logShown(entry) {
+		return
+	}
+	if logSearch != "" && logMatchesSearch(entry) {
+		logMatches = append(logMatches, len(logEntries)-1)
+		fmt.Fprintf(LogView, "[\"%d\"]%s[\"\"]\n", len(logEntries)-1, line)
+		updateLogTitle()
+		return
+	}
+	fmt.Fprintln(LogView, line)
+}
+
+// logShown reports whether the level filter shows a line
+func logShown(entry logEntry) bool {
+	return !logProblems || entry.level == "WARNING" || entry.level == "ERROR"
+}
+
+// logMatchesSearch reports whether a line contains the search text, ignoring case
+func logMatchesSearch(entry logEntry) bool {
+	return strings.Contains(strings.ToLower(entry.text), strings.ToLower(logSearch))
+}
+
+// renderLog writes the lines the filter shows to the log view again, marking the
+// search matches, and jumps to the current match. The caller holds logMu.
+func renderLog() {
+	var text strings.Builder
+	logMatches = logMatches[:0]
+	for i, entry := range logEntries {
+		if !logShown(entry) {
+			continue
+		}
+		if logSearch != "" && logMatchesSearch(entry) {
+			logMatches = append(logMatches, i)
+			fmt.Fprintf(&text, "[\"%d\"]%s[\"\"]\n", i, entry.line)
+			continue
+		}
+		text.WriteString(entry.line + "\n")
+	}
+	// A single write, since every write queues a redraw
+	LogView.SetText(text.String())
+	if logMatch >= len(logMatches) {
+		logMatch = len(logMatches) - 1
+	}
+	showLogMatch()
+}
+
+// showLogMatch highlights the current match and scrolls to it, or follows the end of
+// the log when there is no search. The caller holds logMu.
+func showLogMatch() {
+	logPinned.Store(logSearch != "")
+	if logSearch == "" || logMatch < 0 {
+		LogView.Highlight()
+		if logSearch == "" {
+			LogView.ScrollToEnd()
+		}
+	} else {
+		LogView.Highlight(fmt.Sprint(logMatches[logMatch])).ScrollToHighlight()
+	}
+	updateLogTitle()
+}
+
+// updateLogTitle shows the level filter and the search in the log view's title. The
+// caller holds logMu.
+func updateLogTitle() {
+	title := "Log"
+	if logProblems {
+		title += " (warnings and errors)"
+	}
+	if logSearch != "" {
+		if len(logMatches) == 0 {
+			title += fmt.Sprintf(" | /%s: no matches", logSearch)
+		} else {
+			title += fmt.Sprintf(" | /%s: %d of %d  n/N: next/previous  Esc: clear", logSearch, logMatch+1, len(logMatches))
+		}
+	}
+	LogView.SetTitle(tview.Escape(title))
+}
+
+// toggleLogProblems shows only warnings and errors in the log view, or every line again
+func toggleLogProblems() {
+	logMu.Lock()
+	defer logMu.Unlock()
+	logProblems = !logProblems
+	logMatch = len(logEntries)
+	renderLog()
+}
+
+// searchLog marks the lines containing text in the log view and jumps to the last
+// one, the most recent; an empty text clears the search
+func searchLog(text string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	logSearch = text
+	logMatch = len(logEntries)
+	renderLog()
+}
+
+// nextLogMatch jumps to the next match of the search, or the previous one when back is
+// set, wrapping around at either end
+func nextLogMatch(back bool) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if logSearch == "" || len(logMatches) == 0 {
+		return
+	}
+	if back {
+		logMatch = (logMatch - 1 + len(logMatches)) % len(logMatches)
+	} else {
+		logMatch = (logMatch + 1) % len(logMatches)
+	}
+	showLogMatch()
+}
+
+// logSearchActive reports whether the log view shows a search
+func logSearchActive() bool {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return logSearch != ""
+}
+
+// openLogSearch replaces the status bar with a search field: Enter searches the log
+// for its text and Esc closes it
+func openLogSearch() {
+	field := tview.NewInputField().
+		SetLabel("/").
+		SetFieldBackgroundColor(tcell.ColorDefault)
+	field.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			searchLog(field.GetText())
+		}
+		MainFlex.RemoveItem(field)
+		MainFlex.AddItem(StatusBar, 1, 1, false)
+		App.SetFocus(CommitQueue)
+	})
+	MainFlex.RemoveItem(StatusBar)
+	MainFlex.AddItem(field, 1, 1, true)
+	App.SetFocus(field)
+}
@@ -0,0 +1,277 @@
+package ui
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Output renders log lines, progress, status and commit details. The Log*, Update*
+// and ShowConfirmationDialog functions forward to the active output, so commands
+// don't need to know whether the TUI or plain console output is in use.
+type Output interface {
+	// Log writes a log line; color is the tview color used for the level label
+	Log(level, color, msg string)
+	// Progress shows the progress bar, complete is the completed fraction from 0 to 1
+	// or negative when there is nothing to show a bar for
+	Progress(complete float64, text string)
+	// Status shows the status bar text
+	Status(text string)
+	// CommitDetails shows the current commit; final is false while it is still processed
+	CommitDetails(text string, final bool)
+	// LastCommit moves the current commit details to the last commit panel
+	LastCommit()
+	// Confirm asks a yes/no question and waits for the answer
+	Confirm(message string) bool
+	// Stop shuts the output down before the program exits
+	Stop()
+	// Wait blocks until the user exits once a mode has finished
+	Wait()
+}
+
+// out is the active output, set by SetupTUI or SetupConsole
+var out Output
+
+// Headless reports whether plain console output is used instead of the TUI
+var Headless bool
+
+// SetupConsole initializes plain console output for terminals without TUI support,
+// e.g. CI jobs. Informational lines go to stdout, warnings and errors to stderr.
+func SetupConsole() {
+	setupConsole(os.Stdout)
+}
+
+// SetupQuietConsole initializes console output that only prints warnings and errors
+func SetupQuietConsole() {
+	setupConsole(io.Discard)
+}
+
+func setupConsole(stdout io.Writer) {
+	Headless = true
+	Monochrome = true
+	out = &consoleOutput{stdout: stdout, stderr: os.Stderr, stdin: bufio.NewReader(os.Stdin)}
+}
+
+// exitHooks run before the program exits, guarded by exitHooksMu
+var (
+	exitHooks   []func()
+	exitHooksMu sync.Mutex
+)
+
+// AtExit registers a function to run before the program exits, when the output is
+// stopped or the user exits with Ctrl+C, e.g. to remove lock files
+func AtExit(hook func()) {
+	exitHooksMu.Lock()
+	defer exitHooksMu.Unlock()
+	exitHooks = append(exitHooks, hook)
+}
+
+// runExitHooks runs the registered exit hooks, each only once
+func runExitHooks() {
+	exitHooksMu.Lock()
+	hooks := exitHooks
+	exitHooks = nil
+	exitHooksMu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// Stop shuts down the active output
+func Stop() {
+	runExitHooks()
+	out.Stop()
+}
+
+// WaitForExit waits for the user to exit after a mode has finished. In the TUI this
+// keeps the final screen visible until Ctrl+C; console output exits right away. The
+// exit status is 1 if an error was logged.
+func WaitForExit() {
+	out.Wait()
+}
+
+// tuiOutput renders into the tview widgets created by SetupTUI
+type tuiOutput struct{}
+
+func (tuiOutput) Log(level, color, msg string) {
+	timestamp := time.Now().Format("15:04:05")
+	// Escaped, since brackets in the message would be read as color or region tags
+	line := fmt.Sprintf("%s %s: %s", Colorize(theme.Accent, timestamp), Colorize(color, level), tview.Escape(msg))
+	appendLogLine(level, line, fmt.Sprintf("%s %s: %s", timestamp, level, msg))
+}
+
+func (tuiOutput) Progress(complete float64, text string) {
+	if complete < 0 {
+		ProgressBar.SetText(text)
+		App.Draw()
+		return
+	}
+	barWidth := 50
+	completedWidth := int(float64(barWidth) * complete)
+	bar := ""
+	for i := 0; i < barWidth; i++ {
+		if Monochrome {
+			if i < completedWidth {
+				bar += "#"
+			} else {
+				bar += "-"
+			}
+		} else if i < completedWidth {
+			bar += Colorize(theme.Success, "█")
+		} else {
+			bar += Colorize(theme.Muted, "░")
+		}
+	}
+	ProgressBar.SetText(fmt.Sprintf("%s %s", bar, text))
+	App.Draw()
+}
+
+func (tuiOutput) Status(text string) {
+	StatusBar.SetText(Colorize(theme.Heading, text))
+	App.Draw()
+}
+
+func (tuiOutput) CommitDetails(text string, final bool) {
+	CommitDetails.Clear()
+	fmt.Fprint(CommitDetails, text)
+}
+
+func (tuiOutput) LastCommit() {
+	LastCommitDetails.Clear()
+	LastCommitDetails.SetText(CommitDetails.GetText(true))
+}
+
+func (tuiOutput) Confirm(message string) bool {
+	// Reset confirmation variables
+	ConfirmationResult = false
+	ConfirmationDone = false
+
+	// Create the modal dialog
+	modal := tview.NewModal().
+		SetText(message + "\n\n'No' is selected by default. Use Tab to select 'Yes' if you want to proceed.").
+		AddButtons([]string{"Yes", "No"}).
+		SetFocus(1). // Set focus on the second button ("No")
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			ConfirmationResult = (buttonLabel == "Yes")
+			ConfirmationDone = true
+			App.SetRoot(MainFlex, true)
+		}).
+		SetBackgroundColor(tcell.ColorDefault).
+		SetTextColor(widgetColor(theme.Error))
+
+	// Show the modal dialog
+	App.SetRoot(modal, true)
+	App.Draw()
+
+	// Wait for the user's response
+	for !ConfirmationDone {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return ConfirmationResult
+}
+
+func (tuiOutput) Stop() {
+	App.Stop()
+}
+
+func (tuiOutput) Wait() {
+	select {}
+}
+
+// consoleOutput writes plain lines without color tags. Status bar updates are not
+// printed, since the log lines already report every step.
+type consoleOutput struct {
+	stdout       io.Writer
+	stderr       io.Writer
+	stdin        *bufio.Reader
+	lastProgress string
+	// structured writes JSON lines instead of log, progress and commit detail lines
+	// with -log-format=json
+	structured slog.Handler
+}
+
+func (c *consoleOutput) Log(level, color, msg string) {
+	if c.structured != nil {
+		// The log event reaches stdout as a JSON line through the log sinks
+		return
+	}
+	w := c.stdout
+	if level == "ERROR" || level == "WARNING" {
+		w = c.stderr
+	}
+	fmt.Fprintf(w, "%s %s: %s\n", time.Now().Format("15:04:05"), level, msg)
+}
+
+func (c *consoleOutput) Progress(complete float64, text string) {
+	// Only print when the progress actually moves, not on every redraw
+	if text == c.lastProgress {
+		return
+	}
+	c.lastProgress = text
+	if c.structured != nil {
+		c.logStructured(LevelProgress, text, slog.Int("processed", ProcessedCommits), slog.Int("total", TotalCommits))
+		return
+	}
+	fmt.Fprintf(c.stdout, "%s PROGRESS: %s\n", time.Now().Format("15:04:05"), text)
+}
+
+func (c *consoleOutput) Status(text string) {}
+
+func (c *consoleOutput) CommitDetails(text string, final bool) {
+	if !final {
+		return
+	}
+	if c.structured != nil {
+		c.logStructured(LevelDetails, strings.TrimRight(text, "\n"))
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if line == "" {
+			fmt.Fprintln(c.stdout)
+			continue
+		}
+		fmt.Fprintf(c.stdout, "    %s\n", line)
+	}
+}
+
+// logStructured writes a JSON line, with the commit being processed, if any
+func (c *consoleOutput) logStructured(level slog.Level, msg string, attrs ...slog.Attr) {
+	record := slog.NewRecord(time.Now(), level, msg, 0)
+	if CurrentCommit != "" {
+		record.AddAttrs(slog.String("commit_id", CurrentCommit))
+	}
+	record.AddAttrs(attrs...)
+	c.structured.Handle(context.Background(), record)
+}
+
+func (c *consoleOutput) LastCommit() {}
+
+func (c *consoleOutput) Confirm(message string) bool {
+	fmt.Fprintf(c.stderr, "%s\n\nProceed? [y/N]: ", message)
+	answer, err := c.stdin.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(c.stderr)
+		fmt.Fprintln(c.stderr, "No answer available on stdin; pass -yes to confirm non-interactively")
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func (c *consoleOutput) Stop() {}
+
+func (c *consoleOutput) Wait() {
+	runExitHooks()
+	CloseDebugLog()
+	os.Exit(ExitCode())
+}
@@ -0,0 +1,39 @@
+package ui
+
+import "sync"
+
+// Pausing holds the processing between commits without aborting the run, e.g. to
+// free a GPU shared with other workloads for a while. resumed is closed on resume.
+var (
+	pauseMu sync.Mutex
+	resumed chan struct{}
+)
+
+// TogglePause pauses processing after the current commit, or resumes it when it is
+// paused
+func TogglePause() {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	if resumed == nil {
+		resumed = make(chan struct{})
+		LogInfo("Pausing after the current commit, press p again to resume")
+		UpdateStatus("Pausing after the current commit...")
+		return
+	}
+	close(resumed)
+	resumed = nil
+	LogInfo("Resuming processing")
+	UpdateStatus("Resuming...")
+}
+
+// WaitWhilePaused blocks while processing is paused and returns right away otherwise
+func WaitWhilePaused() {
+	pauseMu.Lock()
+	wait := resumed
+	pauseMu.Unlock()
+	if wait == nil {
+		return
+	}
+	UpdateStatus("Paused - press p to resume")
+	<-wait
+}
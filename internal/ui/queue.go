@@ -0,0 +1,230 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/MrLemur/gitrewrite/pkg/events"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Statuses of a commit in the commit queue panel
+const (
+	queuePending    = "pending"
+	queueGenerating = "generating"
+	queueGenerated  = "generated"
+	queueApplied    = "applied"
+	queueSkipped    = "skipped"
+	queueFailed     = "failed"
+)
+
+// queueStatusColor returns the theme color of a status in the commit queue panel
+func queueStatusColor(status string) string {
+	switch status {
+	case queueGenerating:
+		return theme.Warning
+	case queueGenerated:
+		return theme.Accent
+	case queueApplied:
+		return theme.Success
+	case queueSkipped:
+		return theme.Text
+	case queueFailed:
+		return theme.Error
+	}
+	return theme.Muted
+}
+
+// queuedCommit is a commit listed in the commit queue panel
+type queuedCommit struct {
+	id       string
+	original string
+	proposed string
+	status   string
+}
+
+// commitQueue follows the commits of a run through its events and serves them as the
+// rows of the CommitQueue table, which reads them while drawing. Row 0 is the header.
+type commitQueue struct {
+	tview.TableContentReadOnly
+	events.NopHandler
+	mu      sync.Mutex
+	commits []*queuedCommit
+	byID    map[string]*queuedCommit
+}
+
+// queue holds the commits shown in the commit queue panel
+var queue = &commitQueue{byID: make(map[string]*queuedCommit)}
+
+func (q *commitQueue) GetCell(row, column int) *tview.TableCell {
+	if row == 0 {
+		header := []string{"Status", "Commit", "Message"}[column]
+		return tview.NewTableCell(header).SetTextColor(widgetColor(theme.Heading)).SetSelectable(false)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if row > len(q.commits) {
+		return nil
+	}
+	commit := q.commits[row-1]
+	switch column {
+	case 0:
+		return tview.NewTableCell(commit.status).SetTextColor(widgetColor(queueStatusColor(commit.status)))
+	case 1:
+		return tview.NewTableCell(commit.id[:8])
+	default:
+		subject := strings.SplitN(strings.TrimSpace(commit.original), "\n", 2)[0]
+		return tview.NewTableCell(tview.Escape(subject)).SetExpansion(1)
+	}
+}
+
+func (q *commitQueue) GetRowCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.commits) + 1
+}
+
+func (q *commitQueue) GetColumnCount() int {
+	return 3
+}
+
+// get returns a copy of the commit shown in a row
+func (q *commitQueue) get(row int) (queuedCommit, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if row < 1 || row > len(q.commits) {
+		return queuedCommit{}, false
+	}
+	return *q.commits[row-1], true
+}
+
+// update changes a listed commit and redraws the panel. Commits the run didn't scan,
+// e.g. those retried with -retry-failed, are not listed.
+func (q *commitQueue) update(id string, change func(*queuedCommit)) {
+	q.mu.Lock()
+	commit, ok := q.byID[id]
+	if ok {
+		change(commit)
+	}
+	q.mu.Unlock()
+	// Drawing reads the rows, so it must not wait while they are locked
+	if ok && App != nil {
+		App.Draw()
+	}
+}
+
+func (q *commitQueue) OnCommitScanned(e events.CommitScanned) {
+	status := queuePending
+	if !e.NeedsRewrite {
+		status = queueSkipped
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if commit, ok := q.byID[e.CommitID]; ok {
+		commit.original, commit.proposed, commit.status = e.Message, "", status
+		return
+	}
+	commit := &queuedCommit{id: e.CommitID, original: e.Message, status: status}
+	q.commits = append(q.commits, commit)
+	q.byID[e.CommitID] = commit
+}
+
+func (q *commitQueue) OnCommitStarted(e events.CommitStarted) {
+	q.update(e.CommitID, func(commit *queuedCommit) {
+		commit.status = queueGenerating
+	})
+}
+
+func (q *commitQueue) OnMessageGenerated(e events.MessageGenerated) {
+	q.update(e.CommitID, func(commit *queuedCommit) {
+		commit.proposed, commit.status = e.Message, queueGenerated
+	})
+}
+
+func (q *commitQueue) OnCommitApplied(e events.CommitApplied) {
+	q.update(e.CommitID, func(commit *queuedCommit) {
+		if e.Rewritten {
+			commit.proposed, commit.status = e.Message, queueApplied
+		} else if commit.status != queueFailed {
+			commit.status = queueSkipped
+		}
+	})
+}
+
+func (q *commitQueue) OnError(e events.Error) {
+	q.update(e.CommitID, func(commit *queuedCommit) {
+		commit.status = queueFailed
+	})
+}
+
+// MarkCommitSkipped shows a commit as keeping its original message in the commit queue
+// panel, also in dry runs where it is never applied. Failed commits stay failed.
+func MarkCommitSkipped(id string) {
+	queue.update(id, func(commit *queuedCommit) {
+		if commit.status != queueFailed {
+			commit.proposed, commit.status = "", queueSkipped
+		}
+	})
+}
+
+// newCommitQueueTable creates the commit queue panel: Up and Down select a commit,
+// Enter shows its original and rewritten messages
+func newCommitQueueTable() *tview.Table {
+	table := tview.NewTable().
+		SetContent(queue).
+		SetSelectable(true, false).
+		SetFixed(1, 0)
+	table.SetBorder(true)
+	table.SetTitle("Commits | Enter: show messages")
+	table.SetTitleColor(widgetColor(theme.Heading))
+	table.SetSelectedFunc(func(row, _ int) {
+		if commit, ok := queue.get(row); ok {
+			showQueuedCommit(commit)
+		}
+	})
+	events.Register(queue)
+	return table
+}
+
+// showQueuedCommit shows the messages of a commit selected in the commit queue panel
+// until Esc, Enter or q returns to the main view
+func showQueuedCommit(commit queuedCommit) {
+	comparison := newMessageComparison(nil)
+	if commit.proposed != "" {
+		comparison.SetMessages(commit.original, commit.proposed)
+	} else {
+		switch commit.status {
+		case queuePending:
+			comparison.SetOriginal(commit.original, "Not generated yet")
+		case queueGenerating:
+			comparison.SetOriginal(commit.original, "Being generated...")
+		case queueFailed:
+			comparison.SetOriginal(commit.original, "Generating the message failed, the original message is kept")
+		default:
+			comparison.SetOriginal(commit.original, "The original message is kept")
+		}
+	}
+
+	helpView := tview.NewTextView().SetText("Esc: back")
+	helpView.SetTextColor(widgetColor(theme.Heading))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow)
+	flex.SetBorder(true)
+	flex.SetTitle(fmt.Sprintf("Commit %s (%s)", commit.id[:8], commit.status))
+	flex.SetTitleColor(widgetColor(theme.Heading))
+	flex.AddItem(comparison, 0, 1, true).
+		AddItem(helpView, 1, 0, false)
+
+	comparison.rewritten.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyEnter || event.Rune() == 'q' {
+			App.SetRoot(MainFlex, true)
+			return nil
+		}
+		return event
+	})
+
+	App.SetRoot(flex, true)
+	App.SetFocus(comparison.rewritten)
+}
@@ -0,0 +1,119 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ReviewAction is the decision taken on a rewritten message in ReviewMessage
+type ReviewAction int
+
+const (
+	// ReviewAccept applies the (possibly edited) rewritten message
+	ReviewAccept ReviewAction = iota
+	// ReviewSkip keeps the commit's original message
+	ReviewSkip
+	// ReviewAbort stops the run
+	ReviewAbort
+)
+
+// ReviewMessage shows a rewritten message next to the original one and the changed
+// files before it is used: a accepts it, e edits it first, s keeps the original message
+// and q aborts the run. While editing, Ctrl+S accepts the edited message and Esc
+// returns to the review. It returns the decision and the message to use, and is only
+// available in the TUI.
+func ReviewMessage(commitID string, files []string, original, proposed string) (ReviewAction, string) {
+	done := false
+	action := ReviewAccept
+	message := proposed
+
+	help := "a: accept  e: edit  s: skip (keep original)  q: abort"
+	editHelp := "Ctrl+S: accept edited message  Esc: back"
+
+	comparison := newMessageComparison(files)
+	comparison.SetMessages(original, proposed)
+	proposedView := comparison.rewritten
+
+	filesView := tview.NewTextView().
+		SetWrap(false).
+		SetText(strings.Join(files, "\n"))
+	filesView.SetBorder(true)
+	filesView.SetTitle("Changed Files")
+	filesView.SetTitleColor(widgetColor(theme.Accent))
+
+	editor := tview.NewTextArea()
+	editor.SetBorder(true)
+	editor.SetTitle("Edit Rewritten Message")
+	editor.SetTitleColor(widgetColor(theme.Heading))
+
+	helpView := tview.NewTextView().SetText(help)
+	helpView.SetTextColor(widgetColor(theme.Heading))
+
+	flex := tview.NewFlex().SetDirection(tview.FlexRow)
+	flex.SetBorder(true)
+	flex.SetTitle(fmt.Sprintf("Review commit %s (%d files)", commitID[:8], len(files)))
+	flex.SetTitleColor(widgetColor(theme.Heading))
+	flex.AddItem(comparison, 0, 2, true).
+		AddItem(filesView, 0, 1, false).
+		AddItem(helpView, 1, 0, false)
+
+	finish := func(result ReviewAction) {
+		action = result
+		done = true
+		App.SetRoot(MainFlex, true)
+	}
+
+	showReview := func() {
+		comparison.swapRewritten(nil)
+		helpView.SetText(help)
+		App.SetFocus(proposedView)
+	}
+
+	editor.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyCtrlS:
+			message = editor.GetText()
+			finish(ReviewAccept)
+			return nil
+		case tcell.KeyEscape:
+			showReview()
+			return nil
+		}
+		return event
+	})
+
+	proposedView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Rune() {
+		case 'a':
+			finish(ReviewAccept)
+		case 's':
+			finish(ReviewSkip)
+		case 'q':
+			finish(ReviewAbort)
+		case 'e':
+			editor.SetText(message, true)
+			comparison.swapRewritten(editor)
+			helpView.SetText(editHelp)
+			App.SetFocus(editor)
+		default:
+			return event
+		}
+		return nil
+	})
+
+	App.QueueUpdateDraw(func() {
+		App.SetRoot(flex, true)
+		App.SetFocus(proposedView)
+	})
+
+	// Wait until the user decides
+	for !done {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return action, strings.TrimSpace(message)
+}
@@ -0,0 +1,50 @@
+package ui
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// streamInterval is how often a reply streaming in redraws the commit details at most
+const streamInterval = 100 * time.Millisecond
+
+// A reply streaming in replaces the New Message field of the commit being processed.
+// streamDetails holds the rest of its details, streamCommit is empty when no reply
+// is expected.
+var (
+	streamMu      sync.Mutex
+	streamCommit  string
+	streamDetails string
+	streamShown   time.Time
+)
+
+// startStream records the details shown above the New Message field of commit id,
+// while it is processed, or stops showing partial replies once it is done
+func startStream(id, details string, processing bool) {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	if !processing {
+		streamCommit = ""
+		return
+	}
+	streamCommit, streamDetails, streamShown = id, details, time.Time{}
+}
+
+// UpdatePartialMessage shows the reply received so far for a commit in the New Message
+// field while the backend generates it. Replies for commits other than the current
+// one, e.g. generated ahead by the -concurrency workers, are not shown.
+func UpdatePartialMessage(id, partial string) {
+	if Headless {
+		return
+	}
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	if id != streamCommit || time.Since(streamShown) < streamInterval {
+		return
+	}
+	streamShown = time.Now()
+	out.CommitDetails(streamDetails+tview.Escape(strings.TrimSpace(partial))+"\n", false)
+}
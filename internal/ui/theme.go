@@ -0,0 +1,128 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Theme maps the elements of the TUI to colors. A color is a name such as "yellow" or
+// "darkblue", or a hex value such as "#d75f00"; an empty color keeps the terminal's
+// default.
+type Theme struct {
+	// Background is the background of every panel
+	Background string `json:"background"`
+	// Text is the regular text, the borders and the skipped commits
+	Text string `json:"text"`
+	// Muted is the pending and excluded commits and the empty part of the progress bar
+	Muted string `json:"muted"`
+	// Heading is the header, labels, table headers, help lines, status bar and INFO lines
+	Heading string `json:"heading"`
+	// Accent is the timestamps, the Current Commit and Original Message titles and the
+	// generated commits
+	Accent string `json:"accent"`
+	// Highlight is the Last Processed Commit title
+	Highlight string `json:"highlight"`
+	// Success is SUCCESS lines, new messages, the Log title, applied commits and the
+	// progress bar
+	Success string `json:"success"`
+	// Warning is WARNING lines and the commits being generated
+	Warning string `json:"warning"`
+	// Error is ERROR lines, the file and diff size labels, confirmation dialogs and
+	// failed commits
+	Error string `json:"error"`
+}
+
+// themes are the built-in -theme values. mono uses the terminal's default colors
+// throughout, as on terminals without color support.
+var themes = map[string]Theme{
+	"dark": {
+		Background: "black",
+		Text:       "white",
+		Muted:      "gray",
+		Heading:    "yellow",
+		Accent:     "blue",
+		Highlight:  "purple",
+		Success:    "green",
+		Warning:    "yellow",
+		Error:      "red",
+	},
+	"light": {
+		Text:      "black",
+		Muted:     "gray",
+		Heading:   "darkgoldenrod",
+		Accent:    "navy",
+		Highlight: "purple",
+		Success:   "darkgreen",
+		Warning:   "darkorange",
+		Error:     "darkred",
+	},
+	"mono": {},
+}
+
+// theme is the theme the TUI is drawn with
+var theme = themes["dark"]
+
+// LoadTheme selects a built-in theme by name. A theme file, when given, is a JSON
+// object overriding some of its colors, e.g. {"heading": "#af5f00"}.
+func LoadTheme(name, file string) error {
+	selected, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("invalid -theme value %q: must be 'dark', 'light' or 'mono'", name)
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read theme file: %v", err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&selected); err != nil {
+			return fmt.Errorf("failed to parse theme file %s: %v", file, err)
+		}
+	}
+	for element, color := range map[string]string{
+		"background": selected.Background, "text": selected.Text, "muted": selected.Muted,
+		"heading": selected.Heading, "accent": selected.Accent, "highlight": selected.Highlight,
+		"success": selected.Success, "warning": selected.Warning, "error": selected.Error,
+	} {
+		if color != "" && tcell.GetColor(color) == tcell.ColorDefault {
+			return fmt.Errorf("unknown color %q for %s in theme", color, element)
+		}
+	}
+	theme = selected
+	if name == "mono" {
+		Monochrome = true
+	}
+	return nil
+}
+
+// applyTheme sets the colors tview draws widgets with before SetupTUI creates them
+func applyTheme() {
+	if Monochrome {
+		// Render every widget in the terminal's default attributes
+		tview.Styles = tview.Theme{
+			PrimitiveBackgroundColor:    tcell.ColorDefault,
+			ContrastBackgroundColor:     tcell.ColorDefault,
+			MoreContrastBackgroundColor: tcell.ColorDefault,
+			BorderColor:                 tcell.ColorDefault,
+			TitleColor:                  tcell.ColorDefault,
+			GraphicsColor:               tcell.ColorDefault,
+			PrimaryTextColor:            tcell.ColorDefault,
+			SecondaryTextColor:          tcell.ColorDefault,
+			TertiaryTextColor:           tcell.ColorDefault,
+			InverseTextColor:            tcell.ColorDefault,
+			ContrastSecondaryTextColor:  tcell.ColorDefault,
+		}
+		return
+	}
+	tview.Styles.PrimitiveBackgroundColor = widgetColor(theme.Background)
+	tview.Styles.PrimaryTextColor = widgetColor(theme.Text)
+	tview.Styles.BorderColor = widgetColor(theme.Text)
+	tview.Styles.TitleColor = widgetColor(theme.Text)
+	tview.Styles.GraphicsColor = widgetColor(theme.Text)
+}
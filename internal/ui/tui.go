@@ -2,14 +2,18 @@ package ui
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/MrLemur/gitrewrite/pkg/events"
 	"github.com/gdamore/tcell/v2"
+	"github.com/gdamore/tcell/v2/terminfo"
 	"github.com/rivo/tview"
 )
 
@@ -22,6 +26,7 @@ var (
 	StatusBar          *tview.TextView
 	CommitDetails      *tview.TextView
 	LastCommitDetails  *tview.TextView
+	CommitQueue        *tview.Table
 	TotalCommits       int
 	ProcessedCommits   int
 	ConfirmationResult bool
@@ -31,21 +36,69 @@ var (
 	LastCommitStartTime time.Time
 	TotalProcessingTime time.Duration
 	CommitTimings       []time.Duration
+	// ColdStartTime is how long loading the model took, kept out of CommitTimings so
+	// the first commit doesn't skew the ETA. ColdStartMeasured is set once it is known.
+	ColdStartTime     time.Duration
+	ColdStartMeasured bool
+	// Phase is the label shown before the progress bar, e.g. "Generate" or "Apply"
+	Phase string
+	// CurrentCommit is the commit being processed, whose details are shown
+	CurrentCommit string
+	// Monochrome disables color tags on terminals without color support
+	Monochrome bool
 	// Debug logging variables
 	debugLogger    *os.File
 	debugLogMutex  sync.Mutex
 	isDebugLogging bool
 )
 
+// detectMonochrome reports whether the terminal cannot render colors, honoring
+// the NO_COLOR convention and the color count in the terminal's terminfo entry
+func detectMonochrome() bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return true
+	}
+	term := os.Getenv("TERM")
+	if term == "" || term == "dumb" {
+		return true
+	}
+	if ti, err := terminfo.LookupTerminfo(term); err == nil && ti.Colors < 8 {
+		return true
+	}
+	return false
+}
+
+// Colorize wraps text in a tview color tag, or returns it unchanged in monochrome mode
+// or when the theme leaves color to the terminal's default
+func Colorize(color, text string) string {
+	if Monochrome || color == "" {
+		return text
+	}
+	return "[" + color + "]" + text + "[-]"
+}
+
+// widgetColor returns the color for a widget attribute, or the terminal default in
+// monochrome mode and for empty colors
+func widgetColor(color string) tcell.Color {
+	if Monochrome || color == "" {
+		return tcell.ColorDefault
+	}
+	return tcell.GetColor(color)
+}
+
 // SetupTUI initializes the terminal UI components
 func SetupTUI() {
+	Monochrome = Monochrome || detectMonochrome()
+	applyTheme()
+
+	out = tuiOutput{}
 	App = tview.NewApplication()
 	MainFlex = tview.NewFlex().SetDirection(tview.FlexRow)
 
 	header := tview.NewTextView().
 		SetTextAlign(tview.AlignCenter).
 		SetText("GitRewrite").
-		SetTextColor(tcell.ColorYellow)
+		SetTextColor(widgetColor(theme.Heading))
 
 	ProgressBar = tview.NewTextView().
 		SetDynamicColors(true).
@@ -54,17 +107,21 @@ func SetupTUI() {
 	// Configure log view with auto-scrolling
 	LogView = tview.NewTextView().
 		SetDynamicColors(true).
+		SetRegions(true).
 		SetScrollable(true).
 		SetWordWrap(true).
 		SetChangedFunc(func() {
-			// Auto-scroll to the bottom when new content is added
+			// Auto-scroll to the bottom when new content is added, unless a search
+			// shows a match
 			App.QueueUpdateDraw(func() {
-				LogView.ScrollToEnd()
+				if !logPinned.Load() {
+					LogView.ScrollToEnd()
+				}
 			})
 		})
 	LogView.SetBorder(true)
 	LogView.SetTitle("Log")
-	LogView.SetTitleColor(tcell.ColorGreen)
+	LogView.SetTitleColor(widgetColor(theme.Success))
 
 	CommitDetails = tview.NewTextView().
 		SetDynamicColors(true).
@@ -75,7 +132,7 @@ func SetupTUI() {
 		})
 	CommitDetails.SetBorder(true)
 	CommitDetails.SetTitle("Current Commit")
-	CommitDetails.SetTitleColor(tcell.ColorBlue)
+	CommitDetails.SetTitleColor(widgetColor(theme.Accent))
 
 	LastCommitDetails = tview.NewTextView().
 		SetDynamicColors(true).
@@ -84,14 +141,17 @@ func SetupTUI() {
 		SetChangedFunc(func() {
 			App.Draw()
 		})
+	LastCommitDetails.SetText(Colorize(theme.Heading, "No commits processed yet"))
 	LastCommitDetails.SetBorder(true)
 	LastCommitDetails.SetTitle("Last Processed Commit")
-	LastCommitDetails.SetTitleColor(tcell.ColorPurple)
+	LastCommitDetails.SetTitleColor(widgetColor(theme.Highlight))
 
 	StatusBar = tview.NewTextView().
 		SetDynamicColors(true).
 		SetTextAlign(tview.AlignCenter).
-		SetText("[yellow]Press Ctrl+C to exit[white]")
+		SetText(Colorize(theme.Heading, "Press Ctrl+C to exit"))
+
+	CommitQueue = newCommitQueueTable()
 
 	// Create a flex container for commit details
 	commitDetailsFlex := tview.NewFlex().
@@ -103,16 +163,21 @@ func SetupTUI() {
 		AddItem(ProgressBar, 1, 1, false).
 		AddItem(tview.NewFlex().
 			SetDirection(tview.FlexRow).
-			AddItem(LogView, 0, 3, false).
+			AddItem(tview.NewFlex().
+				SetDirection(tview.FlexColumn).
+				AddItem(LogView, 0, 3, false).
+				AddItem(CommitQueue, 0, 1, true),
+				0, 3, true).
 			AddItem(commitDetailsFlex, 0, 2, false),
-			0, 10, false).
+			0, 10, true).
 		AddItem(StatusBar, 1, 1, false)
 
 	// Add keyboard controls for scrolling logs
 	App.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		if event.Key() == tcell.KeyCtrlC {
+			runExitHooks()
 			App.Stop()
-			os.Exit(0)
+			os.Exit(ExitCode())
 			return nil
 		}
 		if event.Key() == tcell.KeyPgUp {
@@ -131,6 +196,26 @@ func SetupTUI() {
 		} else if event.Key() == tcell.KeyHome {
 			LogView.ScrollTo(0, 0)
 			return nil
+		} else if App.GetFocus() == CommitQueue {
+			// Only in the main view, so dialogs and the message editor still get the keys
+			switch {
+			case event.Rune() == 'p':
+				// Toggling logs and redraws, which can't happen on the event loop itself
+				go TogglePause()
+			case event.Rune() == '/':
+				openLogSearch()
+			case event.Rune() == 'n':
+				nextLogMatch(false)
+			case event.Rune() == 'N':
+				nextLogMatch(true)
+			case event.Rune() == 'f':
+				toggleLogProblems()
+			case event.Key() == tcell.KeyEscape && logSearchActive():
+				searchLog("")
+			default:
+				return event
+			}
+			return nil
 		}
 		return event
 	})
@@ -191,186 +276,206 @@ func LogShellCommand(command string, args []string, workDir string) {
 	fmt.Fprintf(debugLogger, "[%s] SHELL CMD: [dir=%s] %s\n", fullTimestamp, workDir, cmdLine)
 }
 
-// ShowConfirmationDialog displays a confirmation dialog and waits for user input
+// ShowConfirmationDialog asks the user to confirm and waits for the answer
 func ShowConfirmationDialog(message string) bool {
-	// Reset confirmation variables
-	ConfirmationResult = false
-	ConfirmationDone = false
-
-	// Create the modal dialog
-	modal := tview.NewModal().
-		SetText(message).
-		AddButtons([]string{"Yes", "No"}).
-		SetFocus(1). // Set focus on the second button ("No")
-		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
-			ConfirmationResult = (buttonLabel == "Yes")
-			ConfirmationDone = true
-			App.SetRoot(MainFlex, true)
-		}).
-		SetBackgroundColor(tcell.ColorDefault).
-		SetTextColor(tcell.ColorRed)
-
-	// Show the modal dialog
-	App.SetRoot(modal, true)
-	App.Draw()
-
-	// Wait for the user's response
-	for !ConfirmationDone {
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	return ConfirmationResult
+	return out.Confirm(message)
 }
 
 // UpdateProgressBar updates the progress bar with the current status
 func UpdateProgressBar() {
 	if TotalCommits == 0 {
-		ProgressBar.SetText("[yellow]No commits to process[white]")
+		out.Progress(-1, Colorize(theme.Heading, "No commits to process"))
 		return
 	}
 	percentage := float64(ProcessedCommits) / float64(TotalCommits) * 100
-	barWidth := 50
-	completedWidth := int(float64(barWidth) * percentage / 100)
 
 	// Calculate ETA
 	var etaText string
-	if ProcessedCommits > 0 {
-		// Calculate average time per commit
-		var avgTimePerCommit time.Duration
-
-		// Only use timing data if we have any
-		if len(CommitTimings) > 0 {
-			// Use median of last few commits for more stable estimates
-			recentTimings := append([]time.Duration{}, CommitTimings...)
-			sort.Slice(recentTimings, func(i, j int) bool {
-				return recentTimings[i] < recentTimings[j]
-			})
-			medianIdx := len(recentTimings) / 2
-			avgTimePerCommit = recentTimings[medianIdx]
-		} else {
-			// Fall back to simple average if we don't have enough samples
-			if TotalProcessingTime > 0 && ProcessedCommits > 0 {
-				avgTimePerCommit = TotalProcessingTime / time.Duration(ProcessedCommits)
-			} else {
-				// Default to 5 seconds if we don't have data yet
-				avgTimePerCommit = 5 * time.Second
-			}
-		}
-
-		// Ensure we don't have a zero duration (minimum 500ms per commit)
-		if avgTimePerCommit < 500*time.Millisecond {
-			avgTimePerCommit = 500 * time.Millisecond
-		}
-
-		// Calculate remaining time
-		remainingCommits := TotalCommits - ProcessedCommits
-		remainingTime := avgTimePerCommit * time.Duration(remainingCommits)
-
+	remainingTime, ok := EstimatedTimeRemaining()
+	if ok {
 		// Format ETA nicely
 		etaText = fmt.Sprintf(" ETA: %s", formatDuration(remainingTime))
 	} else {
 		etaText = " ETA: calculating..."
 	}
 
-	progressText := fmt.Sprintf("[green]%d/%d commits processed (%.1f%%)[white]%s",
-		ProcessedCommits, TotalCommits, percentage, etaText)
-	bar := ""
-	for i := 0; i < barWidth; i++ {
-		if i < completedWidth {
-			bar += "[green]█[white]"
+	progressText := Colorize(theme.Success, fmt.Sprintf("%d/%d commits processed (%.1f%%)",
+		ProcessedCommits, TotalCommits, percentage)) + etaText
+	if Phase != "" {
+		label := "[" + Phase + "]"
+		if !Headless {
+			label = tview.Escape(label)
+		}
+		progressText = Colorize(theme.Heading, label) + " " + progressText
+	}
+	out.Progress(percentage/100, progressText)
+	events.Progressed(events.Progress{Processed: ProcessedCommits, Total: TotalCommits, ETA: remainingTime})
+}
+
+// RecordCommitTiming adds the processing time of a commit to the ETA statistics. Unless
+// a warm-up request already measured the cold start, the first commit's time includes
+// loading the model and is recorded as ColdStartTime instead.
+func RecordCommitTiming(d time.Duration) {
+	if !ColdStartMeasured {
+		ColdStartTime = d
+		ColdStartMeasured = true
+		return
+	}
+	TotalProcessingTime += d
+	CommitTimings = append(CommitTimings, d)
+}
+
+// EstimatedTimeRemaining estimates the time left for the remaining commits from the
+// timings of the commits processed so far. ok is false until a commit was processed.
+func EstimatedTimeRemaining() (remaining time.Duration, ok bool) {
+	if ProcessedCommits == 0 {
+		return 0, false
+	}
+	// Calculate average time per commit
+	var avgTimePerCommit time.Duration
+
+	// Only use timing data if we have any
+	if len(CommitTimings) > 0 {
+		// Use median of last few commits for more stable estimates
+		recentTimings := append([]time.Duration{}, CommitTimings...)
+		sort.Slice(recentTimings, func(i, j int) bool {
+			return recentTimings[i] < recentTimings[j]
+		})
+		medianIdx := len(recentTimings) / 2
+		avgTimePerCommit = recentTimings[medianIdx]
+	} else {
+		// Fall back to simple average if we don't have enough samples
+		if TotalProcessingTime > 0 && ProcessedCommits > 0 {
+			avgTimePerCommit = TotalProcessingTime / time.Duration(ProcessedCommits)
 		} else {
-			bar += "[gray]░[white]"
+			// Default to 5 seconds if we don't have data yet
+			avgTimePerCommit = 5 * time.Second
 		}
 	}
-	ProgressBar.SetText(fmt.Sprintf("%s %s", bar, progressText))
-	App.Draw()
+
+	// Ensure we don't have a zero duration (minimum 500ms per commit)
+	if avgTimePerCommit < 500*time.Millisecond {
+		avgTimePerCommit = 500 * time.Millisecond
+	}
+
+	// Calculate remaining time
+	remainingCommits := TotalCommits - ProcessedCommits
+	return avgTimePerCommit * time.Duration(remainingCommits), true
 }
 
 // LogInfo logs an informational message
 func LogInfo(format string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05")
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintf(LogView, "[blue]%s[white] [yellow]INFO[white]: %s\n", timestamp, msg)
-
-	if isDebugLogging {
-		debugLogMutex.Lock()
-		defer debugLogMutex.Unlock()
-		fullTimestamp := time.Now().Format("2006-01-02 15:04:05.000")
-		fmt.Fprintf(debugLogger, "[%s] INFO: %s\n", fullTimestamp, msg)
-	}
+	logLine(slog.LevelInfo, format, args...)
 }
 
+// errorsLogged is set once an error has been logged, for the exit status of the run
+var errorsLogged atomic.Bool
+
 // LogError logs an error message
 func LogError(format string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05")
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintf(LogView, "[blue]%s[white] [red]ERROR[white]: %s\n", timestamp, msg)
-
-	if isDebugLogging {
-		debugLogMutex.Lock()
-		defer debugLogMutex.Unlock()
-		fullTimestamp := time.Now().Format("2006-01-02 15:04:05.000")
-		fmt.Fprintf(debugLogger, "[%s] ERROR: %s\n", fullTimestamp, msg)
+	errorsLogged.Store(true)
+	logLine(slog.LevelError, format, args...)
+}
+
+// ExitCode returns the exit status of the run: 1 if an error was logged, else 0
+func ExitCode() int {
+	if errorsLogged.Load() {
+		return 1
 	}
+	return 0
 }
 
 // LogWarning logs a warning message
 func LogWarning(format string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05")
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintf(LogView, "[blue]%s[white] [yellow]WARNING[white]: %s\n", timestamp, msg)
-
-	if isDebugLogging {
-		debugLogMutex.Lock()
-		defer debugLogMutex.Unlock()
-		fullTimestamp := time.Now().Format("2006-01-02 15:04:05.000")
-		fmt.Fprintf(debugLogger, "[%s] WARNING: %s\n", fullTimestamp, msg)
-	}
+	logLine(slog.LevelWarn, format, args...)
 }
 
 // LogSuccess logs a success message
 func LogSuccess(format string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05")
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintf(LogView, "[blue]%s[white] [green]SUCCESS[white]: %s\n", timestamp, msg)
-
-	if isDebugLogging {
-		debugLogMutex.Lock()
-		defer debugLogMutex.Unlock()
-		fullTimestamp := time.Now().Format("2006-01-02 15:04:05.000")
-		fmt.Fprintf(debugLogger, "[%s] SUCCESS: %s\n", fullTimestamp, msg)
-	}
+	logLine(LevelSuccess, format, args...)
 }
 
-// UpdateCommitDetails updates the details of the current commit being processed
+// UpdateCommitDetails updates the details of the current commit being processed.
+// A new message of "Processing..." means the message is still being generated.
 func UpdateCommitDetails(id string, totalFiles int, diffSize int, old, new string) {
-	CommitDetails.Clear()
-	fmt.Fprintf(CommitDetails, "[yellow]Commit ID:[white]\n%s\n\n", id)
-	fmt.Fprintf(CommitDetails, "[red]Total Files Changed:[white]\n%d\n", totalFiles)
+	CurrentCommit = id
+	var details strings.Builder
+	fmt.Fprintf(&details, "%s\n%s\n\n", Colorize(theme.Heading, "Commit ID:"), id)
+	fmt.Fprintf(&details, "%s\n%d\n", Colorize(theme.Error, "Total Files Changed:"), totalFiles)
 
 	// Format diff size nicely
 	if diffSize >= 0 {
 		if diffSize >= 1024 {
-			fmt.Fprintf(CommitDetails, "[red]Total Diff Size:[white]\n%.2f KB\n\n", float64(diffSize)/1024)
+			fmt.Fprintf(&details, "%s\n%.2f KB\n\n", Colorize(theme.Error, "Total Diff Size:"), float64(diffSize)/1024)
 		} else {
-			fmt.Fprintf(CommitDetails, "[red]Total Diff Size:[white]\n%d bytes\n\n", diffSize)
+			fmt.Fprintf(&details, "%s\n%d bytes\n\n", Colorize(theme.Error, "Total Diff Size:"), diffSize)
+		}
+	}
+
+	fmt.Fprintf(&details, "%s\n%s\n\n", Colorize(theme.Heading, "Original Message:"), old)
+	fmt.Fprintf(&details, "%s\n", Colorize(theme.Success, "New Message:"))
+	startStream(id, details.String(), new == "Processing...")
+	fmt.Fprintf(&details, "%s\n", new)
+	out.CommitDetails(details.String(), new != "Processing...")
+}
+
+// ShowRunSummary shows the summary of a finished run in place of the current commit
+func ShowRunSummary(text string) {
+	if !Headless {
+		CommitDetails.SetTitle("Run Summary")
+	}
+	out.CommitDetails(text, true)
+}
+
+// UpdateApplyDetails shows a commit being applied without generation, e.g. from a
+// changes file. A zero applyTime means the commit is still being applied.
+func UpdateApplyDetails(id string, totalFiles int, original, message string, rewritten bool, applyTime time.Duration) {
+	CurrentCommit = id
+	var details strings.Builder
+	fmt.Fprintf(&details, "%s\n%s\n\n", Colorize(theme.Heading, "Commit ID:"), id)
+	if totalFiles >= 0 {
+		fmt.Fprintf(&details, "%s\n%d\n\n", Colorize(theme.Error, "Total Files Changed:"), totalFiles)
+	}
+
+	if rewritten {
+		fmt.Fprintf(&details, "%s\n%s\n\n", Colorize(theme.Heading, "Original Message:"), original)
+		fmt.Fprintf(&details, "%s\n%s\n\n", Colorize(theme.Success, "New Message:"), message)
+	} else {
+		fmt.Fprintf(&details, "%s\n%s\n\n", Colorize(theme.Heading, "Message (unchanged):"), original)
+	}
+
+	if applyTime == 0 {
+		fmt.Fprintf(&details, "%s\nApplying...\n", Colorize(theme.Accent, "Apply Time:"))
+		out.CommitDetails(details.String(), false)
+		return
+	}
+	average := applyTime
+	if len(CommitTimings) > 0 {
+		var total time.Duration
+		for _, timing := range CommitTimings {
+			total += timing
 		}
+		average = total / time.Duration(len(CommitTimings))
 	}
+	fmt.Fprintf(&details, "%s\n%s (average %s)\n", Colorize(theme.Accent, "Apply Time:"),
+		applyTime.Round(time.Millisecond), average.Round(time.Millisecond))
+	out.CommitDetails(details.String(), true)
+}
 
-	fmt.Fprintf(CommitDetails, "[yellow]Original Message:[white]\n%s\n\n", old)
-	fmt.Fprintf(CommitDetails, "[green]New Message:[white]\n%s\n", new)
+// SetPhase sets the phase label shown in front of the progress bar
+func SetPhase(phase string) {
+	Phase = phase
+	UpdateProgressBar()
 }
 
 // MoveToLastCommit moves the current commit details to the last commit details panel
 func MoveToLastCommit() {
-	LastCommitDetails.Clear()
-	LastCommitDetails.SetText(CommitDetails.GetText(true))
+	out.LastCommit()
 }
 
 // UpdateStatus updates the status bar text
 func UpdateStatus(text string) {
-	StatusBar.SetText(fmt.Sprintf("[yellow]%s[white]", text))
-	App.Draw()
+	out.Status(text)
 }
 
 // formatDuration formats a duration in a human-readable way
@@ -378,7 +483,7 @@ func formatDuration(d time.Duration) string {
 	d = d.Round(time.Second)
 
 	if d < time.Minute {
-		return fmt.Sprintf("%ds", d.Seconds())
+		return fmt.Sprintf("%ds", d/time.Second)
 	}
 
 	if d < time.Hour {
@@ -393,3 +498,15 @@ func formatDuration(d time.Duration) string {
 
 	return fmt.Sprintf("%dh %dm %ds", h, m, s)
 }
+
+// UpdateDownloadProgress shows the progress of a download, such as pulling a model, in
+// the progress bar. A zero total only shows the status.
+func UpdateDownloadProgress(status string, completed, total int64) {
+	if total <= 0 {
+		out.Progress(-1, Colorize(theme.Heading, status))
+		return
+	}
+	// Whole percents only, so the console output gets a line per percent, not per chunk
+	percent := int(completed * 100 / total)
+	out.Progress(float64(percent)/100, Colorize(theme.Success, fmt.Sprintf("%s: %d%% of %.1f MB", status, percent, float64(total)/1e6)))
+}
@@ -0,0 +1,143 @@
+// Package events lets applications embedding gitrewrite, such as IDE plugins and bots,
+// follow a rewrite as it runs and drive their own UI on top of it.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// CommitScanned is sent for every commit found while scanning the history, oldest first
+type CommitScanned struct {
+	CommitID string
+	Message  string
+	// NeedsRewrite reports whether the commit was selected for rewriting
+	NeedsRewrite bool
+	// SideBranch marks commits only reachable from branches and tags other than HEAD
+	SideBranch bool
+}
+
+// CommitStarted is sent when the model starts generating a message for a commit
+type CommitStarted struct {
+	CommitID string
+	Message  string
+}
+
+// MessageGenerated is sent when the model produced a new message for a commit, before
+// it is reviewed or applied
+type MessageGenerated struct {
+	CommitID        string
+	OriginalMessage string
+	Message         string
+}
+
+// CommitApplied is sent when a commit was written to the new repository
+type CommitApplied struct {
+	CommitID    string
+	NewCommitID string
+	// Rewritten is false for commits copied with their original message
+	Rewritten bool
+	// Message is the message the commit was written with
+	Message string
+}
+
+// Error is sent when a commit's message could not be generated or the commit could not
+// be applied
+type Error struct {
+	CommitID string
+	Err      error
+}
+
+// Progress is sent whenever the number of processed commits changes
+type Progress struct {
+	Processed int
+	Total     int
+	// ETA is the estimated time until every commit is processed, zero while unknown
+	ETA time.Duration
+}
+
+// Handler receives the events of a run. Its methods are called synchronously from the
+// pipeline, so they should return quickly and hand slow work to another goroutine.
+type Handler interface {
+	OnCommitScanned(CommitScanned)
+	OnCommitStarted(CommitStarted)
+	OnMessageGenerated(MessageGenerated)
+	OnCommitApplied(CommitApplied)
+	OnError(Error)
+	OnProgress(Progress)
+}
+
+// NopHandler ignores every event. Embed it in handlers that only need some of them.
+type NopHandler struct{}
+
+func (NopHandler) OnCommitScanned(CommitScanned)       {}
+func (NopHandler) OnCommitStarted(CommitStarted)       {}
+func (NopHandler) OnMessageGenerated(MessageGenerated) {}
+func (NopHandler) OnCommitApplied(CommitApplied)       {}
+func (NopHandler) OnError(Error)                       {}
+func (NopHandler) OnProgress(Progress)                 {}
+
+// handlers are the registered handlers, called in registration order
+var (
+	handlersMu sync.RWMutex
+	handlers   []*Handler
+)
+
+// Register adds a handler for the events of every following run and returns a
+// function that removes it again
+func Register(h Handler) (unregister func()) {
+	entry := &h
+	handlersMu.Lock()
+	handlers = append(handlers, entry)
+	handlersMu.Unlock()
+	return func() {
+		handlersMu.Lock()
+		defer handlersMu.Unlock()
+		for i, registered := range handlers {
+			if registered == entry {
+				handlers = append(handlers[:i:i], handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Scanned sends a CommitScanned event to every handler
+func Scanned(e CommitScanned) {
+	each(func(h Handler) { h.OnCommitScanned(e) })
+}
+
+// Started sends a CommitStarted event to every handler
+func Started(e CommitStarted) {
+	each(func(h Handler) { h.OnCommitStarted(e) })
+}
+
+// Generated sends a MessageGenerated event to every handler
+func Generated(e MessageGenerated) {
+	each(func(h Handler) { h.OnMessageGenerated(e) })
+}
+
+// Applied sends a CommitApplied event to every handler
+func Applied(e CommitApplied) {
+	each(func(h Handler) { h.OnCommitApplied(e) })
+}
+
+// Failed sends an Error event to every handler
+func Failed(e Error) {
+	each(func(h Handler) { h.OnError(e) })
+}
+
+// Progressed sends a Progress event to every handler
+func Progressed(e Progress) {
+	each(func(h Handler) { h.OnProgress(e) })
+}
+
+// each calls send for every registered handler
+func each(send func(Handler)) {
+	handlersMu.RLock()
+	registered := append([]*Handler(nil), handlers...)
+	handlersMu.RUnlock()
+	for _, h := range registered {
+		send(*h)
+	}
+}
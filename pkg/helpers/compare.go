@@ -0,0 +1,104 @@
+package helpers
+
+import (
+	"regexp"
+	"strings"
+)
+
+// messageWordPattern splits a message into words and the punctuation and whitespace
+// between them; wordStart tells them apart
+var (
+	messageWordPattern = regexp.MustCompile(`[\p{L}\p{N}]+|[^\p{L}\p{N}]+`)
+	wordStart          = regexp.MustCompile(`^[\p{L}\p{N}]`)
+)
+
+// uncomparedWords are too common to tell whether a rewrite kept or invented them:
+// Conventional Commits types and filler words
+var uncomparedWords = map[string]bool{
+	"feat": true, "fix": true, "chore": true, "docs": true, "refactor": true, "perf": true,
+	"test": true, "style": true, "build": true, "ci": true, "revert": true,
+	"the": true, "and": true, "for": true, "with": true, "from": true, "into": true,
+	"this": true, "that": true, "also": true, "when": true, "use": true, "not": true,
+}
+
+// WordMark is a run of a message's text, marked with where its words come from
+type WordMark struct {
+	// Kind is '=' for words the other message has too, '~' for words of a rewrite only
+	// found in the changed file paths, '!' for words found in neither, and ' ' for
+	// punctuation, whitespace and words too short or common to compare
+	Kind byte
+	Text string
+}
+
+// CompareMessages marks the words of an original and a rewritten message, to show what
+// a rewrite kept and what it made up. Words of the rewrite are kept ('=') when the
+// original has them, taken from the changes ('~') when a changed file path has them,
+// and invented ('!') otherwise; words of the original are kept or dropped ('!').
+// Words are compared ignoring case and common endings such as "-s" and "-ed".
+func CompareMessages(original, rewritten string, paths []string) (originalMarks, rewrittenMarks []WordMark) {
+	originalWords := wordStems(original)
+	rewrittenWords := wordStems(rewritten)
+	pathWords := wordStems(strings.Join(paths, " "))
+	originalMarks = markWords(original, func(stem string) byte {
+		if rewrittenWords[stem] {
+			return '='
+		}
+		return '!'
+	})
+	rewrittenMarks = markWords(rewritten, func(stem string) byte {
+		switch {
+		case originalWords[stem]:
+			return '='
+		case pathWords[stem]:
+			return '~'
+		}
+		return '!'
+	})
+	return originalMarks, rewrittenMarks
+}
+
+// markWords splits text into marks, asking kind for the words worth comparing.
+// Adjacent runs of the same kind are merged.
+func markWords(text string, kind func(stem string) byte) []WordMark {
+	var marks []WordMark
+	for _, part := range messageWordPattern.FindAllString(text, -1) {
+		k := byte(' ')
+		if stem, ok := wordStem(part); ok {
+			k = kind(stem)
+		}
+		if len(marks) > 0 && marks[len(marks)-1].Kind == k {
+			marks[len(marks)-1].Text += part
+			continue
+		}
+		marks = append(marks, WordMark{Kind: k, Text: part})
+	}
+	return marks
+}
+
+// wordStems returns the stems of the words of text
+func wordStems(text string) map[string]bool {
+	stems := make(map[string]bool)
+	for _, part := range messageWordPattern.FindAllString(text, -1) {
+		if stem, ok := wordStem(part); ok {
+			stems[stem] = true
+		}
+	}
+	return stems
+}
+
+// wordStem returns a word in lower case without a common ending, so "fixes" and
+// "fixed" compare equal to "fix". ok is false for punctuation and for words too short
+// or common to compare.
+func wordStem(word string) (stem string, ok bool) {
+	word = strings.ToLower(word)
+	if len(word) < 3 || uncomparedWords[word] || !wordStart.MatchString(word) {
+		return "", false
+	}
+	for _, suffix := range []string{"ing", "ed", "es", "s", "e"} {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= 3 {
+			word = strings.TrimSuffix(word, suffix)
+			break
+		}
+	}
+	return word, !uncomparedWords[word]
+}
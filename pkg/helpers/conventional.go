@@ -0,0 +1,7 @@
+package helpers
+
+import "regexp"
+
+// ConventionalSubject matches a Conventional Commits subject, e.g. "feat(api)!: add x".
+// The first group is the type and the second the scope with its parentheses.
+var ConventionalSubject = regexp.MustCompile(`^([a-zA-Z]+)(\([^)]*\))?!?: \S`)
@@ -0,0 +1,28 @@
+package helpers
+
+import (
+	"regexp"
+	"strings"
+)
+
+// revertedCommit matches the line git revert adds to name the reverted commit
+var revertedCommit = regexp.MustCompile(`(?m)^This reverts commit ([0-9a-f]{7,40})\b`)
+
+// RevertedCommit returns the hash named in a message written by git revert, as in
+// "Revert \"subject\"\n\nThis reverts commit <hash>.", and whether the message is one
+func RevertedCommit(message string) (string, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(message), `Revert "`) {
+		return "", false
+	}
+	match := revertedCommit.FindStringSubmatch(message)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// IsRevert reports whether a message was written by git revert
+func IsRevert(message string) bool {
+	_, ok := RevertedCommit(message)
+	return ok
+}
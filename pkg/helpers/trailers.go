@@ -0,0 +1,150 @@
+package helpers
+
+import (
+	"regexp"
+	"strings"
+)
+
+// trailerLinePattern matches a single "Key: value" git trailer line
+var trailerLinePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*: \S`)
+
+// footerOnlyKeys are the trailer keys that belong in the footer wherever they appear,
+// such as Developer Certificate of Origin sign-offs
+var footerOnlyKeys = map[string]bool{
+	"signed-off-by":  true,
+	"co-authored-by": true,
+	"acked-by":       true,
+	"reviewed-by":    true,
+	"tested-by":      true,
+	"reported-by":    true,
+	"suggested-by":   true,
+	"helped-by":      true,
+	"change-id":      true,
+}
+
+// Trailer is a "Key: value" line of a commit message's trailer block
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// String formats the trailer as a message line
+func (t Trailer) String() string {
+	return t.Key + ": " + t.Value
+}
+
+// AppendTrailer adds a "key: value" trailer to a commit message, joining an existing
+// trailer block in the last paragraph or starting a new one after a blank line
+func AppendTrailer(message, key, value string) string {
+	body, trailers := ParseTrailers(message)
+	return FormatTrailers(body, append(trailers, Trailer{Key: key, Value: value}))
+}
+
+// ParseTrailers splits a commit message into its body and the trailers of its last
+// paragraph. The first paragraph is always part of the body, since a Conventional
+// Commits subject like "fix: typo" looks like a trailer. Indented lines continue the
+// value of the trailer before them, as in git.
+func ParseTrailers(message string) (string, []Trailer) {
+	message = strings.TrimRight(message, " \t\r\n")
+	paragraphs := strings.Split(message, "\n\n")
+	if len(paragraphs) < 2 || !isTrailerBlock(paragraphs[len(paragraphs)-1]) {
+		return message, nil
+	}
+
+	var trailers []Trailer
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if isContinuationLine(line) {
+			trailers[len(trailers)-1].Value += "\n" + line
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(line), ": ")
+		trailers = append(trailers, Trailer{Key: key, Value: strings.TrimSpace(value)})
+	}
+	body := strings.TrimRight(strings.Join(paragraphs[:len(paragraphs)-1], "\n\n"), " \t\r\n")
+	return body, trailers
+}
+
+// FormatTrailers joins a message body and trailers into a commit message with the
+// trailers as its last paragraph
+func FormatTrailers(body string, trailers []Trailer) string {
+	body = strings.TrimRight(body, " \t\r\n")
+	if len(trailers) == 0 {
+		return body
+	}
+	lines := make([]string, 0, len(trailers))
+	for _, trailer := range trailers {
+		lines = append(lines, trailer.String())
+	}
+	if body == "" {
+		return strings.Join(lines, "\n")
+	}
+	return body + "\n\n" + strings.Join(lines, "\n")
+}
+
+// MergeTrailers combines a generated message with trailers preserved from the original
+// message. Sign-offs and similar lines found in the body are moved to the footer. The
+// preserved trailers come first, in their original order, followed by the message's
+// own trailers; a trailer with the same key and value is only kept once.
+func MergeTrailers(message string, preserved []Trailer) string {
+	body, own := ParseTrailers(message)
+
+	var bodyLines []string
+	var moved []Trailer
+	for i, line := range strings.Split(body, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if i > 0 && ok && footerOnlyKeys[strings.ToLower(key)] && trailerLinePattern.MatchString(strings.TrimSpace(line)) {
+			moved = append(moved, Trailer{Key: key, Value: strings.TrimSpace(value)})
+			continue
+		}
+		bodyLines = append(bodyLines, line)
+	}
+	body = collapseBlankLines(strings.Join(bodyLines, "\n"))
+
+	var merged []Trailer
+	seen := make(map[string]bool)
+	for _, group := range [][]Trailer{preserved, moved, own} {
+		for _, trailer := range group {
+			id := strings.ToLower(trailer.Key) + ": " + trailer.Value
+			if !seen[id] {
+				seen[id] = true
+				merged = append(merged, trailer)
+			}
+		}
+	}
+	return FormatTrailers(body, merged)
+}
+
+// isTrailerBlock reports whether every line of a paragraph is a trailer line or
+// continues the trailer before it
+func isTrailerBlock(paragraph string) bool {
+	for i, line := range strings.Split(paragraph, "\n") {
+		if i > 0 && isContinuationLine(strings.TrimRight(line, " \t\r")) {
+			continue
+		}
+		if !trailerLinePattern.MatchString(strings.TrimSpace(line)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isContinuationLine reports whether a trailer block line continues the previous value
+func isContinuationLine(line string) bool {
+	return strings.TrimSpace(line) != "" && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t"))
+}
+
+// collapseBlankLines trims a message and reduces runs of blank lines to one
+func collapseBlankLines(message string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(message), "\n") {
+		if strings.TrimSpace(line) == "" && len(lines) > 0 && lines[len(lines)-1] == "" {
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			line = ""
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
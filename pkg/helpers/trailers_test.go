@@ -0,0 +1,77 @@
+package helpers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseTrailers(t *testing.T) {
+	tests := []struct {
+		message  string
+		body     string
+		trailers []Trailer
+	}{
+		// A Conventional Commits subject is never a trailer
+		{"fix: typo", "fix: typo", nil},
+		{
+			"feat: add login\n\nAdds the endpoint.\n\nRefs: #12\nSigned-off-by: A <a@example.com>\n",
+			"feat: add login\n\nAdds the endpoint.",
+			[]Trailer{{"Refs", "#12"}, {"Signed-off-by", "A <a@example.com>"}},
+		},
+		// Indented lines continue the value before them
+		{"feat: add login\n\nNote: first\n  second", "feat: add login", []Trailer{{"Note", "first\n  second"}}},
+		// A last paragraph with any other line is part of the body
+		{"feat: add login\n\nRefs: #12\nnot a trailer", "feat: add login\n\nRefs: #12\nnot a trailer", nil},
+	}
+	for _, tt := range tests {
+		body, trailers := ParseTrailers(tt.message)
+		if body != tt.body || !slices.Equal(trailers, tt.trailers) {
+			t.Errorf("ParseTrailers(%q) = %q, %v, want %q, %v", tt.message, body, trailers, tt.body, tt.trailers)
+		}
+	}
+}
+
+func TestMergeTrailers(t *testing.T) {
+	tests := []struct {
+		message   string
+		preserved []Trailer
+		want      string
+	}{
+		{"fix: typo", nil, "fix: typo"},
+		// Preserved trailers come first, sign-offs move out of the body and
+		// duplicates are dropped
+		{
+			"feat: add login\n\nAdds the endpoint.\nSigned-off-by: A <a@example.com>\n\nRefs: #3",
+			[]Trailer{{"Change-Id", "I123"}, {"Refs", "#3"}},
+			"feat: add login\n\nAdds the endpoint.\n\nChange-Id: I123\nRefs: #3\nSigned-off-by: A <a@example.com>",
+		},
+		// Moving a sign-off doesn't leave a run of blank lines behind
+		{
+			"feat: add login\n\nSigned-off-by: A <a@example.com>\n\nMore text.",
+			nil,
+			"feat: add login\n\nMore text.\n\nSigned-off-by: A <a@example.com>",
+		},
+		{"Signed-off-by: A <a@example.com>", nil, "Signed-off-by: A <a@example.com>"},
+	}
+	for _, tt := range tests {
+		if got := MergeTrailers(tt.message, tt.preserved); got != tt.want {
+			t.Errorf("MergeTrailers(%q, %v) = %q, want %q", tt.message, tt.preserved, got, tt.want)
+		}
+	}
+}
+
+func TestAppendTrailer(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"fix: typo", "fix: typo\n\nRefs: #1"},
+		{"fix: typo\n\nFixes the README.", "fix: typo\n\nFixes the README.\n\nRefs: #1"},
+		{"fix: typo\n\nSigned-off-by: A <a@example.com>\n", "fix: typo\n\nSigned-off-by: A <a@example.com>\nRefs: #1"},
+	}
+	for _, tt := range tests {
+		if got := AppendTrailer(tt.message, "Refs", "#1"); got != tt.want {
+			t.Errorf("AppendTrailer(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
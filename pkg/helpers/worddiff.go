@@ -0,0 +1,82 @@
+package helpers
+
+import "regexp"
+
+// wordPattern splits text into words and the whitespace between them, so a diff can
+// be joined back into the original text
+var wordPattern = regexp.MustCompile(`\s+|\S+`)
+
+// maxWordDiffCells bounds the size of the LCS table; longer texts are shown as a
+// whole deletion followed by a whole insertion
+const maxWordDiffCells = 4_000_000
+
+// DiffOp is a run of text that is unchanged, removed or added between two texts
+type DiffOp struct {
+	// Kind is '=' for unchanged, '-' for removed and '+' for added text
+	Kind byte
+	Text string
+}
+
+// WordDiff returns the word-level differences between two texts. Whitespace is
+// compared like words, and adjacent runs of the same kind are merged.
+func WordDiff(before, after string) []DiffOp {
+	a := wordPattern.FindAllString(before, -1)
+	b := wordPattern.FindAllString(after, -1)
+
+	var ops []DiffOp
+	add := func(kind byte, text string) {
+		if len(ops) > 0 && ops[len(ops)-1].Kind == kind {
+			ops[len(ops)-1].Text += text
+			return
+		}
+		ops = append(ops, DiffOp{Kind: kind, Text: text})
+	}
+
+	if (len(a)+1)*(len(b)+1) > maxWordDiffCells {
+		if before != "" {
+			add('-', before)
+		}
+		if after != "" {
+			add('+', after)
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			add('=', a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add('-', a[i])
+			i++
+		default:
+			add('+', b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add('-', a[i])
+	}
+	for ; j < len(b); j++ {
+		add('+', b[j])
+	}
+	return ops
+}